@@ -3,13 +3,13 @@ module github.com/seakee/dudu-proxy
 go 1.24.6
 
 require (
+	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible
 	github.com/sk-pkg/logger v1.3.3
 	go.uber.org/zap v1.27.0
 	golang.org/x/time v0.14.0
 )
 
 require (
-	github.com/lestrrat-go/file-rotatelogs v2.4.0+incompatible // indirect
 	github.com/lestrrat-go/strftime v1.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	go.uber.org/multierr v1.10.0 // indirect
@@ -0,0 +1,138 @@
+package admin
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Stats is a point-in-time view of the proxy's runtime state
+type Stats struct {
+	Connections    []manager.ConnInfo   `json:"connections"`
+	BytesUp        int64                `json:"bytes_up"`   // Client to target, including closed connections
+	BytesDown      int64                `json:"bytes_down"` // Target to client, including closed connections
+	Bans           []manager.BanRecord  `json:"bans"`
+	CircuitBreaker BreakerStats         `json:"circuit_breaker"`
+	TargetBreakers int                  `json:"target_breakers"` // Targets with a breaker
+	RateLimit      RateLimitStats       `json:"rate_limit"`
+	TopTargets     []manager.TargetStat `json:"top_targets"` // Most popular target hosts, empty when target stats are disabled
+}
+
+// BreakerStats describes the global circuit breaker
+type BreakerStats struct {
+	Enabled          bool    `json:"enabled"`
+	State            string  `json:"state"`
+	Requests         int     `json:"requests"`
+	Failures         int     `json:"failures"`
+	FailureRate      float64 `json:"failure_rate"`
+	Shadow           bool    `json:"shadow"`            // Tracking only, never rejects
+	ShadowRejections int64   `json:"shadow_rejections"` // Requests it would have rejected outside shadow mode
+}
+
+// RateLimitStats counts rate limit rejections since start
+type RateLimitStats struct {
+	GlobalRejections int64 `json:"global_rejections"`
+	PerIPRejections  int64 `json:"per_ip_rejections"`
+}
+
+// dashboardRefresh is how often the dashboard page reloads itself
+const dashboardRefresh = 5 * time.Second
+
+// dashboardData is rendered by dashboardTemplate
+type dashboardData struct {
+	Info    Info
+	Stats   Stats
+	Now     time.Time
+	Refresh int
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"bytes": formatBytes,
+	"since": func(now, t time.Time) string { return now.Sub(t).Truncate(time.Second).String() },
+	"until": func(now, t time.Time) string { return t.Sub(now).Truncate(time.Second).String() },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="{{.Refresh}}">
+<title>DuDu Proxy</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 10px; text-align: left; }
+th { background: #f0f0f0; }
+.open { color: #c00; font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>DuDu Proxy {{.Info.Version}}</h1>
+<p>Updated {{.Now.Format "2006-01-02 15:04:05"}}, refreshing every {{.Refresh}}s</p>
+
+<h2>Overview</h2>
+<table>
+<tr><th>Active connections</th><td>{{len .Stats.Connections}}</td></tr>
+<tr><th>Transferred up / down</th><td>{{bytes .Stats.BytesUp}} / {{bytes .Stats.BytesDown}}</td></tr>
+<tr><th>Banned IPs</th><td>{{len .Stats.Bans}}</td></tr>
+<tr><th>Circuit breaker</th><td>{{if .Stats.CircuitBreaker.Enabled}}<span class="{{.Stats.CircuitBreaker.State}}">{{.Stats.CircuitBreaker.State}}</span>
+ ({{.Stats.CircuitBreaker.Failures}}/{{.Stats.CircuitBreaker.Requests}} failed){{if .Stats.CircuitBreaker.Shadow}}, shadow mode: {{.Stats.CircuitBreaker.ShadowRejections}} would-be rejections{{end}}{{else}}disabled{{end}}</td></tr>
+<tr><th>Target breakers</th><td>{{.Stats.TargetBreakers}}</td></tr>
+<tr><th>Rate limit rejections (global / per IP)</th><td>{{.Stats.RateLimit.GlobalRejections}} / {{.Stats.RateLimit.PerIPRejections}}</td></tr>
+</table>
+
+<h2>Connections</h2>
+<table>
+<tr><th>ID</th><th>Protocol</th><th>Client</th><th>User</th><th>Target</th><th>Age</th><th>Up</th><th>Down</th></tr>
+{{range .Stats.Connections}}<tr><td>{{.ID}}</td><td>{{.Protocol}}</td><td>{{.ClientIP}}</td><td>{{.Username}}</td><td>{{.Target}}</td><td>{{since $.Now .StartedAt}}</td><td>{{bytes .BytesUp}}</td><td>{{bytes .BytesDown}}</td></tr>
+{{else}}<tr><td colspan="8">No active connections</td></tr>
+{{end}}</table>
+
+<h2>Banned IPs</h2>
+<table>
+<tr><th>IP</th><th>Reason</th><th>Failures</th><th>Expires</th><th>Remaining</th></tr>
+{{range .Stats.Bans}}<tr><td>{{.IP}}</td><td>{{.Reason}}</td><td>{{.FailCount}}</td><td>{{.ExpiresAt.Format "2006-01-02 15:04:05"}}</td><td>{{until $.Now .ExpiresAt}}</td></tr>
+{{else}}<tr><td colspan="5">No banned IPs</td></tr>
+{{end}}</table>
+{{if .Stats.TopTargets}}<h2>Top Targets</h2>
+<table>
+<tr><th>Host</th><th>Connections</th><th>Transferred</th></tr>
+{{range .Stats.TopTargets}}<tr><td>{{.Host}}</td><td>{{.Connections}}</td><td>{{bytes .Bytes}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// handleDashboard renders the HTML status page
+func (s *Server) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	data := dashboardData{
+		Info:    s.info,
+		Stats:   s.stats(),
+		Now:     time.Now(),
+		Refresh: int(dashboardRefresh / time.Second),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		logger.Error("Failed to render dashboard", "error", err)
+	}
+}
+
+// formatBytes renders n with a binary unit suffix
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
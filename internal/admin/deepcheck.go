@@ -0,0 +1,74 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// CheckResult is the outcome of one deep check probe
+type CheckResult struct {
+	Name       string `json:"name"`
+	OK         bool   `json:"ok"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// deepCheck runs round-trip probes through the proxy and caches the results
+type deepCheck struct {
+	run     func(ctx context.Context) []CheckResult
+	ttl     time.Duration // How long results are reused
+	timeout time.Duration // Bound on a single run
+
+	mu        sync.Mutex // Held while probing so concurrent polls share one run
+	checkedAt time.Time
+	results   []CheckResult
+}
+
+// deepCheckResponse is returned by GET /deepcheck
+type deepCheckResponse struct {
+	Status    string        `json:"status"` // "ok" or "fail"
+	CheckedAt time.Time     `json:"checked_at"`
+	Checks    []CheckResult `json:"checks"`
+}
+
+// WithDeepCheck serves GET /deepcheck, which reports the results of run. Results
+// are cached for ttl so frequent polling doesn't open a tunnel every time.
+func WithDeepCheck(run func(ctx context.Context) []CheckResult, ttl, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.deepCheck = &deepCheck{run: run, ttl: ttl, timeout: timeout}
+	}
+}
+
+// check returns the cached results, probing again once they are older than ttl
+func (d *deepCheck) check(ctx context.Context) ([]CheckResult, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.results == nil || time.Since(d.checkedAt) >= d.ttl {
+		ctx, cancel := context.WithTimeout(ctx, d.timeout)
+		defer cancel()
+
+		d.results = d.run(ctx)
+		d.checkedAt = time.Now()
+	}
+
+	return d.results, d.checkedAt
+}
+
+// handleDeepCheck reports whether requests make it through the proxy, with 503 on any failure
+func (s *Server) handleDeepCheck(w http.ResponseWriter, r *http.Request) {
+	results, checkedAt := s.deepCheck.check(context.WithoutCancel(r.Context()))
+
+	resp := deepCheckResponse{Status: "ok", CheckedAt: checkedAt, Checks: results}
+	status := http.StatusOK
+	for _, result := range results {
+		if !result.OK {
+			resp.Status = "fail"
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJSON(w, status, resp)
+}
@@ -0,0 +1,90 @@
+package admin
+
+import (
+	"github.com/seakee/dudu-proxy/internal/config"
+)
+
+// Info describes what the proxy offers. It never contains credentials.
+type Info struct {
+	Version   string          `json:"version"`
+	Protocols []ProtocolInfo  `json:"protocols"`
+	Auth      AuthInfo        `json:"auth"`
+	RateLimit RateLimitInfo   `json:"rate_limit"`
+	Features  map[string]bool `json:"features"`
+}
+
+// ProtocolInfo describes one enabled proxy listener
+type ProtocolInfo struct {
+	Name    string   `json:"name"`
+	Port    int      `json:"port"`
+	Listen  []string `json:"listen"` // address:port endpoints
+	Network string   `json:"network"`
+	TLS     bool     `json:"tls"`
+	Auth    bool     `json:"auth"`   // Whether clients must authenticate
+	Paused  bool     `json:"paused"` // Whether new connections are being turned away
+}
+
+// AuthInfo describes client authentication
+type AuthInfo struct {
+	Enabled bool   `json:"enabled"`
+	Scheme  string `json:"scheme,omitempty"` // "basic" or "basic,bearer" for HTTP, username/password (RFC 1929) for SOCKS5
+}
+
+// RateLimitInfo describes the configured request rates
+type RateLimitInfo struct {
+	Enabled                 bool `json:"enabled"`
+	GlobalRequestsPerSecond int  `json:"global_requests_per_second"`
+	PerIPRequestsPerSecond  int  `json:"per_ip_requests_per_second"`
+}
+
+// NewInfo builds the introspection document from the loaded configuration
+func NewInfo(cfg *config.Config, version string) Info {
+	info := Info{
+		Version: version,
+		Protocols: []ProtocolInfo{
+			{
+				Name:    "http",
+				Port:    cfg.Server.HTTPPort,
+				Listen:  cfg.Server.HTTPListenAddresses(),
+				Network: cfg.Server.Network,
+				TLS:     cfg.TLS.ServesTLS("http"),
+				Auth:    cfg.Auth.EnabledFor("http"),
+			},
+			{
+				Name:    "socks5",
+				Port:    cfg.Server.SOCKS5Port,
+				Listen:  cfg.Server.SOCKS5ListenAddresses(),
+				Network: cfg.Server.Network,
+				TLS:     cfg.TLS.ServesTLS("socks5"),
+				Auth:    cfg.Auth.EnabledFor("socks5"),
+			},
+		},
+		Auth: AuthInfo{
+			Enabled: cfg.Auth.AnyEnabled(),
+		},
+		RateLimit: RateLimitInfo{
+			Enabled:                 cfg.RateLimit.Enabled,
+			GlobalRequestsPerSecond: cfg.RateLimit.GlobalRequestsPerSecond,
+			PerIPRequestsPerSecond:  cfg.RateLimit.PerIPRequestsPerSecond,
+		},
+		Features: map[string]bool{
+			"ip_ban":                 cfg.IPBan.Enabled,
+			"circuit_breaker":        cfg.CircuitBreaker.Enabled,
+			"target_circuit_breaker": cfg.TargetBreaker.Enabled,
+			"scan_detection":         cfg.ScanDetection.Enabled,
+			"ssrf_guard":             cfg.SSRFGuard.Enabled,
+			"transparent":            cfg.HTTP.Transparent,
+			"compress":               cfg.HTTP.Compress,
+			"socks5_resolve":         cfg.SOCKS5.EnableResolveExtension,
+		},
+	}
+
+	if cfg.Auth.AnyEnabled() {
+		info.Auth.Scheme = "basic"
+		if len(cfg.Auth.Tokens) > 0 && cfg.Auth.EnabledFor("http") {
+			info.Auth.Scheme = "basic,bearer"
+		}
+	}
+
+	return info
+}
@@ -0,0 +1,528 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Server serves the health and introspection endpoints, plus user draining
+// when configured
+type Server struct {
+	address     string
+	info        Info
+	bans        *manager.IPBanManager        // Serves GET /bans/{ip} when set
+	stats       func() Stats                 // Serves GET /dashboard when set
+	auths       []*middleware.AuthMiddleware // Serves POST /users/drain with conns when set
+	conns       *manager.ConnRegistry
+	deepCheck   *deepCheck           // Serves GET /deepcheck when set
+	targets     *manager.TargetStats // Serves GET /targets when set
+	targetConns *manager.ConnLimiter // Serves GET /targets/active when set
+	listeners   map[string]Pausable  // Serves POST /listeners/{proto}/pause and /resume when set
+	debugIPs    *manager.DebugIPs    // Serves GET and PUT /debug-ips when set
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+// Option configures an admin server
+type Option func(*Server)
+
+// WithBans exposes ban records from bans at GET /bans and GET /bans/{ip}, its
+// IP feeds at GET /feeds and DELETE /feeds/{source}, and backs its state up
+// and restores it through GET /state/export and POST /state/import
+func WithBans(bans *manager.IPBanManager) Option {
+	return func(s *Server) {
+		s.bans = bans
+	}
+}
+
+// WithTargetStats exposes the most popular target hosts at GET /targets
+func WithTargetStats(targets *manager.TargetStats) Option {
+	return func(s *Server) {
+		s.targets = targets
+	}
+}
+
+// WithTargetConns exposes the target hosts with the most open connections,
+// as counted by the per-target connection limit, at GET /targets/active
+func WithTargetConns(limiter *manager.ConnLimiter) Option {
+	return func(s *Server) {
+		s.targetConns = limiter
+	}
+}
+
+// WithDashboard serves an auto-refreshing HTML status page at GET /dashboard,
+// rendered from stats on every request
+func WithDashboard(stats func() Stats) Option {
+	return func(s *Server) {
+		s.stats = stats
+	}
+}
+
+// Pausable is a proxy that can stop serving new connections without closing
+// established ones
+type Pausable interface {
+	Pause()
+	Resume()
+	Paused() bool
+}
+
+// WithListenerControl serves POST /listeners/{proto}/pause and
+// POST /listeners/{proto}/resume for the proxies in listeners, keyed by
+// protocol name, and reports their paused state in /info
+func WithListenerControl(listeners map[string]Pausable) Option {
+	return func(s *Server) {
+		s.listeners = listeners
+	}
+}
+
+// WithDebugIPs serves GET /debug-ips and PUT /debug-ips, which read and
+// replace the clients whose connections log at debug verbosity
+func WithDebugIPs(debugIPs *manager.DebugIPs) Option {
+	return func(s *Server) {
+		s.debugIPs = debugIPs
+	}
+}
+
+// WithUserDrain serves POST /users/drain, which revokes a user in every one
+// of auths and aborts their active connections in conns
+func WithUserDrain(conns *manager.ConnRegistry, auths ...*middleware.AuthMiddleware) Option {
+	return func(s *Server) {
+		s.auths = auths
+		s.conns = conns
+	}
+}
+
+// NewServer creates a new admin server listening on address
+func NewServer(address string, info Info, opts ...Option) *Server {
+	s := &Server{
+		address: address,
+		info:    info,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Handler returns the HTTP handler serving the admin endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", s.handleHealth)
+	mux.HandleFunc("GET /info", s.handleInfo)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
+	if s.bans != nil {
+		mux.HandleFunc("GET /bans", s.handleBans)
+		mux.HandleFunc("GET /bans/{ip}", s.handleBan)
+		mux.HandleFunc("GET /feeds", s.handleFeeds)
+		mux.HandleFunc("DELETE /feeds/{source}", s.handleClearFeed)
+		mux.HandleFunc("GET /state/export", s.handleExportState)
+		mux.HandleFunc("POST /state/import", s.handleImportState)
+	}
+	if s.stats != nil {
+		mux.HandleFunc("GET /dashboard", s.handleDashboard)
+	}
+	if s.deepCheck != nil {
+		mux.HandleFunc("GET /deepcheck", s.handleDeepCheck)
+	}
+	if s.targets != nil {
+		mux.HandleFunc("GET /targets", s.handleTargets)
+	}
+	if s.targetConns != nil {
+		mux.HandleFunc("GET /targets/active", s.handleTargetConns)
+	}
+	if len(s.auths) > 0 && s.conns != nil {
+		mux.HandleFunc("POST /users/drain", s.handleDrainUser)
+	}
+	if s.debugIPs != nil {
+		mux.HandleFunc("GET /debug-ips", s.handleDebugIPs)
+		mux.HandleFunc("PUT /debug-ips", s.handleSetDebugIPs)
+	}
+	if len(s.listeners) > 0 {
+		mux.HandleFunc("POST /listeners/{proto}/pause", s.handleSetPaused(true))
+		mux.HandleFunc("POST /listeners/{proto}/resume", s.handleSetPaused(false))
+	}
+	return mux
+}
+
+// Start starts the admin server and blocks until it is stopped
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+
+	if err := server.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// Stop shuts the admin server down
+func (s *Server) Stop() error {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+
+	if server == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return server.Shutdown(ctx)
+}
+
+// handleHealth reports that the process is up
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleInfo returns the introspection document
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request) {
+	info := s.info
+	if len(s.listeners) > 0 {
+		info.Protocols = slices.Clone(info.Protocols)
+		for i, p := range info.Protocols {
+			if listener, ok := s.listeners[p.Name]; ok {
+				info.Protocols[i].Paused = listener.Paused()
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// handleMetrics returns all metrics in the Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metrics.Default.WriteText(w)
+}
+
+const (
+	// defaultBanPageSize is the page size of GET /bans without a limit
+	defaultBanPageSize = 100
+	// maxBanPageSize caps the limit accepted by GET /bans
+	maxBanPageSize = 1000
+)
+
+// banListResponse is a page of active bans
+type banListResponse struct {
+	Total  int                 `json:"total"`
+	Offset int                 `json:"offset"`
+	Limit  int                 `json:"limit"`
+	Bans   []manager.BanRecord `json:"bans"`
+}
+
+// handleBans returns a page of active bans ordered by expiry, selected by the
+// offset and limit query parameters
+func (s *Server) handleBans(w http.ResponseWriter, r *http.Request) {
+	offset, ok := queryInt(w, r, "offset", 0)
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, r, "limit", defaultBanPageSize)
+	if !ok {
+		return
+	}
+	if limit == 0 || limit > maxBanPageSize {
+		limit = maxBanPageSize
+	}
+
+	bans, total := s.bans.ListBans(offset, limit)
+	writeJSON(w, http.StatusOK, banListResponse{
+		Total:  total,
+		Offset: offset,
+		Limit:  limit,
+		Bans:   bans,
+	})
+}
+
+// defaultTopTargets is how many hosts GET /targets returns without a limit
+const defaultTopTargets = 20
+
+// handleTargets lists the most popular target hosts, most connections first
+func (s *Server) handleTargets(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryInt(w, r, "limit", defaultTopTargets)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.targets.Top(limit))
+}
+
+// handleTargetConns lists the target hosts with the most open connections
+func (s *Server) handleTargetConns(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryInt(w, r, "limit", defaultTopTargets)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.targetConns.Top(limit))
+}
+
+// handleFeeds lists the loaded IP feeds
+func (s *Server) handleFeeds(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.bans.Feeds())
+}
+
+// handleClearFeed removes a feed's entries, leaving local bans alone. A
+// refreshed feed is loaded again at its next refresh.
+func (s *Server) handleClearFeed(w http.ResponseWriter, r *http.Request) {
+	source := r.PathValue("source")
+	if !s.bans.ClearFeed(source) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown feed"})
+		return
+	}
+
+	logger.Info("IP feed cleared through admin API", "feed", source)
+	writeJSON(w, http.StatusOK, map[string]string{"cleared": source})
+}
+
+// maxStateImportBytes bounds the body of POST /state/import
+const maxStateImportBytes = 10 << 20
+
+// stateSnapshot is the body of GET /state/export and POST /state/import
+type stateSnapshot struct {
+	ExportedAt time.Time         `json:"exported_at"`
+	Bans       []json.RawMessage `json:"bans"` // manager.BanRecord, decoded one by one so a bad record is only skipped
+}
+
+// importResponse reports what POST /state/import did
+type importResponse struct {
+	Imported int `json:"imported"`
+	Skipped  int `json:"skipped"`
+}
+
+// handleExportState returns the active bans and pending failure counts
+func (s *Server) handleExportState(w http.ResponseWriter, r *http.Request) {
+	records := s.bans.Export()
+	snapshot := stateSnapshot{ExportedAt: time.Now(), Bans: make([]json.RawMessage, 0, len(records))}
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		snapshot.Bans = append(snapshot.Bans, data)
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// handleImportState merges an exported snapshot into the running ban state.
+// Malformed records are skipped along with those the manager rejects.
+func (s *Server) handleImportState(w http.ResponseWriter, r *http.Request) {
+	var snapshot stateSnapshot
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxStateImportBytes)).Decode(&snapshot); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be a snapshot from GET /state/export"})
+		return
+	}
+
+	var resp importResponse
+	records := make([]manager.BanRecord, 0, len(snapshot.Bans))
+	for _, entry := range snapshot.Bans {
+		var record manager.BanRecord
+		if err := json.Unmarshal(entry, &record); err != nil {
+			resp.Skipped++
+			continue
+		}
+		records = append(records, record)
+	}
+	imported, skipped := s.bans.Import(records)
+	resp.Imported = imported
+	resp.Skipped += skipped
+
+	logger.Info("Ban state imported through admin API",
+		"imported", resp.Imported,
+		"skipped", resp.Skipped,
+		"exported_at", snapshot.ExportedAt)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// queryInt returns the non-negative integer query parameter name, or def when
+// it is absent. It writes a 400 response and reports false when it is invalid.
+func queryInt(w http.ResponseWriter, r *http.Request, name string, def int) (int, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": name + " must be a non-negative integer"})
+		return 0, false
+	}
+	return n, true
+}
+
+// banResponse describes an IP's ban state. Ban times are omitted for IPs that
+// only have pending failures or are banned by a feed.
+type banResponse struct {
+	IP        string     `json:"ip"`
+	Banned    bool       `json:"banned"`
+	BannedAt  *time.Time `json:"banned_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	FailCount int        `json:"fail_count"`
+	Reason    string     `json:"reason,omitempty"`
+	Source    string     `json:"source,omitempty"` // Feed that lists the IP, empty for local bans
+}
+
+// handleBan returns the ban record for the IP in the path
+func (s *Server) handleBan(w http.ResponseWriter, r *http.Request) {
+	ip := net.ParseIP(manager.StripZone(r.PathValue("ip")))
+	if ip == nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid IP address"})
+		return
+	}
+
+	record, ok := s.bans.GetBanRecord(ip.String())
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "no ban record"})
+		return
+	}
+
+	resp := banResponse{
+		IP:        record.IP,
+		Banned:    record.IsBanned(),
+		FailCount: record.FailCount,
+		Reason:    record.Reason,
+		Source:    record.Source,
+	}
+	if !record.ExpiresAt.IsZero() {
+		resp.BannedAt = &record.BannedAt
+		resp.ExpiresAt = &record.ExpiresAt
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// drainRequest is the body of POST /users/drain
+type drainRequest struct {
+	Username string `json:"username"`
+}
+
+// drainResponse reports what POST /users/drain did
+type drainResponse struct {
+	Username          string `json:"username"`
+	Removed           bool   `json:"removed"` // Whether credentials were revoked
+	ConnectionsClosed int    `json:"connections_closed"`
+}
+
+// handleDrainUser revokes a user's credentials and aborts their connections
+func (s *Server) handleDrainUser(w http.ResponseWriter, r *http.Request) {
+	var req drainRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil || req.Username == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"username\": \"...\"}"})
+		return
+	}
+
+	// Revoke first so the user can't reconnect while connections are closing
+	resp := drainResponse{Username: req.Username}
+	for _, auth := range s.auths {
+		if auth.RemoveUser(req.Username) {
+			resp.Removed = true
+		}
+	}
+	resp.ConnectionsClosed = s.conns.CloseUser(req.Username)
+
+	if !resp.Removed && resp.ConnectionsClosed == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown user"})
+		return
+	}
+
+	logger.Warn("User drained",
+		"username", req.Username,
+		"credentials_removed", resp.Removed,
+		"connections_closed", resp.ConnectionsClosed)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// debugIPsBody is the request and response body of /debug-ips
+type debugIPsBody struct {
+	IPs []string `json:"ips"`
+}
+
+// handleDebugIPs returns the clients logged at debug verbosity
+func (s *Server) handleDebugIPs(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, debugIPsBody{IPs: s.debugIPs.List()})
+}
+
+// handleSetDebugIPs replaces the clients logged at debug verbosity. The
+// change lasts until the next restart.
+func (s *Server) handleSetDebugIPs(w http.ResponseWriter, r *http.Request) {
+	var req debugIPsBody
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"ips\": [\"...\"]}"})
+		return
+	}
+	if err := s.debugIPs.Set(req.IPs); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	logger.Warn("Debug IPs updated", "ips", s.debugIPs.List())
+	writeJSON(w, http.StatusOK, debugIPsBody{IPs: s.debugIPs.List()})
+}
+
+// listenerResponse is the result of pausing or resuming a listener
+type listenerResponse struct {
+	Protocol string `json:"protocol"`
+	Paused   bool   `json:"paused"`
+}
+
+// handleSetPaused returns a handler that pauses or resumes the proxy named in
+// the path. Established tunnels are left alone either way.
+func (s *Server) handleSetPaused(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		proto := r.PathValue("proto")
+		listener, ok := s.listeners[proto]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown protocol"})
+			return
+		}
+
+		if paused {
+			listener.Pause()
+			logger.Warn("Listener paused, new connections will be closed", "protocol", proto)
+		} else {
+			listener.Resume()
+			logger.Info("Listener resumed", "protocol", proto)
+		}
+
+		writeJSON(w, http.StatusOK, listenerResponse{Protocol: proto, Paused: listener.Paused()})
+	}
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,602 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/config"
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Server.HTTPPort = 8080
+	cfg.Server.SOCKS5Port = 1080
+	cfg.Server.Network = "tcp"
+	cfg.Auth.Enabled = true
+	cfg.Auth.Users = []config.User{{Username: "user1", Password: "secret-password"}}
+	cfg.RateLimit.Enabled = true
+	cfg.RateLimit.GlobalRequestsPerSecond = 1000
+	cfg.RateLimit.PerIPRequestsPerSecond = 10
+	cfg.IPBan.Enabled = true
+	return cfg
+}
+
+func TestServer_Info(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/info", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON content type, got %q", ct)
+	}
+	if strings.Contains(rec.Body.String(), "secret-password") || strings.Contains(rec.Body.String(), "user1") {
+		t.Error("Info must not expose user credentials")
+	}
+
+	var info Info
+	if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+		t.Fatalf("Failed to decode info: %v", err)
+	}
+
+	if info.Version != "1.2.3" {
+		t.Errorf("Expected version 1.2.3, got %q", info.Version)
+	}
+	if len(info.Protocols) != 2 || info.Protocols[0].Port != 8080 || info.Protocols[1].Port != 1080 {
+		t.Errorf("Unexpected protocols: %+v", info.Protocols)
+	}
+	if !info.Auth.Enabled || info.Auth.Scheme != "basic" {
+		t.Errorf("Unexpected auth info: %+v", info.Auth)
+	}
+	if info.RateLimit.PerIPRequestsPerSecond != 10 {
+		t.Errorf("Expected per-IP rate 10, got %d", info.RateLimit.PerIPRequestsPerSecond)
+	}
+	if !info.Features["ip_ban"] || info.Features["scan_detection"] {
+		t.Errorf("Unexpected feature flags: %v", info.Features)
+	}
+}
+
+func TestServer_Methods(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"))
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodGet, "/health", http.StatusOK},
+		{http.MethodGet, "/info", http.StatusOK},
+		{http.MethodGet, "/metrics", http.StatusOK},
+		{http.MethodPost, "/info", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			if rec.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestServer_Bans(t *testing.T) {
+	bans := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	defer bans.Stop()
+	bans.BanIPWithReason("10.0.0.1", manager.BanReasonScan)
+	bans.RecordFailure("10.0.0.2")
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithBans(bans))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBanned bool
+		wantReason string
+	}{
+		{"/bans/10.0.0.1", http.StatusOK, true, manager.BanReasonScan},
+		{"/bans/10.0.0.2", http.StatusOK, false, ""},
+		{"/bans/10.0.0.3", http.StatusNotFound, false, ""},
+		{"/bans/not-an-ip", http.StatusBadRequest, false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp banResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Banned != tt.wantBanned || resp.Reason != tt.wantReason {
+				t.Errorf("Got banned=%v reason=%q, want banned=%v reason=%q",
+					resp.Banned, resp.Reason, tt.wantBanned, tt.wantReason)
+			}
+			if resp.Banned != (resp.ExpiresAt != nil) {
+				t.Errorf("expires_at should be present only for active bans: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_BanList(t *testing.T) {
+	bans := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	defer bans.Stop()
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		bans.BanIP(ip)
+	}
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithBans(bans))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantLimit  int
+		wantLen    int
+	}{
+		{"/bans", http.StatusOK, defaultBanPageSize, 3},
+		{"/bans?offset=1&limit=1", http.StatusOK, 1, 1},
+		{"/bans?offset=5", http.StatusOK, defaultBanPageSize, 0},
+		{"/bans?limit=5000", http.StatusOK, maxBanPageSize, 3},
+		{"/bans?limit=-1", http.StatusBadRequest, 0, 0},
+		{"/bans?offset=x", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp banListResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if resp.Total != 3 || resp.Limit != tt.wantLimit || len(resp.Bans) != tt.wantLen {
+				t.Errorf("Got total=%d limit=%d bans=%d, want total=3 limit=%d bans=%d",
+					resp.Total, resp.Limit, len(resp.Bans), tt.wantLimit, tt.wantLen)
+			}
+		})
+	}
+}
+
+func TestServer_StateExportImport(t *testing.T) {
+	source := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	defer source.Stop()
+	source.BanIP("10.0.0.1")
+	source.RecordFailure("10.0.0.2")
+	source.RecordFailure("10.0.0.2")
+
+	rec := httptest.NewRecorder()
+	NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithBans(source)).Handler().
+		ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/state/export", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from export, got %d", rec.Code)
+	}
+
+	// Add records the import must skip: malformed, expired and an invalid IP
+	var snapshot map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("Failed to decode snapshot: %v", err)
+	}
+	expired := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	snapshot["bans"] = append(snapshot["bans"].([]interface{}),
+		"not a record",
+		map[string]interface{}{"ip": "10.0.0.3", "expires_at": expired, "fail_count": 3},
+		map[string]interface{}{"ip": "not-an-ip", "fail_count": 1},
+	)
+	body, _ := json.Marshal(snapshot)
+
+	target := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	defer target.Stop()
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithBans(target))
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/state/import", strings.NewReader(string(body))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from import, got %d", rec.Code)
+	}
+
+	var resp importResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if resp.Imported != 2 || resp.Skipped != 3 {
+		t.Errorf("Got imported=%d skipped=%d, want imported=2 skipped=3", resp.Imported, resp.Skipped)
+	}
+	if !target.IsBanned("10.0.0.1") {
+		t.Error("Expected the exported ban to be imported")
+	}
+	if got := target.GetFailureCount("10.0.0.2"); got != 2 {
+		t.Errorf("Expected 2 imported failures, got %d", got)
+	}
+	if target.IsBanned("10.0.0.3") {
+		t.Error("Expected the expired ban to be skipped")
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/state/import", strings.NewReader("[")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed body, got %d", rec.Code)
+	}
+}
+
+func TestServer_Targets(t *testing.T) {
+	targets := manager.NewTargetStats(10)
+	targets.RecordConnection("a.example")
+	targets.RecordConnection("a.example")
+	targets.RecordConnection("b.example")
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithTargetStats(targets))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantHosts  []string
+	}{
+		{"/targets", http.StatusOK, []string{"a.example", "b.example"}},
+		{"/targets?limit=1", http.StatusOK, []string{"a.example"}},
+		{"/targets?limit=-1", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var got []manager.TargetStat
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			var hosts []string
+			for _, stat := range got {
+				hosts = append(hosts, stat.Host)
+			}
+			if !reflect.DeepEqual(hosts, tt.wantHosts) {
+				t.Errorf("Expected hosts %v, got %v", tt.wantHosts, hosts)
+			}
+		})
+	}
+}
+
+func TestServer_TargetConns(t *testing.T) {
+	limiter := manager.NewConnLimiter(10)
+	limiter.Acquire("a.example")
+	limiter.Acquire("a.example")
+	limiter.Acquire("b.example")
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithTargetConns(limiter))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/targets/active?limit=1", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+
+	var got []manager.ConnCount
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := []manager.ConnCount{{Key: "a.example", Active: 2}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+func TestServer_Feeds(t *testing.T) {
+	bans := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	defer bans.Stop()
+	bans.SetFeed("drop", manager.FeedBlock, []netip.Prefix{netip.MustParsePrefix("198.51.100.0/24")})
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithBans(bans))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bans/198.51.100.9", nil))
+	var ban banResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &ban); err != nil {
+		t.Fatalf("Failed to decode ban: %v", err)
+	}
+	if !ban.Banned || ban.Source != "drop" || ban.Reason != manager.BanReasonFeed || ban.ExpiresAt != nil {
+		t.Errorf("Unexpected feed ban: %+v", ban)
+	}
+
+	tests := []struct {
+		method     string
+		path       string
+		wantStatus int
+	}{
+		{http.MethodGet, "/feeds", http.StatusOK},
+		{http.MethodDelete, "/feeds/drop", http.StatusOK},
+		{http.MethodDelete, "/feeds/drop", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.wantStatus, rec.Code)
+		}
+	}
+
+	if bans.IsBanned("198.51.100.9") {
+		t.Error("Expected the feed to be cleared")
+	}
+}
+
+func TestServer_BansDisabled(t *testing.T) {
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/bans/10.0.0.1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a ban manager, got %d", rec.Code)
+	}
+}
+
+func TestServer_Dashboard(t *testing.T) {
+	now := time.Now()
+	stats := Stats{
+		Connections: []manager.ConnInfo{
+			{ID: 7, Protocol: "socks5", ClientIP: "10.0.0.1", Username: "<alice>", Target: "example.com:443", StartedAt: now, BytesDown: 3 << 20},
+		},
+		Bans:           []manager.BanRecord{{IP: "10.0.0.9", Reason: manager.BanReasonScan, ExpiresAt: now.Add(time.Hour)}},
+		CircuitBreaker: BreakerStats{Enabled: true, State: "open"},
+		RateLimit:      RateLimitStats{GlobalRejections: 12, PerIPRejections: 34},
+	}
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithDashboard(func() Stats { return stats }))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected HTML content type, got %q", ct)
+	}
+
+	body := rec.Body.String()
+	for _, want := range []string{"example.com:443", "&lt;alice&gt;", "3.0 MiB", "10.0.0.9", "scan", `class="open"`, "12 / 34", `http-equiv="refresh"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Dashboard missing %q", want)
+		}
+	}
+
+	// Disabled without a stats source
+	s = NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"))
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without a stats source, got %d", rec.Code)
+	}
+}
+
+func TestServer_DrainUser(t *testing.T) {
+	auth := middleware.NewAuthMiddleware(true, map[string]string{"alice": "pass", "bob": "pass"})
+	conns := manager.NewConnRegistry()
+
+	// Like the proxies, a canceled connection unregisters itself
+	closed := 0
+	register := func(username string) {
+		var tracked *manager.TrackedConn
+		tracked = conns.Register(1, "socks5", "10.0.0.1", username, "example.com:443", func() {
+			closed++
+			tracked.Close()
+		})
+	}
+	register("alice")
+	register("alice")
+	register("bob")
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithUserDrain(conns, auth))
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantClosed int
+	}{
+		{"drain alice", `{"username":"alice"}`, http.StatusOK, 2},
+		{"already drained", `{"username":"alice"}`, http.StatusNotFound, 0},
+		{"missing username", `{}`, http.StatusBadRequest, 0},
+		{"malformed body", `alice`, http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/users/drain", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var resp drainResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if !resp.Removed || resp.ConnectionsClosed != tt.wantClosed {
+				t.Errorf("Unexpected response: %+v", resp)
+			}
+		})
+	}
+
+	if closed != 2 || conns.Count() != 1 {
+		t.Errorf("Expected only alice's 2 connections to be canceled, got %d", closed)
+	}
+	if auth.Authenticate("alice", "pass") || !auth.Authenticate("bob", "pass") {
+		t.Error("Only alice's credentials should be revoked")
+	}
+}
+
+func TestServer_DeepCheck(t *testing.T) {
+	runs := 0
+	healthy := true
+	run := func(ctx context.Context) []CheckResult {
+		runs++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("Expected the probe context to have a deadline")
+		}
+		return []CheckResult{{Name: "http", OK: true}, {Name: "socks5", OK: healthy}}
+	}
+
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithDeepCheck(run, time.Hour, time.Second))
+
+	get := func() (int, deepCheckResponse) {
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/deepcheck", nil))
+		var resp deepCheckResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return rec.Code, resp
+	}
+
+	if code, resp := get(); code != http.StatusOK || resp.Status != "ok" || len(resp.Checks) != 2 {
+		t.Errorf("Unexpected healthy response: %d %+v", code, resp)
+	}
+
+	// Cached results are reused
+	healthy = false
+	get()
+	if runs != 1 {
+		t.Errorf("Expected 1 probe run within the cache TTL, got %d", runs)
+	}
+
+	s = NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithDeepCheck(run, 0, time.Second))
+	if code, resp := get(); code != http.StatusServiceUnavailable || resp.Status != "fail" {
+		t.Errorf("Unexpected failing response: %d %+v", code, resp)
+	}
+}
+
+// fakeListener records whether it is paused
+type fakeListener struct {
+	paused bool
+}
+
+func (l *fakeListener) Pause()       { l.paused = true }
+func (l *fakeListener) Resume()      { l.paused = false }
+func (l *fakeListener) Paused() bool { return l.paused }
+
+func TestServer_ListenerControl(t *testing.T) {
+	httpListener := &fakeListener{}
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"),
+		WithListenerControl(map[string]Pausable{"http": httpListener, "socks5": &fakeListener{}}))
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantPaused bool
+	}{
+		{"pause", "/listeners/http/pause", http.StatusOK, true},
+		{"pause again", "/listeners/http/pause", http.StatusOK, true},
+		{"unknown protocol", "/listeners/ftp/pause", http.StatusNotFound, true},
+		{"resume", "/listeners/http/resume", http.StatusOK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, tt.path, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if httpListener.paused != tt.wantPaused {
+				t.Errorf("Expected paused = %v, got %v", tt.wantPaused, httpListener.paused)
+			}
+		})
+	}
+
+	t.Run("reported in info", func(t *testing.T) {
+		httpListener.Pause()
+
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/info", nil))
+
+		var info Info
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatalf("Failed to decode info: %v", err)
+		}
+		if !info.Protocols[0].Paused || info.Protocols[1].Paused {
+			t.Errorf("Unexpected paused state: %+v", info.Protocols)
+		}
+	})
+}
+
+func TestServer_DebugIPs(t *testing.T) {
+	debugIPs, err := manager.NewDebugIPs([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatalf("NewDebugIPs() error = %v", err)
+	}
+	s := NewServer("127.0.0.1:0", NewInfo(testConfig(), "1.2.3"), WithDebugIPs(debugIPs))
+
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		wantStatus int
+		wantIPs    []string
+	}{
+		{"list", http.MethodGet, "", http.StatusOK, []string{"10.0.0.1"}},
+		{"replace", http.MethodPut, `{"ips":["10.0.0.2","172.16.0.0/12"]}`, http.StatusOK, []string{"10.0.0.2", "172.16.0.0/12"}},
+		{"invalid entry", http.MethodPut, `{"ips":["nope"]}`, http.StatusBadRequest, nil},
+		{"malformed body", http.MethodPut, `nope`, http.StatusBadRequest, nil},
+		{"clear", http.MethodPut, `{"ips":[]}`, http.StatusOK, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, httptest.NewRequest(tt.method, "/debug-ips", strings.NewReader(tt.body)))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tt.wantIPs == nil {
+				return
+			}
+
+			var body debugIPsBody
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatalf("Failed to decode body: %v", err)
+			}
+			if !reflect.DeepEqual(body.IPs, tt.wantIPs) {
+				t.Errorf("Expected IPs %v, got %v", tt.wantIPs, body.IPs)
+			}
+		})
+	}
+}
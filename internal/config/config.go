@@ -1,32 +1,406 @@
 package config
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/textproto"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Server         ServerConfig         `json:"server"`
-	Auth           AuthConfig           `json:"auth"`
-	IPBan          IPBanConfig          `json:"ip_ban"`
-	RateLimit      RateLimitConfig      `json:"rate_limit"`
-	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker"`
-	Log            LogConfig            `json:"log"`
+	Server           ServerConfig           `json:"server"`
+	HTTP             HTTPConfig             `json:"http"`
+	SOCKS5           SOCKS5Config           `json:"socks5"`
+	TLS              TLSConfig              `json:"tls"`
+	Auth             AuthConfig             `json:"auth"`
+	IPBan            IPBanConfig            `json:"ip_ban"`
+	RateLimit        RateLimitConfig        `json:"rate_limit"`
+	CircuitBreaker   CircuitBreakerConfig   `json:"circuit_breaker"`
+	TargetBreaker    CircuitBreakerConfig   `json:"target_circuit_breaker"` // Per-target breaker gating outbound dials
+	ScanDetection    ScanDetectionConfig    `json:"scan_detection"`
+	SSRFGuard        SSRFGuardConfig        `json:"ssrf_guard"`
+	TargetStats      TargetStatsConfig      `json:"target_stats"`
+	EventStream      EventStreamConfig      `json:"event_stream"`
+	EventQueue       EventQueueConfig       `json:"event_queue"`
+	UserLog          UserLogConfig          `json:"user_log"`
+	HostOverrides    map[string]string      `json:"host_overrides"` // Hostname or *.domain -> ip or ip:port dialed instead of resolving it
+	Upstream         UpstreamConfig         `json:"upstream"`
+	TargetCategories TargetCategoriesConfig `json:"target_categories"`
+	Admin            AdminConfig            `json:"admin"`
+	Log              LogConfig              `json:"log"`
+	Startup          StartupConfig          `json:"startup"`
 }
 
 // ServerConfig contains server-related settings
 type ServerConfig struct {
-	HTTPPort   int    `json:"http_port"`
-	SOCKS5Port int    `json:"socks5_port"`
-	Network    string `json:"network"` // 网络类型: "tcp" (自动), "tcp4" (仅IPv4), "tcp6" (仅IPv6)
+	HTTPPort                 int      `json:"http_port"`
+	SOCKS5Port               int      `json:"socks5_port"`
+	Network                  string   `json:"network"`                    // 网络类型: "tcp" (自动), "tcp4" (仅IPv4), "tcp6" (仅IPv6)
+	DialTimeoutSeconds       int      `json:"dial_timeout_seconds"`       // Timeout for connecting to targets
+	ConnectionTimeoutSeconds int      `json:"connection_timeout_seconds"` // Max lifetime of a client connection, 0 means unlimited
+	MaxHandshakes            int      `json:"max_handshakes"`             // Max connections in the handshake phase per listener, 0 means unlimited
+	HandshakeQueueSize       int      `json:"handshake_queue_size"`       // Connections that wait for a handshake slot instead of being closed, 0 disables the queue
+	HandshakeQueuePolicy     string   `json:"handshake_queue_policy"`     // Who is turned away when the queue is full: "drop-newest", "drop-oldest" or "reject"
+	HandshakeTimeoutSeconds  int      `json:"handshake_timeout_seconds"`  // Time a SOCKS5 client has to send its greeting
+	AuthTimeoutSeconds       int      `json:"auth_timeout_seconds"`       // Time a client has to send complete credentials when auth is enabled
+	HTTPListen               []string `json:"http_listen"`                // HTTP proxy address:port endpoints, overrides http_port
+	SOCKS5Listen             []string `json:"socks5_listen"`              // SOCKS5 proxy address:port endpoints, overrides socks5_port
+	SourceIPs                []string `json:"source_ips"`                 // Local addresses for target connections, used round-robin
+	ReusePort                bool     `json:"reuse_port"`                 // Set SO_REUSEPORT on the proxy listeners for zero-downtime restarts
+	RejectWithRST            bool     `json:"reject_with_rst"`            // Reset connections rejected for bans, rate limits or the circuit breaker instead of closing them gracefully
+}
+
+// SourceIPAddrs returns the parsed source_ips, nil when unset. Call after Validate.
+func (s ServerConfig) SourceIPAddrs() []net.IP {
+	if len(s.SourceIPs) == 0 {
+		return nil
+	}
+	ips := make([]net.IP, 0, len(s.SourceIPs))
+	for _, addr := range s.SourceIPs {
+		ips = append(ips, net.ParseIP(addr))
+	}
+	return ips
+}
+
+// HTTPListenAddresses returns the HTTP proxy endpoints, defaulting to http_port on all interfaces
+func (s ServerConfig) HTTPListenAddresses() []string {
+	return listenAddresses(s.HTTPListen, s.HTTPPort)
+}
+
+// SOCKS5ListenAddresses returns the SOCKS5 proxy endpoints, defaulting to socks5_port on all interfaces
+func (s ServerConfig) SOCKS5ListenAddresses() []string {
+	return listenAddresses(s.SOCKS5Listen, s.SOCKS5Port)
+}
+
+// listenAddresses returns listen, or the port on all interfaces when it is empty
+func listenAddresses(listen []string, port int) []string {
+	if len(listen) > 0 {
+		return listen
+	}
+	return []string{fmt.Sprintf(":%d", port)}
+}
+
+// validateListenAddress checks that addr is a host:port endpoint with a valid port
+func validateListenAddress(addr string) error {
+	_, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid listen address %q: port must be between 1 and 65535", addr)
+	}
+	return nil
+}
+
+// validateHostOverride checks a host_overrides rule: host is a hostname or a
+// "*.domain" pattern, address an IP optionally followed by a port
+func validateHostOverride(host, address string) error {
+	if host == "" || strings.Contains(host, ":") || strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return fmt.Errorf("invalid host_overrides host %q (must be a hostname or *.domain)", host)
+	}
+
+	ip, port, err := net.SplitHostPort(address)
+	if err != nil {
+		ip, port = address, ""
+	}
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid host_overrides address for %s: %q (must be ip or ip:port)", host, address)
+	}
+	if n, err := strconv.Atoi(port); port != "" && (err != nil || n <= 0 || n > 65535) {
+		return fmt.Errorf("invalid host_overrides address for %s: %q: port must be between 1 and 65535", host, address)
+	}
+	return nil
+}
+
+// validateSourceIP checks that addr is an IP address assigned to a local
+// interface and usable with network
+func validateSourceIP(addr, network string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid source IP %q", addr)
+	}
+	if (network == "tcp4" && ip.To4() == nil) || (network == "tcp6" && ip.To4() != nil) {
+		return fmt.Errorf("source IP %s can't be used with network %s", addr, network)
+	}
+
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	for _, a := range ifaceAddrs {
+		if ipNet, ok := a.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("source IP %s is not assigned to a local interface", addr)
+}
+
+// HTTPConfig contains HTTP proxy specific settings
+type HTTPConfig struct {
+	LandingStatus    int                  `json:"landing_status"`     // Status returned to non-proxy requests such as "GET /"
+	LandingBody      string               `json:"landing_body"`       // Body returned to non-proxy requests
+	Transparent      bool                 `json:"transparent"`        // Forward origin-form requests to their Host header instead of rejecting them
+	Compress         bool                 `json:"compress"`           // Gzip compressible responses for clients that accept it
+	ConnectOnly      bool                 `json:"connect_only"`       // Refuse plain HTTP requests with a 403, allowing only CONNECT tunnels
+	Upstream         UpstreamProxyConfig  `json:"upstream"`           // Bridge requests to an upstream proxy instead of dialing targets directly
+	SOCKS5Upstream   UpstreamProxyConfig  `json:"socks5_upstream"`    // Deprecated: alias of upstream, copied into it by Validate
+	MaxResponseBytes int64                `json:"max_response_bytes"` // Cut off plain HTTP responses larger than this, 0 means unlimited
+	Realm            *string              `json:"realm"`              // Realm advertised in 407 responses, unset means "DuDu Proxy", empty sends realm=""
+	ServerHeader     string               `json:"server_header"`      // Server header on responses the proxy generates itself, empty omits it
+	ErrorFormat      string               `json:"error_format"`       // "text" or "json" bodies for errors the proxy generates
+	ConnectFailure   ConnectFailureConfig `json:"connect_failure"`    // Response sent when a CONNECT target cannot be reached
+	RateLimit        *RateLimitConfig     `json:"rate_limit"`         // Replaces the top-level rate_limit for this listener, unset shares it
+	IPBan            *IPBanConfig         `json:"ip_ban"`             // Replaces the top-level ip_ban for this listener, unset shares it
+	Intercept        InterceptConfig      `json:"intercept"`          // Decrypt HTTPS in CONNECT tunnels to filter requests
+
+	ReadTimeoutSeconds  int `json:"read_timeout_seconds"`  // Close a tunnel whose client sends nothing for this long, 0 means unlimited
+	WriteTimeoutSeconds int `json:"write_timeout_seconds"` // Close a tunnel when a write to its client blocks this long, 0 means unlimited
+}
+
+// InterceptConfig contains settings for HTTPS interception, which decrypts
+// CONNECT tunnels with certificates issued by a local CA that clients trust
+type InterceptConfig struct {
+	Enabled          bool     `json:"enabled"`
+	CACertFile       string   `json:"ca_cert_file"`       // PEM CA certificate that issues the certificates shown to clients
+	CAKeyFile        string   `json:"ca_key_file"`        // PEM private key of the CA
+	BypassHosts      []string `json:"bypass_hosts"`       // Hosts tunneled without interception, "*.example.com" for subdomains
+	BlockURLPrefixes []string `json:"block_url_prefixes"` // Requests whose host/path starts with one of these get a 403
+	CertCacheSize    int      `json:"cert_cache_size"`    // Issued certificates kept for reuse
+}
+
+// ConnectFailureConfig describes the response sent when a CONNECT target
+// cannot be reached
+type ConnectFailureConfig struct {
+	Status  int               `json:"status"`
+	Body    string            `json:"body"`
+	Headers map[string]string `json:"headers"` // Extra response headers; Content-Type replaces text/plain
+}
+
+// defaultRealm is advertised in 407 responses when no realm is configured
+const defaultRealm = "DuDu Proxy"
+
+// GetRealm returns the realm advertised in 407 responses
+func (h HTTPConfig) GetRealm() string {
+	if h.Realm != nil {
+		return *h.Realm
+	}
+	return defaultRealm
+}
+
+// UpstreamProxyConfig describes the upstream proxy that HTTP requests are
+// bridged to: a SOCKS5 proxy, or an HTTP proxy with the http and https schemes
+type UpstreamProxyConfig struct {
+	Address            string `json:"address"` // host:port or scheme://host:port, empty disables bridging
+	Username           string `json:"username"`
+	Password           string `json:"password"`
+	CAFile             string `json:"ca_file"`              // PEM CAs trusted for TLS upstreams, empty uses the system roots
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // Don't verify a TLS upstream's certificate
+}
+
+// upstreamSchemes are the accepted upstream address schemes, mapped to
+// whether the connection to the upstream uses TLS
+var upstreamSchemes = map[string]bool{
+	"socks5":     false,
+	"socks5+tls": true,
+	"http":       false,
+	"https":      true,
+}
+
+// Endpoint returns the scheme of Address, socks5 when it has none, and its
+// host:port
+func (u UpstreamProxyConfig) Endpoint() (scheme, address string) {
+	scheme, address, ok := strings.Cut(u.Address, "://")
+	if !ok {
+		return "socks5", u.Address
+	}
+	return strings.ToLower(scheme), strings.TrimSuffix(address, "/")
+}
+
+// UsesTLS reports whether the connection to the upstream is wrapped in TLS
+func (u UpstreamProxyConfig) UsesTLS() bool {
+	scheme, _ := u.Endpoint()
+	return upstreamSchemes[scheme]
+}
+
+// IsHTTP reports whether the upstream is an HTTP proxy
+func (u UpstreamProxyConfig) IsHTTP() bool {
+	scheme, _ := u.Endpoint()
+	return scheme == "http" || scheme == "https"
+}
+
+// validate checks the address, credentials and TLS settings
+func (u UpstreamProxyConfig) validate() error {
+	scheme, address := u.Endpoint()
+	if _, ok := upstreamSchemes[scheme]; !ok {
+		return fmt.Errorf("invalid http upstream scheme: %s (must be socks5, socks5+tls, http or https)", scheme)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return fmt.Errorf("invalid http upstream address: %s (must be host:port or scheme://host:port)", u.Address)
+	}
+	if !u.IsHTTP() && (len(u.Username) > 255 || len(u.Password) > 255) {
+		return fmt.Errorf("http upstream username and password must be at most 255 bytes")
+	}
+	if !u.UsesTLS() && (u.CAFile != "" || u.InsecureSkipVerify) {
+		return fmt.Errorf("http upstream ca_file and insecure_skip_verify require the socks5+tls or https scheme")
+	}
+	return nil
+}
+
+// SOCKS5Config contains SOCKS5 proxy specific settings
+type SOCKS5Config struct {
+	EnableResolveExtension bool   `json:"enable_resolve_extension"` // Answer Tor RESOLVE (0xF0) / RESOLVE_PTR (0xF1) commands
+	PreserveSourcePort     bool   `json:"preserve_source_port"`     // Dial targets from the client's source port, falling back to an ephemeral one
+	ReplyAddressMode       string `json:"reply_address_mode"`       // "real" bound address or IPv4 "zeros" in CONNECT replies
+	PolicyDenyReplyCode    int    `json:"policy_deny_reply_code"`   // Reply code (1-8) for targets the SSRF guard rejects
+	ReadTimeoutSeconds     int    `json:"read_timeout_seconds"`     // Close a tunnel whose client sends nothing for this long, 0 means unlimited
+	WriteTimeoutSeconds    int    `json:"write_timeout_seconds"`    // Close a tunnel when a write to its client blocks this long, 0 means unlimited
+
+	RateLimit *RateLimitConfig `json:"rate_limit"` // Replaces the top-level rate_limit for this listener, unset shares it
+	IPBan     *IPBanConfig     `json:"ip_ban"`     // Replaces the top-level ip_ban for this listener, unset shares it
+}
+
+// TLSConfig contains settings for terminating TLS on the proxy listeners
+type TLSConfig struct {
+	Enabled       bool             `json:"enabled"`
+	Listeners     []string         `json:"listeners"`      // Listeners serving TLS: "http", "socks5"
+	Certificates  []TLSCertificate `json:"certificates"`   // Selected by SNI; the first one is the default
+	MinVersion    string           `json:"min_version"`    // "1.2" or "1.3"
+	CipherSuites  []string         `json:"cipher_suites"`  // Go cipher suite names for TLS 1.2, empty uses Go's secure defaults
+	LogHandshakes bool             `json:"log_handshakes"` // Log each handshake's version, cipher suite and SNI at info instead of debug
+	ClientCAFile  string           `json:"client_ca_file"` // PEM CAs that client certificates must chain to, empty doesn't request one
+}
+
+// tlsVersions maps the accepted min_version values to their protocol versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// MinTLSVersion returns the minimum protocol version. Call after Validate.
+func (t TLSConfig) MinTLSVersion() uint16 {
+	return tlsVersions[t.MinVersion]
+}
+
+// CipherSuiteIDs returns the configured cipher suites, nil for Go's defaults.
+// Call after Validate.
+func (t TLSConfig) CipherSuiteIDs() []uint16 {
+	if len(t.CipherSuites) == 0 {
+		return nil
+	}
+
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+
+	ids := make([]uint16, 0, len(t.CipherSuites))
+	for _, name := range t.CipherSuites {
+		ids = append(ids, byName[name])
+	}
+	return ids
+}
+
+// validateCipherSuites rejects names that aren't secure TLS 1.2 suites known to Go
+func (t TLSConfig) validateCipherSuites() error {
+	if len(t.CipherSuites) > 0 && t.MinVersion == "1.3" {
+		return fmt.Errorf("tls cipher_suites cannot be set with min_version 1.3, whose suites are not configurable")
+	}
+
+	for _, name := range t.CipherSuites {
+		supported := false
+		for _, suite := range tls.CipherSuites() {
+			if suite.Name == name && slices.Contains(suite.SupportedVersions, tls.VersionTLS12) {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return fmt.Errorf("unknown or insecure tls cipher suite: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// TLSCertificate is a certificate/key pair in PEM files
+type TLSCertificate struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+// ServesTLS reports whether TLS is enabled for the named listener ("http" or "socks5")
+func (t TLSConfig) ServesTLS(listener string) bool {
+	if !t.Enabled {
+		return false
+	}
+	for _, l := range t.Listeners {
+		if l == listener {
+			return true
+		}
+	}
+	return false
 }
 
 // AuthConfig contains authentication settings
 type AuthConfig struct {
-	Enabled bool   `json:"enabled"`
-	Users   []User `json:"users"`
+	Enabled       bool    `json:"enabled"`
+	HTTP          *bool   `json:"http"`   // Overrides enabled for the HTTP proxy when set
+	SOCKS5        *bool   `json:"socks5"` // Overrides enabled for the SOCKS5 proxy when set
+	Users         []User  `json:"users"`
+	Tokens        []Token `json:"tokens"`         // Bearer tokens accepted by the HTTP proxy
+	AnonymousUser string  `json:"anonymous_user"` // Username logged for connections when auth is disabled
+
+	MinPasswordLength     int  `json:"min_password_length"`     // Refuse to start with a shorter plaintext password, 0 disables
+	RejectCommonPasswords bool `json:"reject_common_passwords"` // Refuse to start with a plaintext password on the common password list
+
+	FailureDelayMs           int `json:"failure_delay_ms"`            // Delay added to an IP's failed auth responses per recent failure, 0 disables
+	MaxFailureDelayMs        int `json:"max_failure_delay_ms"`        // Cap on the delay of one response
+	FailureDelayResetSeconds int `json:"failure_delay_reset_seconds"` // An IP's failures are forgotten after this long without one
+
+	RotationGraceSeconds int `json:"rotation_grace_seconds"` // Keep accepting credentials replaced by a reload for this long, 0 disables
+
+	MaxConnsPerUser int `json:"max_conns_per_user"` // Simultaneous connections allowed per authenticated user across both listeners, 0 disables
+
+	RequireCertUsernameMatch bool `json:"require_cert_username_match"` // On TLS listeners, the username must equal the client certificate's common name
+
+	MaxAttemptsPerConn int `json:"max_attempts_per_conn"` // Failed HTTP authentications allowed on one connection before it is closed
+
+	Anonymous AnonymousLimitsConfig `json:"anonymous"` // Stricter limits for connections on listeners with auth disabled
+}
+
+// AnonymousLimitsConfig holds the limits applied, on top of a listener's own,
+// to connections that didn't authenticate
+type AnonymousLimitsConfig struct {
+	PerIPRequestsPerSecond int   `json:"per_ip_requests_per_second"` // Connections per second allowed per anonymous client IP, 0 disables
+	MaxConns               int   `json:"max_conns"`                  // Simultaneous anonymous connections across both listeners, 0 disables
+	AllowedPorts           []int `json:"allowed_ports"`              // Target ports anonymous clients may reach, empty allows any
+}
+
+// EnabledFor reports whether the named listener ("http" or "socks5") requires authentication
+func (a AuthConfig) EnabledFor(listener string) bool {
+	override := a.HTTP
+	if listener == "socks5" {
+		override = a.SOCKS5
+	}
+	if override != nil {
+		return *override
+	}
+	return a.Enabled
+}
+
+// AnyEnabled reports whether at least one listener requires authentication
+func (a AuthConfig) AnyEnabled() bool {
+	return a.EnabledFor("http") || a.EnabledFor("socks5")
 }
 
 // User represents a proxy user
@@ -35,12 +409,44 @@ type User struct {
 	Password string `json:"password"`
 }
 
+// Token maps an HTTP bearer token to the user identity it authenticates as
+type Token struct {
+	Token     string     `json:"token"`
+	Username  string     `json:"username"`
+	ExpiresAt *time.Time `json:"expires_at"` // RFC 3339; the token is refused and its tunnels closed from then on, nil never expires
+}
+
 // IPBanConfig contains IP ban settings
 type IPBanConfig struct {
-	Enabled            bool     `json:"enabled"`
-	MaxFailures        int      `json:"max_failures"`
-	BanDurationSeconds int      `json:"ban_duration_seconds"`
-	Whitelist          []string `json:"whitelist"`
+	Enabled              bool             `json:"enabled"`
+	MaxFailures          int              `json:"max_failures"`
+	BanDurationSeconds   int              `json:"ban_duration_seconds"`
+	Whitelist            []string         `json:"whitelist"`
+	WindowMode           bool             `json:"window_mode"`            // Count failures within a sliding window instead of cumulatively
+	FailureWindowSeconds int              `json:"failure_window_seconds"` // Sliding window size, used when window_mode is enabled
+	FailureDecaySeconds  int              `json:"failure_decay_seconds"`  // Without window_mode, forget one failure per this many seconds without failures, 0 disables
+	MaxTrackedIPs        int              `json:"max_tracked_ips"`        // Max failing IPs tracked before the least recent is evicted
+	Feeds                []IPFeedConfig   `json:"feeds"`                  // External blocklists and allow-lists merged with local bans
+	SaveRetries          int              `json:"save_retries"`           // Retries of a failed save of the ban state, with backoff
+	ReverseDNS           ReverseDNSConfig `json:"reverse_dns"`            // Allow or block clients by hostname once the IP rules miss
+}
+
+// ReverseDNSConfig contains rules matching clients by the hostname of their
+// IP's PTR record, confirmed by resolving it back to the IP
+type ReverseDNSConfig struct {
+	Enabled      bool     `json:"enabled"`
+	Allow        []string `json:"allow"`         // Hostname patterns exempt from bans, e.g. "*.corp.example.com"
+	Block        []string `json:"block"`         // Hostname patterns rejected as if banned
+	CacheSeconds int      `json:"cache_seconds"` // How long a lookup result is reused
+	TimeoutMs    int      `json:"timeout_ms"`    // Bound on resolving one client IP
+}
+
+// IPFeedConfig describes an external list of IPs and CIDRs, such as a threat feed
+type IPFeedConfig struct {
+	Name           string `json:"name"`            // Reported as the source of bans it causes
+	Kind           string `json:"kind"`            // "block" (default) bans listed addresses, "allow" exempts them from bans
+	Location       string `json:"location"`        // File path or http(s) URL, one IP or CIDR per line
+	RefreshSeconds int    `json:"refresh_seconds"` // Reload this often, 0 loads once at startup
 }
 
 // RateLimitConfig contains rate limiting settings
@@ -48,6 +454,45 @@ type RateLimitConfig struct {
 	Enabled                 bool `json:"enabled"`
 	GlobalRequestsPerSecond int  `json:"global_requests_per_second"`
 	PerIPRequestsPerSecond  int  `json:"per_ip_requests_per_second"`
+	FairQueuing             bool `json:"fair_queuing"`           // Share a saturated global limit across client IPs round-robin instead of first come first served
+	FairQueueMaxWaitMs      int  `json:"fair_queue_max_wait_ms"` // How long a request may wait for its turn before being rejected
+	IdleTimeoutSeconds      int  `json:"idle_timeout_seconds"`   // Per-IP limiters unused for this long are evicted
+	WarnTrackedIPs          int  `json:"warn_tracked_ips"`       // Log a warning when more IPs are tracked, 0 disables
+	BanThreshold            int  `json:"ban_threshold"`          // Ban an IP after more per-IP rejections than this within the ban window, 0 disables
+	BanWindowSeconds        int  `json:"ban_window_seconds"`
+	BudgetConnections       int  `json:"budget_connections"`    // Admit at most this many connections per budget window across the proxy, 0 disables
+	BudgetWindowSeconds     int  `json:"budget_window_seconds"` // Length of the sliding budget window
+
+	PerIPBytesPerSecond   int64 `json:"per_ip_bytes_per_second"`   // Throughput shared by a client IP's connections, 0 disables
+	PerUserBytesPerSecond int64 `json:"per_user_bytes_per_second"` // Throughput shared by an authenticated user's connections, 0 disables
+
+	Adaptive AdaptiveRateLimitConfig `json:"adaptive"` // Scale per-IP limits by each IP's recent failures and bans
+
+	Backend string               `json:"backend"` // "local" (default) or "redis" to share limits across instances
+	Redis   RateLimitRedisConfig `json:"redis"`
+}
+
+// AdaptiveRateLimitConfig maps an IP's recent behavior to a multiplier of its
+// per-IP rate limit
+type AdaptiveRateLimitConfig struct {
+	Enabled          bool    `json:"enabled"`
+	CleanMultiplier  float64 `json:"clean_multiplier"`   // For IPs with no failures and no recent ban
+	FailurePenalty   float64 `json:"failure_penalty"`    // A failing IP's limit is divided by 1 + failure_penalty * failures
+	BannedMultiplier float64 `json:"banned_multiplier"`  // Cap for IPs banned within ban_memory_seconds
+	BanMemorySeconds int     `json:"ban_memory_seconds"` // How long after a ban an IP still counts as banned
+	RecomputeSeconds int     `json:"recompute_seconds"`  // How often an IP's multiplier is recomputed
+}
+
+// RateLimitRedisConfig describes the Redis server holding shared rate limits
+type RateLimitRedisConfig struct {
+	Address   string `json:"address"` // host:port
+	Password  string `json:"password"`
+	DB        int    `json:"db"`
+	KeyPrefix string `json:"key_prefix"`
+	TimeoutMs int    `json:"timeout_ms"` // Per command; exceeding it counts as a failure
+
+	HealthCheckIntervalSeconds int    `json:"health_check_interval_seconds"` // PING interval, and how long a lost server is given up on before retrying
+	FailMode                   string `json:"fail_mode"`                     // "open" (default) allows requests while Redis fails, "closed" rejects them
 }
 
 // CircuitBreakerConfig contains circuit breaker settings
@@ -57,13 +502,180 @@ type CircuitBreakerConfig struct {
 	WindowSizeSeconds       int  `json:"window_size_seconds"`
 	MinRequests             int  `json:"min_requests"`
 	BreakDurationSeconds    int  `json:"break_duration_seconds"`
+	HalfOpenSuccesses       int  `json:"half_open_successes"`         // Consecutive successes that close a half-open circuit
+	HalfOpenProbes          int  `json:"half_open_probes"`            // Requests admitted per probe interval while half-open, 0 admits all
+	HalfOpenProbeIntervalMs int  `json:"half_open_probe_interval_ms"` // Spacing between batches of half-open probes
+	HalfOpenSuccessSeconds  int  `json:"half_open_success_seconds"`   // Minimum half-open time of uninterrupted success before closing
+	ShadowMode              bool `json:"shadow_mode"`                 // Track state and log transitions without rejecting requests
+	OffenderPercent         int  `json:"offender_percent"`            // When the circuit opens, count an IP ban failure for an IP causing at least this share of failures, 0 disables
+}
+
+// ScanDetectionConfig contains settings for detecting clients that scan many targets
+type ScanDetectionConfig struct {
+	Enabled            bool `json:"enabled"`
+	MaxDistinctTargets int  `json:"max_distinct_targets"` // Distinct host:port targets allowed per window
+	WindowSeconds      int  `json:"window_seconds"`
+	BanImmediately     bool `json:"ban_immediately"` // Ban right away instead of counting an auth failure
+}
+
+// SSRFGuardConfig contains settings for rejecting targets that resolve to internal addresses
+type SSRFGuardConfig struct {
+	Enabled bool     `json:"enabled"`
+	Allow   []string `json:"allow"` // IPs or CIDRs exempt from the guard
+}
+
+// TargetStatsConfig contains settings for tracking the most popular target hosts
+type TargetStatsConfig struct {
+	Enabled              bool `json:"enabled"`
+	Capacity             int  `json:"capacity"`               // Max hosts tracked, the least popular is replaced when full
+	DecayIntervalSeconds int  `json:"decay_interval_seconds"` // Halve every count this often, 0 disables decay
+	LogIntervalSeconds   int  `json:"log_interval_seconds"`   // Log the top hosts this often, 0 disables
+	LogTop               int  `json:"log_top"`                // Hosts included in each log entry
+}
+
+// EventStreamConfig contains settings for streaming connection events over a Unix socket
+type EventStreamConfig struct {
+	Enabled    bool   `json:"enabled"`
+	SocketPath string `json:"socket_path"` // Unix socket clients connect to
+	BufferSize int    `json:"buffer_size"` // Events queued per client before new ones are dropped
+}
+
+// EventQueueConfig contains settings for publishing connection events to a NATS server
+type EventQueueConfig struct {
+	Enabled    bool   `json:"enabled"`
+	Address    string `json:"address"`     // NATS server host:port
+	Subject    string `json:"subject"`     // Subject events are published on
+	Format     string `json:"format"`      // "json" or "cloudevents"
+	Username   string `json:"username"`    // Optional credentials
+	Password   string `json:"password"`    // Sent with username
+	BufferSize int    `json:"buffer_size"` // Events queued while the server is slow or unreachable before new ones are dropped
+}
+
+// StartupConfig contains settings applied once the proxy has started
+type StartupConfig struct {
+	ReadyNotify ReadyNotifyConfig `json:"ready_notify"`
+}
+
+// ReadyNotifyConfig describes how deployment tooling is told the proxy is
+// ready, once both listeners are bound
+type ReadyNotifyConfig struct {
+	Systemd               bool   `json:"systemd"`                 // Send READY=1 to $NOTIFY_SOCKET, for Type=notify units
+	File                  string `json:"file"`                    // Write this file, removed again on shutdown
+	WebhookURL            string `json:"webhook_url"`             // POST a JSON notice to this http(s) URL
+	WebhookTimeoutSeconds int    `json:"webhook_timeout_seconds"` // Bound on the webhook request
+	Probe                 bool   `json:"probe"`                   // Wait for a deep check to admin.probe_target to pass first
+}
+
+// UserLogConfig contains settings for writing each user's connections to
+// their own log file
+type UserLogConfig struct {
+	Enabled      bool   `json:"enabled"`
+	Directory    string `json:"directory"`      // Directory holding the per-user files
+	FileName     string `json:"file_name"`      // File name pattern, "{user}" is replaced by the username
+	MaxOpenFiles int    `json:"max_open_files"` // Files kept open at once, least recently written ones are closed first
+}
+
+// UpstreamConfig contains settings for connections the proxy opens to targets
+type UpstreamConfig struct {
+	SendProxyProtocol string `json:"send_proxy_protocol"`  // "v1" or "v2" to send the client's address to targets, empty disables
+	MaxConnsPerTarget int    `json:"max_conns_per_target"` // Simultaneous connections allowed to each target host across both listeners, 0 disables
+}
+
+// TargetCategoriesConfig contains settings for tagging connections with a
+// category derived from their target
+type TargetCategoriesConfig struct {
+	Enabled bool                 `json:"enabled"`
+	Rules   []TargetCategoryRule `json:"rules"`   // Checked in order, the first match names the category
+	Default string               `json:"default"` // Category of targets no rule matches
+}
+
+// TargetCategoryRule assigns Name to targets whose port is in Ports and whose
+// host matches Hosts; an empty list matches anything
+type TargetCategoryRule struct {
+	Name  string   `json:"name"`
+	Ports []string `json:"ports"` // Ports or ranges, e.g. "22" or "8000-8999"
+	Hosts []string `json:"hosts"` // Hostnames, "*.example.com" for subdomains, or "*"
+}
+
+// validate checks the rules when target categories are enabled
+func (t TargetCategoriesConfig) validate() error {
+	if !t.Enabled {
+		return nil
+	}
+	if len(t.Rules) == 0 {
+		return fmt.Errorf("target_categories requires at least one rule when enabled")
+	}
+
+	for i, rule := range t.Rules {
+		if rule.Name == "" {
+			return fmt.Errorf("target_categories rule %d has no name", i)
+		}
+		if len(rule.Ports) == 0 && len(rule.Hosts) == 0 {
+			return fmt.Errorf("target_categories rule %q needs ports or hosts", rule.Name)
+		}
+		for _, ports := range rule.Ports {
+			if !validPortRange(ports) {
+				return fmt.Errorf("invalid target_categories port %q in rule %q (must be a port or a range like 8000-8999)", ports, rule.Name)
+			}
+		}
+		for _, host := range rule.Hosts {
+			if host == "" || (host != "*" && strings.Contains(strings.TrimPrefix(host, "*."), "*")) {
+				return fmt.Errorf("invalid target_categories host %q in rule %q (wildcards must be \"*\" or lead as \"*.\")", host, rule.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// validPortRange reports whether s is a port or an ascending range of ports
+func validPortRange(s string) bool {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if !isRange {
+		hi = lo
+	}
+	first, err1 := strconv.Atoi(lo)
+	last, err2 := strconv.Atoi(hi)
+	return err1 == nil && err2 == nil && first >= 1 && first <= last && last <= 65535
+}
+
+// ProxyProtocolVersion returns the PROXY protocol version to send, 0 when disabled
+func (u UpstreamConfig) ProxyProtocolVersion() int {
+	switch u.SendProxyProtocol {
+	case "v1":
+		return 1
+	case "v2":
+		return 2
+	default:
+		return 0
+	}
+}
+
+// AdminConfig contains settings for the health/admin HTTP server
+type AdminConfig struct {
+	Enabled             bool   `json:"enabled"`
+	Address             string `json:"address"`               // Listen address, e.g. "127.0.0.1:9090"
+	Dashboard           bool   `json:"dashboard"`             // Serve the HTML status page at /dashboard
+	ProbeTarget         string `json:"probe_target"`          // host:port tunneled to by /deepcheck, empty disables it
+	ProbeUsername       string `json:"probe_username"`        // User /deepcheck authenticates as on listeners requiring auth
+	ProbePassword       string `json:"probe_password"`        // Password sent with probe_username
+	ProbeCacheSeconds   int    `json:"probe_cache_seconds"`   // How long a /deepcheck result is reused
+	ProbeTimeoutSeconds int    `json:"probe_timeout_seconds"` // Bound on a /deepcheck run
 }
 
 // LogConfig contains logging settings
 type LogConfig struct {
-	Level  string `json:"level"`
-	Driver string `json:"driver"`
-	Path   string `json:"path"`
+	Level             string   `json:"level"`
+	Driver            string   `json:"driver"`
+	Path              string   `json:"path"`
+	Format            string   `json:"format"`              // "console", "json" or "logfmt"
+	SampleInitial     int      `json:"sample_initial"`      // Repeated rejection logs kept per message each second, 0 disables sampling
+	SampleThereafter  int      `json:"sample_thereafter"`   // After that, keep every Nth; 0 drops the rest of the second
+	LogFullURL        bool     `json:"log_full_url"`        // Log HTTP request paths and query strings at info instead of only at debug
+	DebugIPs          []string `json:"debug_ips"`           // Client IPs or CIDRs whose connections log debug messages whatever the level
+	NoBanner          bool     `json:"no_banner"`           // Don't print the startup banner to stdout
+	IncludeClientPort bool     `json:"include_client_port"` // Add the client's source port to connection logs as client_port
+
+	RuntimeStatsIntervalSeconds int `json:"runtime_stats_interval_seconds"` // Log goroutine and open file descriptor counts this often, 0 disables
 }
 
 // Load reads and parses the configuration file
@@ -102,52 +714,800 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("invalid network type: %s (must be tcp, tcp4, or tcp6)", c.Server.Network)
 	}
 
-	if c.Server.HTTPPort <= 0 || c.Server.HTTPPort > 65535 {
+	// 设置默认的目标连接超时
+	if c.Server.DialTimeoutSeconds == 0 {
+		c.Server.DialTimeoutSeconds = 10
+	}
+	if c.Server.DialTimeoutSeconds < 0 {
+		return fmt.Errorf("dial_timeout_seconds must not be negative")
+	}
+
+	// 设置默认的握手超时
+	if c.Server.HandshakeTimeoutSeconds == 0 {
+		c.Server.HandshakeTimeoutSeconds = 10
+	}
+	if c.Server.HandshakeTimeoutSeconds < 0 {
+		return fmt.Errorf("handshake_timeout_seconds must not be negative")
+	}
+
+	// 设置默认的认证超时
+	if c.Server.AuthTimeoutSeconds == 0 {
+		c.Server.AuthTimeoutSeconds = 10
+	}
+	if c.Server.AuthTimeoutSeconds < 0 {
+		return fmt.Errorf("auth_timeout_seconds must not be negative")
+	}
+
+	if c.Server.ConnectionTimeoutSeconds < 0 {
+		return fmt.Errorf("connection_timeout_seconds must not be negative")
+	}
+
+	if c.Server.MaxHandshakes < 0 {
+		return fmt.Errorf("max_handshakes must not be negative")
+	}
+
+	// 设置握手队列的默认溢出策略
+	if c.Server.HandshakeQueuePolicy == "" {
+		c.Server.HandshakeQueuePolicy = "drop-newest"
+	}
+	switch c.Server.HandshakeQueuePolicy {
+	case "drop-newest", "drop-oldest", "reject":
+	default:
+		return fmt.Errorf("invalid handshake_queue_policy: %s (must be drop-newest, drop-oldest or reject)", c.Server.HandshakeQueuePolicy)
+	}
+	if c.Server.HandshakeQueueSize < 0 {
+		return fmt.Errorf("handshake_queue_size must not be negative")
+	}
+	if c.Server.HandshakeQueueSize > 0 && c.Server.MaxHandshakes == 0 {
+		return fmt.Errorf("handshake_queue_size requires max_handshakes")
+	}
+
+	if len(c.Server.HTTPListen) == 0 && (c.Server.HTTPPort <= 0 || c.Server.HTTPPort > 65535) {
 		return fmt.Errorf("invalid HTTP port: %d", c.Server.HTTPPort)
 	}
-	if c.Server.SOCKS5Port <= 0 || c.Server.SOCKS5Port > 65535 {
+	if len(c.Server.SOCKS5Listen) == 0 && (c.Server.SOCKS5Port <= 0 || c.Server.SOCKS5Port > 65535) {
 		return fmt.Errorf("invalid SOCKS5 port: %d", c.Server.SOCKS5Port)
 	}
+	for _, addr := range append(append([]string{}, c.Server.HTTPListen...), c.Server.SOCKS5Listen...) {
+		if err := validateListenAddress(addr); err != nil {
+			return err
+		}
+	}
+	for _, addr := range c.Server.SourceIPs {
+		if err := validateSourceIP(addr, c.Server.Network); err != nil {
+			return err
+		}
+	}
+
+	if c.HTTP.MaxResponseBytes < 0 {
+		return fmt.Errorf("max_response_bytes must not be negative")
+	}
+
+	// socks5_upstream 是 upstream 的旧名称
+	if c.HTTP.SOCKS5Upstream.Address != "" {
+		if c.HTTP.Upstream.Address != "" {
+			return fmt.Errorf("http upstream and socks5_upstream are mutually exclusive (socks5_upstream is a deprecated alias of upstream)")
+		}
+		c.HTTP.Upstream = c.HTTP.SOCKS5Upstream
+	}
+	if c.HTTP.Upstream.Address != "" {
+		if err := c.HTTP.Upstream.validate(); err != nil {
+			return err
+		}
+	}
+
+	// 设置非代理请求的默认响应
+	if c.HTTP.LandingStatus == 0 {
+		c.HTTP.LandingStatus = 400
+	}
+	if c.HTTP.LandingBody == "" {
+		c.HTTP.LandingBody = "Bad Request: this is a proxy"
+	}
+	if c.HTTP.LandingStatus < 100 || c.HTTP.LandingStatus > 599 {
+		return fmt.Errorf("invalid landing_status: %d", c.HTTP.LandingStatus)
+	}
+	if !isHeaderValue(c.HTTP.GetRealm()) || !isHeaderValue(c.HTTP.ServerHeader) {
+		return fmt.Errorf("realm and server_header must not contain control characters")
+	}
+
+	// 设置代理生成的错误响应的默认格式
+	if c.HTTP.ErrorFormat == "" {
+		c.HTTP.ErrorFormat = "text"
+	}
+	if c.HTTP.ErrorFormat != "text" && c.HTTP.ErrorFormat != "json" {
+		return fmt.Errorf("invalid error_format: %s (must be text or json)", c.HTTP.ErrorFormat)
+	}
+
+	// 设置 CONNECT 目标不可达时的默认响应
+	if c.HTTP.ConnectFailure.Status == 0 {
+		c.HTTP.ConnectFailure.Status = 502
+	}
+	if c.HTTP.ConnectFailure.Body == "" {
+		c.HTTP.ConnectFailure.Body = "Failed to connect to target"
+	}
+	if c.HTTP.ConnectFailure.Status < 200 || c.HTTP.ConnectFailure.Status > 599 {
+		return fmt.Errorf("invalid connect_failure status: %d", c.HTTP.ConnectFailure.Status)
+	}
+	for name, value := range c.HTTP.ConnectFailure.Headers {
+		if !isHeaderName(name) || !isHeaderValue(value) {
+			return fmt.Errorf("invalid connect_failure header: %q", name)
+		}
+		switch textproto.CanonicalMIMEHeaderKey(name) {
+		case "Content-Length", "Transfer-Encoding", "Connection":
+			return fmt.Errorf("connect_failure header %s is set by the proxy", name)
+		}
+	}
+
+	if c.HTTP.Intercept.Enabled {
+		if c.HTTP.Intercept.CACertFile == "" || c.HTTP.Intercept.CAKeyFile == "" {
+			return fmt.Errorf("intercept is enabled but ca_cert_file and ca_key_file are not both set")
+		}
+		// 设置 HTTPS 拦截证书缓存的默认大小
+		if c.HTTP.Intercept.CertCacheSize == 0 {
+			c.HTTP.Intercept.CertCacheSize = 1000
+		}
+		if c.HTTP.Intercept.CertCacheSize < 0 {
+			return fmt.Errorf("intercept cert_cache_size must not be negative")
+		}
+	}
+
+	if c.TLS.Enabled {
+		// 默认只对 HTTP 代理启用 TLS
+		if len(c.TLS.Listeners) == 0 {
+			c.TLS.Listeners = []string{"http"}
+		}
+		for _, l := range c.TLS.Listeners {
+			if l != "http" && l != "socks5" {
+				return fmt.Errorf("invalid tls listener: %s (must be http or socks5)", l)
+			}
+		}
+		if len(c.TLS.Certificates) == 0 {
+			return fmt.Errorf("tls is enabled but no certificates are configured")
+		}
+		for i, cert := range c.TLS.Certificates {
+			if cert.CertFile == "" || cert.KeyFile == "" {
+				return fmt.Errorf("tls certificate %d requires cert_file and key_file", i)
+			}
+		}
+
+		// 默认最低 TLS 版本为 1.2
+		if c.TLS.MinVersion == "" {
+			c.TLS.MinVersion = "1.2"
+		}
+		if _, ok := tlsVersions[c.TLS.MinVersion]; !ok {
+			return fmt.Errorf("invalid tls min_version: %s (must be 1.2 or 1.3)", c.TLS.MinVersion)
+		}
+		if err := c.TLS.validateCipherSuites(); err != nil {
+			return err
+		}
+	}
+	if c.Auth.RequireCertUsernameMatch && (!c.TLS.Enabled || c.TLS.ClientCAFile == "") {
+		return fmt.Errorf("require_cert_username_match requires tls to be enabled with a client_ca_file")
+	}
 
-	if c.Auth.Enabled && len(c.Auth.Users) == 0 {
+	if err := c.RateLimit.validate(); err != nil {
+		return err
+	}
+	if c.HTTP.RateLimit != nil {
+		if err := c.HTTP.RateLimit.validate(); err != nil {
+			return fmt.Errorf("http rate_limit: %w", err)
+		}
+	}
+	// 设置 SOCKS5 CONNECT 应答地址的默认模式
+	if c.SOCKS5.ReplyAddressMode == "" {
+		c.SOCKS5.ReplyAddressMode = "real"
+	}
+	if c.SOCKS5.ReplyAddressMode != "real" && c.SOCKS5.ReplyAddressMode != "zeros" {
+		return fmt.Errorf("invalid reply_address_mode: %s (must be real or zeros)", c.SOCKS5.ReplyAddressMode)
+	}
+	if c.HTTP.ReadTimeoutSeconds < 0 || c.HTTP.WriteTimeoutSeconds < 0 ||
+		c.SOCKS5.ReadTimeoutSeconds < 0 || c.SOCKS5.WriteTimeoutSeconds < 0 {
+		return fmt.Errorf("read_timeout_seconds and write_timeout_seconds must not be negative")
+	}
+	// 设置 SSRF 防护拒绝目标时默认的 SOCKS5 应答码（connection not allowed）
+	if c.SOCKS5.PolicyDenyReplyCode == 0 {
+		c.SOCKS5.PolicyDenyReplyCode = 2
+	}
+	if c.SOCKS5.PolicyDenyReplyCode < 1 || c.SOCKS5.PolicyDenyReplyCode > 8 {
+		return fmt.Errorf("invalid policy_deny_reply_code: %d (must be a failure code from 1 to 8)", c.SOCKS5.PolicyDenyReplyCode)
+	}
+	if c.SOCKS5.RateLimit != nil {
+		if err := c.SOCKS5.RateLimit.validate(); err != nil {
+			return fmt.Errorf("socks5 rate_limit: %w", err)
+		}
+	}
+
+	// 设置默认日志格式
+	if c.Log.Format == "" {
+		c.Log.Format = "console"
+	}
+	if c.Log.Format != "console" && c.Log.Format != "json" && c.Log.Format != "logfmt" {
+		return fmt.Errorf("invalid log format: %s (must be console, json, or logfmt)", c.Log.Format)
+	}
+	if c.Log.SampleInitial < 0 || c.Log.SampleThereafter < 0 {
+		return fmt.Errorf("sample_initial and sample_thereafter must not be negative")
+	}
+	if c.Log.RuntimeStatsIntervalSeconds < 0 {
+		return fmt.Errorf("runtime_stats_interval_seconds must not be negative")
+	}
+	for _, entry := range c.Log.DebugIPs {
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("invalid log debug_ips entry: %s (must be an IP or CIDR)", entry)
+			}
+		}
+	}
+
+	// 设置默认管理接口地址
+	if c.Admin.Address == "" {
+		c.Admin.Address = "127.0.0.1:9090"
+	}
+
+	// 设置默认的自检缓存与超时时间
+	if c.Admin.ProbeCacheSeconds == 0 {
+		c.Admin.ProbeCacheSeconds = 10
+	}
+	if c.Admin.ProbeTimeoutSeconds == 0 {
+		c.Admin.ProbeTimeoutSeconds = 5
+	}
+	if c.Admin.ProbeCacheSeconds < 0 || c.Admin.ProbeTimeoutSeconds < 0 {
+		return fmt.Errorf("probe_cache_seconds and probe_timeout_seconds must not be negative")
+	}
+	if c.Admin.ProbeTarget != "" {
+		if _, _, err := net.SplitHostPort(c.Admin.ProbeTarget); err != nil {
+			return fmt.Errorf("invalid admin probe_target: %s (must be host:port)", c.Admin.ProbeTarget)
+		}
+		if c.Auth.AnyEnabled() && (c.Admin.ProbeUsername == "" || c.Admin.ProbePassword == "") {
+			return fmt.Errorf("admin probe_target requires probe_username and probe_password when auth is enabled")
+		}
+	}
+
+	// 设置默认的匿名用户名
+	if c.Auth.AnonymousUser == "" {
+		c.Auth.AnonymousUser = "anonymous"
+	}
+
+	if c.Auth.MinPasswordLength < 0 {
+		return fmt.Errorf("min_password_length must not be negative")
+	}
+
+	// 设置认证失败延迟的默认上限和重置时间
+	if c.Auth.MaxFailureDelayMs == 0 {
+		c.Auth.MaxFailureDelayMs = 5000
+	}
+	if c.Auth.FailureDelayResetSeconds == 0 {
+		c.Auth.FailureDelayResetSeconds = 900
+	}
+	if c.Auth.FailureDelayMs < 0 || c.Auth.MaxFailureDelayMs < 0 || c.Auth.FailureDelayResetSeconds < 0 {
+		return fmt.Errorf("failure_delay_ms, max_failure_delay_ms and failure_delay_reset_seconds must not be negative")
+	}
+	if c.Auth.RotationGraceSeconds < 0 {
+		return fmt.Errorf("rotation_grace_seconds must not be negative")
+	}
+	if c.Auth.MaxConnsPerUser < 0 {
+		return fmt.Errorf("max_conns_per_user must not be negative")
+	}
+
+	// 设置每个连接默认的最大认证尝试次数
+	if c.Auth.MaxAttemptsPerConn == 0 {
+		c.Auth.MaxAttemptsPerConn = 3
+	}
+	if c.Auth.MaxAttemptsPerConn < 0 {
+		return fmt.Errorf("max_attempts_per_conn must not be negative")
+	}
+	if c.Auth.Anonymous.PerIPRequestsPerSecond < 0 || c.Auth.Anonymous.MaxConns < 0 {
+		return fmt.Errorf("anonymous per_ip_requests_per_second and max_conns must not be negative")
+	}
+	for _, port := range c.Auth.Anonymous.AllowedPorts {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid anonymous allowed_ports entry: %d (must be 1-65535)", port)
+		}
+	}
+	if c.Auth.MaxFailureDelayMs > 30000 {
+		return fmt.Errorf("max_failure_delay_ms must be at most 30000")
+	}
+
+	if c.Auth.AnyEnabled() && len(c.Auth.Users) == 0 && len(c.Auth.Tokens) == 0 {
 		return fmt.Errorf("authentication is enabled but no users are configured")
 	}
 
-	if c.IPBan.Enabled && c.IPBan.MaxFailures <= 0 {
-		return fmt.Errorf("max_failures must be positive when IP ban is enabled")
+	seenUsers := make(map[string]bool, len(c.Auth.Users))
+	for i, user := range c.Auth.Users {
+		if user.Username == "" {
+			return fmt.Errorf("auth user %d must have a username", i)
+		}
+		if seenUsers[user.Username] {
+			return fmt.Errorf("auth user %s is configured more than once", user.Username)
+		}
+		seenUsers[user.Username] = true
+		if c.Auth.AnyEnabled() && user.Password == "" {
+			return fmt.Errorf("auth user %s must have a password", user.Username)
+		}
+		if err := c.Auth.checkPasswordStrength(user.Password); err != nil {
+			return fmt.Errorf("auth user %s: %w", user.Username, err)
+		}
 	}
 
-	if c.IPBan.Enabled && c.IPBan.BanDurationSeconds <= 0 {
-		return fmt.Errorf("ban_duration_seconds must be positive when IP ban is enabled")
+	seenTokens := make(map[string]bool, len(c.Auth.Tokens))
+	for i, token := range c.Auth.Tokens {
+		if token.Token == "" || token.Username == "" {
+			return fmt.Errorf("auth token %d must have a token and a username", i)
+		}
+		if seenTokens[token.Token] {
+			return fmt.Errorf("auth token for user %s is configured more than once", token.Username)
+		}
+		seenTokens[token.Token] = true
+	}
+
+	if err := c.IPBan.validate(); err != nil {
+		return err
+	}
+	if c.HTTP.IPBan != nil {
+		if err := c.HTTP.IPBan.validate(); err != nil {
+			return fmt.Errorf("http ip_ban: %w", err)
+		}
+	}
+	if c.SOCKS5.IPBan != nil {
+		if err := c.SOCKS5.IPBan.validate(); err != nil {
+			return fmt.Errorf("socks5 ip_ban: %w", err)
+		}
+	}
+
+	// 设置熔断器半开状态关闭所需的默认连续成功次数
+	if c.CircuitBreaker.HalfOpenSuccesses == 0 {
+		c.CircuitBreaker.HalfOpenSuccesses = 3
+	}
+	if c.TargetBreaker.HalfOpenSuccesses == 0 {
+		c.TargetBreaker.HalfOpenSuccesses = 3
+	}
+
+	if err := c.CircuitBreaker.validate(); err != nil {
+		return err
+	}
+
+	if err := c.TargetBreaker.validate(); err != nil {
+		return fmt.Errorf("target_circuit_breaker: %w", err)
 	}
 
-	if c.RateLimit.Enabled {
-		if c.RateLimit.GlobalRequestsPerSecond <= 0 {
-			return fmt.Errorf("global_requests_per_second must be positive when rate limit is enabled")
+	if c.ScanDetection.Enabled {
+		if c.ScanDetection.MaxDistinctTargets <= 0 {
+			return fmt.Errorf("max_distinct_targets must be positive when scan detection is enabled")
 		}
-		if c.RateLimit.PerIPRequestsPerSecond <= 0 {
-			return fmt.Errorf("per_ip_requests_per_second must be positive when rate limit is enabled")
+		if c.ScanDetection.WindowSeconds <= 0 {
+			return fmt.Errorf("window_seconds must be positive when scan detection is enabled")
 		}
 	}
 
-	if c.CircuitBreaker.Enabled {
-		if c.CircuitBreaker.FailureThresholdPercent <= 0 || c.CircuitBreaker.FailureThresholdPercent > 100 {
-			return fmt.Errorf("failure_threshold_percent must be between 1 and 100")
+	// 设置热门目标统计的默认容量、衰减周期和日志条数
+	if c.TargetStats.Capacity == 0 {
+		c.TargetStats.Capacity = 1000
+	}
+	if c.TargetStats.DecayIntervalSeconds == 0 {
+		c.TargetStats.DecayIntervalSeconds = 3600
+	}
+	if c.TargetStats.LogTop == 0 {
+		c.TargetStats.LogTop = 10
+	}
+	if c.TargetStats.Capacity < 0 || c.TargetStats.DecayIntervalSeconds < 0 ||
+		c.TargetStats.LogIntervalSeconds < 0 || c.TargetStats.LogTop < 0 {
+		return fmt.Errorf("target_stats settings must not be negative")
+	}
+
+	// 设置事件流的默认套接字路径和每个客户端的缓冲大小
+	if c.EventStream.SocketPath == "" {
+		c.EventStream.SocketPath = "dudu-events.sock"
+	}
+	if c.EventStream.BufferSize == 0 {
+		c.EventStream.BufferSize = 256
+	}
+	if c.EventStream.BufferSize < 0 {
+		return fmt.Errorf("event_stream buffer_size must not be negative")
+	}
+
+	// 设置消息队列的默认主题、格式和缓冲大小
+	if c.EventQueue.Subject == "" {
+		c.EventQueue.Subject = "dudu.events"
+	}
+	if c.EventQueue.Format == "" {
+		c.EventQueue.Format = "json"
+	}
+	if c.EventQueue.BufferSize == 0 {
+		c.EventQueue.BufferSize = 1024
+	}
+	if c.EventQueue.Enabled {
+		if _, _, err := net.SplitHostPort(c.EventQueue.Address); err != nil {
+			return fmt.Errorf("invalid event_queue address: %q (must be host:port)", c.EventQueue.Address)
 		}
-		if c.CircuitBreaker.WindowSizeSeconds <= 0 {
-			return fmt.Errorf("window_size_seconds must be positive")
+	}
+	if strings.ContainsAny(c.EventQueue.Subject, " \t\r\n") {
+		return fmt.Errorf("invalid event_queue subject: %q (must not contain whitespace)", c.EventQueue.Subject)
+	}
+	if c.EventQueue.Format != "json" && c.EventQueue.Format != "cloudevents" {
+		return fmt.Errorf("invalid event_queue format: %s (must be json or cloudevents)", c.EventQueue.Format)
+	}
+	if c.EventQueue.BufferSize < 0 {
+		return fmt.Errorf("event_queue buffer_size must not be negative")
+	}
+
+	// 设置就绪通知 webhook 的默认超时
+	if c.Startup.ReadyNotify.WebhookTimeoutSeconds == 0 {
+		c.Startup.ReadyNotify.WebhookTimeoutSeconds = 5
+	}
+	if c.Startup.ReadyNotify.WebhookTimeoutSeconds < 0 {
+		return fmt.Errorf("ready_notify webhook_timeout_seconds must not be negative")
+	}
+	if webhook := c.Startup.ReadyNotify.WebhookURL; webhook != "" {
+		if u, err := url.Parse(webhook); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid ready_notify webhook_url: %q (must be an http or https URL)", webhook)
 		}
-		if c.CircuitBreaker.MinRequests <= 0 {
-			return fmt.Errorf("min_requests must be positive")
+	}
+	if c.Startup.ReadyNotify.Probe && c.Admin.ProbeTarget == "" {
+		return fmt.Errorf("ready_notify probe requires admin probe_target")
+	}
+
+	for host, address := range c.HostOverrides {
+		if err := validateHostOverride(host, address); err != nil {
+			return err
 		}
-		if c.CircuitBreaker.BreakDurationSeconds <= 0 {
-			return fmt.Errorf("break_duration_seconds must be positive")
+	}
+
+	// 设置按用户记录日志的默认目录、文件名和打开文件数上限
+	if c.UserLog.Directory == "" {
+		c.UserLog.Directory = "logs/users"
+	}
+	if c.UserLog.FileName == "" {
+		c.UserLog.FileName = "{user}.log"
+	}
+	if c.UserLog.MaxOpenFiles == 0 {
+		c.UserLog.MaxOpenFiles = 64
+	}
+	if !strings.Contains(c.UserLog.FileName, "{user}") || strings.ContainsAny(c.UserLog.FileName, `/\`) {
+		return fmt.Errorf("invalid user_log file_name: %s (must contain {user} and no path separators)", c.UserLog.FileName)
+	}
+	if c.UserLog.MaxOpenFiles < 0 {
+		return fmt.Errorf("user_log max_open_files must not be negative")
+	}
+
+	for _, entry := range c.SSRFGuard.Allow {
+		if net.ParseIP(entry) == nil {
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				return fmt.Errorf("invalid ssrf_guard allow entry: %s (must be an IP or CIDR)", entry)
+			}
 		}
 	}
 
+	switch c.Upstream.SendProxyProtocol {
+	case "", "v1", "v2":
+	default:
+		return fmt.Errorf("invalid upstream send_proxy_protocol: %s (must be v1 or v2)", c.Upstream.SendProxyProtocol)
+	}
+	if c.Upstream.MaxConnsPerTarget < 0 {
+		return fmt.Errorf("max_conns_per_target must not be negative")
+	}
+
+	// 设置未匹配任何规则的目标的默认分类
+	if c.TargetCategories.Default == "" {
+		c.TargetCategories.Default = "other"
+	}
+	if err := c.TargetCategories.validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkPasswordStrength rejects a password that is shorter than
+// MinPasswordLength or, with RejectCommonPasswords, on the common password
+// list
+func (a AuthConfig) checkPasswordStrength(password string) error {
+	if password == "" {
+		return nil
+	}
+	if a.MinPasswordLength > 0 && utf8.RuneCountInString(password) < a.MinPasswordLength {
+		return fmt.Errorf("password is shorter than min_password_length (%d)", a.MinPasswordLength)
+	}
+	if a.RejectCommonPasswords && isCommonPassword(password) {
+		return fmt.Errorf("password is a commonly used password")
+	}
 	return nil
 }
 
+// validate checks rate limit settings and fills in their defaults
+func (r *RateLimitConfig) validate() error {
+	// 设置默认的限流器空闲淘汰时间
+	if r.IdleTimeoutSeconds == 0 {
+		r.IdleTimeoutSeconds = 300
+	}
+	if r.IdleTimeoutSeconds < 0 || r.WarnTrackedIPs < 0 {
+		return fmt.Errorf("idle_timeout_seconds and warn_tracked_ips must not be negative")
+	}
+
+	// 设置默认的限流封禁窗口
+	if r.BanWindowSeconds == 0 {
+		r.BanWindowSeconds = 60
+	}
+	if r.BanThreshold < 0 || r.BanWindowSeconds < 0 {
+		return fmt.Errorf("ban_threshold and ban_window_seconds must not be negative")
+	}
+
+	// 设置默认的连接预算窗口
+	if r.BudgetWindowSeconds == 0 {
+		r.BudgetWindowSeconds = 3600
+	}
+	if r.BudgetConnections < 0 || r.BudgetWindowSeconds < 0 {
+		return fmt.Errorf("budget_connections and budget_window_seconds must not be negative")
+	}
+
+	// 设置默认的限流后端
+	if r.Backend == "" {
+		r.Backend = "local"
+	}
+	switch r.Backend {
+	case "local":
+	case "redis":
+		if _, _, err := net.SplitHostPort(r.Redis.Address); err != nil {
+			return fmt.Errorf("invalid rate_limit redis address: %q (must be host:port)", r.Redis.Address)
+		}
+		if r.Redis.DB < 0 || r.Redis.TimeoutMs < 0 {
+			return fmt.Errorf("rate_limit redis db and timeout_ms must not be negative")
+		}
+		// 设置默认的 Redis 键前缀和超时
+		if r.Redis.KeyPrefix == "" {
+			r.Redis.KeyPrefix = "dudu:ratelimit:"
+		}
+		if r.Redis.TimeoutMs == 0 {
+			r.Redis.TimeoutMs = 100
+		}
+		// 设置默认的 Redis 健康检查间隔和故障模式
+		if r.Redis.HealthCheckIntervalSeconds == 0 {
+			r.Redis.HealthCheckIntervalSeconds = 5
+		}
+		if r.Redis.HealthCheckIntervalSeconds < 0 {
+			return fmt.Errorf("rate_limit redis health_check_interval_seconds must not be negative")
+		}
+		if r.Redis.FailMode == "" {
+			r.Redis.FailMode = "open"
+		}
+		if r.Redis.FailMode != "open" && r.Redis.FailMode != "closed" {
+			return fmt.Errorf("invalid rate_limit redis fail_mode: %s (must be open or closed)", r.Redis.FailMode)
+		}
+	default:
+		return fmt.Errorf("invalid rate_limit backend: %s (must be local or redis)", r.Backend)
+	}
+
+	if err := r.Adaptive.validate(r.Backend); err != nil {
+		return err
+	}
+
+	// 设置默认的公平排队最长等待时间
+	if r.FairQueueMaxWaitMs == 0 {
+		r.FairQueueMaxWaitMs = 1000
+	}
+	if r.FairQueueMaxWaitMs < 0 {
+		return fmt.Errorf("fair_queue_max_wait_ms must not be negative")
+	}
+	if r.FairQueuing && r.Backend != "local" {
+		return fmt.Errorf("fair_queuing requires the local rate_limit backend")
+	}
+
+	// A zero rate disables that limit
+	if r.GlobalRequestsPerSecond < 0 || r.PerIPRequestsPerSecond < 0 {
+		return fmt.Errorf("global_requests_per_second and per_ip_requests_per_second must not be negative")
+	}
+	if r.PerIPBytesPerSecond < 0 || r.PerUserBytesPerSecond < 0 {
+		return fmt.Errorf("per_ip_bytes_per_second and per_user_bytes_per_second must not be negative")
+	}
+
+	return nil
+}
+
+// validate checks adaptive rate limit settings and fills in their defaults
+func (a *AdaptiveRateLimitConfig) validate(backend string) error {
+	// 设置默认的自适应限流倍率
+	if a.CleanMultiplier == 0 {
+		a.CleanMultiplier = 2
+	}
+	if a.FailurePenalty == 0 {
+		a.FailurePenalty = 0.5
+	}
+	if a.BannedMultiplier == 0 {
+		a.BannedMultiplier = 0.1
+	}
+	// 设置默认的封禁记忆时长和重新计算间隔
+	if a.BanMemorySeconds == 0 {
+		a.BanMemorySeconds = 3600
+	}
+	if a.RecomputeSeconds == 0 {
+		a.RecomputeSeconds = 30
+	}
+
+	if a.CleanMultiplier < 0 || a.FailurePenalty < 0 || a.BannedMultiplier < 0 {
+		return fmt.Errorf("adaptive clean_multiplier, failure_penalty and banned_multiplier must not be negative")
+	}
+	if a.BanMemorySeconds < 0 || a.BanMemorySeconds > 86400 {
+		return fmt.Errorf("adaptive ban_memory_seconds must be between 1 and 86400")
+	}
+	if a.RecomputeSeconds < 0 {
+		return fmt.Errorf("adaptive recompute_seconds must not be negative")
+	}
+	if a.Enabled && backend != "local" {
+		return fmt.Errorf("adaptive rate limits require the local rate_limit backend")
+	}
+	return nil
+}
+
+// validate checks the hostname patterns, normalizing them to lowercase, and
+// fills in the lookup defaults
+func (r *ReverseDNSConfig) validate() error {
+	// 设置反向 DNS 查询的默认缓存时间和超时
+	if r.CacheSeconds == 0 {
+		r.CacheSeconds = 300
+	}
+	if r.TimeoutMs == 0 {
+		r.TimeoutMs = 1000
+	}
+	if r.CacheSeconds < 0 || r.TimeoutMs < 0 {
+		return fmt.Errorf("reverse_dns cache_seconds and timeout_ms must not be negative")
+	}
+	if r.Enabled && len(r.Allow) == 0 && len(r.Block) == 0 {
+		return fmt.Errorf("reverse_dns is enabled but has no allow or block patterns")
+	}
+
+	for _, patterns := range [][]string{r.Allow, r.Block} {
+		for i, pattern := range patterns {
+			pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+			if !validHostnamePattern(pattern) {
+				return fmt.Errorf("invalid reverse_dns pattern: %q (must be a hostname or *.domain)", patterns[i])
+			}
+			patterns[i] = pattern
+		}
+	}
+	return nil
+}
+
+// validHostnamePattern reports whether pattern is a lowercase hostname,
+// optionally prefixed by "*." to match every name below it
+func validHostnamePattern(pattern string) bool {
+	name := strings.TrimPrefix(pattern, "*.")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validate checks IP ban settings and fills in their defaults
+func (b *IPBanConfig) validate() error {
+	if b.Enabled && b.MaxFailures <= 0 {
+		return fmt.Errorf("max_failures must be positive when IP ban is enabled")
+	}
+
+	if b.Enabled && b.BanDurationSeconds <= 0 {
+		return fmt.Errorf("ban_duration_seconds must be positive when IP ban is enabled")
+	}
+
+	if b.FailureDecaySeconds < 0 {
+		return fmt.Errorf("failure_decay_seconds must not be negative")
+	}
+	if b.WindowMode && b.FailureDecaySeconds > 0 {
+		return fmt.Errorf("failure_decay_seconds cannot be combined with window_mode")
+	}
+
+	// 设置默认的封禁状态保存重试次数
+	if b.SaveRetries == 0 {
+		b.SaveRetries = 3
+	}
+	if b.SaveRetries < 0 {
+		return fmt.Errorf("save_retries must not be negative")
+	}
+
+	// 设置默认的失败 IP 跟踪上限
+	if b.MaxTrackedIPs == 0 {
+		b.MaxTrackedIPs = 100000
+	}
+	if b.MaxTrackedIPs < 0 {
+		return fmt.Errorf("max_tracked_ips must not be negative")
+	}
+
+	if err := b.ReverseDNS.validate(); err != nil {
+		return err
+	}
+
+	feedNames := make(map[string]bool)
+	for i := range b.Feeds {
+		feed := &b.Feeds[i]
+		if feed.Name == "" || feed.Location == "" {
+			return fmt.Errorf("ip_ban feed %d: name and location are required", i)
+		}
+		if feedNames[feed.Name] {
+			return fmt.Errorf("duplicate ip_ban feed name: %s", feed.Name)
+		}
+		feedNames[feed.Name] = true
+
+		// 设置默认的订阅源类型
+		if feed.Kind == "" {
+			feed.Kind = "block"
+		}
+		if feed.Kind != "block" && feed.Kind != "allow" {
+			return fmt.Errorf("invalid ip_ban feed kind: %s (must be block or allow)", feed.Kind)
+		}
+		if feed.RefreshSeconds < 0 {
+			return fmt.Errorf("ip_ban feed %s: refresh_seconds must not be negative", feed.Name)
+		}
+	}
+
+	if b.Enabled && b.WindowMode && b.FailureWindowSeconds <= 0 {
+		return fmt.Errorf("failure_window_seconds must be positive when window mode is enabled")
+	}
+
+	return nil
+}
+
+// validate checks circuit breaker settings when the breaker is enabled
+func (b CircuitBreakerConfig) validate() error {
+	if !b.Enabled {
+		return nil
+	}
+
+	if b.FailureThresholdPercent <= 0 || b.FailureThresholdPercent > 100 {
+		return fmt.Errorf("failure_threshold_percent must be between 1 and 100")
+	}
+	if b.WindowSizeSeconds <= 0 {
+		return fmt.Errorf("window_size_seconds must be positive")
+	}
+	if b.MinRequests <= 0 {
+		return fmt.Errorf("min_requests must be positive")
+	}
+	if b.BreakDurationSeconds <= 0 {
+		return fmt.Errorf("break_duration_seconds must be positive")
+	}
+	if b.HalfOpenSuccesses < 0 || b.HalfOpenProbes < 0 || b.HalfOpenSuccessSeconds < 0 {
+		return fmt.Errorf("half_open_successes, half_open_probes and half_open_success_seconds must not be negative")
+	}
+	if b.HalfOpenProbes > 0 && b.HalfOpenProbeIntervalMs <= 0 {
+		return fmt.Errorf("half_open_probe_interval_ms must be positive when half_open_probes is set")
+	}
+	if b.OffenderPercent < 0 || b.OffenderPercent > 100 {
+		return fmt.Errorf("offender_percent must be between 0 and 100")
+	}
+
+	return nil
+}
+
+// isHeaderName reports whether s is a valid HTTP header field name
+func isHeaderName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// isHeaderValue reports whether s can be sent in an HTTP header without
+// breaking the response
+func isHeaderValue(s string) bool {
+	for _, c := range []byte(s) {
+		if c < 0x20 || c == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
 // GetUserCredentials returns a map of username to password for quick lookup
 func (c *Config) GetUserCredentials() map[string]string {
 	credentials := make(map[string]string)
@@ -156,3 +1516,24 @@ func (c *Config) GetUserCredentials() map[string]string {
 	}
 	return credentials
 }
+
+// GetTokenUsers returns a map of bearer token to the username it authenticates as
+func (c *Config) GetTokenUsers() map[string]string {
+	tokens := make(map[string]string)
+	for _, token := range c.Auth.Tokens {
+		tokens[token.Token] = token.Username
+	}
+	return tokens
+}
+
+// GetTokenExpiry returns a map of bearer token to when it expires, for the
+// tokens that do
+func (c *Config) GetTokenExpiry() map[string]time.Time {
+	expiries := make(map[string]time.Time)
+	for _, token := range c.Auth.Tokens {
+		if token.ExpiresAt != nil {
+			expiries[token.Token] = *token.ExpiresAt
+		}
+	}
+	return expiries
+}
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"crypto/tls"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -78,51 +80,888 @@ func TestLoad(t *testing.T) {
 }
 
 func TestValidate(t *testing.T) {
+	enabled := true
+
 	tests := []struct {
 		name    string
 		config  Config
 		wantErr bool
 	}{
 		{
-			name: "valid config",
+			name: "valid config",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{{"user1", "pass1"}}},
+				IPBan:  IPBanConfig{Enabled: true, MaxFailures: 3, BanDurationSeconds: 300},
+				RateLimit: RateLimitConfig{
+					Enabled:                 true,
+					GlobalRequestsPerSecond: 1000,
+					PerIPRequestsPerSecond:  10,
+				},
+				CircuitBreaker: CircuitBreakerConfig{
+					Enabled:                 true,
+					FailureThresholdPercent: 50,
+					WindowSizeSeconds:       60,
+					MinRequests:             20,
+					BreakDurationSeconds:    30,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid http port",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 0, SOCKS5Port: 1080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid socks5 port",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 70000},
+			},
+			wantErr: true,
+		},
+		{
+			name: "auth enabled with no users",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "auth enabled with tokens only",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Tokens: []Token{{Token: "tok-1", Username: "api"}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "per-IP rate limit disabled",
+			config: Config{
+				Server:    ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{Enabled: true, GlobalRequestsPerSecond: 100},
+			},
+			wantErr: false,
+		},
+		{
+			name: "redis rate limit backend",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{
+					Enabled:                true,
+					PerIPRequestsPerSecond: 10,
+					Backend:                "redis",
+					Redis:                  RateLimitRedisConfig{Address: "127.0.0.1:6379"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "redis rate limit backend without address",
+			config: Config{
+				Server:    ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{Enabled: true, PerIPRequestsPerSecond: 10, Backend: "redis"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "adaptive ban memory beyond the history kept",
+			config: Config{
+				Server:    ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{Enabled: true, PerIPRequestsPerSecond: 10, Adaptive: AdaptiveRateLimitConfig{Enabled: true, BanMemorySeconds: 86401}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "fair queuing with redis backend",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{Enabled: true, GlobalRequestsPerSecond: 10, FairQueuing: true, Backend: "redis",
+					Redis: RateLimitRedisConfig{Address: "127.0.0.1:6379"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "reverse dns patterns",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan: IPBanConfig{ReverseDNS: ReverseDNSConfig{
+					Enabled: true,
+					Allow:   []string{"*.Corp.Example.com."},
+					Block:   []string{"scanner.example.net"},
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "reverse dns pattern with inner wildcard",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{ReverseDNS: ReverseDNSConfig{Enabled: true, Allow: []string{"build*.example.com"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "reverse dns without patterns",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{ReverseDNS: ReverseDNSConfig{Enabled: true}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ready webhook without scheme",
+			config: Config{
+				Server:  ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Startup: StartupConfig{ReadyNotify: ReadyNotifyConfig{WebhookURL: "deploy.example.com/ready"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ready probe without probe target",
+			config: Config{
+				Server:  ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Startup: StartupConfig{ReadyNotify: ReadyNotifyConfig{Systemd: true, Probe: true}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "probe target with auth but no probe credentials",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{{"user1", "pass1"}}},
+				Admin:  AdminConfig{ProbeTarget: "example.com:443"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "probe target with auth and probe credentials",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{{"user1", "pass1"}}},
+				Admin:  AdminConfig{ProbeTarget: "example.com:443", ProbeUsername: "user1", ProbePassword: "pass1"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "ready notify",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Admin:  AdminConfig{ProbeTarget: "example.com:443"},
+				Startup: StartupConfig{ReadyNotify: ReadyNotifyConfig{
+					Systemd:    true,
+					WebhookURL: "https://deploy.example.com/ready",
+					Probe:      true,
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid redis fail mode",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{
+					Enabled:                true,
+					PerIPRequestsPerSecond: 10,
+					Backend:                "redis",
+					Redis:                  RateLimitRedisConfig{Address: "127.0.0.1:6379", FailMode: "ajar"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown rate limit backend",
+			config: Config{
+				Server:    ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{Enabled: true, PerIPRequestsPerSecond: 10, Backend: "memcached"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "server header with line break",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{ServerHeader: "nginx\r\nX-Injected: 1"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ip ban feed without location",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{Feeds: []IPFeedConfig{{Name: "drop"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ip ban feed with unknown kind",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{Feeds: []IPFeedConfig{{Name: "drop", Kind: "deny", Location: "drop.txt"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate ip ban feed names",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan: IPBanConfig{Feeds: []IPFeedConfig{
+					{Name: "drop", Location: "drop.txt"},
+					{Name: "drop", Kind: "allow", Location: "allow.txt"},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative rate limit",
+			config: Config{
+				Server:    ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				RateLimit: RateLimitConfig{Enabled: true, GlobalRequestsPerSecond: -1, PerIPRequestsPerSecond: 10},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listener rate limit override",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{RateLimit: &RateLimitConfig{Enabled: true, PerIPRequestsPerSecond: 5}},
+				SOCKS5: SOCKS5Config{RateLimit: &RateLimitConfig{}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid listener rate limit override",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				SOCKS5: SOCKS5Config{RateLimit: &RateLimitConfig{Enabled: true, PerIPRequestsPerSecond: -1}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid listener ip ban override",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{IPBan: &IPBanConfig{Enabled: true}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max response bytes",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{MaxResponseBytes: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "host overrides",
+			config: Config{
+				Server:        ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HostOverrides: map[string]string{"foo.internal": "10.0.0.5:8080", "*.blue.test": "2001:db8::1"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "host override to a hostname",
+			config: Config{
+				Server:        ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HostOverrides: map[string]string{"foo.internal": "backend.internal"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "host override with misplaced wildcard",
+			config: Config{
+				Server:        ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HostOverrides: map[string]string{"foo.*.internal": "10.0.0.5"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid socks5 reply address mode",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				SOCKS5: SOCKS5Config{ReplyAddressMode: "ipv6"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid error format",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{ErrorFormat: "xml"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid policy deny reply code",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				SOCKS5: SOCKS5Config{PolicyDenyReplyCode: 9},
+			},
+			wantErr: true,
+		},
+		{
+			name: "failure decay with window mode",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{WindowMode: true, FailureWindowSeconds: 600, FailureDecaySeconds: 3600},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative failure decay",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{FailureDecaySeconds: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "event queue without address",
+			config: Config{
+				Server:     ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				EventQueue: EventQueueConfig{Enabled: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "event queue with unknown format",
+			config: Config{
+				Server:     ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				EventQueue: EventQueueConfig{Enabled: true, Address: "127.0.0.1:4222", Format: "avro"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "event queue",
+			config: Config{
+				Server:     ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				EventQueue: EventQueueConfig{Enabled: true, Address: "127.0.0.1:4222", Format: "cloudevents"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "cert username match without client certificates",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{RequireCertUsernameMatch: true},
+				TLS: TLSConfig{
+					Enabled:      true,
+					Certificates: []TLSCertificate{{CertFile: "proxy.crt", KeyFile: "proxy.key"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cert username match with client certificates",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{RequireCertUsernameMatch: true},
+				TLS: TLSConfig{
+					Enabled:      true,
+					Certificates: []TLSCertificate{{CertFile: "proxy.crt", KeyFile: "proxy.key"}},
+					ClientCAFile: "clients.pem",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "negative max conns per target",
+			config: Config{
+				Server:   ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Upstream: UpstreamConfig{MaxConnsPerTarget: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max conns per user",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{MaxConnsPerUser: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative max attempts per conn",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{MaxAttemptsPerConn: -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative anonymous max conns",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Anonymous: AnonymousLimitsConfig{MaxConns: -1}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid anonymous allowed port",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Anonymous: AnonymousLimitsConfig{AllowedPorts: []int{443, 70000}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "user log file name without placeholder",
+			config: Config{
+				Server:  ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				UserLog: UserLogConfig{FileName: "access.log"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "user log file name with path separator",
+			config: Config{
+				Server:  ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				UserLog: UserLogConfig{FileName: "{user}/access.log"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "intercept without CA key",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{Intercept: InterceptConfig{Enabled: true, CACertFile: "ca.crt"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "intercept with negative cert cache size",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP: HTTPConfig{Intercept: InterceptConfig{
+					Enabled: true, CACertFile: "ca.crt", CAKeyFile: "ca.key", CertCacheSize: -1,
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "intercept with CA",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{Intercept: InterceptConfig{Enabled: true, CACertFile: "ca.crt", CAKeyFile: "ca.key"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid upstream address",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{Upstream: UpstreamProxyConfig{Address: "upstream"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "https upstream",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP: HTTPConfig{Upstream: UpstreamProxyConfig{
+					Address: "https://proxy.example.com:443", CAFile: "ca.pem",
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown upstream scheme",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{Upstream: UpstreamProxyConfig{Address: "socks4://proxy.example.com:1080"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream TLS settings without a TLS scheme",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP: HTTPConfig{Upstream: UpstreamProxyConfig{
+					Address: "socks5://proxy.example.com:1080", InsecureSkipVerify: true,
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "deprecated socks5_upstream alias",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{SOCKS5Upstream: UpstreamProxyConfig{Address: "proxy.example.com:1080"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "upstream and socks5_upstream both set",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP: HTTPConfig{
+					Upstream:       UpstreamProxyConfig{Address: "proxy.example.com:1080"},
+					SOCKS5Upstream: UpstreamProxyConfig{Address: "other.example.com:1080"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "loopback source IP",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, SourceIPs: []string{"127.0.0.1"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "source IP not on a local interface",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, SourceIPs: []string{"192.0.2.1"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid source IP",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, SourceIPs: []string{"eth0"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "IPv6 source IP with tcp4",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, Network: "tcp4", SourceIPs: []string{"::1"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "socks5 auth override with no users",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{SOCKS5: &enabled},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate username",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{{"user1", "pass1"}, {"user1", "pass2"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty username",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{{"", "pass1"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty password with auth enabled",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Users: []User{{"user1", ""}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty password with auth disabled",
 			config: Config{
 				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
-				Auth:   AuthConfig{Enabled: true, Users: []User{{"user1", "pass1"}}},
-				IPBan:  IPBanConfig{Enabled: true, MaxFailures: 3, BanDurationSeconds: 300},
-				RateLimit: RateLimitConfig{
+				Auth:   AuthConfig{Users: []User{{"user1", ""}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "token without username",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Tokens: []Token{{Token: "tok-1"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate token",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth:   AuthConfig{Enabled: true, Tokens: []Token{{Token: "tok-1", Username: "a"}, {Token: "tok-1", Username: "b"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "half-open probes without interval",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				CircuitBreaker: CircuitBreakerConfig{
 					Enabled:                 true,
-					GlobalRequestsPerSecond: 1000,
-					PerIPRequestsPerSecond:  10,
+					FailureThresholdPercent: 50,
+					WindowSizeSeconds:       60,
+					MinRequests:             20,
+					BreakDurationSeconds:    30,
+					HalfOpenProbes:          1,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "weak password",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Auth: AuthConfig{
+					Enabled:               true,
+					Users:                 []User{{Username: "admin", Password: "changeme"}},
+					RejectCommonPasswords: true,
 				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "connect failure header set by the proxy",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{ConnectFailure: ConnectFailureConfig{Headers: map[string]string{"content-length": "0"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid connect failure header name",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				HTTP:   HTTPConfig{ConnectFailure: ConnectFailureConfig{Headers: map[string]string{"X Bad": "1"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "offender percent out of range",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
 				CircuitBreaker: CircuitBreakerConfig{
 					Enabled:                 true,
 					FailureThresholdPercent: 50,
 					WindowSizeSeconds:       60,
 					MinRequests:             20,
 					BreakDurationSeconds:    30,
+					OffenderPercent:         101,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "window mode without window size",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				IPBan:  IPBanConfig{Enabled: true, MaxFailures: 3, BanDurationSeconds: 300, WindowMode: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls without certificates",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls with unknown listener",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS: TLSConfig{
+					Enabled:      true,
+					Listeners:    []string{"ftp"},
+					Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}},
 				},
 			},
+			wantErr: true,
+		},
+		{
+			name: "tls 1.3 minimum",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, MinVersion: "1.3"},
+			},
 			wantErr: false,
 		},
 		{
-			name: "invalid http port",
+			name: "tls unknown min version",
 			config: Config{
-				Server: ServerConfig{HTTPPort: 0, SOCKS5Port: 1080},
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, MinVersion: "1.1"},
 			},
 			wantErr: true,
 		},
 		{
-			name: "invalid socks5 port",
+			name: "tls cipher suites",
 			config: Config{
-				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 70000},
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "tls unknown cipher suite",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, CipherSuites: []string{"TLS_FAKE"}},
 			},
 			wantErr: true,
 		},
 		{
-			name: "auth enabled with no users",
+			name: "tls insecure cipher suite",
 			config: Config{
 				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
-				Auth:   AuthConfig{Enabled: true, Users: []User{}},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls 1.3 suite name",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, CipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "tls cipher suites with 1.3 minimum",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TLS:    TLSConfig{Enabled: true, Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}}, MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listen addresses replace ports",
+			config: Config{
+				Server: ServerConfig{
+					HTTPListen:   []string{"127.0.0.1:8080", "[::1]:8080"},
+					SOCKS5Listen: []string{"0.0.0.0:1080"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "listen address without port",
+			config: Config{
+				Server: ServerConfig{HTTPListen: []string{"127.0.0.1"}, SOCKS5Port: 1080},
+			},
+			wantErr: true,
+		},
+		{
+			name: "listen address with invalid port",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Listen: []string{"127.0.0.1:0"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ssrf guard with invalid allow entry",
+			config: Config{
+				Server:    ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				SSRFGuard: SSRFGuardConfig{Enabled: true, Allow: []string{"10.0.0.0/8", "not-an-ip"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "handshake queue",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, MaxHandshakes: 100, HandshakeQueueSize: 50, HandshakeQueuePolicy: "drop-oldest"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "handshake queue without max handshakes",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, HandshakeQueueSize: 50},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown handshake queue policy",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080, MaxHandshakes: 100, HandshakeQueueSize: 50, HandshakeQueuePolicy: "lifo"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "target categories",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true, Rules: []TargetCategoryRule{{Name: "web", Ports: []string{"80", "8000-8999"}, Hosts: []string{"*.example.com", "*"}}}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "target categories without rules",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "target category rule without name",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true, Rules: []TargetCategoryRule{{Ports: []string{"22"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "target category rule without ports or hosts",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true, Rules: []TargetCategoryRule{{Name: "any"}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "descending target category port range",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true, Rules: []TargetCategoryRule{{Name: "web", Ports: []string{"8999-8000"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "target category port out of range",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true, Rules: []TargetCategoryRule{{Name: "web", Ports: []string{"65536"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mid-name target category wildcard",
+			config: Config{
+				Server:           ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				TargetCategories: TargetCategoriesConfig{Enabled: true, Rules: []TargetCategoryRule{{Name: "web", Hosts: []string{"www.*.com"}}}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid debug IP",
+			config: Config{
+				Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Log:    LogConfig{DebugIPs: []string{"10.0.0.1", "10.0.0.0/33"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "proxy protocol v2",
+			config: Config{
+				Server:   ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Upstream: UpstreamConfig{SendProxyProtocol: "v2"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "unknown proxy protocol version",
+			config: Config{
+				Server:   ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+				Upstream: UpstreamConfig{SendProxyProtocol: "v3"},
 			},
 			wantErr: true,
 		},
@@ -207,3 +1046,130 @@ func BenchmarkValidate(b *testing.B) {
 		_ = cfg.Validate()
 	}
 }
+
+func TestTLSConfig_Defaults(t *testing.T) {
+	cfg := Config{
+		Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+		TLS: TLSConfig{
+			Enabled:      true,
+			Certificates: []TLSCertificate{{CertFile: "a.crt", KeyFile: "a.key"}},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if cfg.TLS.MinTLSVersion() != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2 minimum by default, got %x", cfg.TLS.MinTLSVersion())
+	}
+	if cfg.TLS.CipherSuiteIDs() != nil {
+		t.Error("Expected Go's default cipher suites when none are configured")
+	}
+
+	cfg.TLS.CipherSuites = []string{"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"}
+	if ids := cfg.TLS.CipherSuiteIDs(); len(ids) != 1 || ids[0] != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("Unexpected cipher suite IDs: %v", ids)
+	}
+}
+
+func TestListenerOverrides_Defaults(t *testing.T) {
+	cfg := Config{
+		Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+		HTTP: HTTPConfig{
+			RateLimit: &RateLimitConfig{Enabled: true, PerIPRequestsPerSecond: 5},
+			IPBan:     &IPBanConfig{Enabled: true, MaxFailures: 3, BanDurationSeconds: 60},
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	// Overrides get the same defaults as the top-level sections
+	if got := cfg.HTTP.RateLimit.IdleTimeoutSeconds; got != cfg.RateLimit.IdleTimeoutSeconds || got != 300 {
+		t.Errorf("Expected the override's idle timeout to default to 300, got %d", got)
+	}
+	if got := cfg.HTTP.RateLimit.Backend; got != "local" {
+		t.Errorf("Expected the override's backend to default to local, got %q", got)
+	}
+	if got := cfg.HTTP.IPBan.MaxTrackedIPs; got != 100000 {
+		t.Errorf("Expected the override's max_tracked_ips to default to 100000, got %d", got)
+	}
+	if cfg.SOCKS5.RateLimit != nil || cfg.SOCKS5.IPBan != nil {
+		t.Error("Expected listeners without overrides to keep sharing the top-level sections")
+	}
+
+	cfg.SOCKS5.RateLimit = &RateLimitConfig{BanThreshold: -1}
+	err := cfg.Validate()
+	if err == nil || !strings.HasPrefix(err.Error(), "socks5 rate_limit: ") {
+		t.Errorf("Expected an error naming the listener, got %v", err)
+	}
+}
+
+func TestHTTPConfig_SOCKS5UpstreamAlias(t *testing.T) {
+	cfg := Config{
+		Server: ServerConfig{HTTPPort: 8080, SOCKS5Port: 1080},
+		HTTP:   HTTPConfig{SOCKS5Upstream: UpstreamProxyConfig{Address: "https://proxy.example.com:443", Username: "customer"}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if cfg.HTTP.Upstream != cfg.HTTP.SOCKS5Upstream {
+		t.Errorf("Expected socks5_upstream to be copied into upstream, got %+v", cfg.HTTP.Upstream)
+	}
+}
+
+func TestAuthConfig_EnabledFor(t *testing.T) {
+	on, off := true, false
+	tests := []struct {
+		name       string
+		auth       AuthConfig
+		wantHTTP   bool
+		wantSOCKS5 bool
+	}{
+		{"inherits enabled", AuthConfig{Enabled: true}, true, true},
+		{"inherits disabled", AuthConfig{}, false, false},
+		{"http only", AuthConfig{HTTP: &on}, true, false},
+		{"socks5 opted out", AuthConfig{Enabled: true, SOCKS5: &off}, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.auth.EnabledFor("http"); got != tt.wantHTTP {
+				t.Errorf("EnabledFor(http) = %v, want %v", got, tt.wantHTTP)
+			}
+			if got := tt.auth.EnabledFor("socks5"); got != tt.wantSOCKS5 {
+				t.Errorf("EnabledFor(socks5) = %v, want %v", got, tt.wantSOCKS5)
+			}
+			if got := tt.auth.AnyEnabled(); got != (tt.wantHTTP || tt.wantSOCKS5) {
+				t.Errorf("AnyEnabled() = %v", got)
+			}
+		})
+	}
+}
+
+func TestAuthConfig_CheckPasswordStrength(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     AuthConfig
+		password string
+		wantErr  bool
+	}{
+		{"no checks", AuthConfig{}, "pw", false},
+		{"too short", AuthConfig{MinPasswordLength: 12}, "short-pass", true},
+		{"long enough", AuthConfig{MinPasswordLength: 12}, "correct-horse-battery", false},
+		{"length counts characters", AuthConfig{MinPasswordLength: 4}, "密码密码", false},
+		{"common password", AuthConfig{RejectCommonPasswords: true}, "Password123", true},
+		{"uncommon password", AuthConfig{RejectCommonPasswords: true}, "correct-horse-battery", false},
+		{"hash-shaped password checked too", AuthConfig{MinPasswordLength: 100}, "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.auth.checkPasswordStrength(tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkPasswordStrength() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package config
+
+import "strings"
+
+// commonPasswords holds widely used passwords from public breach corpora,
+// lowercased. It is short on purpose: it catches defaults and placeholders
+// left in a config, not every guessable password.
+var commonPasswords = map[string]struct{}{
+	"000000": {}, "111111": {}, "112233": {}, "121212": {}, "123123": {},
+	"123321": {}, "1234": {}, "12345": {}, "123456": {}, "1234567": {},
+	"12345678": {}, "123456789": {}, "1234567890": {}, "123qwe": {}, "1q2w3e": {},
+	"1q2w3e4r": {}, "1qaz2wsx": {}, "654321": {}, "666666": {}, "696969": {},
+	"7777777": {}, "888888": {}, "987654321": {}, "abc123": {}, "access": {},
+	"admin": {}, "admin123": {}, "administrator": {}, "baseball": {}, "changeme": {},
+	"charlie": {}, "default": {}, "dragon": {}, "football": {}, "guest": {},
+	"hello": {}, "iloveyou": {}, "letmein": {}, "login": {}, "master": {},
+	"michael": {}, "monkey": {}, "mustang": {}, "passw0rd": {}, "password": {},
+	"password1": {}, "password123": {}, "pass": {}, "proxy": {}, "qazwsx": {},
+	"qwerty": {}, "qwerty123": {}, "qwertyuiop": {}, "root": {}, "secret": {},
+	"shadow": {}, "starwars": {}, "sunshine": {}, "superman": {}, "test": {},
+	"test123": {}, "trustno1": {}, "user": {}, "welcome": {}, "zaq12wsx": {},
+}
+
+// isCommonPassword reports whether password is on the common password list,
+// ignoring case
+func isCommonPassword(password string) bool {
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
@@ -0,0 +1,320 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Message formats
+const (
+	FormatJSON        = "json"        // The Event as written to the event stream
+	FormatCloudEvents = "cloudevents" // The Event wrapped in a CloudEvents 1.0 JSON envelope
+)
+
+const (
+	natsDialTimeout  = 5 * time.Second
+	natsWriteTimeout = 5 * time.Second
+	natsMaxBackoff   = 30 * time.Second
+)
+
+// NATS publishes connection events to a subject on a NATS server, speaking
+// the small subset of the client protocol needed to publish. Events are
+// queued and sent by Start; when the queue is full, because the server is
+// slow or unreachable, new events are dropped and counted instead of
+// blocking the publisher. Lost connections are redialed with backoff.
+type NATS struct {
+	address  string
+	subject  string
+	format   string
+	username string
+	password string
+
+	bufferSize int
+	queue      chan Event
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex // Serializes writes between the publisher and PONG replies
+	conn net.Conn
+
+	dropped *metrics.Counter
+}
+
+// NATSOption configures optional NATS behavior
+type NATSOption func(*NATS)
+
+// WithNATSFormat sets the message format, FormatJSON or FormatCloudEvents
+func WithNATSFormat(format string) NATSOption {
+	return func(n *NATS) {
+		n.format = format
+	}
+}
+
+// WithNATSCredentials authenticates with a username and password
+func WithNATSCredentials(username, password string) NATSOption {
+	return func(n *NATS) {
+		n.username = username
+		n.password = password
+	}
+}
+
+// WithNATSBufferSize sets how many events are queued before new events are dropped
+func WithNATSBufferSize(size int) NATSOption {
+	return func(n *NATS) {
+		n.bufferSize = size
+	}
+}
+
+// NewNATS creates a publisher for subject on the NATS server at address (host:port)
+func NewNATS(address, subject string, opts ...NATSOption) *NATS {
+	n := &NATS{
+		address:    address,
+		subject:    subject,
+		format:     FormatJSON,
+		bufferSize: 1024,
+		dropped: metrics.Default.Counter("dudu_event_queue_dropped_total",
+			"Connection events dropped because the message queue fell behind or was unreachable"),
+	}
+
+	for _, opt := range opts {
+		opt(n)
+	}
+
+	n.queue = make(chan Event, n.bufferSize)
+	n.ctx, n.cancel = context.WithCancel(context.Background())
+	return n
+}
+
+// Publish queues e for the server without blocking
+func (n *NATS) Publish(e Event) {
+	select {
+	case n.queue <- e:
+	default:
+		n.dropped.Inc()
+	}
+}
+
+// Dropped returns how many events have been dropped
+func (n *NATS) Dropped() int64 {
+	return n.dropped.Value()
+}
+
+// Start connects to the server and sends queued events until Stop is called,
+// reconnecting whenever the connection is lost
+func (n *NATS) Start() error {
+	logger.Info("Event queue started", "address", n.address, "subject", n.subject, "format", n.format)
+
+	backoff := time.Second
+	for {
+		connected, err := n.run()
+		if n.ctx.Err() != nil {
+			return nil
+		}
+		if connected {
+			backoff = time.Second
+		}
+		logger.Warn("Event queue unavailable, reconnecting",
+			"address", n.address,
+			"retry_in_seconds", backoff.Seconds(),
+			"error", err)
+
+		select {
+		case <-n.ctx.Done():
+			return nil
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, natsMaxBackoff)
+	}
+}
+
+// Stop closes the connection; events still queued are discarded
+func (n *NATS) Stop() error {
+	n.cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		return nil
+	}
+	return n.conn.Close()
+}
+
+// run holds one connection, publishing queued events until it fails, and
+// reports whether it got as far as connecting
+func (n *NATS) run() (bool, error) {
+	conn, br, err := n.connect()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	n.mu.Lock()
+	if n.ctx.Err() != nil {
+		n.mu.Unlock()
+		return true, nil
+	}
+	n.conn = conn
+	n.mu.Unlock()
+
+	logger.Info("Event queue connected", "address", n.address)
+
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- n.read(br)
+	}()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return true, nil
+		case err := <-readErr:
+			return true, err
+		case e := <-n.queue:
+			payload, err := n.encode(e)
+			if err != nil {
+				logger.Error("Failed to encode connection event", "error", err)
+				continue
+			}
+			if err := n.write(fmt.Sprintf("PUB %s %d\r\n%s\r\n", n.subject, len(payload), payload)); err != nil {
+				n.dropped.Inc()
+				return true, err
+			}
+		}
+	}
+}
+
+// connect dials the server, reads its INFO and sends CONNECT, then waits for
+// the PONG to a PING so authentication errors surface here
+func (n *NATS) connect() (net.Conn, *bufio.Reader, error) {
+	dialer := net.Dialer{Timeout: natsDialTimeout}
+	conn, err := dialer.DialContext(n.ctx, "tcp", n.address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn.SetDeadline(time.Now().Add(natsDialTimeout))
+	br := bufio.NewReader(conn)
+
+	line, err := readLine(br)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return nil, nil, fmt.Errorf("nats: unexpected greeting %q", line)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"name":     "dudu-proxy",
+		"lang":     "go",
+	}
+	if n.username != "" {
+		options["user"] = n.username
+		options["pass"] = n.password
+	}
+	connect, err := json.Marshal(options)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\nPING\r\n", connect); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	line, err = readLine(br)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	if line != "PONG" {
+		conn.Close()
+		return nil, nil, fmt.Errorf("nats: %s", line)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, br, nil
+}
+
+// read answers the server's keepalive PINGs until the connection fails or
+// the server reports an error
+func (n *NATS) read(br *bufio.Reader) error {
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PING":
+			if err := n.write("PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("nats: %s", line)
+		}
+	}
+}
+
+// write sends data on the current connection
+func (n *NATS) write(data string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.conn == nil {
+		return errors.New("nats: not connected")
+	}
+	n.conn.SetWriteDeadline(time.Now().Add(natsWriteTimeout))
+	_, err := n.conn.Write([]byte(data))
+	return err
+}
+
+// encode renders e in the configured format
+func (n *NATS) encode(e Event) ([]byte, error) {
+	if n.format != FormatCloudEvents {
+		return json.Marshal(e)
+	}
+	return json.Marshal(cloudEvent{
+		SpecVersion:     "1.0",
+		Type:            "dudu.connection." + e.Type,
+		Source:          "dudu-proxy",
+		ID:              fmt.Sprintf("%d-%d-%s", e.ConnID, e.ID, e.Type),
+		Time:            e.Time,
+		DataContentType: "application/json",
+		Data:            e,
+	})
+}
+
+// cloudEvent is the CloudEvents 1.0 JSON envelope
+type cloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	Type            string    `json:"type"`
+	Source          string    `json:"source"`
+	ID              string    `json:"id"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Event     `json:"data"`
+}
+
+// readLine reads one CRLF-terminated protocol line
+func readLine(br *bufio.Reader) (string, error) {
+	line, err := br.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -0,0 +1,121 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// fakeNATS accepts one client, completes the handshake and sends the
+// payload of each PUB on the returned channel
+func fakeNATS(t *testing.T) (string, <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	published := make(chan string, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		conn.Write([]byte("INFO {\"server_id\":\"test\"}\r\n"))
+		for {
+			line, err := readLine(br)
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch {
+			case line == "PING":
+				conn.Write([]byte("PONG\r\n"))
+			case len(fields) == 3 && fields[0] == "PUB":
+				size, _ := strconv.Atoi(fields[2])
+				payload := make([]byte, size+2)
+				if _, err := io.ReadFull(br, payload); err != nil {
+					return
+				}
+				published <- fields[1] + " " + string(payload[:size])
+			}
+		}
+	}()
+
+	return listener.Addr().String(), published
+}
+
+func TestNATS_Publish(t *testing.T) {
+	info := manager.ConnInfo{ID: 7, ConnID: 42, Protocol: "socks5", ClientIP: "10.0.0.1", Username: "alice", Target: "example.com:443"}
+
+	tests := []struct {
+		name     string
+		format   string
+		wantType string
+	}{
+		{"json", FormatJSON, ""},
+		{"cloudevents", FormatCloudEvents, "dudu.connection.open"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			address, published := fakeNATS(t)
+			n := NewNATS(address, "dudu.events", WithNATSFormat(tt.format))
+			go n.Start()
+			defer n.Stop()
+
+			n.Publish(Event{Type: TypeOpen, Time: time.Now(), ConnInfo: info})
+
+			var msg string
+			select {
+			case msg = <-published:
+			case <-time.After(2 * time.Second):
+				t.Fatal("Event was not published")
+			}
+
+			subject, payload, _ := strings.Cut(msg, " ")
+			if subject != "dudu.events" {
+				t.Errorf("subject = %q, want dudu.events", subject)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal([]byte(payload), &got); err != nil {
+				t.Fatalf("Payload is not JSON: %v", err)
+			}
+			if tt.wantType != "" {
+				if got["type"] != tt.wantType || got["specversion"] != "1.0" || got["id"] != "42-7-open" {
+					t.Errorf("envelope = %v, want type %s and id 42-7-open", got, tt.wantType)
+				}
+				got, _ = got["data"].(map[string]interface{})
+			}
+			if got["event"] != TypeOpen || got["username"] != "alice" {
+				t.Errorf("event = %v, want an open event for alice", got)
+			}
+		})
+	}
+}
+
+func TestNATS_PublishDropsWhenFull(t *testing.T) {
+	// Never started, so nothing drains the queue
+	n := NewNATS("127.0.0.1:1", "dudu.events", WithNATSBufferSize(2))
+	before := n.Dropped()
+
+	for i := 0; i < 5; i++ {
+		n.Publish(Event{Type: TypeClose, Time: time.Now()})
+	}
+
+	if got := n.Dropped() - before; got != 3 {
+		t.Errorf("Dropped() increased by %d, want 3", got)
+	}
+}
@@ -0,0 +1,206 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Event types
+const (
+	TypeOpen  = "open"  // A tunnel or proxied request was established
+	TypeClose = "close" // It ended; byte counts and duration are final
+
+	TypeAuthFailure = "auth_failure" // A client failed to authenticate; only the id, protocol, client_ip and username are set
+)
+
+// Publisher receives connection events. Implementations must not block.
+type Publisher interface {
+	Publish(e Event)
+}
+
+// Event is one connection lifecycle event, written as a line of JSON
+type Event struct {
+	Type string    `json:"event"`
+	Time time.Time `json:"time"`
+	manager.ConnInfo
+	DurationMs int64 `json:"duration_ms,omitempty"` // Close events only
+}
+
+// Stream writes connection events as newline-delimited JSON to every client
+// connected to a Unix socket. Each client has a bounded queue; when a slow
+// client's queue is full its events are dropped and counted instead of
+// blocking the publisher.
+type Stream struct {
+	path       string
+	bufferSize int
+
+	mu       sync.Mutex
+	listener net.Listener
+	clients  map[*client]struct{}
+	closed   bool
+
+	dropped *metrics.Counter
+}
+
+// client is a connected consumer and its queue of encoded events
+type client struct {
+	conn  net.Conn
+	queue chan []byte
+}
+
+// StreamOption configures optional Stream behavior
+type StreamOption func(*Stream)
+
+// WithBufferSize sets how many events are queued per client before new
+// events are dropped for it
+func WithBufferSize(n int) StreamOption {
+	return func(s *Stream) {
+		s.bufferSize = n
+	}
+}
+
+// NewStream creates an event stream served on the Unix socket at path
+func NewStream(path string, opts ...StreamOption) *Stream {
+	s := &Stream{
+		path:       path,
+		bufferSize: 256,
+		clients:    make(map[*client]struct{}),
+		dropped: metrics.Default.Counter("dudu_event_stream_dropped_total",
+			"Connection events dropped because an event stream client fell behind"),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Start listens on the socket and accepts clients until Stop is called. A
+// stale socket file left by a previous run is removed first.
+func (s *Stream) Start() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		listener.Close()
+		return nil
+	}
+	s.listener = listener
+	s.mu.Unlock()
+
+	logger.Info("Event stream started", "socket", s.path)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.addClient(conn)
+	}
+}
+
+// Stop closes the socket and disconnects every client
+func (s *Stream) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for c := range s.clients {
+		s.removeClient(c)
+	}
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Publish queues e for every connected client without blocking
+func (s *Stream) Publish(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.clients) == 0 {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		logger.Error("Failed to encode connection event", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	for c := range s.clients {
+		select {
+		case c.queue <- line:
+		default:
+			s.dropped.Inc()
+		}
+	}
+}
+
+// Dropped returns how many events have been dropped for slow clients
+func (s *Stream) Dropped() int64 {
+	return s.dropped.Value()
+}
+
+// addClient registers conn and starts writing its queue
+func (s *Stream) addClient(conn net.Conn) {
+	c := &client{
+		conn:  conn,
+		queue: make(chan []byte, s.bufferSize),
+	}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return
+	}
+	s.clients[c] = struct{}{}
+	s.mu.Unlock()
+
+	go s.write(c)
+}
+
+// write sends queued events to c until it disconnects or is removed
+func (s *Stream) write(c *client) {
+	for line := range c.queue {
+		if _, err := c.conn.Write(line); err != nil {
+			s.mu.Lock()
+			s.removeClient(c)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// removeClient disconnects c. Caller must hold the lock.
+func (s *Stream) removeClient(c *client) {
+	if _, ok := s.clients[c]; !ok {
+		return
+	}
+	delete(s.clients, c)
+	close(c.queue)
+	c.conn.Close()
+}
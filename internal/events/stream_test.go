@@ -0,0 +1,120 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// startStream starts a stream on a fresh socket and returns a connected client
+func startStream(t *testing.T, opts ...StreamOption) (*Stream, net.Conn) {
+	t.Helper()
+
+	// Unix socket paths are short, so avoid the long t.TempDir path
+	dir, err := os.MkdirTemp("", "dudu")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	s := NewStream(filepath.Join(dir, "events.sock"), opts...)
+	go s.Start()
+	t.Cleanup(func() { s.Stop() })
+
+	var conn net.Conn
+	deadline := time.Now().Add(time.Second)
+	for {
+		conn, err = net.Dial("unix", s.path)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Failed to connect to event stream: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	// Wait for the stream to register the client
+	for {
+		s.mu.Lock()
+		n := len(s.clients)
+		s.mu.Unlock()
+		if n == 1 {
+			return s, conn
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Client was not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStream_Publish(t *testing.T) {
+	s, conn := startStream(t)
+
+	info := manager.ConnInfo{ID: 7, Protocol: "socks5", ClientIP: "10.0.0.1", Username: "alice", Target: "example.com:443"}
+	s.Publish(Event{Type: TypeOpen, Time: time.Now(), ConnInfo: info})
+	info.BytesUp, info.BytesDown = 100, 2000
+	s.Publish(Event{Type: TypeClose, Time: time.Now(), ConnInfo: info, DurationMs: 1500})
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	scanner := bufio.NewScanner(conn)
+	for _, want := range []struct {
+		event     string
+		bytesDown int64
+	}{{TypeOpen, 0}, {TypeClose, 2000}} {
+		if !scanner.Scan() {
+			t.Fatalf("Failed to read event: %v", scanner.Err())
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &got); err != nil {
+			t.Fatalf("Event is not JSON: %v", err)
+		}
+		if got["event"] != want.event || got["id"] != float64(7) || got["target"] != "example.com:443" ||
+			got["bytes_down"] != float64(want.bytesDown) {
+			t.Errorf("Unexpected %s event: %s", want.event, scanner.Bytes())
+		}
+	}
+}
+
+func TestStream_SlowClientDropsEvents(t *testing.T) {
+	s, _ := startStream(t, WithBufferSize(1))
+	before := s.Dropped()
+
+	// The client never reads, so once the socket buffer fills events must be
+	// dropped rather than block the publisher
+	info := manager.ConnInfo{Target: string(make([]byte, 512))}
+	done := make(chan struct{})
+	go func() {
+		for range 10000 {
+			s.Publish(Event{Type: TypeOpen, ConnInfo: info})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish blocked on a slow client")
+	}
+	if s.Dropped() == before {
+		t.Error("Expected events to be dropped for the slow client")
+	}
+}
+
+func TestStream_NoClients(t *testing.T) {
+	s := NewStream("unused.sock")
+	before := s.Dropped()
+
+	s.Publish(Event{Type: TypeOpen})
+	if s.Dropped() != before {
+		t.Error("Expected nothing to be dropped without clients")
+	}
+}
@@ -0,0 +1,162 @@
+package events
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// UserPlaceholder is replaced by the username in UserLog file names
+const UserPlaceholder = "{user}"
+
+// UserLog appends each connection's close event, as a line of JSON, to a file
+// of the user it belongs to. At most maxOpen files are kept open; the least
+// recently written one is closed to make room and reopened when needed.
+type UserLog struct {
+	dir      string
+	fileName string // Contains UserPlaceholder
+	maxOpen  int
+
+	mu     sync.Mutex
+	files  map[string]*list.Element // Username -> element holding its *userFile
+	lru    *list.List               // Front is the most recently written
+	closed bool
+
+	errors *metrics.Counter
+}
+
+// userFile is an open per-user log file
+type userFile struct {
+	username string
+	file     *os.File
+}
+
+// NewUserLog creates a user log writing to dir, naming each file by replacing
+// UserPlaceholder in fileName with the username
+func NewUserLog(dir, fileName string, maxOpen int) *UserLog {
+	if maxOpen <= 0 {
+		maxOpen = 1
+	}
+	return &UserLog{
+		dir:      dir,
+		fileName: fileName,
+		maxOpen:  maxOpen,
+		files:    make(map[string]*list.Element),
+		lru:      list.New(),
+		errors: metrics.Default.Counter("dudu_user_log_errors_total",
+			"Connection records that could not be written to their user's log file"),
+	}
+}
+
+// Publish writes e to its user's file. Only close events are written, so each
+// connection is one record with its final byte counts and duration.
+func (l *UserLog) Publish(e Event) {
+	if e.Type != TypeClose {
+		return
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		logger.Error("Failed to encode connection event", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.closed {
+		return
+	}
+
+	f, err := l.open(e.Username)
+	if err == nil {
+		_, err = f.file.Write(line)
+	}
+	if err != nil {
+		l.errors.Inc()
+		logger.WarnSampled("Failed to write user log", "username", e.Username, "error", err)
+	}
+}
+
+// open returns username's file, opening it and closing the least recently
+// written one if the limit is reached. Caller must hold the lock.
+func (l *UserLog) open(username string) (*userFile, error) {
+	if elem, ok := l.files[username]; ok {
+		l.lru.MoveToFront(elem)
+		return elem.Value.(*userFile), nil
+	}
+
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(l.dir, strings.ReplaceAll(l.fileName, UserPlaceholder, fileSafe(username)))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return nil, err
+	}
+
+	for l.lru.Len() >= l.maxOpen {
+		l.evict(l.lru.Back())
+	}
+	f := &userFile{username: username, file: file}
+	l.files[username] = l.lru.PushFront(f)
+
+	return f, nil
+}
+
+// evict closes the file in elem. Caller must hold the lock.
+func (l *UserLog) evict(elem *list.Element) {
+	f := l.lru.Remove(elem).(*userFile)
+	delete(l.files, f.username)
+	if err := f.file.Close(); err != nil {
+		logger.Warn("Failed to close user log", "username", f.username, "error", err)
+	}
+}
+
+// OpenFiles returns how many user log files are open
+func (l *UserLog) OpenFiles() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lru.Len()
+}
+
+// Close closes every open file; later events are discarded
+func (l *UserLog) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.closed = true
+	for l.lru.Len() > 0 {
+		l.evict(l.lru.Back())
+	}
+}
+
+// fileSafe escapes username for use in a file name: letters, digits, '-',
+// '_' and '.' are kept, other bytes become %XX, and a leading '.' is escaped
+// too so names like ".." can't refer to directories or hidden files
+func fileSafe(username string) string {
+	if username == "" {
+		return "%00"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(username); i++ {
+		c := username[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.' && i > 0:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,118 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// closeEvent returns a close event for a connection of username
+func closeEvent(username string) Event {
+	return Event{
+		Type:     TypeClose,
+		Time:     time.Now(),
+		ConnInfo: manager.ConnInfo{Username: username, Target: "example.com:443", BytesUp: 10},
+	}
+}
+
+// readUserLog returns the events in a user log file
+func readUserLog(t *testing.T, path string) []Event {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open user log: %v", err)
+	}
+	defer f.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Invalid user log line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+func TestUserLog_Publish(t *testing.T) {
+	dir := t.TempDir()
+	l := NewUserLog(dir, "access-{user}.log", 10)
+	defer l.Close()
+
+	l.Publish(closeEvent("alice"))
+	l.Publish(Event{Type: TypeOpen, ConnInfo: manager.ConnInfo{Username: "alice"}}) // Not written
+	l.Publish(closeEvent("alice"))
+	l.Publish(closeEvent("bob"))
+
+	alice := readUserLog(t, filepath.Join(dir, "access-alice.log"))
+	if len(alice) != 2 {
+		t.Fatalf("Expected 2 records for alice, got %d", len(alice))
+	}
+	if alice[0].Type != TypeClose || alice[0].Target != "example.com:443" || alice[0].BytesUp != 10 {
+		t.Errorf("Unexpected record: %+v", alice[0])
+	}
+	if bob := readUserLog(t, filepath.Join(dir, "access-bob.log")); len(bob) != 1 {
+		t.Errorf("Expected 1 record for bob, got %d", len(bob))
+	}
+}
+
+func TestUserLog_MaxOpenFiles(t *testing.T) {
+	dir := t.TempDir()
+	l := NewUserLog(dir, "{user}.log", 2)
+	defer l.Close()
+
+	l.Publish(closeEvent("alice"))
+	l.Publish(closeEvent("bob"))
+	l.Publish(closeEvent("alice")) // bob is now the least recently written
+	l.Publish(closeEvent("carol"))
+
+	if n := l.OpenFiles(); n != 2 {
+		t.Errorf("Expected 2 open files, got %d", n)
+	}
+	if _, ok := l.files["bob"]; ok {
+		t.Error("Expected bob's file to be closed")
+	}
+
+	// A closed file is reopened and appended to
+	l.Publish(closeEvent("bob"))
+	if bob := readUserLog(t, filepath.Join(dir, "bob.log")); len(bob) != 2 {
+		t.Errorf("Expected 2 records for bob, got %d", len(bob))
+	}
+
+	l.Close()
+	if n := l.OpenFiles(); n != 0 {
+		t.Errorf("Expected no open files after Close, got %d", n)
+	}
+	l.Publish(closeEvent("dave"))
+	if _, err := os.Stat(filepath.Join(dir, "dave.log")); !os.IsNotExist(err) {
+		t.Error("Expected events after Close to be discarded")
+	}
+}
+
+func TestFileSafe(t *testing.T) {
+	tests := []struct {
+		username string
+		want     string
+	}{
+		{"alice", "alice"},
+		{"alice.smith-2_x", "alice.smith-2_x"},
+		{"../etc/passwd", "%2E.%2Fetc%2Fpasswd"},
+		{"..", "%2E."},
+		{"a b@c", "a%20b%40c"},
+		{"", "%00"},
+	}
+
+	for _, tt := range tests {
+		if got := fileSafe(tt.username); got != tt.want {
+			t.Errorf("fileSafe(%q) = %q, want %q", tt.username, got, tt.want)
+		}
+	}
+}
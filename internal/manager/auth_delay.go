@@ -0,0 +1,92 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// authFailures is an IP's run of authentication failures
+type authFailures struct {
+	count int
+	last  time.Time
+}
+
+// AuthDelay computes a per-IP cooldown applied before answering a failed
+// authentication. Each failure adds one step to the IP's delay up to a
+// maximum, slowing online brute forcing below the ban threshold. A success,
+// or a quiet period of the reset interval, clears the IP's failures.
+type AuthDelay struct {
+	mu        sync.Mutex
+	failures  map[string]*authFailures
+	step      time.Duration
+	max       time.Duration
+	reset     time.Duration
+	lastSweep time.Time
+}
+
+// NewAuthDelay creates an auth delay adding step per failure up to max,
+// forgetting an IP's failures after reset without one
+func NewAuthDelay(step, max, reset time.Duration) *AuthDelay {
+	return &AuthDelay{
+		failures:  make(map[string]*authFailures),
+		step:      step,
+		max:       max,
+		reset:     reset,
+		lastSweep: time.Now(),
+	}
+}
+
+// RecordFailure records a failed authentication by ip and returns how long
+// to wait before answering it
+func (d *AuthDelay) RecordFailure(ip string) time.Duration {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	d.sweep(now)
+
+	entry, ok := d.failures[ip]
+	if !ok || now.Sub(entry.last) >= d.reset {
+		entry = &authFailures{}
+		d.failures[ip] = entry
+	}
+	entry.count++
+	entry.last = now
+
+	return min(time.Duration(entry.count)*d.step, d.max)
+}
+
+// RecordSuccess clears ip's failures
+func (d *AuthDelay) RecordSuccess(ip string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.failures, ip)
+}
+
+// GetFailureCount returns how many recent failures ip has
+func (d *AuthDelay) GetFailureCount(ip string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	entry, ok := d.failures[ip]
+	if !ok || time.Since(entry.last) >= d.reset {
+		return 0
+	}
+	return entry.count
+}
+
+// sweep drops IPs quiet for the reset interval, at most once per interval.
+// Caller must hold the lock.
+func (d *AuthDelay) sweep(now time.Time) {
+	if now.Sub(d.lastSweep) < d.reset {
+		return
+	}
+	d.lastSweep = now
+
+	for ip, entry := range d.failures {
+		if now.Sub(entry.last) >= d.reset {
+			delete(d.failures, ip)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAuthDelay(t *testing.T) {
+	d := NewAuthDelay(time.Second, 3*time.Second, time.Minute)
+
+	want := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second}
+	for i, w := range want {
+		if got := d.RecordFailure("10.0.0.1"); got != w {
+			t.Errorf("Failure %d: delay = %v, want %v", i+1, got, w)
+		}
+	}
+
+	if got := d.RecordFailure("10.0.0.2"); got != time.Second {
+		t.Errorf("Expected IPs to be tracked separately, got %v", got)
+	}
+
+	d.RecordSuccess("10.0.0.1")
+	if got := d.GetFailureCount("10.0.0.1"); got != 0 {
+		t.Errorf("Expected success to clear failures, got %d", got)
+	}
+}
+
+func TestAuthDelay_Reset(t *testing.T) {
+	d := NewAuthDelay(time.Second, 10*time.Second, 20*time.Millisecond)
+
+	d.RecordFailure("10.0.0.1")
+	d.RecordFailure("10.0.0.1")
+	time.Sleep(30 * time.Millisecond)
+
+	if got := d.RecordFailure("10.0.0.1"); got != time.Second {
+		t.Errorf("Expected failures to be forgotten after the reset interval, got %v", got)
+	}
+}
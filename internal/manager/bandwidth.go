@@ -0,0 +1,76 @@
+package manager
+
+import (
+	"math"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// BandwidthLimiter hands out byte-rate token buckets keyed by client identity,
+// such as an IP or a username, so every connection of one client draws from
+// the same budget. A bucket lives while any connection holds it.
+type BandwidthLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	buckets        map[string]*bandwidthBucket
+}
+
+// bandwidthBucket is a shared limiter and how many connections hold it
+type bandwidthBucket struct {
+	limiter *rate.Limiter
+	refs    int
+}
+
+// NewBandwidthLimiter creates a limiter allowing bytesPerSecond per key. The
+// burst is one second's worth, so a bucket never holds more than that.
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	return &BandwidthLimiter{
+		bytesPerSecond: bytesPerSecond,
+		buckets:        make(map[string]*bandwidthBucket),
+	}
+}
+
+// Acquire returns the bucket for key. Call the returned release once the
+// connection using it ends.
+func (b *BandwidthLimiter) Acquire(key string) (*rate.Limiter, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bucket, ok := b.buckets[key]
+	if !ok {
+		burst := int(min(b.bytesPerSecond, math.MaxInt32))
+		bucket = &bandwidthBucket{limiter: rate.NewLimiter(rate.Limit(b.bytesPerSecond), burst)}
+		b.buckets[key] = bucket
+	}
+	bucket.refs++
+
+	var once sync.Once
+	return bucket.limiter, func() {
+		once.Do(func() { b.release(key, bucket) })
+	}
+}
+
+// release drops a reference to bucket, forgetting it when unused
+func (b *BandwidthLimiter) release(key string, bucket *bandwidthBucket) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bucket.refs--
+	if bucket.refs == 0 && b.buckets[key] == bucket {
+		delete(b.buckets, key)
+	}
+}
+
+// BytesPerSecond returns the rate allowed per key
+func (b *BandwidthLimiter) BytesPerSecond() int64 {
+	return b.bytesPerSecond
+}
+
+// Len returns the number of keys with active connections
+func (b *BandwidthLimiter) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.buckets)
+}
@@ -0,0 +1,33 @@
+package manager
+
+import "testing"
+
+func TestBandwidthLimiter_SharedBuckets(t *testing.T) {
+	b := NewBandwidthLimiter(1000)
+
+	first, releaseFirst := b.Acquire("10.0.0.1")
+	second, releaseSecond := b.Acquire("10.0.0.1")
+	other, releaseOther := b.Acquire("10.0.0.2")
+
+	if first != second {
+		t.Error("Expected connections from one key to share a bucket")
+	}
+	if first == other {
+		t.Error("Expected different keys to have separate buckets")
+	}
+	if first.Burst() != 1000 {
+		t.Errorf("Expected a burst of one second's worth, got %d", first.Burst())
+	}
+
+	releaseFirst()
+	releaseFirst() // Releasing twice must not drop the other connection's reference
+	if b.Len() != 2 {
+		t.Fatalf("Expected 2 buckets while connections remain, got %d", b.Len())
+	}
+
+	releaseSecond()
+	releaseOther()
+	if b.Len() != 0 {
+		t.Errorf("Expected unused buckets to be forgotten, got %d", b.Len())
+	}
+}
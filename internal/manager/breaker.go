@@ -2,6 +2,7 @@ package manager
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,29 +44,154 @@ type CircuitBreaker struct {
 	lastStateChange      time.Time
 	consecutiveSuccesses int
 	halfOpenMaxRequests  int
+	halfOpenInFlight     int           // Probes Call is running in the current half-open period
+	probeLimit           int           // Half-open probes admitted per probe interval, zero admits all
+	probeInterval        time.Duration // Spacing between batches of half-open probes
+	successPeriod        time.Duration // Minimum time half-open before the circuit may close
+	probeWindowStart     time.Time
+	probesInWindow       int
+	onStateChange        func(from, to CircuitBreakerState)
+	pendingChanges       []stateChange // Transitions not yet reported to onStateChange
+	shadow               bool          // Track state but never reject
+	shadowRejects        atomic.Int64  // Requests shadow mode let through that would have been rejected
+}
+
+type stateChange struct {
+	from, to CircuitBreakerState
 }
 
 type requestRecord struct {
 	timestamp time.Time
 	success   bool
+	source    string // Who caused a failure, such as a client IP; may be empty
+}
+
+// CircuitBreakerOption configures a CircuitBreaker
+type CircuitBreakerOption func(*CircuitBreaker)
+
+// WithFailureThreshold sets the failure percentage (1-100) that opens the circuit
+func WithFailureThreshold(percent int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.failureThreshold = float64(percent)
+	}
+}
+
+// WithWindowSize sets the sliding window used to compute the failure rate
+func WithWindowSize(windowSize time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.windowSize = windowSize
+	}
+}
+
+// WithMinRequests sets the number of requests required in the window before the circuit can open
+func WithMinRequests(minRequests int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.minRequests = minRequests
+	}
 }
 
-// NewCircuitBreaker creates a new circuit breaker
-func NewCircuitBreaker(failureThresholdPercent int, windowSize time.Duration, minRequests int, breakDuration time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
+// WithBreakDuration sets how long the circuit stays open before going half-open
+func WithBreakDuration(breakDuration time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.breakDuration = breakDuration
+	}
+}
+
+// WithHalfOpenMaxRequests sets how many consecutive successes close a half-open circuit
+func WithHalfOpenMaxRequests(n int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.halfOpenMaxRequests = n
+	}
+}
+
+// WithSlowStart admits at most probes requests per interval while half-open,
+// instead of letting every request through at once, and keeps the circuit
+// half-open for at least successPeriod of uninterrupted success before closing.
+// Zero probes disables the probe limit.
+func WithSlowStart(probes int, interval, successPeriod time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.probeLimit = probes
+		cb.probeInterval = interval
+		cb.successPeriod = successPeriod
+	}
+}
+
+// WithShadowMode makes the breaker track its state as usual but never reject:
+// IsOpen always reports false and Allow always admits, counting the requests
+// it would have rejected. Used to tune thresholds against real traffic.
+func WithShadowMode(enabled bool) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) {
+		cb.shadow = enabled
+	}
+}
+
+// NewCircuitBreaker creates a new circuit breaker. Without options it opens at a 50%
+// failure rate over a 60s window with at least 20 requests, and stays open for 30s.
+func NewCircuitBreaker(opts ...CircuitBreakerOption) *CircuitBreaker {
+	cb := &CircuitBreaker{
 		state:               StateClosed,
-		failureThreshold:    float64(failureThresholdPercent),
-		windowSize:          windowSize,
-		minRequests:         minRequests,
-		breakDuration:       breakDuration,
+		failureThreshold:    50,
+		windowSize:          60 * time.Second,
+		minRequests:         20,
+		breakDuration:       30 * time.Second,
 		requests:            make([]requestRecord, 0),
 		lastStateChange:     time.Now(),
 		halfOpenMaxRequests: 3,
 	}
+
+	for _, opt := range opts {
+		opt(cb)
+	}
+
+	return cb
 }
 
-// IsOpen returns true if the circuit breaker is open
+// OnStateChange registers fn to be called after every state transition. fn runs
+// outside the breaker's lock, so it may call GetStats or GetState.
+func (cb *CircuitBreaker) OnStateChange(fn func(from, to CircuitBreakerState)) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.onStateChange = fn
+}
+
+// setState transitions the circuit to state and queues the change for
+// onStateChange. Caller must hold the write lock.
+func (cb *CircuitBreaker) setState(state CircuitBreakerState, now time.Time) {
+	if cb.state == state {
+		return
+	}
+
+	cb.pendingChanges = append(cb.pendingChanges, stateChange{from: cb.state, to: state})
+	cb.state = state
+	cb.lastStateChange = now
+	cb.consecutiveSuccesses = 0
+	cb.probeWindowStart = time.Time{}
+	cb.probesInWindow = 0
+	cb.halfOpenInFlight = 0
+}
+
+// unlockAndNotify releases the write lock and reports transitions made while it was held
+func (cb *CircuitBreaker) unlockAndNotify() {
+	changes := cb.pendingChanges
+	cb.pendingChanges = nil
+	fn := cb.onStateChange
+	cb.mu.Unlock()
+
+	if fn == nil {
+		return
+	}
+	for _, change := range changes {
+		fn(change.from, change.to)
+	}
+}
+
+// IsOpen returns true if the circuit breaker is open. It is always false in shadow mode.
 func (cb *CircuitBreaker) IsOpen() bool {
+	if cb.shadow {
+		return false
+	}
+
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
 
@@ -80,6 +206,75 @@ func (cb *CircuitBreaker) IsOpen() bool {
 	return false
 }
 
+// Allow reports whether a request may proceed. Unlike IsOpen it moves an
+// expired open circuit to half-open and, with slow start, admits half-open
+// probes only at the configured rate. In shadow mode it always admits.
+func (cb *CircuitBreaker) Allow() bool {
+	if cb.allow() {
+		return true
+	}
+	if cb.shadow {
+		cb.shadowRejects.Add(1)
+		return true
+	}
+	return false
+}
+
+// IsShadow reports whether the breaker runs in shadow mode
+func (cb *CircuitBreaker) IsShadow() bool {
+	return cb.shadow
+}
+
+// ShadowRejections returns how many requests shadow mode admitted that the
+// breaker would otherwise have rejected
+func (cb *CircuitBreaker) ShadowRejections() int64 {
+	return cb.shadowRejects.Load()
+}
+
+// allow makes Allow's decision as if shadow mode were off
+func (cb *CircuitBreaker) allow() bool {
+	cb.mu.RLock()
+	closed := cb.state == StateClosed
+	cb.mu.RUnlock()
+	if closed {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.unlockAndNotify()
+
+	now := time.Now()
+	cb.refreshState(now)
+
+	switch cb.state {
+	case StateOpen:
+		return false
+	case StateHalfOpen:
+		return cb.admitProbe(now)
+	default:
+		return true
+	}
+}
+
+// admitProbe counts a half-open probe against the current probe interval.
+// Caller must hold the write lock.
+func (cb *CircuitBreaker) admitProbe(now time.Time) bool {
+	if cb.probeLimit <= 0 {
+		return true
+	}
+
+	if now.Sub(cb.probeWindowStart) >= cb.probeInterval {
+		cb.probeWindowStart = now
+		cb.probesInWindow = 0
+	}
+	if cb.probesInWindow >= cb.probeLimit {
+		return false
+	}
+
+	cb.probesInWindow++
+	return true
+}
+
 // GetState returns the current state of the circuit breaker
 func (cb *CircuitBreaker) GetState() CircuitBreakerState {
 	cb.mu.RLock()
@@ -93,21 +288,28 @@ func (cb *CircuitBreaker) GetState() CircuitBreakerState {
 	return cb.state
 }
 
+// refreshState moves an open circuit to half-open once the break duration
+// has elapsed. Caller must hold the write lock.
+func (cb *CircuitBreaker) refreshState(now time.Time) {
+	if cb.state == StateOpen && now.Sub(cb.lastStateChange) >= cb.breakDuration {
+		cb.setState(StateHalfOpen, now)
+	}
+}
+
 // RecordSuccess records a successful request
 func (cb *CircuitBreaker) RecordSuccess() {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlockAndNotify()
 
 	now := time.Now()
+	cb.refreshState(now)
 	cb.requests = append(cb.requests, requestRecord{timestamp: now, success: true})
 
 	// Handle half-open state
 	if cb.state == StateHalfOpen {
 		cb.consecutiveSuccesses++
-		if cb.consecutiveSuccesses >= cb.halfOpenMaxRequests {
-			cb.state = StateClosed
-			cb.lastStateChange = now
-			cb.consecutiveSuccesses = 0
+		if cb.consecutiveSuccesses >= cb.halfOpenMaxRequests && now.Sub(cb.lastStateChange) >= cb.successPeriod {
+			cb.setState(StateClosed, now)
 		}
 	}
 
@@ -116,17 +318,22 @@ func (cb *CircuitBreaker) RecordSuccess() {
 
 // RecordFailure records a failed request
 func (cb *CircuitBreaker) RecordFailure() {
+	cb.RecordFailureFrom("")
+}
+
+// RecordFailureFrom records a failed request caused by source, such as a
+// client IP, so TopFailureSource can attribute the failures
+func (cb *CircuitBreaker) RecordFailureFrom(source string) {
 	cb.mu.Lock()
-	defer cb.mu.Unlock()
+	defer cb.unlockAndNotify()
 
 	now := time.Now()
-	cb.requests = append(cb.requests, requestRecord{timestamp: now, success: false})
+	cb.refreshState(now)
+	cb.requests = append(cb.requests, requestRecord{timestamp: now, success: false, source: source})
 
 	// If in half-open state, immediately go back to open on failure
 	if cb.state == StateHalfOpen {
-		cb.state = StateOpen
-		cb.lastStateChange = now
-		cb.consecutiveSuccesses = 0
+		cb.setState(StateOpen, now)
 		cb.cleanup(now)
 		return
 	}
@@ -134,28 +341,23 @@ func (cb *CircuitBreaker) RecordFailure() {
 	// Check if we should open the circuit
 	cb.cleanup(now)
 	if cb.shouldOpen() {
-		cb.state = StateOpen
-		cb.lastStateChange = now
+		cb.setState(StateOpen, now)
 	}
 }
 
-// Call executes a function with circuit breaker protection
+// Call executes a function with circuit breaker protection. While half-open
+// at most halfOpenMaxRequests calls run at once as probes; the rest are
+// rejected like calls on an open circuit.
 func (cb *CircuitBreaker) Call(fn func() error) error {
-	// Check state with potential transition to half-open
-	currentState := cb.GetState()
-
-	if currentState == StateOpen {
-		return ErrCircuitBreakerOpen
-	}
-
-	// If half-open, transition to that state
-	if currentState == StateHalfOpen {
-		cb.mu.Lock()
-		if cb.state == StateOpen && time.Since(cb.lastStateChange) >= cb.breakDuration {
-			cb.state = StateHalfOpen
-			cb.lastStateChange = time.Now()
+	probeSince, ok := cb.admitCall()
+	if !ok {
+		if !cb.shadow {
+			return ErrCircuitBreakerOpen
 		}
-		cb.mu.Unlock()
+		cb.shadowRejects.Add(1)
+	}
+	if !probeSince.IsZero() {
+		defer cb.releaseProbe(probeSince)
 	}
 
 	err := fn()
@@ -168,6 +370,41 @@ func (cb *CircuitBreaker) Call(fn func() error) error {
 	return nil
 }
 
+// admitCall decides whether Call may run, moving an expired open circuit to
+// half-open and taking a probe slot under the same lock so concurrent callers
+// can't all see a free slot. probeSince identifies the half-open period the
+// probe was admitted in and is zero when the call isn't a probe.
+func (cb *CircuitBreaker) admitCall() (probeSince time.Time, ok bool) {
+	cb.mu.Lock()
+	defer cb.unlockAndNotify()
+
+	cb.refreshState(time.Now())
+
+	switch cb.state {
+	case StateOpen:
+		return time.Time{}, false
+	case StateHalfOpen:
+		if cb.halfOpenInFlight >= cb.halfOpenMaxRequests {
+			return time.Time{}, false
+		}
+		cb.halfOpenInFlight++
+		return cb.lastStateChange, true
+	default:
+		return time.Time{}, true
+	}
+}
+
+// releaseProbe frees the probe slot taken by admitCall, unless the circuit has
+// left the half-open period it was taken in and the count was reset
+func (cb *CircuitBreaker) releaseProbe(since time.Time) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateHalfOpen && cb.lastStateChange.Equal(since) && cb.halfOpenInFlight > 0 {
+		cb.halfOpenInFlight--
+	}
+}
+
 // shouldOpen determines if the circuit should be opened based on recent requests
 func (cb *CircuitBreaker) shouldOpen() bool {
 	if cb.state != StateClosed {
@@ -191,6 +428,13 @@ func (cb *CircuitBreaker) shouldOpen() bool {
 
 // cleanup removes requests outside the time window
 func (cb *CircuitBreaker) cleanup(now time.Time) {
+	cb.requests = cb.window(now)
+}
+
+// window returns the requests recorded within the time window ending at now.
+// Requests are only pruned when new ones are recorded, so readers use this
+// to ignore stale records after a quiet period. Caller must hold the lock.
+func (cb *CircuitBreaker) window(now time.Time) []requestRecord {
 	cutoff := now.Add(-cb.windowSize)
 	validRequests := make([]requestRecord, 0, len(cb.requests))
 
@@ -200,7 +444,7 @@ func (cb *CircuitBreaker) cleanup(now time.Time) {
 		}
 	}
 
-	cb.requests = validRequests
+	return validRequests
 }
 
 // GetStats returns the current statistics
@@ -208,12 +452,13 @@ func (cb *CircuitBreaker) GetStats() (total, failures int, failureRate float64)
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
 
-	total = len(cb.requests)
+	requests := cb.window(time.Now())
+	total = len(requests)
 	if total == 0 {
 		return 0, 0, 0
 	}
 
-	for _, req := range cb.requests {
+	for _, req := range requests {
 		if !req.success {
 			failures++
 		}
@@ -223,6 +468,31 @@ func (cb *CircuitBreaker) GetStats() (total, failures int, failureRate float64)
 	return
 }
 
+// TopFailureSource returns the source with the most failures in the window,
+// how many it caused and the total failures in the window. Failures recorded
+// without a source are counted in the total only.
+func (cb *CircuitBreaker) TopFailureSource() (source string, sourceFailures, failures int) {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, req := range cb.window(time.Now()) {
+		if req.success {
+			continue
+		}
+		failures++
+		if req.source == "" {
+			continue
+		}
+		counts[req.source]++
+		if n := counts[req.source]; n > sourceFailures || (n == sourceFailures && req.source < source) {
+			source, sourceFailures = req.source, n
+		}
+	}
+
+	return source, sourceFailures, failures
+}
+
 // ErrCircuitBreakerOpen is returned when the circuit breaker is open
 var ErrCircuitBreakerOpen = &CircuitBreakerError{}
 
@@ -1,12 +1,19 @@
 package manager
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
 func TestCircuitBreaker_IsOpen(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 2*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(2*time.Second),
+	)
 
 	if cb.IsOpen() {
 		t.Error("Circuit breaker should be closed initially")
@@ -14,7 +21,12 @@ func TestCircuitBreaker_IsOpen(t *testing.T) {
 }
 
 func TestCircuitBreaker_RecordSuccess(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 2*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(2*time.Second),
+	)
 
 	for i := 0; i < 10; i++ {
 		cb.RecordSuccess()
@@ -37,7 +49,12 @@ func TestCircuitBreaker_RecordSuccess(t *testing.T) {
 }
 
 func TestCircuitBreaker_RecordFailure(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 500*time.Millisecond)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(500*time.Millisecond),
+	)
 
 	// Record enough failures to open the circuit
 	for i := 0; i < 3; i++ {
@@ -59,7 +76,12 @@ func TestCircuitBreaker_RecordFailure(t *testing.T) {
 }
 
 func TestCircuitBreaker_HalfOpen(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 500*time.Millisecond)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(500*time.Millisecond),
+	)
 
 	// Open the circuit
 	for i := 0; i < 3; i++ {
@@ -88,7 +110,12 @@ func TestCircuitBreaker_HalfOpen(t *testing.T) {
 }
 
 func TestCircuitBreaker_HalfOpenFailure(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 500*time.Millisecond)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(500*time.Millisecond),
+	)
 
 	// Open the circuit
 	for i := 0; i < 3; i++ {
@@ -110,7 +137,12 @@ func TestCircuitBreaker_HalfOpenFailure(t *testing.T) {
 }
 
 func TestCircuitBreaker_GetState(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 1*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(1*time.Second),
+	)
 
 	if cb.GetState() != StateClosed {
 		t.Error("Circuit breaker should be closed initially")
@@ -130,7 +162,12 @@ func TestCircuitBreaker_GetState(t *testing.T) {
 }
 
 func TestCircuitBreaker_MinRequests(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 10, 1*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(10),
+		WithBreakDuration(1*time.Second),
+	)
 
 	// Record failures but below min requests
 	for i := 0; i < 5; i++ {
@@ -142,8 +179,37 @@ func TestCircuitBreaker_MinRequests(t *testing.T) {
 	}
 }
 
+func TestCircuitBreaker_GetStatsAfterQuietPeriod(t *testing.T) {
+	cb := NewCircuitBreaker(
+		WithWindowSize(100*time.Millisecond),
+		WithMinRequests(10),
+	)
+
+	cb.RecordSuccess()
+	cb.RecordFailureFrom("10.0.0.1")
+	if total, failures, _ := cb.GetStats(); total != 2 || failures != 1 {
+		t.Fatalf("Expected 2 requests with 1 failure, got %d and %d", total, failures)
+	}
+
+	// Nothing is recorded while the window passes, so nothing prunes the records
+	time.Sleep(150 * time.Millisecond)
+
+	total, failures, rate := cb.GetStats()
+	if total != 0 || failures != 0 || rate != 0 {
+		t.Errorf("Expected empty stats after the window passed, got %d, %d, %.1f%%", total, failures, rate)
+	}
+	if source, _, failures := cb.TopFailureSource(); source != "" || failures != 0 {
+		t.Errorf("Expected no failure source after the window passed, got %q with %d failures", source, failures)
+	}
+}
+
 func TestCircuitBreaker_Call(t *testing.T) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 5, 500*time.Millisecond)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(500*time.Millisecond),
+	)
 
 	// Successful calls
 	for i := 0; i < 5; i++ {
@@ -168,9 +234,72 @@ func TestCircuitBreaker_Call(t *testing.T) {
 	}
 }
 
+func TestCircuitBreaker_CallHalfOpenConcurrency(t *testing.T) {
+	const callers = 200
+	breakDuration := 50 * time.Millisecond
+
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(10*time.Second),
+		WithMinRequests(5),
+		WithBreakDuration(breakDuration),
+		WithHalfOpenMaxRequests(3),
+	)
+	for i := 0; i < 5; i++ {
+		cb.RecordFailure()
+	}
+	if cb.GetState() != StateOpen {
+		t.Fatal("Circuit breaker should be open")
+	}
+
+	start := make(chan struct{})
+	release := make(chan struct{})
+	var admitted, rejected atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			err := cb.Call(func() error {
+				admitted.Add(1)
+				<-release
+				return nil
+			})
+			if err == ErrCircuitBreakerOpen {
+				rejected.Add(1)
+			}
+		}()
+	}
+
+	// Release every caller the moment the circuit may go half-open
+	time.Sleep(breakDuration)
+	close(start)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for admitted.Load()+rejected.Load() < callers && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	got := admitted.Load()
+	close(release)
+	wg.Wait()
+
+	if got != 3 {
+		t.Errorf("Expected exactly 3 concurrent half-open probes, got %d", got)
+	}
+	if cb.GetState() != StateClosed {
+		t.Errorf("Expected the probes' successes to close the circuit, got %v", cb.GetState())
+	}
+}
+
 // Benchmark tests
 func BenchmarkCircuitBreaker_RecordSuccess(b *testing.B) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 10, 1*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(10),
+		WithBreakDuration(1*time.Second),
+	)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -179,7 +308,12 @@ func BenchmarkCircuitBreaker_RecordSuccess(b *testing.B) {
 }
 
 func BenchmarkCircuitBreaker_RecordFailure(b *testing.B) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 10, 1*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(10),
+		WithBreakDuration(1*time.Second),
+	)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -188,7 +322,12 @@ func BenchmarkCircuitBreaker_RecordFailure(b *testing.B) {
 }
 
 func BenchmarkCircuitBreaker_IsOpen(b *testing.B) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 10, 1*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(10),
+		WithBreakDuration(1*time.Second),
+	)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -197,7 +336,12 @@ func BenchmarkCircuitBreaker_IsOpen(b *testing.B) {
 }
 
 func BenchmarkCircuitBreaker_GetStats(b *testing.B) {
-	cb := NewCircuitBreaker(50, 1*time.Second, 10, 1*time.Second)
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(10),
+		WithBreakDuration(1*time.Second),
+	)
 	for i := 0; i < 50; i++ {
 		cb.RecordSuccess()
 	}
@@ -210,3 +354,181 @@ func BenchmarkCircuitBreaker_GetStats(b *testing.B) {
 		cb.GetStats()
 	}
 }
+
+func TestCircuitBreaker_OnStateChange(t *testing.T) {
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(2),
+		WithBreakDuration(50*time.Millisecond),
+		WithHalfOpenMaxRequests(1),
+	)
+
+	var changes []string
+	cb.OnStateChange(func(from, to CircuitBreakerState) {
+		// The callback runs outside the lock
+		cb.GetStats()
+		changes = append(changes, from.String()+"->"+to.String())
+	})
+
+	cb.RecordFailure()
+	cb.RecordFailure() // closed -> open
+
+	time.Sleep(60 * time.Millisecond)
+	cb.RecordFailure() // open -> half-open -> open
+
+	time.Sleep(60 * time.Millisecond)
+	cb.RecordSuccess() // open -> half-open -> closed
+
+	want := []string{
+		"closed->open",
+		"open->half-open",
+		"half-open->open",
+		"open->half-open",
+		"half-open->closed",
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("Expected transitions %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("Transition %d: expected %s, got %s", i, want[i], changes[i])
+		}
+	}
+}
+
+// openBreaker trips cb, which must require at most two requests, and waits out breakDuration
+func openBreaker(t *testing.T, cb *CircuitBreaker, breakDuration time.Duration) {
+	t.Helper()
+
+	cb.RecordFailure()
+	cb.RecordFailure()
+	if cb.Allow() {
+		t.Fatal("Circuit breaker should reject requests while open")
+	}
+	time.Sleep(breakDuration + 10*time.Millisecond)
+}
+
+func TestCircuitBreaker_SlowStartProbes(t *testing.T) {
+	cb := NewCircuitBreaker(
+		WithMinRequests(2),
+		WithBreakDuration(20*time.Millisecond),
+		WithSlowStart(2, 50*time.Millisecond, 0),
+	)
+	openBreaker(t, cb, 20*time.Millisecond)
+
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		if cb.Allow() {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Errorf("Expected 2 probes admitted in the first interval, got %d", admitted)
+	}
+	if cb.GetState() != StateHalfOpen {
+		t.Errorf("Expected half-open, got %s", cb.GetState())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if !cb.Allow() {
+		t.Error("Expected another probe to be admitted in the next interval")
+	}
+}
+
+func TestCircuitBreaker_SlowStartWithoutLimit(t *testing.T) {
+	cb := NewCircuitBreaker(WithMinRequests(2), WithBreakDuration(20*time.Millisecond))
+	openBreaker(t, cb, 20*time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		if !cb.Allow() {
+			t.Fatalf("Request %d should be admitted while half-open without a probe limit", i+1)
+		}
+	}
+}
+
+func TestCircuitBreaker_SlowStartSuccessPeriod(t *testing.T) {
+	cb := NewCircuitBreaker(
+		WithMinRequests(2),
+		WithBreakDuration(20*time.Millisecond),
+		WithHalfOpenMaxRequests(1),
+		WithSlowStart(1, 10*time.Millisecond, 100*time.Millisecond),
+	)
+	openBreaker(t, cb, 20*time.Millisecond)
+
+	cb.Allow()
+	cb.RecordSuccess()
+	cb.RecordSuccess()
+	if state := cb.GetState(); state != StateHalfOpen {
+		t.Fatalf("Successes before the success period should not close the circuit, got %s", state)
+	}
+
+	time.Sleep(110 * time.Millisecond)
+	cb.RecordSuccess()
+	if state := cb.GetState(); state != StateClosed {
+		t.Errorf("Expected the circuit to close after sustained success, got %s", state)
+	}
+}
+
+func TestCircuitBreaker_ShadowMode(t *testing.T) {
+	var transitions []CircuitBreakerState
+	cb := NewCircuitBreaker(
+		WithFailureThreshold(50),
+		WithWindowSize(time.Minute),
+		WithMinRequests(4),
+		WithBreakDuration(time.Minute),
+		WithShadowMode(true),
+	)
+	cb.OnStateChange(func(from, to CircuitBreakerState) {
+		transitions = append(transitions, to)
+	})
+
+	for i := 0; i < 4; i++ {
+		cb.RecordFailure()
+	}
+
+	// State is tracked as usual
+	if cb.GetState() != StateOpen {
+		t.Fatalf("Expected open state, got %v", cb.GetState())
+	}
+	if len(transitions) != 1 || transitions[0] != StateOpen {
+		t.Errorf("Expected a transition to open, got %v", transitions)
+	}
+
+	// But nothing is rejected
+	if cb.IsOpen() {
+		t.Error("IsOpen should be false in shadow mode")
+	}
+	for i := 0; i < 3; i++ {
+		if !cb.Allow() {
+			t.Fatalf("Allow %d: expected admission in shadow mode", i)
+		}
+	}
+	if err := cb.Call(func() error { return nil }); err != nil {
+		t.Errorf("Call should run in shadow mode, got %v", err)
+	}
+
+	if got := cb.ShadowRejections(); got != 4 {
+		t.Errorf("Expected 4 shadow rejections, got %d", got)
+	}
+}
+
+func TestCircuitBreaker_TopFailureSource(t *testing.T) {
+	cb := NewCircuitBreaker(WithMinRequests(100))
+
+	if source, _, _ := cb.TopFailureSource(); source != "" {
+		t.Errorf("Expected no source without failures, got %q", source)
+	}
+
+	for range 3 {
+		cb.RecordFailureFrom("10.0.0.1")
+	}
+	cb.RecordFailureFrom("10.0.0.2")
+	cb.RecordFailure()
+	cb.RecordSuccess()
+
+	source, sourceFailures, failures := cb.TopFailureSource()
+	if source != "10.0.0.1" || sourceFailures != 3 || failures != 5 {
+		t.Errorf("TopFailureSource() = %q, %d, %d, want 10.0.0.1, 3, 5", source, sourceFailures, failures)
+	}
+}
@@ -0,0 +1,93 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// budgetBuckets is how many slices a budget window is counted in; the window
+// slides one slice at a time
+const budgetBuckets = 60
+
+// Budget caps how many events may happen within a sliding time window, such
+// as connections per hour across the whole proxy
+type Budget struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	slice  time.Duration
+	counts [budgetBuckets]int // Events per slice, a ring ending at head
+	head   int
+	start  time.Time // Start of the head slice
+	used   int       // Sum of counts
+}
+
+// NewBudget creates a budget allowing limit events per window
+func NewBudget(limit int, window time.Duration) *Budget {
+	return &Budget{
+		limit:  limit,
+		window: window,
+		slice:  window / budgetBuckets,
+		start:  time.Now(),
+	}
+}
+
+// Take records one event and reports whether it fit within the budget.
+// Rejected events are not counted.
+func (b *Budget) Take() bool {
+	return b.take(time.Now())
+}
+
+// take is Take at the given time
+func (b *Budget) take(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(now)
+	if b.used >= b.limit {
+		return false
+	}
+	b.counts[b.head]++
+	b.used++
+	return true
+}
+
+// Used returns how many events the current window holds
+func (b *Budget) Used() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.advance(time.Now())
+	return b.used
+}
+
+// Limit returns how many events a window may hold
+func (b *Budget) Limit() int {
+	return b.limit
+}
+
+// Window returns the length of the sliding window
+func (b *Budget) Window() time.Duration {
+	return b.window
+}
+
+// advance drops the slices that have slid out of the window by now. Caller
+// must hold the lock.
+func (b *Budget) advance(now time.Time) {
+	steps := int(now.Sub(b.start) / b.slice)
+	if steps <= 0 {
+		return
+	}
+
+	if steps >= budgetBuckets {
+		b.counts = [budgetBuckets]int{}
+		b.used = 0
+	} else {
+		for range steps {
+			b.head = (b.head + 1) % budgetBuckets
+			b.used -= b.counts[b.head]
+			b.counts[b.head] = 0
+		}
+	}
+	b.start = b.start.Add(time.Duration(steps) * b.slice)
+}
@@ -0,0 +1,49 @@
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBudget_Take(t *testing.T) {
+	b := NewBudget(3, time.Hour)
+	start := b.start
+
+	for i := range 3 {
+		if !b.take(start) {
+			t.Fatalf("Take %d should fit within the budget", i+1)
+		}
+	}
+	if b.take(start.Add(time.Minute)) {
+		t.Error("Take should be rejected once the budget is used up")
+	}
+	if b.used != 3 {
+		t.Errorf("Expected rejected takes not to count, got %d used", b.used)
+	}
+}
+
+func TestBudget_Slides(t *testing.T) {
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  bool
+	}{
+		{"within window", 59 * time.Minute, false},
+		{"first slice expired", 61 * time.Minute, true},
+		{"window long past", 5 * time.Hour, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(2, time.Hour)
+			start := b.start
+
+			b.take(start)
+			b.take(start.Add(30 * time.Minute))
+
+			if got := b.take(start.Add(tt.after)); got != tt.want {
+				t.Errorf("Take after %v = %v, want %v", tt.after, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"sort"
+	"sync"
+)
+
+// ConnLimiter caps how many connections each key, such as a username, may
+// have open at once
+type ConnLimiter struct {
+	mu     sync.Mutex
+	max    int
+	active map[string]int
+}
+
+// ConnCount is the number of connections a key has open
+type ConnCount struct {
+	Key    string `json:"key"`
+	Active int    `json:"active"`
+}
+
+// NewConnLimiter creates a limiter allowing max simultaneous connections per key
+func NewConnLimiter(max int) *ConnLimiter {
+	return &ConnLimiter{
+		max:    max,
+		active: make(map[string]int),
+	}
+}
+
+// Acquire takes one of key's connection slots, reporting false when all are
+// in use. Call the returned release once the connection ends.
+func (l *ConnLimiter) Acquire(key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.active[key] >= l.max {
+		return nil, false
+	}
+	l.active[key]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { l.release(key) })
+	}, true
+}
+
+// release frees one of key's slots, forgetting keys without connections
+func (l *ConnLimiter) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.active[key]--
+	if l.active[key] <= 0 {
+		delete(l.active, key)
+	}
+}
+
+// Max returns the number of simultaneous connections allowed per key
+func (l *ConnLimiter) Max() int {
+	return l.max
+}
+
+// Active returns how many connections key has open
+func (l *ConnLimiter) Active(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.active[key]
+}
+
+// Len returns the number of keys with open connections
+func (l *ConnLimiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.active)
+}
+
+// Top returns up to n keys with the most open connections, busiest first.
+// n <= 0 returns every key.
+func (l *ConnLimiter) Top(n int) []ConnCount {
+	l.mu.Lock()
+	counts := make([]ConnCount, 0, len(l.active))
+	for key, active := range l.active {
+		counts = append(counts, ConnCount{Key: key, Active: active})
+	}
+	l.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Active != counts[j].Active {
+			return counts[i].Active > counts[j].Active
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	if n > 0 && len(counts) > n {
+		counts = counts[:n]
+	}
+	return counts
+}
@@ -0,0 +1,69 @@
+package manager
+
+import "testing"
+
+func TestConnLimiter_Acquire(t *testing.T) {
+	l := NewConnLimiter(2)
+
+	releaseFirst, ok := l.Acquire("alice")
+	if !ok {
+		t.Fatal("Expected the first connection to be allowed")
+	}
+	releaseSecond, ok := l.Acquire("alice")
+	if !ok {
+		t.Fatal("Expected the second connection to be allowed")
+	}
+	if _, ok := l.Acquire("alice"); ok {
+		t.Error("Expected a third connection to be rejected")
+	}
+	releaseBob, ok := l.Acquire("bob")
+	if !ok {
+		t.Error("Expected other keys to have their own slots")
+	}
+
+	releaseFirst()
+	releaseFirst() // Releasing twice must not free the other connection's slot
+	if n := l.Active("alice"); n != 1 {
+		t.Errorf("Expected 1 active connection, got %d", n)
+	}
+	releaseAgain, ok := l.Acquire("alice")
+	if !ok {
+		t.Error("Expected a released slot to be reusable")
+	}
+
+	releaseSecond()
+	releaseAgain()
+	releaseBob()
+	if l.Len() != 0 {
+		t.Errorf("Expected keys without connections to be forgotten, got %d", l.Len())
+	}
+}
+
+func TestConnLimiter_Top(t *testing.T) {
+	l := NewConnLimiter(10)
+	for _, key := range []string{"b.example", "a.example", "a.example", "c.example", "c.example"} {
+		l.Acquire(key)
+	}
+
+	tests := []struct {
+		n    int
+		want []ConnCount
+	}{
+		{0, []ConnCount{{"a.example", 2}, {"c.example", 2}, {"b.example", 1}}},
+		{2, []ConnCount{{"a.example", 2}, {"c.example", 2}}},
+		{5, []ConnCount{{"a.example", 2}, {"c.example", 2}, {"b.example", 1}}},
+	}
+
+	for _, tt := range tests {
+		got := l.Top(tt.n)
+		if len(got) != len(tt.want) {
+			t.Fatalf("Top(%d) = %v, want %v", tt.n, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("Top(%d) = %v, want %v", tt.n, got, tt.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConnInfo describes an established proxy connection
+type ConnInfo struct {
+	ID        uint64    `json:"id,omitempty"` // Unique per registered connection
+	ConnID    uint64    `json:"conn_id"`      // The client connection's conn_id in the logs
+	Protocol  string    `json:"protocol"`
+	ClientIP  string    `json:"client_ip"`
+	Username  string    `json:"username"`
+	Target    string    `json:"target"`
+	StartedAt time.Time `json:"started_at"`
+	BytesUp   int64     `json:"bytes_up"`   // Client to target
+	BytesDown int64     `json:"bytes_down"` // Target to client
+}
+
+// TrackedConn is a connection registered with a ConnRegistry. Its byte
+// counts are touched by its own copy goroutines only, so counting never
+// contends with other connections.
+type TrackedConn struct {
+	info      ConnInfo // Immutable fields only, byte counts live in the atomics
+	bytesUp   atomic.Int64
+	bytesDown atomic.Int64
+	cancel    func() // Aborts the connection, may be nil
+	registry  *ConnRegistry
+}
+
+// AddUp counts n bytes sent from the client to the target
+func (c *TrackedConn) AddUp(n int64) {
+	c.bytesUp.Add(n)
+}
+
+// AddDown counts n bytes sent from the target to the client
+func (c *TrackedConn) AddDown(n int64) {
+	c.bytesDown.Add(n)
+}
+
+// Info returns a snapshot of the connection
+func (c *TrackedConn) Info() ConnInfo {
+	info := c.info
+	info.BytesUp = c.bytesUp.Load()
+	info.BytesDown = c.bytesDown.Load()
+	return info
+}
+
+// Close removes the connection from the registry and adds its bytes to the
+// registry's totals. Safe to call more than once.
+func (c *TrackedConn) Close() {
+	r := c.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.conns[c.info.ID]; !ok {
+		return
+	}
+	delete(r.conns, c.info.ID)
+	r.closedUp += c.bytesUp.Load()
+	r.closedDown += c.bytesDown.Load()
+}
+
+// ConnRegistry tracks established connections and the bytes they transfer
+type ConnRegistry struct {
+	mu     sync.Mutex
+	nextID uint64
+	conns  map[uint64]*TrackedConn
+
+	// Bytes transferred by connections that have already closed
+	closedUp   int64
+	closedDown int64
+}
+
+// NewConnRegistry creates an empty connection registry
+func NewConnRegistry() *ConnRegistry {
+	return &ConnRegistry{
+		conns: make(map[uint64]*TrackedConn),
+	}
+}
+
+// Register adds a connection of the client connection logged as connID.
+// cancel aborts it and may be nil. Close the returned handle when the
+// connection ends.
+func (r *ConnRegistry) Register(connID uint64, protocol, clientIP, username, target string, cancel func()) *TrackedConn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextID++
+	c := &TrackedConn{
+		info: ConnInfo{
+			ID:        r.nextID,
+			ConnID:    connID,
+			Protocol:  protocol,
+			ClientIP:  clientIP,
+			Username:  username,
+			Target:    target,
+			StartedAt: time.Now(),
+		},
+		cancel:   cancel,
+		registry: r,
+	}
+	r.conns[c.info.ID] = c
+
+	return c
+}
+
+// List returns a snapshot of the active connections ordered by ID
+func (r *ConnRegistry) List() []ConnInfo {
+	r.mu.Lock()
+	conns := make([]ConnInfo, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c.Info())
+	}
+	r.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool {
+		return conns[i].ID < conns[j].ID
+	})
+
+	return conns
+}
+
+// CloseUser aborts every active connection authenticated as username and
+// returns how many were aborted
+func (r *ConnRegistry) CloseUser(username string) int {
+	return r.closeMatching(func(c *TrackedConn) bool { return c.info.Username == username })
+}
+
+// CloseAll aborts every active connection and returns how many were aborted
+func (r *ConnRegistry) CloseAll() int {
+	return r.closeMatching(func(*TrackedConn) bool { return true })
+}
+
+// closeMatching aborts the active connections match selects
+func (r *ConnRegistry) closeMatching(match func(c *TrackedConn) bool) int {
+	r.mu.Lock()
+	var cancels []func()
+	for _, c := range r.conns {
+		if match(c) && c.cancel != nil {
+			cancels = append(cancels, c.cancel)
+		}
+	}
+	r.mu.Unlock()
+
+	// Cancel outside the lock, connections unregister themselves as they close
+	for _, cancel := range cancels {
+		cancel()
+	}
+
+	return len(cancels)
+}
+
+// Count returns the number of active connections
+func (r *ConnRegistry) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.conns)
+}
+
+// TotalBytes returns the bytes transferred by every connection since start,
+// summing the active connections' counts with those of closed ones
+func (r *ConnRegistry) TotalBytes() (up, down int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	up, down = r.closedUp, r.closedDown
+	for _, c := range r.conns {
+		up += c.bytesUp.Load()
+		down += c.bytesDown.Load()
+	}
+	return up, down
+}
@@ -0,0 +1,78 @@
+package manager
+
+import "testing"
+
+func TestConnRegistry(t *testing.T) {
+	r := NewConnRegistry()
+
+	a := r.Register(2, "http", "10.0.0.1", "alice", "example.com:443", nil)
+	b := r.Register(3, "socks5", "10.0.0.2", "anonymous", "example.org:80", nil)
+
+	a.AddUp(100)
+	a.AddDown(2000)
+	b.AddDown(5)
+
+	list := r.List()
+	if len(list) != 2 || list[0].ID >= list[1].ID {
+		t.Fatalf("Expected two connections ordered by ID, got %+v", list)
+	}
+	if list[0].Username != "alice" || list[0].BytesUp != 100 || list[0].BytesDown != 2000 {
+		t.Errorf("Unexpected first connection: %+v", list[0])
+	}
+
+	a.Close()
+	a.Close()
+	if r.Count() != 1 {
+		t.Errorf("Expected 1 active connection, got %d", r.Count())
+	}
+
+	// Totals keep the traffic of closed connections
+	if up, down := r.TotalBytes(); up != 100 || down != 2005 {
+		t.Errorf("Expected totals 100/2005, got %d/%d", up, down)
+	}
+}
+
+// BenchmarkTrackedConn_Add counts bytes on many connections at once, as
+// their copy goroutines do
+func BenchmarkTrackedConn_Add(b *testing.B) {
+	r := NewConnRegistry()
+
+	b.RunParallel(func(pb *testing.PB) {
+		c := r.Register(4, "socks5", "10.0.0.1", "anonymous", "example.com:443", nil)
+		defer c.Close()
+		for pb.Next() {
+			c.AddUp(32 * 1024)
+			c.AddDown(32 * 1024)
+		}
+	})
+}
+
+func TestConnRegistry_CloseUser(t *testing.T) {
+	r := NewConnRegistry()
+
+	canceled := map[string]int{}
+	register := func(username string) *TrackedConn {
+		return r.Register(5, "socks5", "10.0.0.1", username, "example.com:443", func() { canceled[username]++ })
+	}
+	register("alice")
+	register("alice")
+	register("bob")
+
+	if n := r.CloseUser("alice"); n != 2 {
+		t.Errorf("Expected 2 connections closed, got %d", n)
+	}
+	if canceled["alice"] != 2 || canceled["bob"] != 0 {
+		t.Errorf("Unexpected cancellations: %v", canceled)
+	}
+	if n := r.CloseUser("carol"); n != 0 {
+		t.Errorf("Expected no connections closed for an unknown user, got %d", n)
+	}
+
+	if n := r.CloseAll(); n != 3 {
+		t.Errorf("Expected 3 connections closed, got %d", n)
+	}
+	// Closed connections stay registered until they end, so alice's are canceled again
+	if canceled["alice"] != 4 || canceled["bob"] != 1 {
+		t.Errorf("Unexpected cancellations: %v", canceled)
+	}
+}
@@ -0,0 +1,77 @@
+package manager
+
+import (
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+)
+
+// DebugIPs is the set of client IPs and CIDRs whose connections are logged at
+// debug verbosity whatever the configured level. It is read on every debug
+// log call, so reads are lock-free and Set swaps the whole list.
+type DebugIPs struct {
+	prefixes atomic.Pointer[[]netip.Prefix]
+}
+
+// NewDebugIPs creates a set holding entries, which must be valid (see Set)
+func NewDebugIPs(entries []string) (*DebugIPs, error) {
+	d := &DebugIPs{}
+	if err := d.Set(entries); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Set replaces the set with entries, each an IP or CIDR. Nothing changes if
+// any entry is invalid.
+func (d *DebugIPs) Set(entries []string) error {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		prefix, err := parseFeedEntry(StripZone(entry))
+		if err != nil {
+			return fmt.Errorf("invalid debug IP entry %q: %w", entry, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	d.prefixes.Store(&prefixes)
+	return nil
+}
+
+// Contains reports whether ip is in the set
+func (d *DebugIPs) Contains(ip string) bool {
+	prefixes := d.prefixes.Load()
+	if prefixes == nil || len(*prefixes) == 0 {
+		return false
+	}
+
+	addr, err := netip.ParseAddr(StripZone(ip))
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, prefix := range *prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the entries in the set, bare IPs without a prefix length
+func (d *DebugIPs) List() []string {
+	prefixes := d.prefixes.Load()
+	if prefixes == nil {
+		return []string{}
+	}
+
+	entries := make([]string, 0, len(*prefixes))
+	for _, prefix := range *prefixes {
+		if prefix.IsSingleIP() {
+			entries = append(entries, prefix.Addr().String())
+		} else {
+			entries = append(entries, prefix.String())
+		}
+	}
+	return entries
+}
@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDebugIPs(t *testing.T) {
+	d, err := NewDebugIPs([]string{"10.0.0.1", "192.168.0.0/16", "fe80::1%eth0"})
+	if err != nil {
+		t.Fatalf("NewDebugIPs() error = %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"10.0.0.1", true},
+		{"10.0.0.2", false},
+		{"192.168.4.20", true},
+		{"::ffff:192.168.4.20", true},
+		{"fe80::1", true},
+		{"fe80::1%eth1", true},
+		{"not-an-ip", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := d.Contains(tt.ip); got != tt.want {
+				t.Errorf("Contains(%q) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+
+	if got, want := d.List(), []string{"10.0.0.1", "192.168.0.0/16", "fe80::1"}; !slices.Equal(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+
+	// An invalid entry leaves the set unchanged
+	if err := d.Set([]string{"10.0.0.2", "bogus"}); err == nil {
+		t.Error("Expected an error for an invalid entry")
+	}
+	if !d.Contains("10.0.0.1") || d.Contains("10.0.0.2") {
+		t.Error("A failed Set should not change the set")
+	}
+
+	if err := d.Set(nil); err != nil || d.Contains("10.0.0.1") {
+		t.Errorf("Expected Set(nil) to clear the set, err = %v", err)
+	}
+}
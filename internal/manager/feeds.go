@@ -0,0 +1,244 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Kinds of IP feed
+const (
+	FeedBlock = "block" // Listed addresses are banned
+	FeedAllow = "allow" // Listed addresses are never banned, like the whitelist
+)
+
+// BanReasonFeed is the reason reported for addresses banned by a block feed
+const BanReasonFeed = "feed"
+
+// maxFeedSize bounds how much of a feed is read
+const maxFeedSize = 32 << 20
+
+// feedTimeout bounds fetching a feed over HTTP
+const feedTimeout = 30 * time.Second
+
+// FeedInfo describes a loaded IP feed
+type FeedInfo struct {
+	Source    string    `json:"source"`
+	Kind      string    `json:"kind"`
+	Entries   int       `json:"entries"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ipFeed is a set of addresses and networks from one feed. Single addresses
+// are kept in a map so large lists stay cheap to check.
+type ipFeed struct {
+	info     FeedInfo
+	addrs    map[netip.Addr]struct{}
+	prefixes []netip.Prefix // Networks wider than a single address
+}
+
+// contains reports whether the feed lists addr
+func (f *ipFeed) contains(addr netip.Addr) bool {
+	if _, ok := f.addrs[addr]; ok {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFeed replaces the entries of the feed named source. Feed entries are
+// kept apart from locally generated bans: they are not persisted, expire
+// only when the feed changes, and ClearFeed removes them without touching
+// other bans.
+func (m *IPBanManager) SetFeed(source, kind string, prefixes []netip.Prefix) {
+	feed := &ipFeed{
+		info:  FeedInfo{Source: source, Kind: kind, Entries: len(prefixes), UpdatedAt: time.Now()},
+		addrs: make(map[netip.Addr]struct{}),
+	}
+	for _, prefix := range prefixes {
+		if prefix.IsSingleIP() {
+			feed.addrs[prefix.Addr()] = struct{}{}
+		} else {
+			feed.prefixes = append(feed.prefixes, prefix)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.feeds == nil {
+		m.feeds = make(map[string]*ipFeed)
+	}
+	m.feeds[source] = feed
+}
+
+// ClearFeed removes the feed named source and reports whether it was loaded
+func (m *IPBanManager) ClearFeed(source string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.feeds[source]; !ok {
+		return false
+	}
+	delete(m.feeds, source)
+	return true
+}
+
+// Feeds describes the loaded feeds, ordered by source
+func (m *IPBanManager) Feeds() []FeedInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	infos := make([]FeedInfo, 0, len(m.feeds))
+	for _, feed := range m.feeds {
+		infos = append(infos, feed.info)
+	}
+	slices.SortFunc(infos, func(a, b FeedInfo) int { return strings.Compare(a.Source, b.Source) })
+	return infos
+}
+
+// feedMatch returns the first feed of kind listing ip, ordered by source so
+// the answer is stable. Caller must hold the lock.
+func (m *IPBanManager) feedMatch(ip, kind string) (source string, ok bool) {
+	if len(m.feeds) == 0 {
+		return "", false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return "", false
+	}
+	addr = addr.Unmap()
+
+	for name, feed := range m.feeds {
+		if feed.info.Kind == kind && feed.contains(addr) && (!ok || name < source) {
+			source, ok = name, true
+		}
+	}
+	return source, ok
+}
+
+// WatchFeed loads the feed at location, a file path or an http(s) URL, into
+// the feed named source, then reloads it every refresh until Stop. Zero
+// refresh loads it once. A failed load is logged and keeps the previous entries.
+func (m *IPBanManager) WatchFeed(source, kind, location string, refresh time.Duration) {
+	m.reloadFeed(source, kind, location)
+	if refresh <= 0 {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(refresh)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				m.reloadFeed(source, kind, location)
+			case <-m.stopCleanup:
+				return
+			}
+		}
+	}()
+}
+
+// reloadFeed fetches and parses a feed, logging the outcome
+func (m *IPBanManager) reloadFeed(source, kind, location string) {
+	prefixes, skipped, err := fetchFeed(location)
+	if err != nil {
+		logger.Error("Failed to load IP feed", "feed", source, "location", location, "error", err)
+		return
+	}
+
+	m.SetFeed(source, kind, prefixes)
+	logger.Info("Loaded IP feed", "feed", source, "kind", kind, "entries", len(prefixes), "skipped_lines", skipped)
+}
+
+// fetchFeed reads the feed at location, a file path or an http(s) URL
+func fetchFeed(location string) (prefixes []netip.Prefix, skipped int, err error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		ctx, cancel := context.WithTimeout(context.Background(), feedTimeout)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+		if err != nil {
+			return nil, 0, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("unexpected status %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, 0, err
+		}
+		r = f
+	}
+	defer r.Close()
+
+	return ParseFeed(io.LimitReader(r, maxFeedSize))
+}
+
+// ParseFeed reads one IP address or CIDR per line. Text after "#" or ";" is
+// a comment, and only the first field of a line is used, which covers common
+// blocklist formats such as "192.0.2.0/24 ; SBL123". Lines that don't parse
+// are skipped and counted.
+func ParseFeed(r io.Reader) (prefixes []netip.Prefix, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		prefix, err := parseFeedEntry(fields[0])
+		if err != nil {
+			skipped++
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes, skipped, scanner.Err()
+}
+
+// parseFeedEntry parses an IP address or CIDR into a prefix
+func parseFeedEntry(entry string) (netip.Prefix, error) {
+	if strings.Contains(entry, "/") {
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
@@ -0,0 +1,127 @@
+package manager
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeed(t *testing.T) {
+	input := `# Example blocklist
+192.0.2.1
+198.51.100.0/24 ; SBL123
+2001:db8::/32
+::ffff:203.0.113.9
+not-an-ip
+10.0.0.300/8
+`
+	prefixes, skipped, err := ParseFeed(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("ParseFeed() error = %v", err)
+	}
+
+	want := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.1/32"),
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("2001:db8::/32"),
+		netip.MustParsePrefix("203.0.113.9/32"),
+	}
+	if !reflect.DeepEqual(prefixes, want) {
+		t.Errorf("ParseFeed() = %v, want %v", prefixes, want)
+	}
+	if skipped != 2 {
+		t.Errorf("Expected 2 skipped lines, got %d", skipped)
+	}
+}
+
+func TestIPBanManager_Feeds(t *testing.T) {
+	m := NewIPBanManager(3, time.Minute, WithoutPersistence(), WithoutCleanup())
+	defer m.Stop()
+
+	m.SetFeed("drop", FeedBlock, []netip.Prefix{
+		netip.MustParsePrefix("198.51.100.0/24"),
+		netip.MustParsePrefix("192.0.2.1/32"),
+	})
+	m.SetFeed("partners", FeedAllow, []netip.Prefix{netip.MustParsePrefix("198.51.100.7/32")})
+	m.BanIP("203.0.113.5")
+
+	tests := []struct {
+		ip         string
+		wantBanned bool
+		wantSource string
+	}{
+		{"192.0.2.1", true, "drop"},
+		{"198.51.100.20", true, "drop"},
+		{"198.51.100.7", false, ""}, // Allow feed wins over the block feed
+		{"203.0.113.5", true, ""},   // Local ban
+		{"192.0.2.2", false, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := m.IsBanned(tt.ip); got != tt.wantBanned {
+				t.Fatalf("IsBanned() = %v, want %v", got, tt.wantBanned)
+			}
+			record, _ := m.GetBanRecord(tt.ip)
+			if record.Source != tt.wantSource {
+				t.Errorf("Expected source %q, got %q", tt.wantSource, record.Source)
+			}
+		})
+	}
+
+	// Clearing the feed leaves local bans alone
+	if !m.ClearFeed("drop") {
+		t.Fatal("Expected the drop feed to be cleared")
+	}
+	if m.IsBanned("192.0.2.1") {
+		t.Error("Expected feed bans to be removed with the feed")
+	}
+	if !m.IsBanned("203.0.113.5") {
+		t.Error("Expected the local ban to remain")
+	}
+	if m.ClearFeed("drop") {
+		t.Error("Expected clearing an unknown feed to report false")
+	}
+}
+
+func TestIPBanManager_WatchFeed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(path, []byte("192.0.2.0/24\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write feed: %v", err)
+	}
+
+	m := NewIPBanManager(3, time.Minute, WithoutPersistence(), WithoutCleanup())
+	defer m.Stop()
+
+	m.WatchFeed("local-file", FeedBlock, path, 20*time.Millisecond)
+	if !m.IsBanned("192.0.2.10") {
+		t.Fatal("Expected the feed to be loaded immediately")
+	}
+
+	if err := os.WriteFile(path, []byte("198.51.100.0/24\n"), 0o644); err != nil {
+		t.Fatalf("Failed to update feed: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for m.IsBanned("192.0.2.10") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if m.IsBanned("192.0.2.10") || !m.IsBanned("198.51.100.1") {
+		t.Error("Expected the refreshed feed to replace the previous entries")
+	}
+
+	// A failed reload keeps the previous entries
+	os.Remove(path)
+	time.Sleep(50 * time.Millisecond)
+	if !m.IsBanned("198.51.100.1") {
+		t.Error("Expected entries to survive a failed reload")
+	}
+
+	feeds := m.Feeds()
+	if len(feeds) != 1 || feeds[0].Source != "local-file" || feeds[0].Entries != 1 {
+		t.Errorf("Unexpected feeds: %+v", feeds)
+	}
+}
@@ -0,0 +1,94 @@
+package manager
+
+import (
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// HostOverrides pins hostnames to fixed addresses, like a proxy-level
+// /etc/hosts. Rules map an exact hostname or a "*.example.com" pattern,
+// matching subdomains, to an "ip" or "ip:port"; an exact rule wins over a
+// pattern, and a longer pattern over a shorter one. Rules can be replaced
+// while connections are using them.
+type HostOverrides struct {
+	mu       sync.RWMutex
+	exact    map[string]string
+	wildcard []hostOverride // Longest suffix first
+}
+
+// hostOverride is a wildcard rule
+type hostOverride struct {
+	suffix  string // ".example.com"
+	address string
+}
+
+// NewHostOverrides creates overrides from rules, which the configuration has
+// already validated
+func NewHostOverrides(rules map[string]string) *HostOverrides {
+	h := &HostOverrides{}
+	h.Update(rules)
+	return h
+}
+
+// Update replaces every rule
+func (h *HostOverrides) Update(rules map[string]string) {
+	exact := make(map[string]string)
+	var wildcard []hostOverride
+	for host, address := range rules {
+		host = strings.ToLower(strings.TrimSuffix(host, "."))
+		if strings.HasPrefix(host, "*.") {
+			wildcard = append(wildcard, hostOverride{suffix: host[1:], address: address})
+		} else {
+			exact[host] = address
+		}
+	}
+	sort.Slice(wildcard, func(i, j int) bool {
+		return len(wildcard[i].suffix) > len(wildcard[j].suffix)
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.exact = exact
+	h.wildcard = wildcard
+}
+
+// Apply returns the address to dial for target, a host:port, and whether an
+// override matched. An override without a port keeps the target's port.
+func (h *HostOverrides) Apply(target string) (string, bool) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return target, false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	h.mu.RLock()
+	address, ok := h.exact[host]
+	if !ok {
+		for _, rule := range h.wildcard {
+			if strings.HasSuffix(host, rule.suffix) {
+				address, ok = rule.address, true
+				break
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	if !ok {
+		return target, false
+	}
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address, true
+	}
+	return net.JoinHostPort(address, port), true
+}
+
+// Len returns the number of rules
+func (h *HostOverrides) Len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.exact) + len(h.wildcard)
+}
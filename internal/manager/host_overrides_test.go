@@ -0,0 +1,35 @@
+package manager
+
+import "testing"
+
+func TestHostOverrides_Apply(t *testing.T) {
+	h := NewHostOverrides(map[string]string{
+		"foo.internal": "10.0.0.5:8080",
+		"v6.internal":  "2001:db8::1",
+		"*.blue.test":  "10.0.1.1",
+	})
+
+	tests := []struct {
+		target string
+		want   string
+		wantOK bool
+	}{
+		{"foo.internal:443", "10.0.0.5:8080", true},
+		{"v6.internal:443", "[2001:db8::1]:443", true},
+		{"api.Blue.Test:80", "10.0.1.1:80", true},
+		{"blue.test:80", "blue.test:80", false}, // A pattern only matches subdomains
+		{"example.com:443", "example.com:443", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := h.Apply(tt.target)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("Apply(%q) = %q, %v, want %q, %v", tt.target, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	h.Update(nil)
+	if _, ok := h.Apply("foo.internal:443"); ok || h.Len() != 0 {
+		t.Error("Expected Update to replace every rule")
+	}
+}
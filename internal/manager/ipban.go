@@ -1,10 +1,41 @@
 package manager
 
 import (
+	"container/list"
 	"encoding/json"
+	"fmt"
+	"io"
+	"net"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// defaultMaxPersistSize bounds how much of the persistence file is read at startup
+const defaultMaxPersistSize = 10 << 20
+
+// BanHistoryRetention is how long the end of an expired ban is remembered
+const BanHistoryRetention = 24 * time.Hour
+
+// Default retries of a failed save, waiting defaultSaveBackoff before the
+// first and doubling the wait before each next one
+const (
+	defaultSaveRetries = 3
+	defaultSaveBackoff = 100 * time.Millisecond
+)
+
+// Reasons recorded with a ban
+const (
+	BanReasonAuthFailures = "auth_failures" // Too many authentication failures
+	BanReasonManual       = "manual"        // Banned directly through BanIP
+	BanReasonScan         = "scan_detected" // Connected to too many distinct targets
+	BanReasonRateLimit    = "rate_limit"    // Repeatedly exceeded the per-IP rate limit
 )
 
 // BanRecord represents a single IP ban record for persistence
@@ -13,46 +44,188 @@ type BanRecord struct {
 	BannedAt  time.Time `json:"banned_at"`
 	ExpiresAt time.Time `json:"expires_at"`
 	FailCount int       `json:"fail_count"`
+	Reason    string    `json:"reason,omitempty"`
+	Source    string    `json:"source,omitempty"` // Feed that lists the IP, empty for local bans
+}
+
+// IsBanned reports whether the record describes an active ban, local or from
+// a feed, rather than pending failures
+func (r BanRecord) IsBanned() bool {
+	return !r.ExpiresAt.IsZero() || r.Source != ""
 }
 
 // IPBanManager manages IP banning based on authentication failures
 type IPBanManager struct {
 	mu              sync.RWMutex
-	bannedIPs       map[string]time.Time // IP -> ban expiry time
-	bannedFailCount map[string]int       // IP -> failure count at time of ban
-	failureCounts   map[string]int       // IP -> current failure count
+	bannedIPs       map[string]time.Time     // IP -> ban expiry time
+	bannedFailCount map[string]int           // IP -> failure count at time of ban
+	bannedReason    map[string]string        // IP -> why it was banned
+	banEnded        map[string]time.Time     // IP -> when its last ban expired, kept for BanHistoryRetention
+	feeds           map[string]*ipFeed       // Feed source -> entries loaded from it
+	failureCounts   map[string]int           // IP -> current failure count
+	failureTimes    map[string][]time.Time   // IP -> failure timestamps inside the window (window mode only)
+	failureLRU      *list.List               // Tracked failing IPs, most recently failed first
+	failureElems    map[string]*list.Element // IP -> element in failureLRU
+	maxTrackedIPs   int                      // Cap on tracked failing IPs, zero means unlimited
 	maxFailures     int
+	failureWindow   time.Duration        // Zero means failures accumulate until success or ban
+	failureDecay    time.Duration        // Cumulative mode forgets one failure per period without failures, zero disables
+	lastFailure     map[string]time.Time // IP -> when its failure count last grew or decayed (decay only)
 	banDuration     time.Duration
 	whitelist       map[string]bool
+	hostnameRules   *ReverseDNSRules // Allow or block clients by reverse DNS once the IP rules miss, nil disables
 	cleanupInterval time.Duration
 	stopCleanup     chan struct{}
-	persistFile     string // Path to persistence file
+	persistFile     string                                                 // Path to persistence file, empty disables persistence
+	maxPersistSize  int64                                                  // Max persistence file size loaded at startup
+	cleanup         bool                                                   // Whether the background cleanup routine runs
+	saves           sync.WaitGroup                                         // In-flight asynchronous saves
+	stopped         bool                                                   // Set by Stop under mu, after which saveAsync starts no saves
+	saveRetries     int                                                    // Retries of a failed save before giving up on it
+	saveBackoff     time.Duration                                          // Wait before the first retry, doubled for each next one
+	saveFailures    atomic.Int64                                           // Saves that failed after every retry
+	writeFile       func(path string, data []byte, perm os.FileMode) error // writeFileAtomic, replaced in tests
 }
 
-// NewIPBanManager creates a new IP ban manager
-func NewIPBanManager(maxFailures int, banDuration time.Duration, whitelist []string) *IPBanManager {
-	wl := make(map[string]bool)
-	for _, ip := range whitelist {
-		wl[ip] = true
+// IPBanOption configures optional IPBanManager behavior
+type IPBanOption func(*IPBanManager)
+
+// WithWhitelist sets IPs that are never banned
+func WithWhitelist(whitelist []string) IPBanOption {
+	return func(m *IPBanManager) {
+		for _, ip := range whitelist {
+			m.whitelist[StripZone(ip)] = true
+		}
+	}
+}
+
+// WithReverseDNSRules allows or blocks clients by their IP's hostname when
+// neither the whitelist nor a feed decides. An allowed hostname exempts the
+// client from bans like the whitelist.
+func WithReverseDNSRules(rules *ReverseDNSRules) IPBanOption {
+	return func(m *IPBanManager) {
+		m.hostnameRules = rules
+	}
+}
+
+// StripZone removes an IPv6 zone identifier ("fe80::1%eth0" becomes
+// "fe80::1"), so the same host is one key for bans, limits and the whitelist
+// whichever interface it arrived on
+func StripZone(ip string) string {
+	if i := strings.IndexByte(ip, '%'); i >= 0 {
+		return ip[:i]
+	}
+	return ip
+}
+
+// WithFailureWindow enables sliding-window mode: an IP is only banned when
+// maxFailures failures happen within the given window. Older failures are discarded.
+func WithFailureWindow(window time.Duration) IPBanOption {
+	return func(m *IPBanManager) {
+		m.failureWindow = window
+	}
+}
+
+// WithFailureDecay ages out failures in cumulative mode: each full decay
+// period since an IP's last failure forgets one of its failures, so sporadic
+// failures months apart don't add up to a ban. It has no effect in
+// sliding-window mode, where old failures already drop out of the window.
+func WithFailureDecay(decay time.Duration) IPBanOption {
+	return func(m *IPBanManager) {
+		m.failureDecay = decay
+	}
+}
+
+// WithMaxTrackedIPs bounds how many failing IPs are tracked. When the limit is
+// reached the least recently failed IP is forgotten. Bans and the whitelist are unaffected.
+func WithMaxTrackedIPs(max int) IPBanOption {
+	return func(m *IPBanManager) {
+		m.maxTrackedIPs = max
+	}
+}
+
+// WithPersistFile sets the file ban state is persisted to. An empty path disables persistence.
+func WithPersistFile(path string) IPBanOption {
+	return func(m *IPBanManager) {
+		m.persistFile = path
+	}
+}
+
+// WithMaxPersistSize sets the largest persistence file, in bytes, loaded at startup.
+// Larger files are not loaded and the manager starts with an empty ban list.
+func WithMaxPersistSize(size int64) IPBanOption {
+	return func(m *IPBanManager) {
+		m.maxPersistSize = size
+	}
+}
+
+// WithSaveRetries sets how many times a failed save is retried, with a
+// doubling backoff from 100ms, so a transient disk error such as a full disk
+// doesn't lose the save
+func WithSaveRetries(retries int) IPBanOption {
+	return func(m *IPBanManager) {
+		m.saveRetries = retries
 	}
+}
+
+// WithoutPersistence keeps ban state in memory only, without touching disk
+func WithoutPersistence() IPBanOption {
+	return WithPersistFile("")
+}
+
+// WithoutCleanup disables the background routine that removes expired bans.
+// Expired bans are still ignored by IsBanned and GetBannedIPs.
+func WithoutCleanup() IPBanOption {
+	return func(m *IPBanManager) {
+		m.cleanup = false
+	}
+}
 
+// NewIPBanManager creates a new IP ban manager
+func NewIPBanManager(maxFailures int, banDuration time.Duration, opts ...IPBanOption) *IPBanManager {
 	manager := &IPBanManager{
 		bannedIPs:       make(map[string]time.Time),
 		bannedFailCount: make(map[string]int),
+		bannedReason:    make(map[string]string),
+		banEnded:        make(map[string]time.Time),
 		failureCounts:   make(map[string]int),
+		failureTimes:    make(map[string][]time.Time),
+		lastFailure:     make(map[string]time.Time),
+		failureLRU:      list.New(),
+		failureElems:    make(map[string]*list.Element),
 		maxFailures:     maxFailures,
 		banDuration:     banDuration,
-		whitelist:       wl,
+		whitelist:       make(map[string]bool),
 		cleanupInterval: time.Minute,
 		stopCleanup:     make(chan struct{}),
 		persistFile:     "data/ipban.json", // Default persistence file
+		maxPersistSize:  defaultMaxPersistSize,
+		cleanup:         true,
+		saveRetries:     defaultSaveRetries,
+		saveBackoff:     defaultSaveBackoff,
+		writeFile:       writeFileAtomic,
+	}
+
+	for _, opt := range opts {
+		opt(manager)
 	}
 
 	// Load persisted data
-	manager.loadFromFile()
+	if err := manager.loadFromFile(); err != nil {
+		logger.Error("Failed to load persisted IP bans", "file", manager.persistFile, "error", err)
+	}
+
+	// Fall back to memory-only mode rather than failing every save later
+	if err := manager.checkWritable(); err != nil {
+		logger.Warn("IP ban persistence path is not writable, running in memory-only mode",
+			"file", manager.persistFile, "error", err)
+		manager.persistFile = ""
+	}
 
 	// Start cleanup routine
-	go manager.cleanupExpiredBans()
+	if manager.cleanup {
+		go manager.cleanupExpiredBans()
+	}
 
 	return manager
 }
@@ -65,19 +238,27 @@ func (m *IPBanManager) IsBanned(ip string) bool {
 	}
 
 	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	expiry, exists := m.bannedIPs[ip]
-	if !exists {
-		return false
+	// Allow feeds override bans like the whitelist
+	_, allowed := m.feedMatch(ip, FeedAllow)
+	_, blocked := m.feedMatch(ip, FeedBlock)
+	expiry, banned := m.bannedIPs[ip]
+	m.mu.RUnlock()
+
+	if allowed || blocked {
+		return !allowed
 	}
 
-	// Check if ban has expired
-	if time.Now().After(expiry) {
-		return false
+	// Hostname rules need a lookup, so they only run once the IP rules miss
+	if m.hostnameRules != nil {
+		switch m.hostnameRules.Match(ip) {
+		case FeedAllow:
+			return false
+		case FeedBlock:
+			return true
+		}
 	}
 
-	return true
+	return banned && time.Now().Before(expiry)
 }
 
 // RecordFailure records an authentication failure for an IP
@@ -90,18 +271,30 @@ func (m *IPBanManager) RecordFailure(ip string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.failureCounts[ip]++
+	now := time.Now()
+	m.trackFailure(ip)
+	if m.failureWindow > 0 {
+		// Only failures inside the window count towards a ban
+		m.failureTimes[ip] = append(pruneFailures(m.failureTimes[ip], now.Add(-m.failureWindow)), now)
+		m.failureCounts[ip] = len(m.failureTimes[ip])
+	} else {
+		m.failureCounts[ip] = m.decayFailures(ip, now) + 1
+		if m.decays() {
+			m.lastFailure[ip] = now
+		}
+	}
 
 	// Ban the IP if it exceeds the threshold
 	if m.failureCounts[ip] >= m.maxFailures {
 		// Save the failure count that triggered the ban
 		m.bannedFailCount[ip] = m.failureCounts[ip]
-		m.bannedIPs[ip] = time.Now().Add(m.banDuration)
+		m.bannedIPs[ip] = now.Add(m.banDuration)
+		m.bannedReason[ip] = BanReasonAuthFailures
 		// Reset failure count after banning
-		delete(m.failureCounts, ip)
+		m.forgetFailures(ip)
 
 		// Persist the ban
-		go m.saveToFile()
+		m.saveAsync()
 	}
 }
 
@@ -111,7 +304,101 @@ func (m *IPBanManager) RecordSuccess(ip string) {
 	defer m.mu.Unlock()
 
 	// Reset failure count on success
+	m.forgetFailures(ip)
+}
+
+// trackFailure marks ip as the most recently failed IP, evicting the least
+// recently failed one when the tracking limit is exceeded. Caller must hold m.mu.
+func (m *IPBanManager) trackFailure(ip string) {
+	if elem, exists := m.failureElems[ip]; exists {
+		m.failureLRU.MoveToFront(elem)
+		return
+	}
+
+	m.failureElems[ip] = m.failureLRU.PushFront(ip)
+	if m.maxTrackedIPs > 0 && m.failureLRU.Len() > m.maxTrackedIPs {
+		oldest := m.failureLRU.Back()
+		m.forgetFailures(oldest.Value.(string))
+	}
+}
+
+// forgetFailures drops all failure tracking for ip. Caller must hold m.mu.
+func (m *IPBanManager) forgetFailures(ip string) {
 	delete(m.failureCounts, ip)
+	delete(m.failureTimes, ip)
+	delete(m.lastFailure, ip)
+	if elem, exists := m.failureElems[ip]; exists {
+		m.failureLRU.Remove(elem)
+		delete(m.failureElems, ip)
+	}
+}
+
+// decays reports whether failure counts age out
+func (m *IPBanManager) decays() bool {
+	return m.failureDecay > 0 && m.failureWindow == 0
+}
+
+// activeFailures returns ip's failure count without the failures that have
+// decayed by now. Caller must hold m.mu.
+func (m *IPBanManager) activeFailures(ip string, now time.Time) int {
+	count := m.failureCounts[ip]
+	if m.decays() && count > 0 {
+		count -= int(now.Sub(m.lastFailure[ip]) / m.failureDecay)
+	}
+	return max(count, 0)
+}
+
+// decayFailures forgets ip's failures that have decayed by now and returns
+// the remaining count. Caller must hold m.mu for writing.
+func (m *IPBanManager) decayFailures(ip string, now time.Time) int {
+	count := m.failureCounts[ip]
+	active := m.activeFailures(ip, now)
+	if active == count {
+		return count
+	}
+	if active == 0 {
+		m.forgetFailures(ip)
+		return 0
+	}
+
+	// Keep the part of a period that hasn't elapsed yet
+	m.failureCounts[ip] = active
+	m.lastFailure[ip] = m.lastFailure[ip].Add(time.Duration(count-active) * m.failureDecay)
+	return active
+}
+
+// pruneFailures drops failure timestamps at or before the cutoff
+func pruneFailures(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// BanIP bans an IP immediately for the configured ban duration
+func (m *IPBanManager) BanIP(ip string) {
+	m.BanIPWithReason(ip, BanReasonManual)
+}
+
+// BanIPWithReason bans an IP immediately, recording why it was banned
+func (m *IPBanManager) BanIPWithReason(ip, reason string) {
+	// Whitelisted IPs are never banned
+	if m.whitelist[ip] {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.bannedFailCount[ip] = m.activeFailures(ip, now)
+	m.bannedIPs[ip] = now.Add(m.banDuration)
+	m.bannedReason[ip] = reason
+	m.forgetFailures(ip)
+
+	// Persist the ban
+	m.saveAsync()
 }
 
 // UnbanIP manually unbans an IP
@@ -121,13 +408,15 @@ func (m *IPBanManager) UnbanIP(ip string) {
 
 	delete(m.bannedIPs, ip)
 	delete(m.bannedFailCount, ip)
-	delete(m.failureCounts, ip)
+	delete(m.bannedReason, ip)
+	delete(m.banEnded, ip)
+	m.forgetFailures(ip)
 
 	// Persist the change
-	go m.saveToFile()
+	m.saveAsync()
 }
 
-// GetBannedIPs returns a list of currently banned IPs
+// GetBannedIPs returns the currently banned IPs in lexical order
 func (m *IPBanManager) GetBannedIPs() []string {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -139,15 +428,103 @@ func (m *IPBanManager) GetBannedIPs() []string {
 			banned = append(banned, ip)
 		}
 	}
+	sort.Strings(banned)
 	return banned
 }
 
+// ListBans returns up to limit active bans starting at offset, ordered by
+// expiry and then IP, along with the total number of active bans. A limit of
+// zero or less returns every ban from offset on.
+func (m *IPBanManager) ListBans(offset, limit int) (bans []BanRecord, total int) {
+	m.mu.RLock()
+	now := time.Now()
+	records := make([]BanRecord, 0, len(m.bannedIPs))
+	for ip, expiry := range m.bannedIPs {
+		if now.Before(expiry) {
+			records = append(records, BanRecord{
+				IP:        ip,
+				BannedAt:  expiry.Add(-m.banDuration),
+				ExpiresAt: expiry,
+				FailCount: m.bannedFailCount[ip],
+				Reason:    m.bannedReason[ip],
+			})
+		}
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].ExpiresAt.Equal(records[j].ExpiresAt) {
+			return records[i].ExpiresAt.Before(records[j].ExpiresAt)
+		}
+		return records[i].IP < records[j].IP
+	})
+
+	total = len(records)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
+	}
+	return records[offset:end], total
+}
+
+// GetBanRecord returns the record for ip: its active ban with expiry, the
+// failure count that triggered it and the reason, or otherwise its pending
+// failure count. It reports false when the IP is neither banned nor failing.
+func (m *IPBanManager) GetBanRecord(ip string) (BanRecord, bool) {
+	if m.whitelist[ip] {
+		return BanRecord{}, false
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, allowed := m.feedMatch(ip, FeedAllow); allowed {
+		return BanRecord{}, false
+	}
+
+	now := time.Now()
+	if expiry, exists := m.bannedIPs[ip]; exists && now.Before(expiry) {
+		return BanRecord{
+			IP:        ip,
+			BannedAt:  expiry.Add(-m.banDuration),
+			ExpiresAt: expiry,
+			FailCount: m.bannedFailCount[ip],
+			Reason:    m.bannedReason[ip],
+		}, true
+	}
+
+	// Feed bans have no expiry of their own, so they are reported without ban times
+	if source, blocked := m.feedMatch(ip, FeedBlock); blocked {
+		return BanRecord{IP: ip, FailCount: m.activeFailures(ip, now), Reason: BanReasonFeed, Source: source}, true
+	}
+
+	if count := m.activeFailures(ip, now); count > 0 {
+		return BanRecord{IP: ip, FailCount: count}, true
+	}
+
+	return BanRecord{}, false
+}
+
+// BannedWithin reports whether ip is banned or had a ban end less than d ago.
+// Only local bans count, and ended bans are remembered for BanHistoryRetention.
+func (m *IPBanManager) BannedWithin(ip string, d time.Duration) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ended, ok := m.bannedIPs[ip]
+	if !ok {
+		ended, ok = m.banEnded[ip]
+	}
+	return ok && time.Since(ended) < d
+}
+
 // GetFailureCount returns the current failure count for an IP
 func (m *IPBanManager) GetFailureCount(ip string) int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	return m.failureCounts[ip]
+	return m.activeFailures(ip, time.Now())
 }
 
 // cleanupExpiredBans periodically removes expired bans
@@ -164,14 +541,43 @@ func (m *IPBanManager) cleanupExpiredBans() {
 			for ip, expiry := range m.bannedIPs {
 				if now.After(expiry) {
 					delete(m.bannedIPs, ip)
+					delete(m.bannedFailCount, ip)
+					delete(m.bannedReason, ip)
+					m.banEnded[ip] = expiry
 					changed = true
 				}
 			}
+			for ip, ended := range m.banEnded {
+				if now.Sub(ended) > BanHistoryRetention {
+					delete(m.banEnded, ip)
+				}
+			}
+			// Forget failures that have slid out of the window
+			if m.failureWindow > 0 {
+				cutoff := now.Add(-m.failureWindow)
+				for ip, times := range m.failureTimes {
+					if times = pruneFailures(times, cutoff); len(times) == 0 {
+						m.forgetFailures(ip)
+						changed = true
+					} else {
+						m.failureTimes[ip] = times
+						m.failureCounts[ip] = len(times)
+					}
+				}
+			}
+			// Forget failures that have decayed
+			if m.decays() {
+				for ip, count := range m.failureCounts {
+					if m.decayFailures(ip, now) != count {
+						changed = true
+					}
+				}
+			}
 			m.mu.Unlock()
 
 			// Persist if anything changed
 			if changed {
-				go m.saveToFile()
+				m.save()
 			}
 		case <-m.stopCleanup:
 			return
@@ -182,21 +588,119 @@ func (m *IPBanManager) cleanupExpiredBans() {
 // Stop stops the cleanup routine and saves final state
 func (m *IPBanManager) Stop() {
 	close(m.stopCleanup)
-	m.saveToFile() // Save final state before stopping
+
+	// No save may start once Wait begins, or it could outlast the final one
+	m.mu.Lock()
+	m.stopped = true
+	m.mu.Unlock()
+
+	m.saves.Wait()
+	m.save() // Save final state before stopping
+}
+
+// saveAsync persists the current state in the background, unless the
+// manager is stopping. The caller must hold mu for writing.
+func (m *IPBanManager) saveAsync() {
+	if m.stopped {
+		return
+	}
+	m.saves.Add(1)
+	go func() {
+		defer m.saves.Done()
+		m.save()
+	}()
+}
+
+// save persists the current state, retrying failed attempts with backoff.
+// Each attempt saves the state as it is then, so a retry never writes stale bans.
+func (m *IPBanManager) save() {
+	backoff := m.saveBackoff
+	for attempt := 1; ; attempt++ {
+		err := m.saveToFile()
+		if err == nil {
+			return
+		}
+
+		if attempt > m.saveRetries {
+			m.saveFailures.Add(1)
+			logger.Error("Failed to persist IP ban state",
+				"file", m.persistFile,
+				"attempts", attempt,
+				"error", err)
+			return
+		}
+
+		logger.Warn("Failed to persist IP ban state, retrying",
+			"file", m.persistFile,
+			"attempt", attempt,
+			"retry_in_ms", backoff.Milliseconds(),
+			"error", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// SaveFailures returns how many saves of the ban state failed after every retry
+func (m *IPBanManager) SaveFailures() int64 {
+	return m.saveFailures.Load()
+}
+
+// checkWritable verifies the persistence file's directory exists and accepts writes
+func (m *IPBanManager) checkWritable() error {
+	if m.persistFile == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(m.persistFile)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	probe, err := os.CreateTemp(dir, ".ipban-probe-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+
+	return os.Remove(probe.Name())
 }
 
 // saveToFile persists the current ban state to disk
 func (m *IPBanManager) saveToFile() error {
+	if m.persistFile == "" {
+		return nil
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
 	// Create data directory if it doesn't exist
-	if err := os.MkdirAll("data", 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(m.persistFile), 0755); err != nil {
+		return err
+	}
+
+	// Write to file
+	data, err := json.MarshalIndent(m.snapshot(), "", "  ")
+	if err != nil {
 		return err
 	}
 
-	// Prepare records
-	var records []BanRecord
+	return m.writeFile(m.persistFile, data, 0644)
+}
+
+// Export returns the active local bans and pending failure counts, the state
+// that is persisted, for backups and for importing into another instance
+func (m *IPBanManager) Export() []BanRecord {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.snapshot()
+}
+
+// snapshot returns the active local bans and pending failure counts. Caller
+// must hold m.mu.
+func (m *IPBanManager) snapshot() []BanRecord {
+	records := []BanRecord{}
 	now := time.Now()
 	for ip, expiry := range m.bannedIPs {
 		// Only save non-expired bans
@@ -205,6 +709,7 @@ func (m *IPBanManager) saveToFile() error {
 				IP:        ip,
 				ExpiresAt: expiry,
 				BannedAt:  expiry.Add(-m.banDuration),
+				Reason:    m.bannedReason[ip],
 			}
 			// Add the failure count that triggered the ban
 			if failCount, exists := m.bannedFailCount[ip]; exists {
@@ -215,7 +720,8 @@ func (m *IPBanManager) saveToFile() error {
 	}
 
 	// Add IPs with failure counts that haven't been banned yet
-	for ip, count := range m.failureCounts {
+	for ip := range m.failureCounts {
+		count := m.activeFailures(ip, now)
 		// Check if this IP already has a ban record
 		found := false
 		for i := range records {
@@ -233,18 +739,101 @@ func (m *IPBanManager) saveToFile() error {
 		}
 	}
 
-	// Write to file
-	data, err := json.MarshalIndent(records, "", "  ")
+	return records
+}
+
+// Import merges exported records into the running state: bans that haven't
+// expired are applied, keeping the later expiry when the IP is already
+// banned, and pending failure counts are raised to the imported count.
+// Records for invalid or whitelisted IPs, expired bans and feed entries are
+// skipped.
+func (m *IPBanManager) Import(records []BanRecord) (imported, skipped int) {
+	m.mu.Lock()
+	now := time.Now()
+	for _, record := range records {
+		if record.Source != "" || net.ParseIP(record.IP) == nil || m.whitelist[record.IP] || !m.restore(record, now) {
+			skipped++
+			continue
+		}
+		imported++
+	}
+	if imported > 0 {
+		m.saveAsync()
+	}
+	m.mu.Unlock()
+
+	return imported, skipped
+}
+
+// restore applies a persisted or imported record and reports whether it was
+// used. Caller must hold m.mu for writing.
+func (m *IPBanManager) restore(record BanRecord, now time.Time) bool {
+	switch {
+	case !record.ExpiresAt.IsZero() && now.Before(record.ExpiresAt):
+		if expiry, exists := m.bannedIPs[record.IP]; exists && expiry.After(record.ExpiresAt) {
+			return true
+		}
+		m.bannedIPs[record.IP] = record.ExpiresAt
+		// Restore the failure count that triggered the ban
+		if record.FailCount > 0 {
+			m.bannedFailCount[record.IP] = record.FailCount
+		}
+		if record.Reason != "" {
+			m.bannedReason[record.IP] = record.Reason
+		}
+		m.forgetFailures(record.IP)
+		return true
+	case record.ExpiresAt.IsZero() && record.FailCount > 0 && m.failureWindow == 0:
+		// If not banned but has failure count, restore it. In window mode
+		// the failure times are unknown, so pending counts are dropped.
+		if m.activeFailures(record.IP, now) >= record.FailCount {
+			return true
+		}
+		m.failureCounts[record.IP] = record.FailCount
+		if m.decays() {
+			// When the failures happened isn't persisted, so they start decaying now
+			m.lastFailure[record.IP] = now
+		}
+		m.trackFailure(record.IP)
+		return true
+	default:
+		return false
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and
+// renames it over path, so readers and concurrent or retried saves never see a
+// partially written file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
 
-	return os.WriteFile(m.persistFile, data, 0644)
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
 }
 
-// loadFromFile loads the ban state from disk
+// loadFromFile loads the ban state from disk. Malformed records are skipped
+// individually so one bad entry doesn't discard the rest of the ban history.
 func (m *IPBanManager) loadFromFile() error {
-	data, err := os.ReadFile(m.persistFile)
+	if m.persistFile == "" {
+		return nil
+	}
+
+	file, err := os.Open(m.persistFile)
 	if err != nil {
 		// File doesn't exist is not an error on first run
 		if os.IsNotExist(err) {
@@ -252,27 +841,49 @@ func (m *IPBanManager) loadFromFile() error {
 		}
 		return err
 	}
+	defer file.Close()
 
-	var records []BanRecord
-	if err := json.Unmarshal(data, &records); err != nil {
+	data, err := io.ReadAll(io.LimitReader(file, m.maxPersistSize+1))
+	if err != nil {
 		return err
 	}
+	if int64(len(data)) > m.maxPersistSize {
+		return fmt.Errorf("persistence file exceeds %d bytes", m.maxPersistSize)
+	}
 
-	// Restore bans and failure counts
+	var raw []json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	// Restore bans and failure counts the same way Import does
+	m.mu.Lock()
 	now := time.Now()
-	for _, record := range records {
-		// Only restore non-expired bans
-		if !record.ExpiresAt.IsZero() && now.Before(record.ExpiresAt) {
-			m.bannedIPs[record.IP] = record.ExpiresAt
-			// Restore the failure count that triggered the ban
-			if record.FailCount > 0 {
-				m.bannedFailCount[record.IP] = record.FailCount
-			}
-		} else if record.FailCount > 0 {
-			// If not banned anymore（expired) but has failure count, restore it
-			m.failureCounts[record.IP] = record.FailCount
+	restored, expired, ignored, malformed := 0, 0, 0, 0
+	for _, entry := range raw {
+		var record BanRecord
+		if err := json.Unmarshal(entry, &record); err != nil || net.ParseIP(record.IP) == nil {
+			malformed++
+			continue
+		}
+
+		switch {
+		case record.Source != "" || m.whitelist[record.IP]:
+			ignored++
+		case m.restore(record, now):
+			restored++
+		default:
+			expired++
 		}
 	}
+	m.mu.Unlock()
+
+	logger.Info("Loaded persisted IP bans",
+		"file", m.persistFile,
+		"restored", restored,
+		"skipped_expired", expired,
+		"skipped_ignored", ignored,
+		"skipped_malformed", malformed)
 
 	return nil
 }
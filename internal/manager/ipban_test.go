@@ -1,12 +1,16 @@
 package manager
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 	"time"
 )
 
 func TestIPBanManager_IsBanned(t *testing.T) {
-	manager := NewIPBanManager(3, 5*time.Second, []string{"192.168.1.1"})
+	manager := NewIPBanManager(3, 5*time.Second, WithWhitelist([]string{"192.168.1.1"}), WithoutPersistence())
 	defer manager.Stop()
 
 	// Test non-banned IP
@@ -21,7 +25,7 @@ func TestIPBanManager_IsBanned(t *testing.T) {
 }
 
 func TestIPBanManager_RecordFailure(t *testing.T) {
-	manager := NewIPBanManager(3, 1*time.Second, []string{})
+	manager := NewIPBanManager(3, 1*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	ip := "10.0.0.1"
@@ -48,7 +52,7 @@ func TestIPBanManager_RecordFailure(t *testing.T) {
 }
 
 func TestIPBanManager_RecordSuccess(t *testing.T) {
-	manager := NewIPBanManager(3, 5*time.Second, []string{})
+	manager := NewIPBanManager(3, 5*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	ip := "10.0.0.1"
@@ -69,7 +73,7 @@ func TestIPBanManager_RecordSuccess(t *testing.T) {
 }
 
 func TestIPBanManager_UnbanIP(t *testing.T) {
-	manager := NewIPBanManager(3, 5*time.Second, []string{})
+	manager := NewIPBanManager(3, 5*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	ip := "10.0.0.1"
@@ -90,8 +94,51 @@ func TestIPBanManager_UnbanIP(t *testing.T) {
 	}
 }
 
+func TestIPBanManager_BannedWithin(t *testing.T) {
+	manager := NewIPBanManager(3, 20*time.Millisecond, WithoutPersistence(), WithoutCleanup())
+	defer manager.Stop()
+
+	manager.BanIP("10.0.0.1")
+	if !manager.BannedWithin("10.0.0.1", time.Hour) {
+		t.Error("Expected a banned IP to count as banned")
+	}
+	if manager.BannedWithin("10.0.0.2", time.Hour) {
+		t.Error("Expected an IP never banned not to count")
+	}
+
+	// The ban is remembered after it expires, for as long as asked
+	time.Sleep(30 * time.Millisecond)
+	if manager.IsBanned("10.0.0.1") || !manager.BannedWithin("10.0.0.1", time.Hour) {
+		t.Error("Expected an expired ban to be remembered")
+	}
+	if manager.BannedWithin("10.0.0.1", time.Millisecond) {
+		t.Error("Expected a ban that ended earlier than asked about not to count")
+	}
+
+	// A manual unban forgets it
+	manager.UnbanIP("10.0.0.1")
+	if manager.BannedWithin("10.0.0.1", time.Hour) {
+		t.Error("Expected a manual unban to clear the ban history")
+	}
+}
+
+func TestIPBanManager_BanIP(t *testing.T) {
+	manager := NewIPBanManager(3, 5*time.Second, WithWhitelist([]string{"192.168.1.1"}), WithoutPersistence())
+	defer manager.Stop()
+
+	manager.BanIP("10.0.3.1")
+	if !manager.IsBanned("10.0.3.1") {
+		t.Error("IP should be banned immediately")
+	}
+
+	manager.BanIP("192.168.1.1")
+	if manager.IsBanned("192.168.1.1") {
+		t.Error("Whitelisted IP should never be banned")
+	}
+}
+
 func TestIPBanManager_GetBannedIPs(t *testing.T) {
-	manager := NewIPBanManager(2, 5*time.Second, []string{})
+	manager := NewIPBanManager(2, 5*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	// Ban multiple IPs
@@ -108,9 +155,61 @@ func TestIPBanManager_GetBannedIPs(t *testing.T) {
 	}
 }
 
+func TestIPBanManager_ListBans(t *testing.T) {
+	manager := NewIPBanManager(2, time.Minute, WithoutPersistence(), WithoutCleanup())
+	defer manager.Stop()
+
+	ips := []string{"10.0.0.3", "10.0.0.1", "10.0.0.5", "10.0.0.2", "10.0.0.4"}
+	for _, ip := range ips {
+		manager.BanIP(ip)
+	}
+	manager.RecordFailure("10.0.0.9") // Pending failures are not bans
+
+	all, total := manager.ListBans(0, 0)
+	if total != len(ips) || len(all) != len(ips) {
+		t.Fatalf("Expected %d bans, got %d of total %d", len(ips), len(all), total)
+	}
+	for i := 1; i < len(all); i++ {
+		prev, cur := all[i-1], all[i]
+		if cur.ExpiresAt.Before(prev.ExpiresAt) || (cur.ExpiresAt.Equal(prev.ExpiresAt) && cur.IP < prev.IP) {
+			t.Errorf("Bans out of order at %d: %s before %s", i, prev.IP, cur.IP)
+		}
+	}
+
+	tests := []struct {
+		name          string
+		offset, limit int
+		wantFrom      int
+		wantLen       int
+	}{
+		{"first page", 0, 2, 0, 2},
+		{"middle page", 2, 2, 2, 2},
+		{"last partial page", 4, 2, 4, 1},
+		{"past the end", 10, 2, 5, 0},
+		{"negative offset", -1, 2, 0, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, total := manager.ListBans(tt.offset, tt.limit)
+			if total != len(ips) {
+				t.Errorf("Expected total %d, got %d", len(ips), total)
+			}
+			if len(page) != tt.wantLen {
+				t.Fatalf("Expected %d bans, got %d", tt.wantLen, len(page))
+			}
+			for i, record := range page {
+				if record.IP != all[tt.wantFrom+i].IP {
+					t.Errorf("Ban %d: expected %s, got %s", i, all[tt.wantFrom+i].IP, record.IP)
+				}
+			}
+		})
+	}
+}
+
 func TestIPBanManager_Whitelist(t *testing.T) {
 	whitelist := []string{"192.168.1.1", "192.168.1.2"}
-	manager := NewIPBanManager(2, 5*time.Second, whitelist)
+	manager := NewIPBanManager(2, 5*time.Second, WithWhitelist(whitelist), WithoutPersistence())
 	defer manager.Stop()
 
 	// Try to ban whitelisted IPs
@@ -124,9 +223,286 @@ func TestIPBanManager_Whitelist(t *testing.T) {
 	}
 }
 
+func TestIPBanManager_ZonedWhitelist(t *testing.T) {
+	manager := NewIPBanManager(1, 5*time.Second, WithWhitelist([]string{"fe80::1%eth0"}), WithoutPersistence())
+	defer manager.Stop()
+
+	manager.RecordFailure("fe80::1")
+	if manager.IsBanned("fe80::1") {
+		t.Error("A zoned whitelist entry should match the address without its zone")
+	}
+}
+
+func TestStripZone(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{"10.0.0.1", "10.0.0.1"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"fe80::1%", "fe80::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := StripZone(tt.ip); got != tt.want {
+				t.Errorf("StripZone(%q) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPBanManager_FailureWindow(t *testing.T) {
+	manager := NewIPBanManager(3, 5*time.Second, WithFailureWindow(300*time.Millisecond), WithoutPersistence())
+	defer manager.Stop()
+
+	ip := "10.0.1.1"
+
+	// Two failures, then let them slide out of the window
+	manager.RecordFailure(ip)
+	manager.RecordFailure(ip)
+	time.Sleep(400 * time.Millisecond)
+
+	manager.RecordFailure(ip)
+	if manager.IsBanned(ip) {
+		t.Error("IP should not be banned when old failures are outside the window")
+	}
+	if count := manager.GetFailureCount(ip); count != 1 {
+		t.Errorf("Expected 1 failure inside the window, got %d", count)
+	}
+
+	// Reaching the threshold inside the window triggers the ban
+	manager.RecordFailure(ip)
+	manager.RecordFailure(ip)
+	if !manager.IsBanned(ip) {
+		t.Error("IP should be banned after 3 failures within the window")
+	}
+}
+
+func TestIPBanManager_FailureDecay(t *testing.T) {
+	manager := NewIPBanManager(3, 5*time.Second, WithFailureDecay(200*time.Millisecond), WithoutPersistence())
+	defer manager.Stop()
+
+	ip := "10.0.1.2"
+
+	// One decay period forgets one of the two failures
+	manager.RecordFailure(ip)
+	manager.RecordFailure(ip)
+	time.Sleep(250 * time.Millisecond)
+	if count := manager.GetFailureCount(ip); count != 1 {
+		t.Errorf("Expected 1 failure after one decay period, got %d", count)
+	}
+
+	manager.RecordFailure(ip)
+	if manager.IsBanned(ip) {
+		t.Error("IP should not be banned when a failure has decayed")
+	}
+
+	// Failures in quick succession still reach the threshold
+	manager.RecordFailure(ip)
+	if !manager.IsBanned(ip) {
+		t.Error("IP should be banned after 3 failures within a decay period")
+	}
+
+	// Without failures for long enough, every failure is forgotten
+	other := "10.0.1.3"
+	manager.RecordFailure(other)
+	manager.RecordFailure(other)
+	time.Sleep(450 * time.Millisecond)
+	if _, ok := manager.GetBanRecord(other); ok {
+		t.Error("Expected no record once every failure decayed")
+	}
+}
+
+func TestIPBanManager_Import(t *testing.T) {
+	manager := NewIPBanManager(3, time.Minute, WithWhitelist([]string{"10.0.2.9"}), WithoutPersistence(), WithoutCleanup())
+	defer manager.Stop()
+	manager.BanIP("10.0.2.1")
+	existing, _ := manager.GetBanRecord("10.0.2.1")
+
+	now := time.Now()
+	imported, skipped := manager.Import([]BanRecord{
+		{IP: "10.0.2.1", ExpiresAt: now.Add(time.Second), FailCount: 3},          // Earlier than the current ban
+		{IP: "10.0.2.2", ExpiresAt: now.Add(time.Hour), Reason: BanReasonManual}, // New ban
+		{IP: "10.0.2.3", FailCount: 2},                                           // Pending failures
+		{IP: "10.0.2.4", ExpiresAt: now.Add(-time.Hour), FailCount: 3},           // Expired
+		{IP: "10.0.2.9", ExpiresAt: now.Add(time.Hour)},                          // Whitelisted
+		{IP: "10.0.2.5", Source: "feed"},                                         // Feed entry
+	})
+
+	if imported != 3 || skipped != 3 {
+		t.Errorf("Import() = %d imported, %d skipped, want 3 and 3", imported, skipped)
+	}
+	if record, _ := manager.GetBanRecord("10.0.2.1"); !record.ExpiresAt.Equal(existing.ExpiresAt) {
+		t.Error("Expected the later expiry of an existing ban to be kept")
+	}
+	if !manager.IsBanned("10.0.2.2") || manager.IsBanned("10.0.2.4") || manager.IsBanned("10.0.2.9") {
+		t.Error("Expected only the unexpired, non-whitelisted ban to be imported")
+	}
+	if got := manager.GetFailureCount("10.0.2.3"); got != 2 {
+		t.Errorf("Expected 2 imported failures, got %d", got)
+	}
+}
+
+func TestIPBanManager_MaxTrackedIPs(t *testing.T) {
+	manager := NewIPBanManager(5, 5*time.Second, WithMaxTrackedIPs(2), WithoutPersistence())
+	defer manager.Stop()
+
+	manager.RecordFailure("10.0.2.1")
+	manager.RecordFailure("10.0.2.2")
+	manager.RecordFailure("10.0.2.1") // 10.0.2.2 is now the least recently failed
+	manager.RecordFailure("10.0.2.3")
+
+	if count := manager.GetFailureCount("10.0.2.2"); count != 0 {
+		t.Errorf("Least recently failed IP should be evicted, got %d failures", count)
+	}
+	if count := manager.GetFailureCount("10.0.2.1"); count != 2 {
+		t.Errorf("Expected 2 failures for 10.0.2.1, got %d", count)
+	}
+	if count := manager.GetFailureCount("10.0.2.3"); count != 1 {
+		t.Errorf("Expected 1 failure for 10.0.2.3, got %d", count)
+	}
+}
+
+func TestIPBanManager_PersistenceRoundTrip(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "ipban.json")
+
+	manager := NewIPBanManager(1, 5*time.Second, WithPersistFile(persistFile))
+	manager.RecordFailure("10.0.0.1")
+	manager.Stop()
+
+	if _, err := os.Stat(persistFile); err != nil {
+		t.Fatalf("Expected persistence file to be written: %v", err)
+	}
+
+	// A fresh persistent manager restores the ban
+	restored := NewIPBanManager(1, 5*time.Second, WithPersistFile(persistFile), WithoutCleanup())
+	defer restored.Stop()
+	if !restored.IsBanned("10.0.0.1") {
+		t.Error("Ban should be restored from the persistence file")
+	}
+}
+
+func TestIPBanManager_NoSaveAfterStop(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "ipban.json")
+
+	manager := NewIPBanManager(1, 5*time.Second, WithPersistFile(persistFile), WithoutCleanup())
+	manager.BanIP("10.0.0.1")
+	manager.Stop()
+
+	// Changes after Stop stay in memory and never reach the file
+	manager.BanIP("10.0.0.2")
+	manager.Import([]BanRecord{{IP: "10.0.0.3", BannedAt: time.Now(), ExpiresAt: time.Now().Add(time.Minute)}})
+
+	restored := NewIPBanManager(1, 5*time.Second, WithPersistFile(persistFile), WithoutCleanup())
+	defer restored.Stop()
+	if !restored.IsBanned("10.0.0.1") {
+		t.Error("Ban made before Stop should be persisted")
+	}
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		if restored.IsBanned(ip) {
+			t.Errorf("Ban of %s made after Stop should not be persisted", ip)
+		}
+	}
+}
+
+func TestIPBanManager_WithoutPersistence(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// An in-memory manager never touches the default data directory
+	memory := NewIPBanManager(1, 5*time.Second, WithoutPersistence(), WithoutCleanup())
+	memory.RecordFailure("10.0.0.1")
+	memory.Stop()
+	if !memory.IsBanned("10.0.0.1") {
+		t.Error("In-memory manager should still ban")
+	}
+	if _, err := os.Stat("data"); !os.IsNotExist(err) {
+		t.Error("In-memory manager should not create the data directory")
+	}
+}
+
+func TestIPBanManager_GetBanRecord(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "ipban.json")
+
+	manager := NewIPBanManager(3, time.Minute, WithPersistFile(persistFile), WithoutCleanup())
+
+	if _, ok := manager.GetBanRecord("10.0.0.1"); ok {
+		t.Error("Unknown IP should have no record")
+	}
+
+	// Pending failures are reported without ban times
+	manager.RecordFailure("10.0.0.1")
+	record, ok := manager.GetBanRecord("10.0.0.1")
+	if !ok || record.IsBanned() || record.FailCount != 1 {
+		t.Errorf("Expected a pending record with 1 failure, got %+v (ok=%v)", record, ok)
+	}
+
+	manager.RecordFailure("10.0.0.1")
+	manager.RecordFailure("10.0.0.1")
+	manager.BanIPWithReason("10.0.0.2", BanReasonScan)
+
+	tests := []struct {
+		ip        string
+		failCount int
+		reason    string
+	}{
+		{"10.0.0.1", 3, BanReasonAuthFailures},
+		{"10.0.0.2", 0, BanReasonScan},
+	}
+
+	check := func(t *testing.T, m *IPBanManager) {
+		for _, tt := range tests {
+			record, ok := m.GetBanRecord(tt.ip)
+			if !ok || !record.IsBanned() {
+				t.Fatalf("Expected %s to be banned, got %+v (ok=%v)", tt.ip, record, ok)
+			}
+			if record.FailCount != tt.failCount || record.Reason != tt.reason {
+				t.Errorf("%s: got fail count %d and reason %q, want %d and %q",
+					tt.ip, record.FailCount, record.Reason, tt.failCount, tt.reason)
+			}
+			if got := record.ExpiresAt.Sub(record.BannedAt); got != time.Minute {
+				t.Errorf("%s: expected a one minute ban, got %v", tt.ip, got)
+			}
+		}
+	}
+
+	check(t, manager)
+	manager.Stop()
+
+	// Reasons survive a restart
+	restored := NewIPBanManager(3, time.Minute, WithPersistFile(persistFile), WithoutCleanup())
+	defer restored.Stop()
+	check(t, restored)
+}
+
+func TestIPBanManager_ConcurrentSaves(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "ipban.json")
+
+	manager := NewIPBanManager(1, time.Minute, WithPersistFile(persistFile), WithoutCleanup())
+	for i := 0; i < 50; i++ {
+		manager.BanIP(fmt.Sprintf("10.0.0.%d", i))
+	}
+	manager.Stop()
+
+	entries, err := os.ReadDir(filepath.Dir(persistFile))
+	if err != nil {
+		t.Fatalf("Failed to read directory: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the persistence file to remain, got %d entries", len(entries))
+	}
+
+	restored := NewIPBanManager(1, time.Minute, WithPersistFile(persistFile), WithoutCleanup())
+	defer restored.Stop()
+	if got := len(restored.GetBannedIPs()); got != 50 {
+		t.Errorf("Expected 50 restored bans, got %d", got)
+	}
+}
+
 // Benchmark tests
 func BenchmarkIPBanManager_IsBanned(b *testing.B) {
-	manager := NewIPBanManager(3, 5*time.Second, []string{})
+	manager := NewIPBanManager(3, 5*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	b.ResetTimer()
@@ -136,7 +512,7 @@ func BenchmarkIPBanManager_IsBanned(b *testing.B) {
 }
 
 func BenchmarkIPBanManager_RecordFailure(b *testing.B) {
-	manager := NewIPBanManager(3, 5*time.Second, []string{})
+	manager := NewIPBanManager(3, 5*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	b.ResetTimer()
@@ -146,7 +522,7 @@ func BenchmarkIPBanManager_RecordFailure(b *testing.B) {
 }
 
 func BenchmarkIPBanManager_RecordSuccess(b *testing.B) {
-	manager := NewIPBanManager(3, 5*time.Second, []string{})
+	manager := NewIPBanManager(3, 5*time.Second, WithoutPersistence())
 	defer manager.Stop()
 
 	b.ResetTimer()
@@ -154,3 +530,122 @@ func BenchmarkIPBanManager_RecordSuccess(b *testing.B) {
 		manager.RecordSuccess("10.0.0.1")
 	}
 }
+
+func TestIPBanManager_UnwritablePersistence(t *testing.T) {
+	// A regular file where the data directory should be makes the path unwritable
+	dir := t.TempDir()
+	blocker := filepath.Join(dir, "data")
+	if err := os.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatalf("Failed to create blocker file: %v", err)
+	}
+
+	manager := NewIPBanManager(1, 5*time.Second, WithPersistFile(filepath.Join(blocker, "ipban.json")), WithoutCleanup())
+	defer manager.Stop()
+
+	if manager.persistFile != "" {
+		t.Error("Manager should fall back to memory-only mode when the path is not writable")
+	}
+
+	manager.RecordFailure("10.0.0.1")
+	if !manager.IsBanned("10.0.0.1") {
+		t.Error("Memory-only manager should still ban")
+	}
+}
+
+func TestIPBanManager_LoadSkipsBadRecords(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "ipban.json")
+	future := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	past := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	data := `[
+		{"ip": "10.0.0.1", "expires_at": "` + future + `", "fail_count": 3},
+		{"ip": "not-an-ip", "expires_at": "` + future + `"},
+		{"ip": "10.0.0.2", "expires_at": "not-a-time"},
+		{"ip": "10.0.0.3", "expires_at": "` + past + `"},
+		{"ip": "10.0.0.4", "fail_count": 2},
+		{"ip": "10.0.0.5", "expires_at": "` + future + `"},
+		{"ip": "10.0.0.6", "expires_at": "` + future + `", "source": "blocklist"}
+	]`
+	if err := os.WriteFile(persistFile, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write persistence file: %v", err)
+	}
+
+	manager := NewIPBanManager(5, time.Hour, WithPersistFile(persistFile), WithWhitelist([]string{"10.0.0.5"}), WithoutCleanup())
+	defer manager.Stop()
+
+	if !manager.IsBanned("10.0.0.1") {
+		t.Error("Valid ban should be restored despite malformed neighbours")
+	}
+	if manager.IsBanned("10.0.0.5") || manager.IsBanned("10.0.0.6") {
+		t.Error("Whitelisted and feed records should not be restored as local bans")
+	}
+	if manager.IsBanned("10.0.0.3") {
+		t.Error("Expired ban should not be restored")
+	}
+	if count := manager.GetFailureCount("10.0.0.4"); count != 2 {
+		t.Errorf("Expected pending failure count 2, got %d", count)
+	}
+	if banned := manager.GetBannedIPs(); len(banned) != 1 {
+		t.Errorf("Expected 1 restored ban, got %v", banned)
+	}
+}
+
+func TestIPBanManager_LoadSizeLimit(t *testing.T) {
+	persistFile := filepath.Join(t.TempDir(), "ipban.json")
+	future := time.Now().Add(time.Hour).Format(time.RFC3339Nano)
+	data := `[{"ip": "10.0.0.1", "expires_at": "` + future + `"}]`
+	if err := os.WriteFile(persistFile, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write persistence file: %v", err)
+	}
+
+	manager := NewIPBanManager(5, time.Hour, WithPersistFile(persistFile), WithMaxPersistSize(16), WithoutCleanup())
+	defer manager.Stop()
+
+	if manager.IsBanned("10.0.0.1") {
+		t.Error("Oversized persistence file should not be loaded")
+	}
+}
+
+func TestIPBanManager_SaveRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int // Failed writes before they start succeeding
+		wantWrites   int
+		wantFailures int64
+		wantSaved    bool
+	}{
+		{"first attempt", 0, 1, 0, true},
+		{"transient failure", 2, 3, 0, true},
+		{"persistent failure", 10, 4, 1, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			persistFile := filepath.Join(t.TempDir(), "ipban.json")
+			manager := NewIPBanManager(1, time.Minute, WithPersistFile(persistFile), WithoutCleanup(), WithSaveRetries(3))
+			defer manager.Stop()
+			manager.saveBackoff = time.Millisecond
+
+			writes := 0
+			manager.writeFile = func(path string, data []byte, perm os.FileMode) error {
+				writes++
+				if writes <= tt.failures {
+					return syscall.ENOSPC
+				}
+				return writeFileAtomic(path, data, perm)
+			}
+
+			manager.BanIP("10.0.0.1")
+			manager.saves.Wait()
+
+			if writes != tt.wantWrites {
+				t.Errorf("Expected %d write attempts, got %d", tt.wantWrites, writes)
+			}
+			if got := manager.SaveFailures(); got != tt.wantFailures {
+				t.Errorf("SaveFailures() = %d, want %d", got, tt.wantFailures)
+			}
+			if _, err := os.Stat(persistFile); (err == nil) != tt.wantSaved {
+				t.Errorf("Expected the ban state saved: %v, stat error: %v", tt.wantSaved, err)
+			}
+		})
+	}
+}
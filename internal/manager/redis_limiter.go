@@ -0,0 +1,72 @@
+package manager
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/seakee/dudu-proxy/internal/redis"
+)
+
+// tokenBucketScript takes one token from the bucket at KEYS[1], refilled at
+// ARGV[1] tokens per second up to ARGV[2], and returns 1 if one was
+// available. Time comes from the Redis server so proxy clocks need not agree.
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return allowed
+`
+
+// RedisLimiter keeps token buckets in Redis so every proxy instance sharing
+// the server enforces the same limits
+type RedisLimiter struct {
+	client *redis.Client
+	prefix string // Prepended to every bucket key
+}
+
+// NewRedisLimiter creates a limiter storing buckets under prefix
+func NewRedisLimiter(client *redis.Client, prefix string) *RedisLimiter {
+	return &RedisLimiter{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// Allow takes a token from the bucket named key, which refills at limit
+// tokens per second up to burst. An error means Redis could not decide.
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit float64, burst int) (bool, error) {
+	reply, err := l.client.Do(ctx, "EVAL", tokenBucketScript, "1", l.prefix+key,
+		strconv.FormatFloat(limit, 'f', -1, 64), strconv.Itoa(burst))
+	if err != nil {
+		return false, err
+	}
+
+	allowed, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("unexpected token bucket reply %v", reply)
+	}
+	return allowed == 1, nil
+}
+
+// Connected reports whether Redis is reachable
+func (l *RedisLimiter) Connected() bool {
+	return l.client.Connected()
+}
+
+// Close closes the Redis connections
+func (l *RedisLimiter) Close() error {
+	return l.client.Close()
+}
@@ -0,0 +1,89 @@
+package manager
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/seakee/dudu-proxy/internal/redis"
+)
+
+// serveRedis accepts one connection and answers each command with the next
+// reply, sending the command's arguments on got
+func serveRedis(t *testing.T, replies ...string) (addr string, got <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	args := make(chan string, len(replies))
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		br := bufio.NewReader(conn)
+		for _, reply := range replies {
+			// *N, then $len and the value for each argument
+			header, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var n int
+			if _, err := fmt.Sscanf(header, "*%d\r\n", &n); err != nil {
+				return
+			}
+			var parts []string
+			for range n {
+				var size int
+				line, _ := br.ReadString('\n')
+				fmt.Sscanf(line, "$%d\r\n", &size)
+				value := make([]byte, size+2)
+				if _, err := io.ReadFull(br, value); err != nil {
+					return
+				}
+				parts = append(parts, string(value[:size]))
+			}
+			args <- strings.Join(parts, " ")
+			conn.Write([]byte(reply))
+		}
+	}()
+
+	return ln.Addr().String(), args
+}
+
+func TestRedisLimiter_Allow(t *testing.T) {
+	addr, got := serveRedis(t, ":1\r\n", ":0\r\n", "-ERR script error\r\n")
+	l := NewRedisLimiter(redis.NewClient(addr), "dudu:")
+	defer l.Close()
+
+	tests := []struct {
+		want    bool
+		wantErr bool
+	}{
+		{true, false},
+		{false, false},
+		{false, true},
+	}
+
+	for i, tt := range tests {
+		allowed, err := l.Allow(context.Background(), "ip:10.0.0.1", 2.5, 5)
+		if allowed != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("Call %d: Allow() = %v, %v, want %v, error %v", i+1, allowed, err, tt.want, tt.wantErr)
+		}
+
+		cmd := <-got
+		if !strings.HasPrefix(cmd, "EVAL ") || !strings.HasSuffix(cmd, " 1 dudu:ip:10.0.0.1 2.5 5") {
+			t.Errorf("Call %d: unexpected command %q", i+1, cmd)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package manager
+
+import (
+	"context"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// maxReverseDNSCache bounds how many IPs' hostnames are cached
+const maxReverseDNSCache = 10000
+
+// hostResolver is the part of net.Resolver reverse DNS rules use
+type hostResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// rdnsEntry is a cached hostname, empty when the IP has no confirmed one
+type rdnsEntry struct {
+	hostname string
+	expires  time.Time
+}
+
+// rdnsLookup is a lookup in progress, which other callers asking for the
+// same IP wait for instead of resolving it again
+type rdnsLookup struct {
+	done     chan struct{} // Closed once hostname is set
+	hostname string
+}
+
+// ReverseDNSRules allow or block clients by the hostname their IP's PTR
+// record names. A hostname only counts when it resolves back to the IP
+// (forward-confirmed reverse DNS), since whoever controls an IP's reverse
+// zone can otherwise claim any name. Lookups are slow, so results, including
+// failed ones, are cached, and concurrent lookups of one IP share a result.
+type ReverseDNSRules struct {
+	allow    []string // Patterns: "host.example.com" or "*.example.com"
+	block    []string
+	ttl      time.Duration // How long a lookup result is cached
+	timeout  time.Duration // Bound on resolving one IP
+	resolver hostResolver
+
+	mu      sync.Mutex
+	cache   map[string]rdnsEntry   // IP -> hostname
+	pending map[string]*rdnsLookup // IP -> lookup in progress
+}
+
+// NewReverseDNSRules creates rules matching the lowercase hostname patterns
+// allow and block. A pattern is a hostname, or "*." followed by a domain to
+// match every name below it.
+func NewReverseDNSRules(allow, block []string, ttl, timeout time.Duration) *ReverseDNSRules {
+	return &ReverseDNSRules{
+		allow:    allow,
+		block:    block,
+		ttl:      ttl,
+		timeout:  timeout,
+		resolver: net.DefaultResolver,
+		cache:    make(map[string]rdnsEntry),
+		pending:  make(map[string]*rdnsLookup),
+	}
+}
+
+// Match returns FeedAllow or FeedBlock when ip's confirmed hostname matches
+// an allow or block pattern, allow taking precedence, and "" otherwise
+func (r *ReverseDNSRules) Match(ip string) string {
+	hostname := r.hostname(ip)
+	if hostname == "" {
+		return ""
+	}
+
+	matches := func(pattern string) bool { return matchHostname(pattern, hostname) }
+	switch {
+	case slices.ContainsFunc(r.allow, matches):
+		return FeedAllow
+	case slices.ContainsFunc(r.block, matches):
+		return FeedBlock
+	default:
+		return ""
+	}
+}
+
+// hostname returns ip's confirmed hostname from the cache, resolving it on a
+// miss or waiting for a lookup of it already in progress
+func (r *ReverseDNSRules) hostname(ip string) string {
+	now := time.Now()
+
+	r.mu.Lock()
+	if entry, ok := r.cache[ip]; ok && now.Before(entry.expires) {
+		r.mu.Unlock()
+		return entry.hostname
+	}
+	if lookup, ok := r.pending[ip]; ok {
+		r.mu.Unlock()
+		<-lookup.done
+		return lookup.hostname
+	}
+	lookup := &rdnsLookup{done: make(chan struct{})}
+	r.pending[ip] = lookup
+	r.mu.Unlock()
+
+	lookup.hostname = r.resolve(ip)
+
+	r.mu.Lock()
+	delete(r.pending, ip)
+	if len(r.cache) >= maxReverseDNSCache {
+		r.evict(now)
+	}
+	r.cache[ip] = rdnsEntry{hostname: lookup.hostname, expires: now.Add(r.ttl)}
+	r.mu.Unlock()
+	close(lookup.done)
+
+	return lookup.hostname
+}
+
+// resolve looks up ip's PTR names and returns the first that resolves back
+// to ip, lowercase without the trailing dot, or "" when none does
+func (r *ReverseDNSRules) resolve(ip string) string {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+
+	names, err := r.resolver.LookupAddr(ctx, ip)
+	if err != nil {
+		logger.Debug("Reverse DNS lookup failed", "ip", ip, "error", err)
+		return ""
+	}
+
+	for _, name := range names {
+		ips, err := r.resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, forward := range ips {
+			if forward.IP.Equal(addr) {
+				return strings.ToLower(strings.TrimSuffix(name, "."))
+			}
+		}
+	}
+
+	logger.Debug("Reverse DNS names do not resolve back to the IP", "ip", ip, "names", names)
+	return ""
+}
+
+// evict drops expired entries, and arbitrary ones when none have expired,
+// until the cache has room. The caller must hold mu.
+func (r *ReverseDNSRules) evict(now time.Time) {
+	for ip, entry := range r.cache {
+		if !now.Before(entry.expires) {
+			delete(r.cache, ip)
+		}
+	}
+	for ip := range r.cache {
+		if len(r.cache) < maxReverseDNSCache {
+			break
+		}
+		delete(r.cache, ip)
+	}
+}
+
+// matchHostname reports whether hostname matches pattern: exactly, or for a
+// "*.domain" pattern, any name below domain
+func matchHostname(pattern, hostname string) bool {
+	if domain, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(hostname, "."+domain)
+	}
+	return hostname == pattern
+}
@@ -0,0 +1,166 @@
+package manager
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeResolver answers from fixed PTR and forward records and counts PTR
+// lookups. When release is set, PTR lookups wait for it to be closed.
+type fakeResolver struct {
+	ptr     map[string][]string
+	forward map[string][]string
+	lookups atomic.Int32
+	release chan struct{}
+}
+
+func (f *fakeResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	f.lookups.Add(1)
+	if f.release != nil {
+		<-f.release
+	}
+	names, ok := f.ptr[addr]
+	if !ok {
+		return nil, errors.New("no PTR record")
+	}
+	return names, nil
+}
+
+func (f *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var addrs []net.IPAddr
+	for _, ip := range f.forward[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no such host")
+	}
+	return addrs, nil
+}
+
+func newTestReverseDNSRules(allow, block []string) (*ReverseDNSRules, *fakeResolver) {
+	resolver := &fakeResolver{
+		ptr: map[string][]string{
+			"10.0.0.1": {"Build1.Corp.Example.com."},
+			"10.0.0.2": {"scanner.bad.example.net."},
+			"10.0.0.3": {"spoofed.corp.example.com."}, // Forward record points elsewhere
+			"10.0.0.4": {"corp.example.com."},
+		},
+		forward: map[string][]string{
+			"Build1.Corp.Example.com.":  {"10.0.0.1"},
+			"scanner.bad.example.net.":  {"10.0.0.2"},
+			"spoofed.corp.example.com.": {"192.0.2.1"},
+			"corp.example.com.":         {"10.0.0.4"},
+		},
+	}
+	rules := NewReverseDNSRules(allow, block, time.Minute, time.Second)
+	rules.resolver = resolver
+	return rules, resolver
+}
+
+func TestReverseDNSRules_Match(t *testing.T) {
+	rules, _ := newTestReverseDNSRules([]string{"*.corp.example.com"}, []string{"*.example.net", "corp.example.com"})
+
+	tests := []struct {
+		name string
+		ip   string
+		want string
+	}{
+		{"wildcard allow", "10.0.0.1", FeedAllow},
+		{"wildcard block", "10.0.0.2", FeedBlock},
+		{"not forward-confirmed", "10.0.0.3", ""},
+		{"exact block, not below the wildcard", "10.0.0.4", FeedBlock},
+		{"no PTR record", "10.0.0.5", ""},
+		{"invalid IP", "not-an-ip", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rules.Match(tt.ip); got != tt.want {
+				t.Errorf("Match(%s) = %q, want %q", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReverseDNSRules_Cache(t *testing.T) {
+	rules, resolver := newTestReverseDNSRules([]string{"*.corp.example.com"}, nil)
+
+	for range 3 {
+		rules.Match("10.0.0.1")
+		rules.Match("10.0.0.5")
+	}
+	if got := resolver.lookups.Load(); got != 2 {
+		t.Errorf("Expected one lookup per IP, failed ones included, got %d", got)
+	}
+
+	rules.ttl = 0
+	rules.Match("10.0.0.2")
+	rules.Match("10.0.0.2")
+	if got := resolver.lookups.Load(); got != 4 {
+		t.Errorf("Expected expired entries to be looked up again, got %d lookups", got)
+	}
+}
+
+func TestReverseDNSRules_CoalescesLookups(t *testing.T) {
+	rules, resolver := newTestReverseDNSRules([]string{"*.corp.example.com"}, nil)
+	resolver.release = make(chan struct{})
+
+	// A burst from a new IP while its first lookup is still running
+	var wg sync.WaitGroup
+	results := make([]string, 10)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = rules.Match("10.0.0.1")
+		}()
+	}
+	for resolver.lookups.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(resolver.release)
+	wg.Wait()
+
+	if got := resolver.lookups.Load(); got != 1 {
+		t.Errorf("Expected concurrent matches to share one lookup, got %d", got)
+	}
+	for i, got := range results {
+		if got != FeedAllow {
+			t.Errorf("Match %d = %q, want %q", i, got, FeedAllow)
+		}
+	}
+}
+
+func TestIPBanManager_ReverseDNSRules(t *testing.T) {
+	rules, _ := newTestReverseDNSRules([]string{"*.corp.example.com"}, []string{"*.example.net"})
+	m := NewIPBanManager(3, time.Hour, WithoutPersistence(), WithoutCleanup(), WithReverseDNSRules(rules))
+
+	// An allowed hostname exempts the client from its ban
+	m.BanIP("10.0.0.1")
+	if m.IsBanned("10.0.0.1") {
+		t.Error("Expected a client with an allowed hostname not to be banned")
+	}
+
+	if !m.IsBanned("10.0.0.2") {
+		t.Error("Expected a client with a blocked hostname to be banned")
+	}
+
+	// Without a matching hostname the ban applies as usual
+	m.BanIP("10.0.0.3")
+	if !m.IsBanned("10.0.0.3") {
+		t.Error("Expected a banned client without a matching hostname to stay banned")
+	}
+
+	// IP rules are decided before any lookup
+	m = NewIPBanManager(3, time.Hour, WithoutPersistence(), WithoutCleanup(),
+		WithWhitelist([]string{"10.0.0.2"}), WithReverseDNSRules(rules))
+	if m.IsBanned("10.0.0.2") {
+		t.Error("Expected the whitelist to override a blocked hostname")
+	}
+}
@@ -0,0 +1,81 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// ScanDetector flags client IPs that connect to many distinct targets within a
+// time window, which is typical of port or host scanning through the proxy
+type ScanDetector struct {
+	mu         sync.Mutex
+	targets    map[string]map[string]time.Time // IP -> target -> last seen
+	maxTargets int
+	window     time.Duration
+	lastSweep  time.Time
+}
+
+// NewScanDetector creates a new scan detector
+func NewScanDetector(maxTargets int, window time.Duration) *ScanDetector {
+	return &ScanDetector{
+		targets:    make(map[string]map[string]time.Time),
+		maxTargets: maxTargets,
+		window:     window,
+		lastSweep:  time.Now(),
+	}
+}
+
+// Record records a connection from ip to target and reports whether the IP has
+// now exceeded the distinct-target threshold. Tracking for the IP is reset once flagged.
+func (d *ScanDetector) Record(ip, target string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-d.window)
+
+	// Periodically drop IPs that have gone quiet
+	if now.Sub(d.lastSweep) >= d.window {
+		for trackedIP, seen := range d.targets {
+			pruneTargets(seen, cutoff)
+			if len(seen) == 0 {
+				delete(d.targets, trackedIP)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	seen, exists := d.targets[ip]
+	if !exists {
+		seen = make(map[string]time.Time)
+		d.targets[ip] = seen
+	}
+	pruneTargets(seen, cutoff)
+	seen[target] = now
+
+	if len(seen) > d.maxTargets {
+		delete(d.targets, ip)
+		return true
+	}
+
+	return false
+}
+
+// GetDistinctTargets returns how many distinct targets ip reached within the window
+func (d *ScanDetector) GetDistinctTargets(ip string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	seen := d.targets[ip]
+	pruneTargets(seen, time.Now().Add(-d.window))
+	return len(seen)
+}
+
+// pruneTargets removes targets last seen at or before the cutoff
+func pruneTargets(seen map[string]time.Time, cutoff time.Time) {
+	for target, lastSeen := range seen {
+		if !lastSeen.After(cutoff) {
+			delete(seen, target)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScanDetector_Record(t *testing.T) {
+	detector := NewScanDetector(3, 5*time.Second)
+
+	ip := "10.0.0.1"
+	targets := []string{"a:80", "b:80", "c:80"}
+	for _, target := range targets {
+		if detector.Record(ip, target) {
+			t.Errorf("IP should not be flagged after connecting to %s", target)
+		}
+	}
+
+	// Repeated targets don't count twice
+	if detector.Record(ip, "a:80") {
+		t.Error("Repeated target should not flag the IP")
+	}
+	if got := detector.GetDistinctTargets(ip); got != 3 {
+		t.Errorf("Expected 3 distinct targets, got %d", got)
+	}
+
+	if !detector.Record(ip, "d:80") {
+		t.Error("IP should be flagged after exceeding the distinct target limit")
+	}
+	if got := detector.GetDistinctTargets(ip); got != 0 {
+		t.Errorf("Tracking should reset after flagging, got %d targets", got)
+	}
+}
+
+func TestScanDetector_Window(t *testing.T) {
+	detector := NewScanDetector(1, 200*time.Millisecond)
+
+	ip := "10.0.0.1"
+	detector.Record(ip, "a:80")
+	time.Sleep(300 * time.Millisecond)
+
+	if detector.Record(ip, "b:80") {
+		t.Error("Targets outside the window should not count")
+	}
+}
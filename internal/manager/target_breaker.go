@@ -0,0 +1,71 @@
+package manager
+
+import (
+	"sync"
+)
+
+// defaultMaxTargets bounds how many per-target breakers are kept before idle ones are dropped
+const defaultMaxTargets = 10000
+
+// TargetBreakers keeps one circuit breaker per upstream target (host:port)
+type TargetBreakers struct {
+	mu            sync.Mutex
+	breakers      map[string]*CircuitBreaker
+	opts          []CircuitBreakerOption
+	maxTargets    int
+	onStateChange func(target string, from, to CircuitBreakerState)
+}
+
+// NewTargetBreakers creates a set of per-target circuit breakers, each built with opts
+func NewTargetBreakers(opts ...CircuitBreakerOption) *TargetBreakers {
+	return &TargetBreakers{
+		breakers:   make(map[string]*CircuitBreaker),
+		opts:       opts,
+		maxTargets: defaultMaxTargets,
+	}
+}
+
+// Get returns the circuit breaker for target, creating it on first use
+func (t *TargetBreakers) Get(target string) *CircuitBreaker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if breaker, exists := t.breakers[target]; exists {
+		return breaker
+	}
+
+	// Drop healthy breakers once the map is full; they carry no useful state
+	if len(t.breakers) >= t.maxTargets {
+		for key, breaker := range t.breakers {
+			if breaker.GetState() == StateClosed {
+				delete(t.breakers, key)
+			}
+		}
+	}
+
+	breaker := NewCircuitBreaker(t.opts...)
+	if fn := t.onStateChange; fn != nil {
+		breaker.OnStateChange(func(from, to CircuitBreakerState) {
+			fn(target, from, to)
+		})
+	}
+	t.breakers[target] = breaker
+	return breaker
+}
+
+// OnStateChange registers fn to be called after every state transition of a
+// target's breaker. It applies to breakers created after the call.
+func (t *TargetBreakers) OnStateChange(fn func(target string, from, to CircuitBreakerState)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.onStateChange = fn
+}
+
+// Len returns the number of tracked targets
+func (t *TargetBreakers) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.breakers)
+}
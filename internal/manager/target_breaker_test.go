@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTargetBreakers_Get(t *testing.T) {
+	tb := NewTargetBreakers(
+		WithFailureThreshold(50),
+		WithWindowSize(1*time.Second),
+		WithMinRequests(2),
+		WithBreakDuration(2*time.Second),
+	)
+
+	a := tb.Get("a.example.com:443")
+	if tb.Get("a.example.com:443") != a {
+		t.Error("Expected the same breaker for the same target")
+	}
+
+	for i := 0; i < 2; i++ {
+		a.RecordFailure()
+	}
+
+	if !a.IsOpen() {
+		t.Error("Breaker for failing target should be open")
+	}
+
+	if tb.Get("b.example.com:443").IsOpen() {
+		t.Error("Breaker for another target should be unaffected")
+	}
+
+	if tb.Len() != 2 {
+		t.Errorf("Expected 2 tracked targets, got %d", tb.Len())
+	}
+}
+
+func TestTargetBreakers_Eviction(t *testing.T) {
+	tb := NewTargetBreakers(WithMinRequests(1))
+	tb.maxTargets = 2
+
+	open := tb.Get("open:80")
+	open.RecordFailure()
+	tb.Get("closed:80")
+
+	// Adding a third target drops the healthy breaker but keeps the open one
+	tb.Get("new:80")
+
+	if tb.Len() != 2 {
+		t.Errorf("Expected 2 tracked targets after eviction, got %d", tb.Len())
+	}
+	if tb.Get("open:80") != open {
+		t.Error("Open breaker should survive eviction")
+	}
+}
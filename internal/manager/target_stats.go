@@ -0,0 +1,188 @@
+package manager
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// TargetStat counts the traffic to one target host
+type TargetStat struct {
+	Host        string `json:"host"`
+	Connections int64  `json:"connections"`
+	Bytes       int64  `json:"bytes"` // Both directions, counted when connections close
+}
+
+// TargetStats tracks the most popular target hosts in a bounded map. When it
+// is full, a new host replaces the one with the fewest connections and
+// inherits its count, so a host that keeps coming back climbs over one-offs
+// (the Space-Saving heavy-hitter algorithm). Counts are halved every decay
+// interval so the ranking follows recent traffic.
+type TargetStats struct {
+	mu            sync.Mutex
+	hosts         map[string]*TargetStat
+	capacity      int
+	decayInterval time.Duration // Zero disables decay
+	lastDecay     time.Time
+	logInterval   time.Duration // Zero disables periodic logging
+	logTop        int
+	stopLog       chan struct{}
+}
+
+// TargetStatsOption configures optional TargetStats behavior
+type TargetStatsOption func(*TargetStats)
+
+// WithTargetDecay halves every count once per interval. Zero disables decay.
+func WithTargetDecay(interval time.Duration) TargetStatsOption {
+	return func(s *TargetStats) {
+		s.decayInterval = interval
+	}
+}
+
+// WithTargetLogging logs the top hosts every interval until Stop is called
+func WithTargetLogging(interval time.Duration, top int) TargetStatsOption {
+	return func(s *TargetStats) {
+		s.logInterval = interval
+		s.logTop = top
+	}
+}
+
+// NewTargetStats creates target stats tracking at most capacity hosts
+func NewTargetStats(capacity int, opts ...TargetStatsOption) *TargetStats {
+	s := &TargetStats{
+		hosts:     make(map[string]*TargetStat),
+		capacity:  capacity,
+		lastDecay: time.Now(),
+		stopLog:   make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.logInterval > 0 {
+		go s.logLoop()
+	}
+
+	return s
+}
+
+// RecordConnection counts a connection to host
+func (s *TargetStats) RecordConnection(host string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.decay(time.Now())
+
+	if stat, ok := s.hosts[host]; ok {
+		stat.Connections++
+		return
+	}
+
+	stat := &TargetStat{Host: host, Connections: 1}
+	if len(s.hosts) >= s.capacity {
+		// Take over the least popular host's slot and count
+		var least *TargetStat
+		for _, candidate := range s.hosts {
+			if least == nil || candidate.Connections < least.Connections {
+				least = candidate
+			}
+		}
+		delete(s.hosts, least.Host)
+		stat.Connections += least.Connections
+	}
+	s.hosts[host] = stat
+}
+
+// RecordBytes adds n bytes to host if it is still tracked
+func (s *TargetStats) RecordBytes(host string, n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stat, ok := s.hosts[host]; ok {
+		stat.Bytes += n
+	}
+}
+
+// Top returns the n hosts with the most connections, most first. Zero
+// returns every tracked host.
+func (s *TargetStats) Top(n int) []TargetStat {
+	s.mu.Lock()
+	s.decay(time.Now())
+	stats := make([]TargetStat, 0, len(s.hosts))
+	for _, stat := range s.hosts {
+		stats = append(stats, *stat)
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(stats, func(a, b TargetStat) int {
+		return cmp.Or(
+			cmp.Compare(b.Connections, a.Connections),
+			cmp.Compare(b.Bytes, a.Bytes),
+			cmp.Compare(a.Host, b.Host),
+		)
+	})
+
+	if n > 0 && n < len(stats) {
+		stats = stats[:n]
+	}
+	return stats
+}
+
+// Len returns the number of tracked hosts
+func (s *TargetStats) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.hosts)
+}
+
+// Stop stops periodic logging
+func (s *TargetStats) Stop() {
+	close(s.stopLog)
+}
+
+// decay halves every count for each decay interval passed since the last one,
+// dropping hosts that reach zero. Caller must hold the lock.
+func (s *TargetStats) decay(now time.Time) {
+	if s.decayInterval <= 0 {
+		return
+	}
+
+	periods := int(now.Sub(s.lastDecay) / s.decayInterval)
+	if periods <= 0 {
+		return
+	}
+	s.lastDecay = s.lastDecay.Add(time.Duration(periods) * s.decayInterval)
+
+	shift := min(periods, 63)
+	for host, stat := range s.hosts {
+		stat.Connections >>= shift
+		stat.Bytes >>= shift
+		if stat.Connections == 0 {
+			delete(s.hosts, host)
+		}
+	}
+}
+
+// logLoop logs the top hosts every log interval
+func (s *TargetStats) logLoop() {
+	ticker := time.NewTicker(s.logInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			top := s.Top(s.logTop)
+			if len(top) == 0 {
+				continue
+			}
+			logger.Info("Top target hosts", "hosts", top)
+		case <-s.stopLog:
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTargetStats_Top(t *testing.T) {
+	s := NewTargetStats(10)
+
+	for range 3 {
+		s.RecordConnection("a.example")
+	}
+	s.RecordConnection("b.example")
+	s.RecordConnection("c.example")
+	s.RecordBytes("c.example", 100)
+	s.RecordBytes("untracked.example", 100)
+
+	want := []TargetStat{
+		{Host: "a.example", Connections: 3},
+		{Host: "c.example", Connections: 1, Bytes: 100},
+	}
+	if got := s.Top(2); !reflect.DeepEqual(got, want) {
+		t.Errorf("Top(2) = %+v, want %+v", got, want)
+	}
+	if got := len(s.Top(0)); got != 3 {
+		t.Errorf("Expected Top(0) to return every host, got %d", got)
+	}
+}
+
+func TestTargetStats_Capacity(t *testing.T) {
+	s := NewTargetStats(2)
+
+	s.RecordConnection("a.example")
+	s.RecordConnection("a.example")
+	s.RecordConnection("b.example")
+	// Replaces b.example, the least popular, and inherits its count
+	s.RecordConnection("c.example")
+
+	if n := s.Len(); n != 2 {
+		t.Fatalf("Expected 2 tracked hosts, got %d", n)
+	}
+	want := []TargetStat{
+		{Host: "a.example", Connections: 2},
+		{Host: "c.example", Connections: 2},
+	}
+	if got := s.Top(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Top(0) = %+v, want %+v", got, want)
+	}
+}
+
+func TestTargetStats_Decay(t *testing.T) {
+	s := NewTargetStats(10, WithTargetDecay(time.Hour))
+
+	for range 4 {
+		s.RecordConnection("a.example")
+	}
+	s.RecordConnection("b.example")
+	s.RecordBytes("a.example", 1000)
+
+	s.mu.Lock()
+	s.lastDecay = s.lastDecay.Add(-time.Hour)
+	s.mu.Unlock()
+
+	want := []TargetStat{{Host: "a.example", Connections: 2, Bytes: 500}}
+	if got := s.Top(0); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected counts halved and b.example dropped, got %+v", got)
+	}
+}
@@ -0,0 +1,65 @@
+package manager
+
+import (
+	"sync"
+	"time"
+)
+
+// ViolationCounter counts repeated violations per client IP within a time
+// window, such as rate limit rejections, to decide when to escalate to a ban
+type ViolationCounter struct {
+	mu         sync.Mutex
+	violations map[string][]time.Time // IP -> violation times
+	threshold  int
+	window     time.Duration
+	lastSweep  time.Time
+}
+
+// NewViolationCounter creates a new violation counter
+func NewViolationCounter(threshold int, window time.Duration) *ViolationCounter {
+	return &ViolationCounter{
+		violations: make(map[string][]time.Time),
+		threshold:  threshold,
+		window:     window,
+		lastSweep:  time.Now(),
+	}
+}
+
+// Record records a violation by ip and reports whether the IP has now exceeded
+// the threshold. Tracking for the IP is reset once flagged.
+func (c *ViolationCounter) Record(ip string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-c.window)
+
+	// Periodically drop IPs that have gone quiet
+	if now.Sub(c.lastSweep) >= c.window {
+		for trackedIP, times := range c.violations {
+			if times = pruneFailures(times, cutoff); len(times) == 0 {
+				delete(c.violations, trackedIP)
+			} else {
+				c.violations[trackedIP] = times
+			}
+		}
+		c.lastSweep = now
+	}
+
+	times := append(pruneFailures(c.violations[ip], cutoff), now)
+	if len(times) > c.threshold {
+		delete(c.violations, ip)
+		return true
+	}
+	c.violations[ip] = times
+
+	return false
+}
+
+// GetViolationCount returns how many violations ip has within the window
+func (c *ViolationCounter) GetViolationCount(ip string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(pruneFailures(c.violations[ip], time.Now().Add(-c.window)))
+}
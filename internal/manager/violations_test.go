@@ -0,0 +1,39 @@
+package manager
+
+import (
+	"testing"
+	"time"
+)
+
+func TestViolationCounter_Record(t *testing.T) {
+	counter := NewViolationCounter(3, 5*time.Second)
+
+	ip := "10.0.0.1"
+	for i := 0; i < 3; i++ {
+		if counter.Record(ip) {
+			t.Errorf("IP should not be flagged after %d violations", i+1)
+		}
+	}
+	if got := counter.GetViolationCount(ip); got != 3 {
+		t.Errorf("Expected 3 violations, got %d", got)
+	}
+
+	if !counter.Record(ip) {
+		t.Error("IP should be flagged after exceeding the threshold")
+	}
+	if got := counter.GetViolationCount(ip); got != 0 {
+		t.Errorf("Tracking should reset after flagging, got %d violations", got)
+	}
+}
+
+func TestViolationCounter_Window(t *testing.T) {
+	counter := NewViolationCounter(1, 200*time.Millisecond)
+
+	ip := "10.0.0.1"
+	counter.Record(ip)
+	time.Sleep(300 * time.Millisecond)
+
+	if counter.Record(ip) {
+		t.Error("Violations outside the window should not count")
+	}
+}
@@ -0,0 +1,188 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Default is the registry used by the proxy and served by the admin /metrics endpoint
+var Default = NewRegistry()
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	value atomic.Int64
+}
+
+// Inc increments the counter by one
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+// Add increments the counter by n
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+// Value returns the current value
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+// Gauge is a value that can go up and down
+type Gauge struct {
+	value atomic.Int64
+}
+
+// Set sets the gauge to v
+func (g *Gauge) Set(v int64) {
+	g.value.Store(v)
+}
+
+// Add adds n (which may be negative) to the gauge
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+// Value returns the current value
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+// family groups the series of one metric name
+type family struct {
+	kind   string // "counter" or "gauge"
+	help   string
+	series map[string]func() int64 // Rendered label set -> value
+}
+
+// Registry holds named metrics and renders them in the Prometheus text format
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+	counters map[string]*Counter
+	gauges   map[string]*Gauge
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+		counters: make(map[string]*Counter),
+		gauges:   make(map[string]*Gauge),
+	}
+}
+
+// Counter returns the counter for name and labels (key/value pairs), creating it on first use
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := name + renderLabels(labels)
+	if c, ok := r.counters[key]; ok {
+		return c
+	}
+
+	c := &Counter{}
+	r.counters[key] = c
+	r.register(name, "counter", help, labels, c.Value)
+	return c
+}
+
+// Gauge returns the gauge for name and labels (key/value pairs), creating it on first use
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := name + renderLabels(labels)
+	if g, ok := r.gauges[key]; ok {
+		return g
+	}
+
+	g := &Gauge{}
+	r.gauges[key] = g
+	r.register(name, "gauge", help, labels, g.Value)
+	return g
+}
+
+// CounterFunc registers a counter whose value is read from fn at scrape time.
+// fn must never decrease. Registering the same name and labels again replaces fn.
+func (r *Registry) CounterFunc(name, help string, fn func() int64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.register(name, "counter", help, labels, fn)
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time.
+// Registering the same name and labels again replaces fn.
+func (r *Registry) GaugeFunc(name, help string, fn func() int64, labels ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.register(name, "gauge", help, labels, fn)
+}
+
+// register adds a series to its family. Caller must hold r.mu.
+func (r *Registry) register(name, kind, help string, labels []string, value func() int64) {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{kind: kind, help: help, series: make(map[string]func() int64)}
+		r.families[name] = f
+	}
+	f.series[renderLabels(labels)] = value
+}
+
+// WriteText writes every metric in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, f.help, name, f.kind); err != nil {
+			return err
+		}
+
+		labelSets := make([]string, 0, len(f.series))
+		for labels := range f.series {
+			labelSets = append(labelSets, labels)
+		}
+		sort.Strings(labelSets)
+
+		for _, labels := range labelSets {
+			if _, err := fmt.Fprintf(w, "%s%s %d\n", name, labels, f.series[labels]()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// renderLabels formats key/value pairs as {k="v",...}
+func renderLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", labels[i], labels[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
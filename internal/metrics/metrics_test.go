@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+
+	r.Counter("dudu_requests_total", "Requests handled", "protocol", "http").Add(3)
+	r.Counter("dudu_requests_total", "Requests handled", "protocol", "socks5").Inc()
+	r.Gauge("dudu_active_connections", "Open connections").Set(7)
+	r.GaugeFunc("dudu_tracked_ips", "Tracked IPs", func() int64 { return 42 })
+	r.CounterFunc("dudu_rejections_total", "Rejections", func() int64 { return 5 })
+
+	// The same name and labels return the same counter
+	r.Counter("dudu_requests_total", "Requests handled", "protocol", "http").Inc()
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := `# HELP dudu_active_connections Open connections
+# TYPE dudu_active_connections gauge
+dudu_active_connections 7
+# HELP dudu_rejections_total Rejections
+# TYPE dudu_rejections_total counter
+dudu_rejections_total 5
+# HELP dudu_requests_total Requests handled
+# TYPE dudu_requests_total counter
+dudu_requests_total{protocol="http"} 4
+dudu_requests_total{protocol="socks5"} 1
+# HELP dudu_tracked_ips Tracked IPs
+# TYPE dudu_tracked_ips gauge
+dudu_tracked_ips 42
+`
+	if b.String() != want {
+		t.Errorf("Unexpected output:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func BenchmarkCounter_Inc(b *testing.B) {
+	c := NewRegistry().Counter("bench_total", "Benchmark counter")
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Inc()
+		}
+	})
+}
+
+func TestRuntimeMonitor(t *testing.T) {
+	r := NewRegistry()
+	m := NewRuntimeMonitor(r, 0)
+	defer m.Stop()
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText failed: %v", err)
+	}
+
+	want := []string{"dudu_goroutines "}
+	if _, ok := OpenFDs(); ok {
+		want = append(want, "dudu_open_fds ")
+	}
+	for _, series := range want {
+		if !strings.Contains(b.String(), "\n"+series) {
+			t.Errorf("Expected %q in output:\n%s", series, b.String())
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// OpenFDs returns how many file descriptors the process has open, read from
+// /proc/self/fd. It reports false on systems without it.
+func OpenFDs() (int, bool) {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	// The directory handle used for reading is counted too
+	return max(len(entries)-1, 0), true
+}
+
+// RuntimeMonitor exposes the goroutine and open file descriptor counts as
+// gauges and optionally logs them periodically. A count that keeps climbing
+// under steady load is an early sign of a leak.
+type RuntimeMonitor struct {
+	interval time.Duration // Zero disables periodic logging
+	stop     chan struct{}
+}
+
+// NewRuntimeMonitor registers the runtime gauges with r and, when interval is
+// positive, logs the counts every interval until Stop is called
+func NewRuntimeMonitor(r *Registry, interval time.Duration) *RuntimeMonitor {
+	m := &RuntimeMonitor{
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+
+	r.GaugeFunc("dudu_goroutines", "Goroutines currently running",
+		func() int64 { return int64(runtime.NumGoroutine()) })
+	if _, ok := OpenFDs(); ok {
+		r.GaugeFunc("dudu_open_fds", "File descriptors the process has open",
+			func() int64 {
+				n, _ := OpenFDs()
+				return int64(n)
+			})
+	}
+
+	if m.interval > 0 {
+		go m.logLoop()
+	}
+
+	return m
+}
+
+// Stop ends periodic logging
+func (m *RuntimeMonitor) Stop() {
+	close(m.stop)
+}
+
+// logLoop logs the counts, and how they changed since the previous entry,
+// every interval
+func (m *RuntimeMonitor) logLoop() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	lastGoroutines := runtime.NumGoroutine()
+	lastFDs, _ := OpenFDs()
+	for {
+		select {
+		case <-ticker.C:
+			goroutines := runtime.NumGoroutine()
+			fields := []interface{}{
+				"goroutines", goroutines,
+				"goroutines_change", goroutines - lastGoroutines,
+			}
+			if fds, ok := OpenFDs(); ok {
+				fields = append(fields, "open_fds", fds, "open_fds_change", fds-lastFDs)
+				lastFDs = fds
+			}
+			lastGoroutines = goroutines
+			logger.Info("Runtime stats", fields...)
+		case <-m.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// AdaptiveLimits scales each IP's per-IP rate limit by its recent behavior:
+// IPs without authentication failures or recent bans get more than the
+// configured limit, failing IPs get less the more they fail, and recently
+// banned IPs get a small fraction of it.
+type AdaptiveLimits struct {
+	ipBan            *IPBanMiddleware // Source of failure counts and ban history
+	cleanMultiplier  float64          // Multiplier for IPs with no failures and no recent ban
+	failurePenalty   float64          // A failing IP's limit is divided by 1 + failurePenalty*failures
+	bannedMultiplier float64          // Cap on the multiplier of IPs banned within banMemory
+	banMemory        time.Duration    // How long after a ban an IP still counts as banned
+	interval         time.Duration    // How often an IP's multiplier is recomputed
+}
+
+// NewAdaptiveLimits creates adaptive limits reading failure counts and ban
+// history from ipBan, recomputing an IP's limit at most once per interval
+func NewAdaptiveLimits(ipBan *IPBanMiddleware, cleanMultiplier, failurePenalty, bannedMultiplier float64, banMemory, interval time.Duration) *AdaptiveLimits {
+	return &AdaptiveLimits{
+		ipBan:            ipBan,
+		cleanMultiplier:  cleanMultiplier,
+		failurePenalty:   failurePenalty,
+		bannedMultiplier: bannedMultiplier,
+		banMemory:        banMemory,
+		interval:         interval,
+	}
+}
+
+// Multiplier returns the factor applied to ip's per-IP limit
+func (a *AdaptiveLimits) Multiplier(ip string) float64 {
+	failures := a.ipBan.FailureCount(ip)
+	banned := a.ipBan.BannedWithin(ip, a.banMemory)
+
+	if failures == 0 && !banned {
+		return a.cleanMultiplier
+	}
+
+	multiplier := 1 / (1 + a.failurePenalty*float64(failures))
+	if banned {
+		multiplier = min(multiplier, a.bannedMultiplier)
+	}
+	return multiplier
+}
+
+// scale returns limit and burst multiplied by multiplier, keeping a burst of
+// at least one so the IP is never locked out entirely
+func scale(limit rate.Limit, burst int, multiplier float64) (rate.Limit, int) {
+	return limit * rate.Limit(multiplier), max(1, int(float64(burst)*multiplier))
+}
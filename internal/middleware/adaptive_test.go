@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+func newTestAdaptiveLimits(t *testing.T) (*AdaptiveLimits, *manager.IPBanManager) {
+	t.Helper()
+
+	bans := manager.NewIPBanManager(5, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	t.Cleanup(bans.Stop)
+	return NewAdaptiveLimits(NewIPBanMiddleware(true, bans), 2, 0.5, 0.1, time.Hour, time.Minute), bans
+}
+
+func TestAdaptiveLimits_Multiplier(t *testing.T) {
+	adaptive, bans := newTestAdaptiveLimits(t)
+
+	bans.RecordFailure("10.0.0.2")
+	bans.RecordFailure("10.0.0.3")
+	bans.RecordFailure("10.0.0.3")
+	bans.BanIP("10.0.0.4")
+
+	tests := []struct {
+		ip   string
+		want float64
+	}{
+		{"10.0.0.1", 2},       // Clean
+		{"10.0.0.2", 1 / 1.5}, // One failure
+		{"10.0.0.3", 0.5},     // Two failures
+		{"10.0.0.4", 0.1},     // Banned
+	}
+
+	for _, tt := range tests {
+		if got := adaptive.Multiplier(tt.ip); got != tt.want {
+			t.Errorf("Multiplier(%s) = %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+
+	// Without IP banning every IP counts as clean
+	disabled := NewAdaptiveLimits(NewIPBanMiddleware(false, bans), 2, 0.5, 0.1, time.Hour, time.Minute)
+	if got := disabled.Multiplier("10.0.0.4"); got != 2 {
+		t.Errorf("Expected the clean multiplier with IP banning disabled, got %v", got)
+	}
+}
+
+func TestRateLimitMiddleware_AdaptiveLimits(t *testing.T) {
+	adaptive, bans := newTestAdaptiveLimits(t)
+	r := NewRateLimitMiddleware(true, 0, 2, WithAdaptiveLimits(adaptive))
+
+	// A clean IP gets twice the burst of 4
+	for i := 0; i < 8; i++ {
+		if !r.Allow("10.0.0.1") {
+			t.Fatalf("Expected request %d of a clean IP to be allowed", i+1)
+		}
+	}
+	if r.Allow("10.0.0.1") {
+		t.Error("Expected a clean IP to be limited after twice the burst")
+	}
+
+	// A banned IP keeps a burst of at least one
+	bans.BanIP("10.0.0.2")
+	if !r.Allow("10.0.0.2") {
+		t.Error("Expected a banned IP to keep a burst of one")
+	}
+	if r.Allow("10.0.0.2") {
+		t.Error("Expected a banned IP to be limited after one request")
+	}
+
+	// The multiplier is recomputed once the interval has passed
+	bans.UnbanIP("10.0.0.2")
+	if got := r.getIPLimiter("10.0.0.2").Limit(); got != 0.2 {
+		t.Errorf("Expected the limit to stay until the interval passes, got %v", got)
+	}
+	adaptive.interval = 0
+	if got := r.getIPLimiter("10.0.0.2").Limit(); got != 4 {
+		t.Errorf("Expected the limit to be raised after the ban was lifted, got %v", got)
+	}
+}
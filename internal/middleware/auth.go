@@ -1,22 +1,174 @@
 package middleware
 
 import (
+	"crypto/subtle"
 	"fmt"
+	"maps"
 	"net"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
 )
 
 // AuthMiddleware handles proxy authentication
 type AuthMiddleware struct {
-	enabled     bool
-	credentials map[string]string // username -> password
+	enabled       bool
+	rotationGrace time.Duration // How long replaced credentials keep working after an update
+	mu            sync.RWMutex
+	credentials   map[string]string    // username -> password, never mutated once stored
+	tokens        map[string]string    // bearer token -> username, never mutated once stored
+	tokenExpiry   map[string]time.Time // bearer token -> when it stops being accepted, absent when it doesn't expire
+	previous      retiredSet           // Credentials replaced by the last Update
+	prevTokens    retiredSet           // Tokens replaced by the last UpdateTokens
+}
+
+// retiredSet is a replaced credential or token map, still accepted until expires
+type retiredSet struct {
+	entries  map[string]string
+	expiries map[string]time.Time // The replaced tokens' own expiry times
+	expires  time.Time
+}
+
+// active returns the entries while the set is within its grace period, else nil
+func (r retiredSet) active() map[string]string {
+	if !time.Now().Before(r.expires) {
+		return nil
+	}
+	return r.entries
+}
+
+// AuthOption configures optional AuthMiddleware behavior
+type AuthOption func(*AuthMiddleware)
+
+// WithTokens accepts bearer tokens, each authenticating as its mapped username
+func WithTokens(tokens map[string]string) AuthOption {
+	return func(a *AuthMiddleware) {
+		a.tokens = copyCredentials(tokens)
+	}
+}
+
+// WithTokenExpiry stops accepting each token in expiries once its time passes
+func WithTokenExpiry(expiries map[string]time.Time) AuthOption {
+	return func(a *AuthMiddleware) {
+		a.tokenExpiry = maps.Clone(expiries)
+	}
+}
+
+// WithRotationGrace keeps accepting the credentials and tokens replaced by
+// Update and UpdateTokens for grace, so clients can switch to rotated
+// passwords without failing in between. Zero drops them at once.
+func WithRotationGrace(grace time.Duration) AuthOption {
+	return func(a *AuthMiddleware) {
+		a.rotationGrace = grace
+	}
 }
 
 // NewAuthMiddleware creates a new authentication middleware
-func NewAuthMiddleware(enabled bool, credentials map[string]string) *AuthMiddleware {
-	return &AuthMiddleware{
+func NewAuthMiddleware(enabled bool, credentials map[string]string, opts ...AuthOption) *AuthMiddleware {
+	a := &AuthMiddleware{
 		enabled:     enabled,
-		credentials: credentials,
+		credentials: copyCredentials(credentials),
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	return a
+}
+
+// Update replaces the credential set, e.g. on a config reload. The new set is
+// copied and swapped in as a whole, so an in-flight Authenticate call checks
+// either the old set or the new one, never a mix of both. With a rotation
+// grace period the old set is also accepted until the period ends.
+func (a *AuthMiddleware) Update(credentials map[string]string) {
+	updated := copyCredentials(credentials)
+
+	a.mu.Lock()
+	a.previous = a.retire(a.credentials, nil)
+	a.credentials = updated
+	a.mu.Unlock()
+}
+
+// UpdateTokens replaces the bearer token set, with the same guarantees as Update
+func (a *AuthMiddleware) UpdateTokens(tokens map[string]string) {
+	a.UpdateTokensWithExpiry(tokens, nil)
+}
+
+// UpdateTokensWithExpiry replaces the bearer token set and their expiry
+// times together, with the same guarantees as Update
+func (a *AuthMiddleware) UpdateTokensWithExpiry(tokens map[string]string, expiries map[string]time.Time) {
+	updated := copyCredentials(tokens)
+	updatedExpiry := maps.Clone(expiries)
+
+	a.mu.Lock()
+	a.prevTokens = a.retire(a.tokens, a.tokenExpiry)
+	a.tokens, a.tokenExpiry = updated, updatedExpiry
+	a.mu.Unlock()
+}
+
+// retire returns replaced as a set accepted for the rotation grace period, or
+// an empty set without one. Caller must hold the write lock.
+func (a *AuthMiddleware) retire(replaced map[string]string, expiries map[string]time.Time) retiredSet {
+	if a.rotationGrace <= 0 {
+		return retiredSet{}
+	}
+	return retiredSet{entries: replaced, expiries: expiries, expires: time.Now().Add(a.rotationGrace)}
+}
+
+// RemoveUser revokes username's password and every bearer token mapped to it,
+// reporting whether anything was removed. A config reload restores the user
+// unless it was also removed from the file.
+func (a *AuthMiddleware) RemoveUser(username string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Swap in new maps, readers may still hold the old ones. Retired sets are
+	// filtered too so a drained user can't fall back on a rotated password.
+	credentials, removedPassword := withoutKey(a.credentials, username)
+	tokens, removedTokens := withoutValue(a.tokens, username)
+	a.credentials, a.tokens = credentials, tokens
+	a.previous.entries, _ = withoutKey(a.previous.entries, username)
+	a.prevTokens.entries, _ = withoutValue(a.prevTokens.entries, username)
+
+	return removedPassword || removedTokens
+}
+
+// withoutKey returns a copy of m without key, reporting whether it was there
+func withoutKey(m map[string]string, key string) (map[string]string, bool) {
+	_, found := m[key]
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		if k != key {
+			copied[k] = v
+		}
 	}
+	return copied, found
+}
+
+// withoutValue returns a copy of m without the entries mapped to value,
+// reporting whether there were any
+func withoutValue(m map[string]string, value string) (map[string]string, bool) {
+	found := false
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		if v == value {
+			found = true
+			continue
+		}
+		copied[k] = v
+	}
+	return copied, found
+}
+
+// copyCredentials returns a private copy so later changes by the caller can't leak in
+func copyCredentials(credentials map[string]string) map[string]string {
+	copied := make(map[string]string, len(credentials))
+	for username, password := range credentials {
+		copied[username] = password
+	}
+	return copied
 }
 
 // Authenticate verifies the provided credentials
@@ -25,12 +177,60 @@ func (a *AuthMiddleware) Authenticate(username, password string) bool {
 		return true // Authentication disabled
 	}
 
-	expectedPassword, exists := a.credentials[username]
-	if !exists {
-		return false
+	a.mu.RLock()
+	credentials, previous := a.credentials, a.previous
+	a.mu.RUnlock()
+
+	if expectedPassword, exists := credentials[username]; exists && expectedPassword == password {
+		return true
 	}
 
-	return expectedPassword == password
+	// Fall back on the password replaced by the last update, within its grace period
+	expectedPassword, exists := previous.active()[username]
+	return exists && expectedPassword == password
+}
+
+// AuthenticateToken verifies a bearer token and returns the username it maps
+// to. Every configured token is compared in constant time, so the response
+// time doesn't reveal how much of a token matched.
+func (a *AuthMiddleware) AuthenticateToken(token string) (username string, ok bool) {
+	username, _, ok = a.AuthenticateTokenUntil(token)
+	return username, ok
+}
+
+// AuthenticateTokenUntil is AuthenticateToken that also returns when the
+// token stops being accepted, zero when it doesn't expire. Expired tokens fail.
+func (a *AuthMiddleware) AuthenticateTokenUntil(token string) (username string, expires time.Time, ok bool) {
+	if !a.enabled {
+		return "", time.Time{}, true // Authentication disabled
+	}
+
+	a.mu.RLock()
+	tokens, tokenExpiry, prevTokens := a.tokens, a.tokenExpiry, a.prevTokens
+	a.mu.RUnlock()
+
+	if username, ok = matchToken(tokens, token); ok {
+		expires = tokenExpiry[token]
+	} else if username, ok = matchToken(prevTokens.active(), token); ok {
+		// A token replaced by the last update keeps working within its grace period
+		expires = prevTokens.expiries[token]
+	}
+
+	if ok && !expires.IsZero() && !time.Now().Before(expires) {
+		return username, expires, false
+	}
+	return username, expires, ok
+}
+
+// matchToken compares token against every entry of tokens in constant time and
+// returns the username of the one it matches
+func matchToken(tokens map[string]string, token string) (username string, ok bool) {
+	for candidate, user := range tokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			username, ok = user, true
+		}
+	}
+	return username, ok
 }
 
 // IsEnabled returns whether authentication is enabled
@@ -38,7 +238,8 @@ func (a *AuthMiddleware) IsEnabled() bool {
 	return a.enabled
 }
 
-// GetClientIP extracts the IP address from a network connection
+// GetClientIP extracts the IP address from a network connection, without the
+// port or any IPv6 zone identifier
 func GetClientIP(conn net.Conn) string {
 	if conn == nil {
 		return ""
@@ -55,7 +256,7 @@ func GetClientIP(conn net.Conn) string {
 		return addr.String()
 	}
 
-	return host
+	return manager.StripZone(host)
 }
 
 // ProxyAuthError represents an authentication error
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// AuthDelayMiddleware holds back failed authentication responses by a
+// growing per-IP cooldown
+type AuthDelayMiddleware struct {
+	enabled bool
+	delay   *manager.AuthDelay
+}
+
+// NewAuthDelayMiddleware creates a new auth delay middleware
+func NewAuthDelayMiddleware(enabled bool, delay *manager.AuthDelay) *AuthDelayMiddleware {
+	return &AuthDelayMiddleware{
+		enabled: enabled,
+		delay:   delay,
+	}
+}
+
+// Fail records a failed authentication by ip and waits out its cooldown,
+// returning early when ctx is done
+func (a *AuthDelayMiddleware) Fail(ctx context.Context, ip string) {
+	if !a.enabled {
+		return
+	}
+
+	timer := time.NewTimer(a.delay.RecordFailure(ip))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// Succeed clears ip's cooldown after a successful authentication
+func (a *AuthDelayMiddleware) Succeed(ip string) {
+	if !a.enabled {
+		return
+	}
+
+	a.delay.RecordSuccess(ip)
+}
+
+// IsEnabled returns whether failed authentications are delayed
+func (a *AuthDelayMiddleware) IsEnabled() bool {
+	return a.enabled
+}
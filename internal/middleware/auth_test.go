@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"net"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestAuthMiddleware_Authenticate(t *testing.T) {
@@ -82,3 +85,244 @@ func BenchmarkAuthMiddleware_Authenticate(b *testing.B) {
 		auth.Authenticate("user1", "pass1")
 	}
 }
+
+func TestAuthMiddleware_Update(t *testing.T) {
+	credentials := map[string]string{"user1": "pass1"}
+	auth := NewAuthMiddleware(true, credentials)
+
+	// Changes to the caller's map must not leak in
+	credentials["user2"] = "pass2"
+	if auth.Authenticate("user2", "pass2") {
+		t.Error("Caller's map should have been copied")
+	}
+
+	auth.Update(map[string]string{"user2": "pass2"})
+	if auth.Authenticate("user1", "pass1") {
+		t.Error("Old credentials should be rejected after update")
+	}
+	if !auth.Authenticate("user2", "pass2") {
+		t.Error("New credentials should be accepted after update")
+	}
+}
+
+func TestAuthMiddleware_RotationGrace(t *testing.T) {
+	auth := NewAuthMiddleware(true, map[string]string{"user1": "old", "user2": "pass2"},
+		WithTokens(map[string]string{"tok-old": "api"}),
+		WithRotationGrace(time.Minute))
+
+	auth.Update(map[string]string{"user1": "new"})
+	auth.UpdateTokens(map[string]string{"tok-new": "api"})
+
+	// Within the window both sets authenticate
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     bool
+	}{
+		{"new password", "user1", "new", true},
+		{"old password", "user1", "old", true},
+		{"user removed by the update", "user2", "pass2", true},
+		{"wrong password", "user1", "nope", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auth.Authenticate(tt.username, tt.password); got != tt.want {
+				t.Errorf("Authenticate(%q, %q) = %v, want %v", tt.username, tt.password, got, tt.want)
+			}
+		})
+	}
+	for _, token := range []string{"tok-new", "tok-old"} {
+		if user, ok := auth.AuthenticateToken(token); !ok || user != "api" {
+			t.Errorf("Expected %s to authenticate as api during the window, got %q, %v", token, user, ok)
+		}
+	}
+
+	// A drained user loses the retired password at once
+	auth.RemoveUser("user2")
+	if auth.Authenticate("user2", "pass2") {
+		t.Error("Drained user should be rejected during the window")
+	}
+
+	// Once the window has passed only the new set authenticates
+	auth.mu.Lock()
+	auth.previous.expires = time.Now().Add(-time.Second)
+	auth.prevTokens.expires = time.Now().Add(-time.Second)
+	auth.mu.Unlock()
+
+	if !auth.Authenticate("user1", "new") {
+		t.Error("New password should be accepted after the window")
+	}
+	if auth.Authenticate("user1", "old") {
+		t.Error("Old password should be rejected after the window")
+	}
+	if _, ok := auth.AuthenticateToken("tok-old"); ok {
+		t.Error("Old token should be rejected after the window")
+	}
+	if _, ok := auth.AuthenticateToken("tok-new"); !ok {
+		t.Error("New token should be accepted after the window")
+	}
+
+	// A second rotation retires only the set it replaces
+	auth.Update(map[string]string{"user1": "newer"})
+	if !auth.Authenticate("user1", "new") || auth.Authenticate("user1", "old") {
+		t.Error("Only the set replaced by the latest update should remain accepted")
+	}
+}
+
+func TestAuthMiddleware_ConcurrentUpdate(t *testing.T) {
+	oldSet := map[string]string{"old": "pass"}
+	newSet := map[string]string{"new": "pass"}
+	auth := NewAuthMiddleware(true, oldSet)
+
+	stop := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if i%2 == 0 {
+				auth.Update(newSet)
+			} else {
+				auth.Update(oldSet)
+			}
+		}
+	}()
+
+	var readers sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for i := 0; i < 10000; i++ {
+				auth.Authenticate("old", "pass")
+				auth.Authenticate("new", "pass")
+			}
+		}()
+	}
+
+	readers.Wait()
+	close(stop)
+	<-writerDone
+
+	// Exactly one of the two sets is active afterwards
+	if auth.Authenticate("old", "pass") == auth.Authenticate("new", "pass") {
+		t.Error("Expected exactly one credential set to be active")
+	}
+}
+
+func TestAuthMiddleware_AuthenticateToken(t *testing.T) {
+	auth := NewAuthMiddleware(true, map[string]string{"user1": "pass1"},
+		WithTokens(map[string]string{"tok-alpha": "api", "tok-beta": "ci"}))
+
+	tests := []struct {
+		token    string
+		wantUser string
+		wantOK   bool
+	}{
+		{"tok-alpha", "api", true},
+		{"tok-beta", "ci", true},
+		{"tok-alph", "", false},
+		{"tok-alpha-extra", "", false},
+		{"", "", false},
+		{"pass1", "", false},
+	}
+
+	for _, tt := range tests {
+		username, ok := auth.AuthenticateToken(tt.token)
+		if username != tt.wantUser || ok != tt.wantOK {
+			t.Errorf("AuthenticateToken(%q) = (%q, %v), want (%q, %v)", tt.token, username, ok, tt.wantUser, tt.wantOK)
+		}
+	}
+
+	auth.UpdateTokens(map[string]string{"tok-gamma": "api"})
+	if _, ok := auth.AuthenticateToken("tok-alpha"); ok {
+		t.Error("Old token should be rejected after update")
+	}
+	if username, ok := auth.AuthenticateToken("tok-gamma"); !ok || username != "api" {
+		t.Error("New token should be accepted after update")
+	}
+}
+
+func TestAuthMiddleware_TokenExpiry(t *testing.T) {
+	expires := time.Now().Add(time.Hour)
+	auth := NewAuthMiddleware(true, nil,
+		WithTokens(map[string]string{"tok-live": "api", "tok-dead": "ci", "tok-open": "ops"}),
+		WithTokenExpiry(map[string]time.Time{"tok-live": expires, "tok-dead": time.Now().Add(-time.Minute)}))
+
+	username, until, ok := auth.AuthenticateTokenUntil("tok-live")
+	if !ok || username != "api" || !until.Equal(expires) {
+		t.Errorf("AuthenticateTokenUntil(tok-live) = (%q, %v, %v), want (api, %v, true)", username, until, ok, expires)
+	}
+	if _, _, ok := auth.AuthenticateTokenUntil("tok-dead"); ok {
+		t.Error("Expired token should be rejected")
+	}
+	if _, until, ok := auth.AuthenticateTokenUntil("tok-open"); !ok || !until.IsZero() {
+		t.Errorf("Token without expiry should be accepted with no deadline, got %v, %v", until, ok)
+	}
+
+	// A reload can extend a token's lifetime
+	auth.UpdateTokensWithExpiry(map[string]string{"tok-dead": "ci"}, nil)
+	if _, until, ok := auth.AuthenticateTokenUntil("tok-dead"); !ok || !until.IsZero() {
+		t.Errorf("Expected the reloaded token to be accepted without expiry, got %v, %v", until, ok)
+	}
+}
+
+func TestAuthMiddleware_RemoveUser(t *testing.T) {
+	auth := NewAuthMiddleware(true, map[string]string{"user1": "pass1", "user2": "pass2"},
+		WithTokens(map[string]string{"tok-1": "user1", "tok-2": "user2", "tok-3": "api"}))
+
+	if !auth.RemoveUser("user1") {
+		t.Fatal("Expected user1 to be removed")
+	}
+	if auth.Authenticate("user1", "pass1") {
+		t.Error("Removed user's password should be rejected")
+	}
+	if _, ok := auth.AuthenticateToken("tok-1"); ok {
+		t.Error("Removed user's token should be rejected")
+	}
+	if !auth.Authenticate("user2", "pass2") {
+		t.Error("Other users should be unaffected")
+	}
+
+	// Token-only identities can be removed too
+	if !auth.RemoveUser("api") {
+		t.Error("Expected the token-only user to be removed")
+	}
+	if auth.RemoveUser("nobody") {
+		t.Error("Removing an unknown user should report false")
+	}
+}
+
+// addrConn is a net.Conn that only reports a remote address
+type addrConn struct {
+	net.Conn
+	remote net.Addr
+}
+
+func (c addrConn) RemoteAddr() net.Addr { return c.remote }
+
+func TestGetClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		remote net.Addr
+		want   string
+	}{
+		{"ipv4", &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}, "10.0.0.1"},
+		{"ipv6", &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1234}, "2001:db8::1"},
+		{"zoned link-local", &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 1234, Zone: "eth0"}, "fe80::1"},
+		{"no remote address", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetClientIP(addrConn{remote: tt.remote}); got != tt.want {
+				t.Errorf("GetClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
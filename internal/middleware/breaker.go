@@ -24,16 +24,16 @@ func (c *CircuitBreakerMiddleware) IsOpen() bool {
 		return false
 	}
 
-	return c.breaker.IsOpen()
+	return !c.breaker.Allow()
 }
 
-// RecordAuthFailure records an authentication failure
-func (c *CircuitBreakerMiddleware) RecordAuthFailure() {
+// RecordAuthFailure records an authentication failure by ip
+func (c *CircuitBreakerMiddleware) RecordAuthFailure(ip string) {
 	if !c.enabled {
 		return
 	}
 
-	c.breaker.RecordFailure()
+	c.breaker.RecordFailureFrom(ip)
 }
 
 // RecordAuthSuccess records a successful authentication
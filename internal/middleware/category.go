@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// CategoryRule names the category of targets whose port is in Ports and whose
+// host matches Hosts; an empty list matches anything
+type CategoryRule struct {
+	Name  string
+	Ports []string // Ports or ranges, e.g. "22" or "8000-8999"
+	Hosts []string // Hostnames, "*.example.com" for subdomains, or "*"
+}
+
+// TargetCategoryMiddleware tags connections with a category derived from their
+// target, so logs and metrics can be sliced by traffic type
+type TargetCategoryMiddleware struct {
+	enabled  bool
+	rules    []categoryRule
+	fallback string
+}
+
+// categoryRule is a CategoryRule with its port ranges parsed
+type categoryRule struct {
+	name  string
+	ports [][2]int
+	hosts []string // Lowercased
+}
+
+// NewTargetCategoryMiddleware creates a new target category middleware. Rules
+// are checked in order and targets none of them match get fallback; invalid
+// port entries are ignored.
+func NewTargetCategoryMiddleware(enabled bool, rules []CategoryRule, fallback string) *TargetCategoryMiddleware {
+	m := &TargetCategoryMiddleware{enabled: enabled, fallback: fallback}
+
+	for _, rule := range rules {
+		r := categoryRule{name: rule.Name}
+		for _, entry := range rule.Ports {
+			if lo, hi, ok := parsePortRange(entry); ok {
+				r.ports = append(r.ports, [2]int{lo, hi})
+			}
+		}
+		for _, host := range rule.Hosts {
+			r.hosts = append(r.hosts, strings.ToLower(host))
+		}
+		m.rules = append(m.rules, r)
+	}
+
+	return m
+}
+
+// parsePortRange parses a port or a range of ports such as "8000-8999"
+func parsePortRange(s string) (lo, hi int, ok bool) {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	lo, err1 := strconv.Atoi(first)
+	hi, err2 := strconv.Atoi(last)
+	return lo, hi, err1 == nil && err2 == nil && lo <= hi
+}
+
+// Categorize returns the category of target, a host:port, or "" when disabled
+func (m *TargetCategoryMiddleware) Categorize(target string) string {
+	if !m.enabled {
+		return ""
+	}
+
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return m.fallback
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	port, _ := strconv.Atoi(portStr)
+
+	for _, rule := range m.rules {
+		if rule.matchesPort(port) && rule.matchesHost(host) {
+			return rule.name
+		}
+	}
+	return m.fallback
+}
+
+// matchesPort reports whether port is in one of the rule's ranges
+func (r categoryRule) matchesPort(port int) bool {
+	if len(r.ports) == 0 {
+		return true
+	}
+	for _, ports := range r.ports {
+		if port >= ports[0] && port <= ports[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHost reports whether host matches one of the rule's patterns
+func (r categoryRule) matchesHost(host string) bool {
+	return len(r.hosts) == 0 || MatchHost(r.hosts, host)
+}
+
+// MatchHost reports whether host matches one of patterns: an exact name, "*"
+// for any host, or "*.example.com" for subdomains at any depth but not
+// example.com itself. Both must be lower case.
+func MatchHost(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		switch {
+		case pattern == "*" || pattern == host:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// IsEnabled returns whether target categories are enabled
+func (m *TargetCategoryMiddleware) IsEnabled() bool {
+	return m.enabled
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import "testing"
+
+func TestTargetCategoryMiddleware_Categorize(t *testing.T) {
+	m := NewTargetCategoryMiddleware(true, []CategoryRule{
+		{Name: "internal-web", Ports: []string{"80", "443"}, Hosts: []string{"*.corp.example"}},
+		{Name: "web", Ports: []string{"80", "443", "8000-8999"}},
+		{Name: "ssh", Ports: []string{"22"}},
+		{Name: "chat", Hosts: []string{"chat.example.com", "*.slack.com"}},
+	}, "other")
+
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"wiki.corp.example:443", "internal-web"},
+		{"a.b.corp.example:80", "internal-web"},
+		{"corp.example:443", "web"},
+		{"wiki.corp.example:22", "ssh"},
+		{"example.com:8080", "web"},
+		{"example.com:9000", "other"},
+		{"10.0.0.1:22", "ssh"},
+		{"[2001:db8::1]:443", "web"},
+		{"CHAT.Example.com.:5222", "chat"},
+		{"files.slack.com:5222", "chat"},
+		{"slack.com:5222", "other"},
+		{"no-port", "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			if got := m.Categorize(tt.target); got != tt.want {
+				t.Errorf("Categorize(%q) = %q, want %q", tt.target, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetCategoryMiddleware_Disabled(t *testing.T) {
+	m := NewTargetCategoryMiddleware(false, []CategoryRule{{Name: "web", Hosts: []string{"*"}}}, "other")
+	if got := m.Categorize("example.com:443"); got != "" {
+		t.Errorf("Expected no category when disabled, got %q", got)
+	}
+}
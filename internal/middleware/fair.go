@@ -0,0 +1,150 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// fairQueue admits requests against a global limiter fairly across client
+// IPs. While tokens are available requests take them at once; when the
+// limiter is saturated they wait in a queue per IP, and each token that
+// refills goes to the next IP with a waiter in round-robin order rather than
+// to whichever request arrived first. A burst from one IP then only lengthens
+// its own queue.
+type fairQueue struct {
+	limiter *rate.Limiter
+	maxWait time.Duration // A request not served within this long is rejected
+
+	mu          sync.Mutex
+	queues      map[string][]chan struct{} // IP -> waiters, oldest first
+	order       []string                   // IPs with waiters, next to be served first
+	waiting     int
+	dispatching bool // Whether the dispatcher goroutine is running
+}
+
+// newFairQueue creates a fair queue over limiter
+func newFairQueue(limiter *rate.Limiter, maxWait time.Duration) *fairQueue {
+	return &fairQueue{
+		limiter: limiter,
+		maxWait: maxWait,
+		queues:  make(map[string][]chan struct{}),
+	}
+}
+
+// admit takes a token for ip, waiting for its turn when the limiter is
+// saturated, and reports false when none was granted within maxWait
+func (q *fairQueue) admit(ip string) bool {
+	q.mu.Lock()
+	// Requests only skip the queue when nobody is waiting
+	if q.waiting == 0 && q.limiter.Allow() {
+		q.mu.Unlock()
+		return true
+	}
+
+	granted := make(chan struct{})
+	if len(q.queues[ip]) == 0 {
+		q.order = append(q.order, ip)
+	}
+	q.queues[ip] = append(q.queues[ip], granted)
+	q.waiting++
+	if !q.dispatching {
+		q.dispatching = true
+		go q.dispatch()
+	}
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-granted:
+		return true
+	case <-timer.C:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.remove(ip, granted) {
+		// Granted while timing out, the token is already spent
+		return true
+	}
+	return false
+}
+
+// dispatch hands out tokens as they refill until no request is waiting
+func (q *fairQueue) dispatch() {
+	for {
+		q.mu.Lock()
+		if q.waiting == 0 {
+			q.dispatching = false
+			q.mu.Unlock()
+			return
+		}
+		q.mu.Unlock()
+
+		reservation := q.limiter.Reserve()
+		time.Sleep(reservation.Delay())
+
+		q.mu.Lock()
+		if q.waiting == 0 {
+			// Everyone gave up while the token refilled
+			reservation.Cancel()
+		} else {
+			q.grantNext()
+		}
+		q.mu.Unlock()
+	}
+}
+
+// grantNext serves the oldest waiter of the next IP in turn and moves that IP
+// to the back of the order. The caller must hold mu.
+func (q *fairQueue) grantNext() {
+	ip := q.order[0]
+	q.order = q.order[1:]
+
+	waiters := q.queues[ip]
+	close(waiters[0])
+	q.waiting--
+
+	if len(waiters) == 1 {
+		delete(q.queues, ip)
+		return
+	}
+	q.queues[ip] = waiters[1:]
+	q.order = append(q.order, ip)
+}
+
+// remove drops a waiter that gave up and reports whether it was still
+// queued. The caller must hold mu.
+func (q *fairQueue) remove(ip string, granted chan struct{}) bool {
+	waiters := q.queues[ip]
+	for i, waiter := range waiters {
+		if waiter != granted {
+			continue
+		}
+		q.waiting--
+		if len(waiters) == 1 {
+			delete(q.queues, ip)
+			for j, queued := range q.order {
+				if queued == ip {
+					q.order = append(q.order[:j], q.order[j+1:]...)
+					break
+				}
+			}
+		} else {
+			q.queues[ip] = append(waiters[:i], waiters[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+// queued returns how many requests are waiting for a token
+func (q *fairQueue) queued() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.waiting
+}
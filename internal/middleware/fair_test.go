@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// waitQueued waits until q has n requests waiting
+func waitQueued(t *testing.T, q *fairQueue, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for q.queued() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d waiting requests, got %d", n, q.queued())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFairQueue_RoundRobin(t *testing.T) {
+	q := newFairQueue(rate.NewLimiter(20, 1), 2*time.Second)
+
+	if !q.admit("10.0.0.1") {
+		t.Fatal("Expected the first request to take the available token")
+	}
+
+	// A burst from one IP queues up before another IP arrives
+	served := make(chan string, 6)
+	for range 5 {
+		go func() {
+			if q.admit("10.0.0.1") {
+				served <- "10.0.0.1"
+			}
+		}()
+	}
+	waitQueued(t, q, 5)
+	go func() {
+		if q.admit("10.0.0.2") {
+			served <- "10.0.0.2"
+		}
+	}()
+
+	var order []string
+	for range 6 {
+		select {
+		case ip := <-served:
+			order = append(order, ip)
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for grants, got %v", order)
+		}
+	}
+	if order[0] != "10.0.0.2" && order[1] != "10.0.0.2" {
+		t.Errorf("Expected the quiet IP to be served within two grants, got %v", order)
+	}
+}
+
+func TestFairQueue_MaxWait(t *testing.T) {
+	q := newFairQueue(rate.NewLimiter(0.1, 1), 20*time.Millisecond)
+
+	q.admit("10.0.0.1")
+	if q.admit("10.0.0.1") {
+		t.Error("Expected a request not served within the maximum wait to be rejected")
+	}
+	if got := q.queued(); got != 0 {
+		t.Errorf("Expected the rejected request to leave the queue, got %d waiting", got)
+	}
+}
+
+func TestRateLimitMiddleware_FairQueuing(t *testing.T) {
+	r := NewRateLimitMiddleware(true, 1, 0, WithFairQueuing(20*time.Millisecond))
+
+	// The burst is twice the rate, then requests wait and time out
+	for i := 0; i < 2; i++ {
+		if allowed, _ := r.AllowWithReason("10.0.0.1"); !allowed {
+			t.Fatalf("Expected request %d to be within the burst", i+1)
+		}
+	}
+	if allowed, reason := r.AllowWithReason("10.0.0.2"); allowed || reason != LimitGlobalExceeded {
+		t.Errorf("AllowWithReason() = %v, %v, want a global rejection", allowed, reason)
+	}
+	if global, _ := r.Rejections(); global != 1 {
+		t.Errorf("Expected 1 global rejection, got %d", global)
+	}
+	if got := r.FairQueueWaiting(); got != 0 {
+		t.Errorf("FairQueueWaiting() = %d, want 0", got)
+	}
+}
@@ -1,6 +1,8 @@
 package middleware
 
 import (
+	"time"
+
 	"github.com/seakee/dudu-proxy/internal/manager"
 )
 
@@ -45,7 +47,41 @@ func (i *IPBanMiddleware) RecordAuthSuccess(ip string) {
 	i.manager.RecordSuccess(ip)
 }
 
+// Ban bans an IP immediately
+func (i *IPBanMiddleware) Ban(ip string) {
+	i.BanWithReason(ip, manager.BanReasonManual)
+}
+
+// BanWithReason bans an IP immediately, recording why it was banned
+func (i *IPBanMiddleware) BanWithReason(ip, reason string) {
+	if !i.enabled {
+		return
+	}
+
+	i.manager.BanIPWithReason(ip, reason)
+}
+
 // IsEnabled returns whether IP banning is enabled
 func (i *IPBanMiddleware) IsEnabled() bool {
 	return i.enabled
 }
+
+// FailureCount returns the IP's current authentication failure count, zero
+// when IP banning is disabled
+func (i *IPBanMiddleware) FailureCount(ip string) int {
+	if !i.enabled {
+		return 0
+	}
+
+	return i.manager.GetFailureCount(ip)
+}
+
+// BannedWithin reports whether the IP is banned or had a ban end less than d
+// ago, false when IP banning is disabled
+func (i *IPBanMiddleware) BannedWithin(ip string, d time.Duration) bool {
+	if !i.enabled {
+		return false
+	}
+
+	return i.manager.BannedWithin(ip, d)
+}
@@ -1,61 +1,340 @@
 package middleware
 
 import (
+	"context"
+	"math"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/pkg/logger"
 	"golang.org/x/time/rate"
 )
 
+// LimitReason describes which limit, if any, rejected a request
+type LimitReason int
+
+const (
+	// LimitAllowed means the request was within every limit
+	LimitAllowed LimitReason = iota
+	// LimitGlobalExceeded means the proxy-wide limit was exceeded
+	LimitGlobalExceeded
+	// LimitPerIPExceeded means the client IP's own limit was exceeded
+	LimitPerIPExceeded
+	// LimitBudgetExhausted means the proxy-wide budget for the window is used up
+	LimitBudgetExhausted
+	// LimitBackendUnavailable means the shared limiter failed and is set to fail closed
+	LimitBackendUnavailable
+)
+
+// String returns the string representation of the reason
+func (r LimitReason) String() string {
+	switch r {
+	case LimitAllowed:
+		return "allowed"
+	case LimitGlobalExceeded:
+		return "global"
+	case LimitPerIPExceeded:
+		return "per_ip"
+	case LimitBudgetExhausted:
+		return "budget"
+	case LimitBackendUnavailable:
+		return "backend_unavailable"
+	default:
+		return "unknown"
+	}
+}
+
+// Limiter is a token bucket store shared by several proxy instances, so a
+// limit holds across a fleet rather than per process
+type Limiter interface {
+	// Allow takes a token from the bucket named key, which refills at limit
+	// tokens per second up to burst. An error means no decision was made.
+	Allow(ctx context.Context, key string, limit float64, burst int) (bool, error)
+}
+
+// ipLimiter is a per-IP limiter with the time it was last used
+type ipLimiter struct {
+	limiter    *rate.Limiter
+	lastSeen   atomic.Int64  // Unix nanoseconds
+	adjustedAt atomic.Int64  // Unix nanoseconds the adaptive multiplier was last applied
+	multiplier atomic.Uint64 // math.Float64bits of the applied adaptive multiplier
+}
+
 // RateLimitMiddleware handles request rate limiting
 type RateLimitMiddleware struct {
 	enabled       bool
 	globalLimiter *rate.Limiter
-	perIPLimiters map[string]*rate.Limiter
-	perIPLimit    rate.Limit
+	fair          *fairQueue // Shares a saturated global limit across IPs round-robin, nil admits first come first served
+	fairMaxWait   time.Duration
+	perIPLimiters map[string]*ipLimiter
+	perIPLimit    rate.Limit // Zero means no per-IP limit
 	perIPBurst    int
+	adaptive      *AdaptiveLimits // Scales per-IP limits by each IP's behavior, nil keeps them fixed
+	idleTimeout   time.Duration   // Per-IP limiters unused for this long are evicted
+	warnSize      int             // Log a warning when more IPs are tracked, zero disables
+	warned        bool            // Whether the size warning is active
+	lastSweep     time.Time
+	violations    *manager.ViolationCounter // Counts per-IP rejections, nil disables banning
+	ipBan         *IPBanMiddleware
+	shared        Limiter // Replaces the in-memory limiters when set
+	failClosed    bool    // Reject instead of allow requests the shared limiter fails to decide
+	globalLimit   rate.Limit
+	budget        *manager.Budget // Longer-window cap on admitted requests, nil disables
+	budgetEmpty   atomic.Bool     // Whether the budget has been reported exhausted
+	globalBurst   int
+	globalRejects atomic.Int64
+	perIPRejects  atomic.Int64
 	mu            sync.RWMutex
 }
 
-// NewRateLimitMiddleware creates a new rate limit middleware
-func NewRateLimitMiddleware(enabled bool, globalRPS, perIPRPS int) *RateLimitMiddleware {
+// RateLimitOption configures optional RateLimitMiddleware behavior
+type RateLimitOption func(*RateLimitMiddleware)
+
+// WithIdleTimeout sets how long an IP's limiter is kept after its last request
+func WithIdleTimeout(timeout time.Duration) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.idleTimeout = timeout
+	}
+}
+
+// WithTrackedIPsWarning logs a warning when more than size IPs are tracked, which
+// usually means a spray attack or a leak. Zero disables the warning.
+func WithTrackedIPsWarning(size int) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.warnSize = size
+	}
+}
+
+// WithBanOnViolations bans client IPs whose per-IP rejections exceed the
+// counter's threshold within its window. Global limit rejections are not
+// counted, since they reflect overall load rather than a single client.
+func WithBanOnViolations(violations *manager.ViolationCounter, ipBan *IPBanMiddleware) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.violations = violations
+		r.ipBan = ipBan
+	}
+}
+
+// WithLimiter enforces the limits through a shared limiter instead of
+// in-memory ones. Requests are allowed when the limiter fails, unless
+// WithFailClosed is set.
+func WithLimiter(limiter Limiter) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.shared = limiter
+	}
+}
+
+// WithFailClosed rejects requests the shared limiter fails to decide, e.g.
+// while its backend is unreachable, instead of allowing them
+func WithFailClosed(failClosed bool) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.failClosed = failClosed
+	}
+}
+
+// WithBudget additionally caps the requests admitted within the budget's
+// sliding window across all clients. Requests rejected by the other limits
+// do not count against it.
+func WithBudget(budget *manager.Budget) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.budget = budget
+	}
+}
+
+// WithFairQueuing makes requests that find the global limit saturated wait up
+// to maxWait for a token instead of being rejected at once, with tokens handed
+// to the waiting client IPs in turn so one busy client cannot take them all.
+// It applies to the in-memory global limit only.
+func WithFairQueuing(maxWait time.Duration) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.fairMaxWait = maxWait
+	}
+}
+
+// WithAdaptiveLimits scales each IP's in-memory per-IP limit by its recent
+// failures and bans, recomputing it once per the limits' interval when the IP
+// makes a request
+func WithAdaptiveLimits(adaptive *AdaptiveLimits) RateLimitOption {
+	return func(r *RateLimitMiddleware) {
+		r.adaptive = adaptive
+	}
+}
+
+// NewRateLimitMiddleware creates a new rate limit middleware. A zero rate
+// disables that limit, like leaving it unconfigured.
+func NewRateLimitMiddleware(enabled bool, globalRPS, perIPRPS int, opts ...RateLimitOption) *RateLimitMiddleware {
 	var globalLimiter *rate.Limiter
 	if enabled && globalRPS > 0 {
 		globalLimiter = rate.NewLimiter(rate.Limit(globalRPS), globalRPS*2)
 	}
 
-	return &RateLimitMiddleware{
+	r := &RateLimitMiddleware{
 		enabled:       enabled,
 		globalLimiter: globalLimiter,
-		perIPLimiters: make(map[string]*rate.Limiter),
+		perIPLimiters: make(map[string]*ipLimiter),
 		perIPLimit:    rate.Limit(perIPRPS),
 		perIPBurst:    perIPRPS * 2,
+		globalLimit:   rate.Limit(globalRPS),
+		globalBurst:   globalRPS * 2,
+		idleTimeout:   5 * time.Minute,
+		lastSweep:     time.Now(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
 	}
+	if globalLimiter != nil && r.fairMaxWait > 0 {
+		r.fair = newFairQueue(globalLimiter, r.fairMaxWait)
+	}
+
+	return r
 }
 
 // Allow checks if a request from the given IP is allowed
 func (r *RateLimitMiddleware) Allow(ip string) bool {
+	allowed, _ := r.AllowWithReason(ip)
+	return allowed
+}
+
+// AllowWithReason checks if a request from the given IP is allowed and
+// reports which limit rejected it
+func (r *RateLimitMiddleware) AllowWithReason(ip string) (bool, LimitReason) {
 	if !r.enabled {
-		return true
+		return true, LimitAllowed
+	}
+
+	if r.shared != nil {
+		if allowed, reason := r.allowShared(ip); !allowed {
+			return false, reason
+		}
+		return r.takeBudget()
 	}
 
 	// Check global limit
-	if r.globalLimiter != nil && !r.globalLimiter.Allow() {
-		return false
+	if r.globalLimiter != nil && !r.allowGlobal(ip) {
+		r.globalRejects.Add(1)
+		return false, LimitGlobalExceeded
 	}
 
 	// Check per-IP limit
-	limiter := r.getIPLimiter(ip)
-	return limiter.Allow()
+	if r.perIPLimit > 0 && !r.getIPLimiter(ip).Allow() {
+		r.perIPRejects.Add(1)
+		r.recordViolation(ip)
+		return false, LimitPerIPExceeded
+	}
+
+	return r.takeBudget()
+}
+
+// allowGlobal takes a token from the global limiter, queuing fairly for one
+// when fair queuing is enabled
+func (r *RateLimitMiddleware) allowGlobal(ip string) bool {
+	if r.fair != nil {
+		return r.fair.admit(ip)
+	}
+	return r.globalLimiter.Allow()
+}
+
+// FairQueueWaiting returns how many requests are waiting for a global token,
+// zero without fair queuing
+func (r *RateLimitMiddleware) FairQueueWaiting() int {
+	if r.fair == nil {
+		return 0
+	}
+	return r.fair.queued()
+}
+
+// takeBudget counts an otherwise admitted request against the budget, logging
+// once when it runs out and once when the window frees room again
+func (r *RateLimitMiddleware) takeBudget() (bool, LimitReason) {
+	if r.budget == nil {
+		return true, LimitAllowed
+	}
+
+	if !r.budget.Take() {
+		if r.budgetEmpty.CompareAndSwap(false, true) {
+			logger.Warn("Global request budget exhausted, rejecting new connections until the window rolls",
+				"budget", r.budget.Limit(),
+				"window", r.budget.Window().String())
+		}
+		return false, LimitBudgetExhausted
+	}
+
+	if r.budgetEmpty.CompareAndSwap(true, false) {
+		logger.Info("Global request budget available again", "budget", r.budget.Limit())
+	}
+	return true, LimitAllowed
+}
+
+// allowShared checks the limits against the shared limiter
+func (r *RateLimitMiddleware) allowShared(ip string) (bool, LimitReason) {
+	if r.globalLimit > 0 {
+		if allowed, decided := r.sharedAllow("global", r.globalLimit, r.globalBurst); !decided {
+			return false, LimitBackendUnavailable
+		} else if !allowed {
+			r.globalRejects.Add(1)
+			return false, LimitGlobalExceeded
+		}
+	}
+
+	if r.perIPLimit > 0 {
+		if allowed, decided := r.sharedAllow("ip:"+ip, r.perIPLimit, r.perIPBurst); !decided {
+			return false, LimitBackendUnavailable
+		} else if !allowed {
+			r.perIPRejects.Add(1)
+			r.recordViolation(ip)
+			return false, LimitPerIPExceeded
+		}
+	}
+
+	return true, LimitAllowed
+}
+
+// sharedAllow takes a token from the shared bucket named key. When the
+// backend fails it fails open, so an outage does not block all traffic, and
+// reports the request undecided only when set to fail closed.
+func (r *RateLimitMiddleware) sharedAllow(key string, limit rate.Limit, burst int) (allowed, decided bool) {
+	allowed, err := r.shared.Allow(context.Background(), key, float64(limit), burst)
+	if err != nil {
+		if r.failClosed {
+			logger.WarnSampled("Rate limit backend failed, rejecting request", "key", key, "error", err)
+			return false, false
+		}
+		logger.WarnSampled("Rate limit backend failed, allowing request", "key", key, "error", err)
+		return true, true
+	}
+	return allowed, true
+}
+
+// Rejections returns how many requests each limit has rejected since start
+func (r *RateLimitMiddleware) Rejections() (global, perIP int64) {
+	return r.globalRejects.Load(), r.perIPRejects.Load()
+}
+
+// recordViolation counts a per-IP rejection and bans the IP once it exceeds the threshold
+func (r *RateLimitMiddleware) recordViolation(ip string) {
+	if r.violations == nil || !r.violations.Record(ip) {
+		return
+	}
+
+	logger.Warn("Banning IP after repeated rate limit violations", "client_ip", ip)
+	r.ipBan.BanWithReason(ip, manager.BanReasonRateLimit)
 }
 
 // getIPLimiter returns the rate limiter for a specific IP
 func (r *RateLimitMiddleware) getIPLimiter(ip string) *rate.Limiter {
+	now := time.Now()
+
 	r.mu.RLock()
-	limiter, exists := r.perIPLimiters[ip]
+	entry, exists := r.perIPLimiters[ip]
 	r.mu.RUnlock()
 
 	if exists {
-		return limiter
+		entry.lastSeen.Store(now.UnixNano())
+		r.adapt(ip, entry, now)
+		return entry.limiter
 	}
 
 	// Create new limiter for this IP
@@ -63,15 +342,98 @@ func (r *RateLimitMiddleware) getIPLimiter(ip string) *rate.Limiter {
 	defer r.mu.Unlock()
 
 	// Double-check after acquiring write lock
-	limiter, exists = r.perIPLimiters[ip]
+	entry, exists = r.perIPLimiters[ip]
 	if exists {
-		return limiter
+		entry.lastSeen.Store(now.UnixNano())
+		return entry.limiter
 	}
 
-	limiter = rate.NewLimiter(r.perIPLimit, r.perIPBurst)
-	r.perIPLimiters[ip] = limiter
+	r.evictIdle(now)
+
+	// A new limiter starts with its burst available, so it is created scaled
+	multiplier := 1.0
+	if r.adaptive != nil {
+		multiplier = r.adaptive.Multiplier(ip)
+	}
+	limit, burst := scale(r.perIPLimit, r.perIPBurst, multiplier)
+	entry = &ipLimiter{limiter: rate.NewLimiter(limit, burst)}
+	entry.lastSeen.Store(now.UnixNano())
+	entry.adjustedAt.Store(now.UnixNano())
+	entry.multiplier.Store(math.Float64bits(multiplier))
+	r.perIPLimiters[ip] = entry
+
+	r.checkSize()
+
+	return entry.limiter
+}
+
+// adapt applies ip's adaptive multiplier to its limiter when adaptive limits
+// are enabled and the last one applied is older than their interval
+func (r *RateLimitMiddleware) adapt(ip string, entry *ipLimiter, now time.Time) {
+	if r.adaptive == nil {
+		return
+	}
+	last := entry.adjustedAt.Load()
+	if now.UnixNano()-last < r.adaptive.interval.Nanoseconds() {
+		return
+	}
+	// Concurrent requests leave the recomputation to the first
+	if !entry.adjustedAt.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	multiplier := r.adaptive.Multiplier(ip)
+	limit, burst := scale(r.perIPLimit, r.perIPBurst, multiplier)
+	entry.limiter.SetLimit(limit)
+	entry.limiter.SetBurst(burst)
+	if previous := math.Float64frombits(entry.multiplier.Swap(math.Float64bits(multiplier))); previous != multiplier {
+		logger.Debug("Adjusted per-IP rate limit",
+			"client_ip", ip,
+			"multiplier", multiplier,
+			"requests_per_second", float64(r.perIPLimit)*multiplier)
+	}
+}
+
+// evictIdle drops limiters unused for longer than the idle timeout, at most
+// once per idle timeout. Caller must hold the write lock.
+func (r *RateLimitMiddleware) evictIdle(now time.Time) {
+	if r.idleTimeout <= 0 || now.Sub(r.lastSweep) < r.idleTimeout {
+		return
+	}
+	r.lastSweep = now
+
+	cutoff := now.Add(-r.idleTimeout).UnixNano()
+	for ip, entry := range r.perIPLimiters {
+		if entry.lastSeen.Load() < cutoff {
+			delete(r.perIPLimiters, ip)
+		}
+	}
+}
+
+// checkSize warns once when the tracked IP count crosses the warning size and
+// re-arms when it drops back below. Caller must hold the write lock.
+func (r *RateLimitMiddleware) checkSize() {
+	if r.warnSize <= 0 {
+		return
+	}
+
+	size := len(r.perIPLimiters)
+	if size > r.warnSize && !r.warned {
+		r.warned = true
+		logger.Warn("Rate limiter is tracking an unusually large number of IPs",
+			"tracked_ips", size,
+			"warn_threshold", r.warnSize)
+	} else if size <= r.warnSize {
+		r.warned = false
+	}
+}
+
+// TrackedIPs returns the number of IPs with a per-IP limiter
+func (r *RateLimitMiddleware) TrackedIPs() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	return limiter
+	return len(r.perIPLimiters)
 }
 
 // IsEnabled returns whether rate limiting is enabled
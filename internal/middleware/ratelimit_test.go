@@ -1,7 +1,12 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
 )
 
 func TestRateLimitMiddleware_Allow(t *testing.T) {
@@ -40,6 +45,77 @@ func TestRateLimitMiddleware_PerIPLimit(t *testing.T) {
 	}
 }
 
+func TestRateLimitMiddleware_AllowWithReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		globalRPS int
+		perIPRPS  int
+		want      LimitReason
+	}{
+		{"global limit", 1, 1000, LimitGlobalExceeded},
+		{"per-IP limit", 1000, 1, LimitPerIPExceeded},
+		{"global only", 1, 0, LimitGlobalExceeded},
+		{"per-IP only", 0, 1, LimitPerIPExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rateLimit := NewRateLimitMiddleware(true, tt.globalRPS, tt.perIPRPS)
+
+			// Burst is twice the rate, so the first two requests pass
+			for i := 0; i < 2; i++ {
+				if allowed, reason := rateLimit.AllowWithReason("10.0.0.1"); !allowed || reason != LimitAllowed {
+					t.Fatalf("Request %d: got (%v, %v), want (true, allowed)", i+1, allowed, reason)
+				}
+			}
+
+			if allowed, reason := rateLimit.AllowWithReason("10.0.0.1"); allowed || reason != tt.want {
+				t.Errorf("Got (%v, %v), want (false, %v)", allowed, reason, tt.want)
+			}
+
+			global, perIP := rateLimit.Rejections()
+			if (tt.want == LimitGlobalExceeded) != (global == 1) || (tt.want == LimitPerIPExceeded) != (perIP == 1) {
+				t.Errorf("Unexpected rejection counts: global=%d per_ip=%d", global, perIP)
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_ZeroRates(t *testing.T) {
+	rateLimit := NewRateLimitMiddleware(true, 0, 0)
+
+	// Enabled with neither limit configured allows everything
+	for i := 0; i < 1000; i++ {
+		if allowed, reason := rateLimit.AllowWithReason("10.0.0.1"); !allowed || reason != LimitAllowed {
+			t.Fatalf("Request %d: got (%v, %v), want (true, allowed)", i+1, allowed, reason)
+		}
+	}
+
+	if n := rateLimit.TrackedIPs(); n != 0 {
+		t.Errorf("Expected no per-IP limiters without a per-IP rate, got %d", n)
+	}
+}
+
+func TestRateLimitMiddleware_BanOnViolations(t *testing.T) {
+	ipBan := NewIPBanMiddleware(true, manager.NewIPBanManager(100, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup()))
+	rateLimit := NewRateLimitMiddleware(true, 1000, 1,
+		WithBanOnViolations(manager.NewViolationCounter(2, time.Minute), ipBan))
+
+	ip := "10.0.0.1"
+	// Burst is twice the rate, then the next two rejections stay under the threshold
+	for i := 0; i < 4; i++ {
+		rateLimit.Allow(ip)
+	}
+	if ipBan.IsBlocked(ip) {
+		t.Fatal("IP should not be banned before exceeding the threshold")
+	}
+
+	rateLimit.Allow(ip)
+	if !ipBan.IsBlocked(ip) {
+		t.Error("IP should be banned after exceeding the threshold")
+	}
+}
+
 func TestRateLimitMiddleware_IsEnabled(t *testing.T) {
 	enabled := NewRateLimitMiddleware(true, 100, 10)
 	if !enabled.IsEnabled() {
@@ -52,6 +128,41 @@ func TestRateLimitMiddleware_IsEnabled(t *testing.T) {
 	}
 }
 
+func TestRateLimitMiddleware_IdleEviction(t *testing.T) {
+	rateLimit := NewRateLimitMiddleware(true, 1000, 10, WithIdleTimeout(20*time.Millisecond))
+
+	rateLimit.Allow("10.0.0.1")
+	rateLimit.Allow("10.0.0.2")
+	if got := rateLimit.TrackedIPs(); got != 2 {
+		t.Fatalf("Expected 2 tracked IPs, got %d", got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	rateLimit.Allow("10.0.0.2") // Still active
+	time.Sleep(10 * time.Millisecond)
+
+	// A new IP triggers the sweep, which drops only the idle IP
+	rateLimit.Allow("10.0.0.3")
+	if got := rateLimit.TrackedIPs(); got != 2 {
+		t.Errorf("Expected idle IP to be evicted leaving 2 tracked IPs, got %d", got)
+	}
+}
+
+func TestRateLimitMiddleware_TrackedIPsWarning(t *testing.T) {
+	rateLimit := NewRateLimitMiddleware(true, 1000, 10, WithTrackedIPsWarning(2))
+
+	rateLimit.Allow("10.0.0.1")
+	rateLimit.Allow("10.0.0.2")
+	if rateLimit.warned {
+		t.Error("Warning should not fire at the threshold")
+	}
+
+	rateLimit.Allow("10.0.0.3")
+	if !rateLimit.warned {
+		t.Error("Warning should fire above the threshold")
+	}
+}
+
 // Benchmark tests
 func BenchmarkRateLimitMiddleware_Allow(b *testing.B) {
 	rateLimit := NewRateLimitMiddleware(true, 1000000, 1000000)
@@ -71,3 +182,74 @@ func BenchmarkRateLimitMiddleware_AllowMultipleIPs(b *testing.B) {
 		rateLimit.Allow(ips[i%len(ips)])
 	}
 }
+
+// fakeLimiter allows the first n requests per key, or fails every call when err is set
+type fakeLimiter struct {
+	n     int
+	err   error
+	calls map[string]int
+}
+
+func (f *fakeLimiter) Allow(ctx context.Context, key string, limit float64, burst int) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	f.calls[key]++
+	return f.calls[key] <= f.n, nil
+}
+
+func TestRateLimitMiddleware_SharedLimiter(t *testing.T) {
+	tests := []struct {
+		name       string
+		globalRPS  int
+		limiter    *fakeLimiter
+		failClosed bool
+		want       []LimitReason
+	}{
+		{"per-IP limit", 0, &fakeLimiter{n: 2}, false, []LimitReason{LimitAllowed, LimitAllowed, LimitPerIPExceeded}},
+		{"global limit", 100, &fakeLimiter{n: 1}, false, []LimitReason{LimitAllowed, LimitGlobalExceeded}},
+		{"backend failure allows", 100, &fakeLimiter{err: errors.New("connection refused")}, false,
+			[]LimitReason{LimitAllowed, LimitAllowed, LimitAllowed}},
+		{"backend failure rejects when fail closed", 100, &fakeLimiter{err: errors.New("connection refused")}, true,
+			[]LimitReason{LimitBackendUnavailable, LimitBackendUnavailable}},
+		{"fail closed allows when the backend decides", 0, &fakeLimiter{n: 1}, true,
+			[]LimitReason{LimitAllowed, LimitPerIPExceeded}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.limiter.calls = make(map[string]int)
+			rateLimit := NewRateLimitMiddleware(true, tt.globalRPS, 1000, WithLimiter(tt.limiter), WithFailClosed(tt.failClosed))
+
+			for i, want := range tt.want {
+				if _, got := rateLimit.AllowWithReason("10.0.0.1"); got != want {
+					t.Errorf("Request %d: got %v, want %v", i+1, got, want)
+				}
+			}
+			if rateLimit.TrackedIPs() != 0 {
+				t.Errorf("Expected no in-memory limiters with a shared limiter, got %d", rateLimit.TrackedIPs())
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_Budget(t *testing.T) {
+	rateLimit := NewRateLimitMiddleware(true, 1000, 1,
+		WithBudget(manager.NewBudget(2, time.Hour)))
+
+	want := []struct {
+		ip     string
+		reason LimitReason
+	}{
+		{"10.0.0.1", LimitAllowed},
+		{"10.0.0.1", LimitAllowed},
+		{"10.0.0.1", LimitPerIPExceeded}, // Not counted against the budget
+		{"10.0.0.2", LimitBudgetExhausted},
+	}
+
+	for i, w := range want {
+		if _, got := rateLimit.AllowWithReason(w.ip); got != w.reason {
+			t.Errorf("Request %d: got %v, want %v", i+1, got, w.reason)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// ScanDetectMiddleware feeds clients that look like scanners into the IP ban subsystem
+type ScanDetectMiddleware struct {
+	enabled        bool
+	detector       *manager.ScanDetector
+	ipBan          *IPBanMiddleware
+	banImmediately bool
+}
+
+// NewScanDetectMiddleware creates a new scan detection middleware
+func NewScanDetectMiddleware(enabled bool, detector *manager.ScanDetector, ipBan *IPBanMiddleware, banImmediately bool) *ScanDetectMiddleware {
+	return &ScanDetectMiddleware{
+		enabled:        enabled,
+		detector:       detector,
+		ipBan:          ipBan,
+		banImmediately: banImmediately,
+	}
+}
+
+// RecordTarget records a connection attempt from ip to target and reports whether
+// the IP was flagged as a scanner. Flagged IPs are banned or charged an auth failure.
+func (s *ScanDetectMiddleware) RecordTarget(ip, target string) bool {
+	if !s.enabled {
+		return false
+	}
+
+	if !s.detector.Record(ip, target) {
+		return false
+	}
+
+	if s.banImmediately {
+		s.ipBan.BanWithReason(ip, manager.BanReasonScan)
+	} else {
+		s.ipBan.RecordAuthFailure(ip)
+	}
+
+	return true
+}
+
+// IsEnabled returns whether scan detection is enabled
+func (s *ScanDetectMiddleware) IsEnabled() bool {
+	return s.enabled
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestSSRFGuardMiddleware_IsAllowed(t *testing.T) {
+	guard := NewSSRFGuardMiddleware(true, []string{"10.1.0.0/16", "127.0.0.2", "fe80::a:0/112"})
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"8.8.8.8", true},
+		{"2606:4700::1111", true},
+		{"10.0.0.1", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"127.0.0.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"224.0.0.1", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fe80::1%eth0", false},
+		{"fe80::a:1%eth0", true},
+		{"fc00::1", false},
+		{"::ffff:127.0.0.1", false},
+		{"10.1.2.3", true},
+		{"127.0.0.2", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			if got := guard.IsAllowed(netip.MustParseAddr(tt.ip)); got != tt.want {
+				t.Errorf("IsAllowed(%s) = %v, want %v", tt.ip, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSRFGuardMiddleware_Disabled(t *testing.T) {
+	guard := NewSSRFGuardMiddleware(false, nil)
+	if !guard.IsAllowed(netip.MustParseAddr("127.0.0.1")) {
+		t.Error("All addresses should be allowed when the guard is disabled")
+	}
+}
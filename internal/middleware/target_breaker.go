@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// TargetBreakerMiddleware gates dialing on a per-target circuit breaker so
+// repeatedly failing upstreams fail fast instead of costing every client a dial timeout
+type TargetBreakerMiddleware struct {
+	enabled  bool
+	breakers *manager.TargetBreakers
+}
+
+// NewTargetBreakerMiddleware creates a new per-target circuit breaker middleware
+func NewTargetBreakerMiddleware(enabled bool, breakers *manager.TargetBreakers) *TargetBreakerMiddleware {
+	return &TargetBreakerMiddleware{
+		enabled:  enabled,
+		breakers: breakers,
+	}
+}
+
+// Allow reports whether a connection to target may be attempted
+func (t *TargetBreakerMiddleware) Allow(target string) bool {
+	if !t.enabled {
+		return true
+	}
+
+	return t.breakers.Get(target).Allow()
+}
+
+// RecordSuccess records a successful dial to target
+func (t *TargetBreakerMiddleware) RecordSuccess(target string) {
+	if !t.enabled {
+		return
+	}
+
+	t.breakers.Get(target).RecordSuccess()
+}
+
+// RecordFailure records a failed dial to target
+func (t *TargetBreakerMiddleware) RecordFailure(target string) {
+	if !t.enabled {
+		return
+	}
+
+	t.breakers.Get(target).RecordFailure()
+}
+
+// IsEnabled returns whether per-target circuit breaking is enabled
+func (t *TargetBreakerMiddleware) IsEnabled() bool {
+	return t.enabled
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd socket activation
+const listenFDsStart = 3
+
+// activationNames are the proxies inherited sockets are assigned to, in order,
+// when the service manager doesn't name them
+var activationNames = []string{"http", "socks5"}
+
+// InheritedListeners returns the listeners passed by systemd socket activation,
+// keyed by the proxy they belong to ("http" or "socks5"), or nil when the
+// process was not socket-activated. Sockets named with FileDescriptorName= go
+// to the proxy of that name; unnamed ones are assigned in order, HTTP first.
+// The activation variables are unset so child processes don't inherit them.
+func InheritedListeners() (map[string][]net.Listener, error) {
+	pid, fds, fdNames := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		// Meant for another process, e.g. a parent that didn't clear them
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	count, err := strconv.Atoi(fds)
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+
+	names, err := assignActivationNames(count, fdNames)
+	if err != nil {
+		return nil, err
+	}
+
+	listeners := make(map[string][]net.Listener)
+	for i, name := range names {
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		listener, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				closeListeners(opened)
+			}
+			return nil, fmt.Errorf("inherited socket %d (%s) is not a listener: %w", listenFDsStart+i, name, err)
+		}
+		listeners[name] = append(listeners[name], listener)
+	}
+	return listeners, nil
+}
+
+// assignActivationNames returns the proxy each of count inherited sockets
+// belongs to, from the colon-separated LISTEN_FDNAMES when set
+func assignActivationNames(count int, fdNames string) ([]string, error) {
+	names := make([]string, count)
+	if fdNames == "" {
+		if count > len(activationNames) {
+			return nil, fmt.Errorf("got %d inherited sockets without names, expected at most %d", count, len(activationNames))
+		}
+		copy(names, activationNames)
+		return names, nil
+	}
+
+	given := strings.Split(fdNames, ":")
+	if len(given) != count {
+		return nil, fmt.Errorf("LISTEN_FDNAMES has %d names for %d sockets", len(given), count)
+	}
+	for i, name := range given {
+		if name != "http" && name != "socks5" {
+			return nil, fmt.Errorf("inherited socket %d is named %q, expected \"http\" or \"socks5\"", listenFDsStart+i, name)
+		}
+		names[i] = name
+	}
+	return names, nil
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestAssignActivationNames(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		fdNames string
+		want    []string
+		wantErr bool
+	}{
+		{"unnamed single", 1, "", []string{"http"}, false},
+		{"unnamed both", 2, "", []string{"http", "socks5"}, false},
+		{"unnamed too many", 3, "", nil, true},
+		{"named", 2, "socks5:http", []string{"socks5", "http"}, false},
+		{"named repeated", 3, "http:http:socks5", []string{"http", "http", "socks5"}, false},
+		{"name count mismatch", 2, "http", nil, true},
+		{"unknown name", 1, "admin", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := assignActivationNames(tt.count, tt.fdNames)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("assignActivationNames() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !slices.Equal(got, tt.want) {
+				t.Errorf("assignActivationNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInheritedListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", "1") // Some other process
+
+	listeners, err := InheritedListeners()
+	if err != nil {
+		t.Fatalf("InheritedListeners() error = %v", err)
+	}
+	if listeners != nil {
+		t.Errorf("InheritedListeners() = %v, want nil", listeners)
+	}
+	if os.Getenv("LISTEN_FDS") == "" {
+		t.Error("Activation variables for another process should be left alone")
+	}
+}
+
+func TestOpenListeners_Inherited(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	o := newOptions([]Option{WithListeners([]net.Listener{listener})})
+	listeners, err := o.openListeners(1)
+	if err != nil {
+		t.Fatalf("openListeners() error = %v", err)
+	}
+	if len(listeners) != 1 || listeners[0] != listener {
+		t.Errorf("openListeners() = %v, want the inherited listener", listeners)
+	}
+}
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// anonymousConnKey is the one ConnLimiter key every anonymous connection shares
+const anonymousConnKey = "anonymous"
+
+// AnonymousLimits are the stricter limits applied on top of a listener's own
+// to connections that didn't authenticate, those on a listener with
+// authentication disabled. Share one between the proxies so the connection
+// cap spans both listeners.
+type AnonymousLimits struct {
+	rateLimit *middleware.RateLimitMiddleware // Per-IP rate for anonymous connections, nil disables
+	conns     *manager.ConnLimiter            // Caps the simultaneous anonymous connections, nil disables
+	ports     map[int]bool                    // Target ports anonymous clients may reach, empty allows any
+}
+
+// NewAnonymousLimits creates the limits for anonymous connections. Zero
+// perIPRPS or maxConns disables that limit, and no ports allows every port.
+func NewAnonymousLimits(perIPRPS, maxConns int, allowedPorts []int) *AnonymousLimits {
+	l := &AnonymousLimits{}
+	if perIPRPS > 0 {
+		l.rateLimit = middleware.NewRateLimitMiddleware(true, 0, perIPRPS)
+	}
+	if maxConns > 0 {
+		l.conns = manager.NewConnLimiter(maxConns)
+	}
+	if len(allowedPorts) > 0 {
+		l.ports = make(map[int]bool, len(allowedPorts))
+		for _, port := range allowedPorts {
+			l.ports[port] = true
+		}
+	}
+	return l
+}
+
+// Active returns how many anonymous connections are open under the cap
+func (l *AnonymousLimits) Active() int {
+	if l.conns == nil {
+		return 0
+	}
+	return l.conns.Active(anonymousConnKey)
+}
+
+// portAllowed reports whether anonymous clients may reach target's port
+func (l *AnonymousLimits) portAllowed(target string) bool {
+	if len(l.ports) == 0 {
+		return true
+	}
+	_, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	return err == nil && l.ports[port]
+}
+
+// WithAnonymousLimits applies limits to connections on a listener without
+// authentication; nil applies none
+func WithAnonymousLimits(limits *AnonymousLimits) Option {
+	return func(o *options) {
+		o.anonymousLimits = limits
+	}
+}
+
+// anonymousLimited returns the limits the proxy's connections are held to
+// beyond its own, nil when its clients authenticate or none are set
+func (o *options) anonymousLimited() *AnonymousLimits {
+	if o.auth.IsEnabled() {
+		return nil
+	}
+	return o.anonymousLimits
+}
+
+// acquireAnonymous applies the anonymous rate limit and takes one of the
+// anonymous connection slots, logging and counting the rejection when either
+// is exhausted. Call release once the connection ends.
+func (o *options) acquireAnonymous(protocol string, connID uint64, clientIP string) (release func(), ok bool) {
+	limits := o.anonymousLimited()
+	if limits == nil {
+		return func() {}, true
+	}
+
+	if limits.rateLimit != nil && !limits.rateLimit.Allow(clientIP) {
+		o.logAnonymousRejection(protocol, connID, clientIP, "rate_limit")
+		return nil, false
+	}
+
+	if limits.conns == nil {
+		return func() {}, true
+	}
+	release, ok = limits.conns.Acquire(anonymousConnKey)
+	if !ok {
+		o.logAnonymousRejection(protocol, connID, clientIP, "max_conns")
+	}
+	return release, ok
+}
+
+// logAnonymousRejection records an anonymous connection turned away by one of
+// the anonymous limits
+func (o *options) logAnonymousRejection(protocol string, connID uint64, clientIP, reason string) {
+	logger.WarnSampled("Connection rejected: anonymous limit reached",
+		"protocol", protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"reason", reason)
+	metrics.Default.Counter("dudu_anonymous_rejections_total", "Anonymous connections rejected by the anonymous rate or connection limit",
+		"protocol", protocol, "reason", reason).Inc()
+}
+
+// checkAnonymousTarget returns errTargetForbidden when the connection is
+// anonymous and target's port is not open to anonymous clients
+func (o *options) checkAnonymousTarget(target string) error {
+	if limits := o.anonymousLimited(); limits != nil && !limits.portAllowed(target) {
+		return fmt.Errorf("%w: port of %s is not open to anonymous clients", errTargetForbidden, target)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func TestAcquireAnonymous(t *testing.T) {
+	counter := metrics.Default.Counter("dudu_anonymous_rejections_total", "", "protocol", "socks5", "reason", "max_conns")
+	before := counter.Value()
+
+	limits := NewAnonymousLimits(0, 1, nil)
+
+	// Authenticated listeners are held only to their own limits
+	o := newOptions([]Option{
+		WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+		WithAnonymousLimits(limits),
+	})
+	for i := 0; i < 2; i++ {
+		if _, ok := o.acquireAnonymous("socks5", nextConnID(), "10.0.0.1"); !ok {
+			t.Fatal("Expected no anonymous cap with authentication")
+		}
+	}
+
+	o = newOptions([]Option{WithAnonymousLimits(limits)})
+	release, ok := o.acquireAnonymous("socks5", nextConnID(), "10.0.0.1")
+	if !ok {
+		t.Fatal("Expected the first anonymous connection to be allowed")
+	}
+	// The cap is shared by every anonymous client
+	if _, ok := o.acquireAnonymous("socks5", nextConnID(), "10.0.0.2"); ok {
+		t.Error("Expected a second anonymous connection to be rejected")
+	}
+	if got := counter.Value() - before; got != 1 {
+		t.Errorf("Expected the rejection counter to grow by 1, got %d", got)
+	}
+	if got := limits.Active(); got != 1 {
+		t.Errorf("Active() = %d, want 1", got)
+	}
+
+	release()
+	if _, ok := o.acquireAnonymous("socks5", nextConnID(), "10.0.0.2"); !ok {
+		t.Error("Expected a connection to be allowed once the first closed")
+	}
+}
+
+func TestAcquireAnonymous_RateLimit(t *testing.T) {
+	o := newOptions([]Option{WithAnonymousLimits(NewAnonymousLimits(1, 0, nil))})
+
+	// The burst is twice the rate
+	for i := 0; i < 2; i++ {
+		if _, ok := o.acquireAnonymous("http", nextConnID(), "10.0.0.1"); !ok {
+			t.Fatalf("Expected connection %d to be within the burst", i+1)
+		}
+	}
+	if _, ok := o.acquireAnonymous("http", nextConnID(), "10.0.0.1"); ok {
+		t.Error("Expected the IP to be rate limited")
+	}
+	if _, ok := o.acquireAnonymous("http", nextConnID(), "10.0.0.2"); !ok {
+		t.Error("Expected another IP to be allowed")
+	}
+}
+
+func TestCheckAnonymousTarget(t *testing.T) {
+	auth := middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})
+	limits := NewAnonymousLimits(0, 0, []int{80, 443})
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		target    string
+		forbidden bool
+	}{
+		{name: "allowed port", opts: []Option{WithAnonymousLimits(limits)}, target: "example.com:443"},
+		{name: "other port", opts: []Option{WithAnonymousLimits(limits)}, target: "example.com:22", forbidden: true},
+		{name: "no port", opts: []Option{WithAnonymousLimits(limits)}, target: "example.com", forbidden: true},
+		{name: "authenticated", opts: []Option{WithAuth(auth), WithAnonymousLimits(limits)}, target: "example.com:22"},
+		{name: "any port", opts: []Option{WithAnonymousLimits(NewAnonymousLimits(0, 0, nil))}, target: "example.com:22"},
+		{name: "no limits", target: "example.com:22"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions(tt.opts)
+			err := o.checkAnonymousTarget(tt.target)
+			if got := errors.Is(err, errTargetForbidden); got != tt.forbidden {
+				t.Errorf("checkAnonymousTarget(%q) = %v, want forbidden %v", tt.target, err, tt.forbidden)
+			}
+		})
+	}
+}
@@ -0,0 +1,23 @@
+package proxy
+
+import (
+	"github.com/seakee/dudu-proxy/internal/metrics"
+)
+
+// withCategory appends the target_category log field to fields, unless the
+// connection is untagged because target categories are disabled
+func withCategory(fields []interface{}, category string) []interface{} {
+	if category == "" {
+		return fields
+	}
+	return append(fields, "target_category", category)
+}
+
+// countCategory counts a connection established to a target of category
+func countCategory(protocol, category string) {
+	if category == "" {
+		return
+	}
+	metrics.Default.Counter("dudu_target_category_connections_total", "Connections established to targets, by target category",
+		"protocol", protocol, "category", category).Inc()
+}
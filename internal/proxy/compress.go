@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// compressibleTypes lists non-text media types worth compressing
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/xhtml+xml":  true,
+	"image/svg+xml":          true,
+}
+
+// relayCompressed reads a single response from targetConn and writes it to
+// clientConn, gzip-compressing the body when the client accepts it and the content
+// is compressible. The connection is closed afterwards, so only one response is relayed.
+func relayCompressed(clientConn io.Writer, targetConn io.Reader, req *http.Request) error {
+	resp, err := http.ReadResponse(bufio.NewReader(targetConn), req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	resp.Close = true
+	if !shouldCompress(req, resp) {
+		return resp.Write(clientConn)
+	}
+
+	body := resp.Body
+	pr, pw := io.Pipe()
+	go func() {
+		gz := gzip.NewWriter(pw)
+		_, err := io.Copy(gz, body)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	resp.Body = pr
+	resp.ContentLength = -1
+	resp.Header.Del("Content-Length")
+	resp.Header.Set("Content-Encoding", "gzip")
+	resp.Header.Add("Vary", "Accept-Encoding")
+	resp.TransferEncoding = []string{"chunked"}
+
+	return resp.Write(clientConn)
+}
+
+// shouldCompress reports whether resp can be gzip-compressed for the client that sent req
+func shouldCompress(req *http.Request, resp *http.Response) bool {
+	if req.Method == http.MethodHead || !acceptsGzip(req.Header.Get("Accept-Encoding")) {
+		return false
+	}
+
+	// Responses without a body
+	if resp.StatusCode < 200 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return false
+	}
+
+	// Already compressed
+	if resp.Header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	return isCompressible(resp.Header.Get("Content-Type"))
+}
+
+// isCompressible reports whether the media type is text-like
+func isCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	return strings.HasPrefix(mediaType, "text/") ||
+		strings.HasSuffix(mediaType, "+json") ||
+		strings.HasSuffix(mediaType, "+xml") ||
+		compressibleTypes[mediaType]
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(acceptEncoding string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+
+		// "gzip;q=0" explicitly refuses gzip
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,283 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Dialer opens connections to proxy targets. *net.Dialer implements it.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// sourcePortKey carries the local port a target connection should be bound to
+type sourcePortKey struct{}
+
+// withSourcePort asks the dialer to bind the target connection to port, zero
+// leaving the choice to the system
+func withSourcePort(ctx context.Context, port int) context.Context {
+	return context.WithValue(ctx, sourcePortKey{}, port)
+}
+
+// sourcePort returns the local port requested by withSourcePort, or zero
+func sourcePort(ctx context.Context) int {
+	port, _ := ctx.Value(sourcePortKey{}).(int)
+	return port
+}
+
+// directDialer dials targets from the system's choice of source address, and
+// from the source port requested by withSourcePort when there is one
+type directDialer struct {
+	net.Dialer
+}
+
+// DialContext dials address, binding the requested source port
+func (d *directDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := d.Dialer
+	if port := sourcePort(ctx); port != 0 {
+		dialer.LocalAddr = &net.TCPAddr{Port: port}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// sourceIPDialer binds each connection to the next address of a pool of local
+// source IPs in turn
+type sourceIPDialer struct {
+	sources []net.IP
+	next    atomic.Uint64
+}
+
+// DialContext dials from the next source IP. For a literal IP target it skips
+// sources of the other address family, which could never reach it.
+func (d *sourceIPDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	start := d.next.Add(1) - 1
+	source := d.sources[start%uint64(len(d.sources))]
+
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		if target, err := netip.ParseAddr(host); err == nil {
+			for i := range uint64(len(d.sources)) {
+				candidate := d.sources[(start+i)%uint64(len(d.sources))]
+				if (candidate.To4() == nil) == !target.Unmap().Is4() {
+					source = candidate
+					break
+				}
+			}
+		}
+	}
+
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: source, Port: sourcePort(ctx)}}
+	return dialer.DialContext(ctx, network, address)
+}
+
+var (
+	// errTargetUnavailable is returned when the target's circuit breaker is open
+	errTargetUnavailable = errors.New("target circuit breaker is open")
+	// errTargetForbidden is returned when the SSRF guard rejects the target's
+	// address or the target's port is not open to anonymous clients
+	errTargetForbidden = errors.New("target address is not allowed")
+)
+
+// dial connects to target, giving up when ctx is done or the dial timeout passes.
+// When the target's circuit breaker is open it fails fast without attempting a
+// connection; otherwise the outcome is recorded in that breaker. A host
+// override replaces the address dialed, but the breaker still tracks target.
+func (o *options) dial(ctx context.Context, target string) (net.Conn, error) {
+	if err := o.checkAnonymousTarget(target); err != nil {
+		return nil, err
+	}
+
+	if !o.targetBreaker.Allow(target) {
+		return nil, errTargetUnavailable
+	}
+
+	address := target
+	if o.hostOverrides != nil {
+		if pinned, ok := o.hostOverrides.Apply(target); ok {
+			logger.Debug("Host override applied", "target", target, "address", pinned)
+			address = pinned
+		}
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, o.dialTimeout)
+	defer cancel()
+
+	conn, err := o.dialGuarded(dialCtx, address)
+	// Neither a forbidden target nor a canceled connection says anything about the target's health
+	if errors.Is(err, errTargetForbidden) || (err != nil && ctx.Err() != nil) {
+		return nil, err
+	}
+	if err != nil {
+		o.targetBreaker.RecordFailure(target)
+		return nil, err
+	}
+
+	o.targetBreaker.RecordSuccess(target)
+	return conn, nil
+}
+
+// dialGuarded dials target directly, or with the SSRF guard enabled resolves it,
+// checks every address and dials the checked addresses themselves so a second
+// DNS answer can't point the connection somewhere else
+func (o *options) dialGuarded(ctx context.Context, target string) (net.Conn, error) {
+	if !o.ssrfGuard.IsEnabled() {
+		return o.dialFromSourcePort(ctx, target)
+	}
+
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := lookupTarget(ctx, resolveNetwork(o.network), host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no addresses found", Name: host, IsNotFound: true}
+	}
+	for _, ip := range ips {
+		if !o.ssrfGuard.IsAllowed(ip) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", errTargetForbidden, host, ip)
+		}
+	}
+
+	for _, ip := range ips {
+		var conn net.Conn
+		conn, err = o.dialFromSourcePort(ctx, net.JoinHostPort(ip.Unmap().String(), port))
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, err
+}
+
+// dialFromSourcePort dials address with the configured dialer. When the
+// requested source port is taken, it dials again from an ephemeral port.
+func (o *options) dialFromSourcePort(ctx context.Context, address string) (net.Conn, error) {
+	conn, err := o.dialer.DialContext(ctx, o.network, address)
+	if port := sourcePort(ctx); port != 0 && errors.Is(err, syscall.EADDRINUSE) {
+		logger.Debug("Source port in use, dialing from an ephemeral port", "target", address, "source_port", port)
+		return o.dialer.DialContext(withSourcePort(ctx, 0), o.network, address)
+	}
+	return conn, err
+}
+
+// lookupTarget resolves host, returning an IP literal as is. The resolver would
+// drop the zone of a link-local literal such as fe80::1%eth0, leaving an
+// address that can't be dialed.
+func lookupTarget(ctx context.Context, network, host string) ([]netip.Addr, error) {
+	if ip, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{ip}, nil
+	}
+	return net.DefaultResolver.LookupNetIP(ctx, network, host)
+}
+
+// resolvedAddr returns the remote address the target connection resolved to
+func resolvedAddr(conn net.Conn) string {
+	if addr := conn.RemoteAddr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// Dial failure categories reported in logs and in dudu_dial_errors_total
+const (
+	dialErrorDNS         = "dns_error"          // The target's name did not resolve
+	dialErrorConnect     = "connect_error"      // The target resolved but the connection failed
+	dialErrorUnavailable = "target_unavailable" // The target's circuit breaker is open
+	dialErrorForbidden   = "target_forbidden"   // The SSRF guard or anonymous port policy rejected the target
+)
+
+// Dial failure reasons, logged as reason alongside the category
+const (
+	dialReasonTimeout     = "timeout"     // The target or its resolver did not answer in time
+	dialReasonRefused     = "refused"     // The target host actively refused the connection
+	dialReasonUnreachable = "unreachable" // No route to the target's network or host
+	dialReasonDNS         = "dns"         // The target's name did not resolve
+	dialReasonPolicy      = "policy"      // The proxy declined to dial: SSRF guard or open circuit breaker
+	dialReasonOther       = "other"
+)
+
+// classifyDialError returns the category of a dial error and, for DNS
+// errors, whether the name does not exist, timed out or failed on the server
+func classifyDialError(err error) (category, dnsResult string) {
+	if errors.Is(err, errTargetUnavailable) {
+		return dialErrorUnavailable, ""
+	}
+	if errors.Is(err, errTargetForbidden) {
+		return dialErrorForbidden, ""
+	}
+
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		return dialErrorConnect, ""
+	}
+	switch {
+	case dnsErr.IsNotFound:
+		return dialErrorDNS, "nxdomain"
+	case dnsErr.IsTimeout:
+		return dialErrorDNS, "timeout"
+	case dnsErr.IsTemporary:
+		return dialErrorDNS, "servfail"
+	default:
+		return dialErrorDNS, "other"
+	}
+}
+
+// dialFailureReason returns why a dial failed, in terms that don't depend on
+// the platform's error text
+func dialFailureReason(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errTargetForbidden), errors.Is(err, errTargetUnavailable):
+		return dialReasonPolicy
+	case errors.As(err, &dnsErr):
+		return dialReasonDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return dialReasonTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return dialReasonRefused
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return dialReasonUnreachable
+	default:
+		return dialReasonOther
+	}
+}
+
+// logDialFailure logs a failed dial to target and counts it by protocol and
+// category, so unresolvable names stand apart from blocked or refused
+// connections. Both proxies log every failure, including targets the SSRF
+// guard rejects, through here with the same fields.
+func logDialFailure(protocol string, connID uint64, clientIP, target, targetCategory string, err error) {
+	category, dnsResult := classifyDialError(err)
+
+	fields := withCategory([]interface{}{
+		"category", category,
+		"reason", dialFailureReason(err),
+		"protocol", protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"target", target,
+		"error", err,
+	}, targetCategory)
+	switch category {
+	case dialErrorForbidden:
+		logger.WarnSampled("Request rejected: target address not allowed", fields...)
+	case dialErrorDNS:
+		logger.Error("Failed to resolve target", append(fields, "dns_result", dnsResult)...)
+	default:
+		logger.Error("Failed to connect to target", fields...)
+	}
+
+	metrics.Default.Counter("dudu_dial_errors_total", "Failed connections to targets",
+		"protocol", protocol, "category", category).Inc()
+}
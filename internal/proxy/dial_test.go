@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func TestDial_TargetBreaker(t *testing.T) {
+	// Reserve a port and close it so dials are refused
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	target := ln.Addr().String()
+	ln.Close()
+
+	breakers := manager.NewTargetBreakers(
+		manager.WithMinRequests(2),
+		manager.WithBreakDuration(time.Minute),
+	)
+	o := newOptions([]Option{
+		WithDialTimeout(time.Second),
+		WithTargetBreaker(middleware.NewTargetBreakerMiddleware(true, breakers)),
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := o.dial(context.Background(), target); err == nil || errors.Is(err, errTargetUnavailable) {
+			t.Fatalf("Dial %d: expected connection error, got %v", i, err)
+		}
+	}
+
+	if _, err := o.dial(context.Background(), target); !errors.Is(err, errTargetUnavailable) {
+		t.Errorf("Expected errTargetUnavailable once the breaker opens, got %v", err)
+	}
+}
+
+func TestClassifyDialError(t *testing.T) {
+	tests := []struct {
+		name         string
+		err          error
+		wantCategory string
+		wantDNS      string
+		wantReason   string
+	}{
+		{"nxdomain", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}, dialErrorDNS, "nxdomain", dialReasonDNS},
+		{"dns timeout", &net.DNSError{Err: "i/o timeout", IsTimeout: true}, dialErrorDNS, "timeout", dialReasonDNS},
+		{"servfail", &net.DNSError{Err: "server misbehaving", IsTemporary: true}, dialErrorDNS, "servfail", dialReasonDNS},
+		{"connection refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, dialErrorConnect, "", dialReasonRefused},
+		{"host unreachable", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.EHOSTUNREACH)}, dialErrorConnect, "", dialReasonUnreachable},
+		{"network unreachable", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}, dialErrorConnect, "", dialReasonUnreachable},
+		{"dial timeout", &net.OpError{Op: "dial", Err: context.DeadlineExceeded}, dialErrorConnect, "", dialReasonTimeout},
+		{"unknown", &net.OpError{Op: "dial", Err: errors.New("something else")}, dialErrorConnect, "", dialReasonOther},
+		{"breaker open", errTargetUnavailable, dialErrorUnavailable, "", dialReasonPolicy},
+		{"ssrf guard", fmt.Errorf("%w: internal resolves to 10.0.0.1", errTargetForbidden), dialErrorForbidden, "", dialReasonPolicy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			category, dnsResult := classifyDialError(tt.err)
+			if category != tt.wantCategory || dnsResult != tt.wantDNS {
+				t.Errorf("classifyDialError() = %q, %q, want %q, %q", category, dnsResult, tt.wantCategory, tt.wantDNS)
+			}
+			if reason := dialFailureReason(tt.err); reason != tt.wantReason {
+				t.Errorf("dialFailureReason() = %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+// echoDialer connects every dial to an in-memory server that echoes what it reads
+type echoDialer struct {
+	targets []string
+}
+
+func (d *echoDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.targets = append(d.targets, address)
+
+	client, server := net.Pipe()
+	go func() {
+		io.Copy(server, server)
+		server.Close()
+	}()
+	return client, nil
+}
+
+func TestDial_Dialer(t *testing.T) {
+	t.Run("SOCKS5", func(t *testing.T) {
+		dialer := &echoDialer{}
+		s := NewSOCKS5Proxy(0, WithDialer(dialer))
+
+		client, server := net.Pipe()
+		defer client.Close()
+		go s.handleConnection(server)
+
+		go func() {
+			client.Write([]byte{socks5Version, 1, authNone})
+			client.Write(append([]byte{socks5Version, cmdConnect, 0, atypDomain, 11}, "example.com\x00\x50"...))
+		}()
+
+		// Method selection plus a reply for the non-TCP bound address
+		reply := make([]byte, 12)
+		if _, err := io.ReadFull(client, reply); err != nil {
+			t.Fatalf("Failed to read replies: %v", err)
+		}
+		if reply[3] != repSuccess {
+			t.Fatalf("Expected success reply, got %#v", reply)
+		}
+
+		go client.Write([]byte("ping"))
+		echo := make([]byte, 4)
+		if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "ping" {
+			t.Fatalf("Expected echoed data, got %q (%v)", echo, err)
+		}
+
+		if len(dialer.targets) != 1 || dialer.targets[0] != "example.com:80" {
+			t.Errorf("Expected a dial to example.com:80, got %v", dialer.targets)
+		}
+	})
+
+	t.Run("HTTP CONNECT", func(t *testing.T) {
+		dialer := &echoDialer{}
+		h := NewHTTPProxy(0, WithDialer(dialer))
+
+		client, server := net.Pipe()
+		defer client.Close()
+		go h.handleConnection(server)
+
+		go io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+
+		br := bufio.NewReader(client)
+		resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+
+		go io.WriteString(client, "ping")
+		echo := make([]byte, 4)
+		if _, err := io.ReadFull(br, echo); err != nil || string(echo) != "ping" {
+			t.Fatalf("Expected echoed data, got %q (%v)", echo, err)
+		}
+
+		if len(dialer.targets) != 1 || dialer.targets[0] != "example.com:443" {
+			t.Errorf("Expected a dial to example.com:443, got %v", dialer.targets)
+		}
+	})
+}
+
+func TestDial_SSRFGuard(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		allow      []string
+		wantErr    error
+		wantDialed string
+	}{
+		{"loopback literal", "127.0.0.1:80", nil, errTargetForbidden, ""},
+		{"loopback name", "localhost:80", nil, errTargetForbidden, ""},
+		{"link-local metadata", "169.254.169.254:80", nil, errTargetForbidden, ""},
+		{"allowlisted", "127.0.0.1:80", []string{"127.0.0.0/8"}, nil, "127.0.0.1:80"},
+		{"public", "8.8.8.8:53", nil, nil, "8.8.8.8:53"},
+		{"zoned link-local", "[fe80::1%eth0]:80", nil, errTargetForbidden, ""},
+		{"zoned link-local allowlisted", "[fe80::1%eth0]:80", []string{"fe80::/10"}, nil, "[fe80::1%eth0]:80"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &echoDialer{}
+			o := newOptions([]Option{
+				WithNetwork("tcp4"),
+				WithDialer(dialer),
+				WithSSRFGuard(middleware.NewSSRFGuardMiddleware(true, tt.allow)),
+			})
+
+			conn, err := o.dial(context.Background(), tt.target)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("dial(%s) error = %v, want %v", tt.target, err, tt.wantErr)
+			}
+			if conn != nil {
+				conn.Close()
+			}
+
+			if tt.wantDialed == "" {
+				if len(dialer.targets) != 0 {
+					t.Errorf("Expected no dial, got %v", dialer.targets)
+				}
+			} else if len(dialer.targets) != 1 || dialer.targets[0] != tt.wantDialed {
+				t.Errorf("Expected a dial to %s, got %v", tt.wantDialed, dialer.targets)
+			}
+		})
+	}
+}
+
+func TestDial_HostOverrides(t *testing.T) {
+	overrides := manager.NewHostOverrides(map[string]string{
+		"foo.internal":  "10.0.0.5:8080",
+		"*.blue.test":   "10.0.1.1",
+		"*.a.blue.test": "10.0.1.2",
+	})
+
+	tests := []struct {
+		target     string
+		ssrfGuard  bool
+		wantErr    error
+		wantDialed string
+	}{
+		{"foo.internal:443", false, nil, "10.0.0.5:8080"},
+		{"FOO.internal.:443", false, nil, "10.0.0.5:8080"},
+		{"api.blue.test:443", false, nil, "10.0.1.1:443"},
+		{"x.a.blue.test:80", false, nil, "10.0.1.2:80"}, // The longer pattern wins
+		{"example.com:443", false, nil, "example.com:443"},
+		// The SSRF guard still checks the pinned address
+		{"foo.internal:443", true, errTargetForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		dialer := &echoDialer{}
+		o := newOptions([]Option{
+			WithDialer(dialer),
+			WithHostOverrides(overrides),
+			WithSSRFGuard(middleware.NewSSRFGuardMiddleware(tt.ssrfGuard, nil)),
+		})
+
+		conn, err := o.dial(context.Background(), tt.target)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("dial(%s) error = %v, want %v", tt.target, err, tt.wantErr)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+
+		if tt.wantDialed == "" {
+			if len(dialer.targets) != 0 {
+				t.Errorf("dial(%s): expected no dial, got %v", tt.target, dialer.targets)
+			}
+		} else if len(dialer.targets) != 1 || dialer.targets[0] != tt.wantDialed {
+			t.Errorf("dial(%s): expected a dial to %s, got %v", tt.target, tt.wantDialed, dialer.targets)
+		}
+	}
+
+	// Reloaded rules apply to the next dial
+	overrides.Update(map[string]string{"foo.internal": "10.0.0.6"})
+	dialer := &echoDialer{}
+	o := newOptions([]Option{WithDialer(dialer), WithHostOverrides(overrides)})
+	if conn, err := o.dial(context.Background(), "foo.internal:443"); err == nil {
+		conn.Close()
+	}
+	if len(dialer.targets) != 1 || dialer.targets[0] != "10.0.0.6:443" {
+		t.Errorf("Expected the updated override to be dialed, got %v", dialer.targets)
+	}
+}
+
+func TestSourceIPDialer(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	sources := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			host, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+			sources <- host
+			conn.Close()
+		}
+	}()
+
+	dialFrom := func(t *testing.T, d *sourceIPDialer) string {
+		t.Helper()
+		conn, err := d.DialContext(context.Background(), "tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.Close()
+		return <-sources
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		// Linux routes all of 127.0.0.0/8 to loopback
+		if probe, err := net.Listen("tcp4", "127.0.0.2:0"); err != nil {
+			t.Skipf("127.0.0.2 is not usable: %v", err)
+		} else {
+			probe.Close()
+		}
+
+		d := &sourceIPDialer{sources: []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}}
+		for i, want := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.1"} {
+			if got := dialFrom(t, d); got != want {
+				t.Errorf("Dial %d: expected source %s, got %s", i, want, got)
+			}
+		}
+	})
+
+	t.Run("skips other address family", func(t *testing.T) {
+		d := &sourceIPDialer{sources: []net.IP{net.IPv6loopback, net.ParseIP("127.0.0.1")}}
+		for i := 0; i < 2; i++ {
+			if got := dialFrom(t, d); got != "127.0.0.1" {
+				t.Errorf("Dial %d: expected source 127.0.0.1, got %s", i, got)
+			}
+		}
+	})
+}
+
+func TestDialFromSourcePort(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	ports := make(chan int, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			ports <- conn.RemoteAddr().(*net.TCPAddr).Port
+			conn.Close()
+		}
+	}()
+
+	// freePort returns a port nothing is bound to, keeping it bound when hold is set
+	freePort := func(t *testing.T, hold bool) int {
+		t.Helper()
+		l, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		if hold {
+			t.Cleanup(func() { l.Close() })
+		} else {
+			l.Close()
+		}
+		return l.Addr().(*net.TCPAddr).Port
+	}
+
+	dialFrom := func(t *testing.T, port int) int {
+		t.Helper()
+		o := newOptions([]Option{WithNetwork("tcp4")})
+		conn, err := o.dial(withSourcePort(context.Background(), port), ln.Addr().String())
+		if err != nil {
+			t.Fatalf("Dial failed: %v", err)
+		}
+		conn.Close()
+		return <-ports
+	}
+
+	t.Run("free port", func(t *testing.T) {
+		port := freePort(t, false)
+		if got := dialFrom(t, port); got != port {
+			t.Errorf("Expected source port %d, got %d", port, got)
+		}
+	})
+
+	t.Run("port in use", func(t *testing.T) {
+		port := freePort(t, true)
+		if got := dialFrom(t, port); got == port || got == 0 {
+			t.Errorf("Expected an ephemeral source port instead of %d, got %d", port, got)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// Overflow policies applied when the handshake queue is full
+const (
+	QueueDropNewest = "drop-newest" // Close the arriving connection
+	QueueDropOldest = "drop-oldest" // Close the connection that has waited longest and queue the new one
+	QueueReject     = "reject"      // Like drop-newest, but HTTP clients are told 503 first
+)
+
+// handshakeQueue holds connections waiting for a handshake slot. Waiting is
+// done on the slot channel itself, which serves blocked senders in order;
+// the queue only bounds how many may wait and lets the oldest be evicted.
+type handshakeQueue struct {
+	size   int
+	policy string
+
+	mu      sync.Mutex
+	waiters list.List // Eviction channels, oldest first
+}
+
+// newHandshakeQueue creates a queue holding up to size connections
+func newHandshakeQueue(size int, policy string) *handshakeQueue {
+	return &handshakeQueue{size: size, policy: policy}
+}
+
+// enqueue adds a waiter, applying the overflow policy when the queue is full.
+// The waiter's channel is closed if it is evicted.
+func (q *handshakeQueue) enqueue() (*list.Element, rejectReason) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.waiters.Len() >= q.size {
+		if q.policy != QueueDropOldest {
+			return nil, rejectQueueFull
+		}
+		oldest := q.waiters.Front()
+		q.waiters.Remove(oldest)
+		close(oldest.Value.(chan struct{}))
+	}
+
+	return q.waiters.PushBack(make(chan struct{})), rejectNone
+}
+
+// remove takes a waiter off the queue; removing an evicted waiter is a no-op
+func (q *handshakeQueue) remove(e *list.Element) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.waiters.Remove(e)
+}
+
+// Len returns the number of waiting connections
+func (q *handshakeQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.waiters.Len()
+}
+
+// acquireHandshake reserves a handshake slot. Without a queue it never blocks
+// and reports rejectMaxHandshakes when every slot is taken; with one it waits
+// in line until a slot frees, the connection is evicted or ctx ends. release
+// frees the slot and is safe to call more than once.
+func (o *options) acquireHandshake(ctx context.Context) (release func(), reason rejectReason) {
+	if o.handshakes == nil {
+		return func() {}, rejectNone
+	}
+
+	select {
+	case o.handshakes <- struct{}{}:
+		return o.handshakeRelease(), rejectNone
+	default:
+	}
+
+	if o.handshakeQueue == nil {
+		return nil, rejectMaxHandshakes
+	}
+
+	waiter, reason := o.handshakeQueue.enqueue()
+	if reason != rejectNone {
+		return nil, reason
+	}
+	defer o.handshakeQueue.remove(waiter)
+
+	select {
+	case o.handshakes <- struct{}{}:
+		return o.handshakeRelease(), rejectNone
+	case <-waiter.Value.(chan struct{}):
+		return nil, rejectQueueEvicted
+	case <-ctx.Done():
+		return nil, rejectQueueTimeout
+	}
+}
+
+// handshakeRelease returns a function freeing one handshake slot once
+func (o *options) handshakeRelease() func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() { <-o.handshakes })
+	}
+}
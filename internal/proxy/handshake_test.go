@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAcquireHandshake(t *testing.T) {
+	o := newOptions([]Option{WithMaxHandshakes(1)})
+
+	release, reason := o.acquireHandshake(context.Background())
+	if reason != rejectNone {
+		t.Fatal("First handshake should acquire a slot")
+	}
+	if _, reason := o.acquireHandshake(context.Background()); reason != rejectMaxHandshakes {
+		t.Fatal("Second handshake should be rejected while the slot is taken")
+	}
+
+	release()
+	release() // Releasing twice must not free a second slot
+
+	if _, reason := o.acquireHandshake(context.Background()); reason != rejectNone {
+		t.Fatal("Handshake should acquire the released slot")
+	}
+	if _, reason := o.acquireHandshake(context.Background()); reason != rejectMaxHandshakes {
+		t.Error("Double release should not have freed an extra slot")
+	}
+}
+
+func TestSOCKS5Proxy_MaxHandshakes(t *testing.T) {
+	s := NewSOCKS5Proxy(0, WithMaxHandshakes(1))
+
+	// A client that connects and stalls holds the only slot
+	staller, stallerServer := net.Pipe()
+	defer staller.Close()
+	go s.handleConnection(stallerServer)
+
+	// Wait for the staller to take the slot
+	deadline := time.Now().Add(time.Second)
+	for len(s.handshakes) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		s.handleConnection(server)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Over-limit connection should be closed without a handshake")
+	}
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected closed connection, got %v", err)
+	}
+}
+
+func TestAcquireHandshake_Queue(t *testing.T) {
+	// queued starts a connection waiting for a slot and returns its outcome
+	queued := func(t *testing.T, o *options, ctx context.Context) <-chan rejectReason {
+		t.Helper()
+		waiting := o.handshakeQueue.Len()
+		result := make(chan rejectReason, 1)
+		go func() {
+			release, reason := o.acquireHandshake(ctx)
+			if release != nil {
+				defer release()
+			}
+			result <- reason
+		}()
+
+		deadline := time.Now().Add(time.Second)
+		for o.handshakeQueue.Len() == waiting && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		return result
+	}
+
+	expect := func(t *testing.T, result <-chan rejectReason, want rejectReason) {
+		t.Helper()
+		select {
+		case got := <-result:
+			if got != want {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected %q, still waiting", want)
+		}
+	}
+
+	t.Run("drop newest", func(t *testing.T) {
+		o := newOptions([]Option{WithMaxHandshakes(1), WithHandshakeQueue(1, QueueDropNewest)})
+		release, _ := o.acquireHandshake(context.Background())
+
+		first := queued(t, &o, context.Background())
+		if _, reason := o.acquireHandshake(context.Background()); reason != rejectQueueFull {
+			t.Errorf("Expected the newest connection to be turned away, got %q", reason)
+		}
+
+		release()
+		expect(t, first, rejectNone)
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		o := newOptions([]Option{WithMaxHandshakes(1), WithHandshakeQueue(1, QueueDropOldest)})
+		release, _ := o.acquireHandshake(context.Background())
+
+		first := queued(t, &o, context.Background())
+		second := make(chan rejectReason, 1)
+		go func() {
+			_, reason := o.acquireHandshake(context.Background())
+			second <- reason
+		}()
+		expect(t, first, rejectQueueEvicted)
+
+		release()
+		expect(t, second, rejectNone)
+	})
+
+	t.Run("connection ends while queued", func(t *testing.T) {
+		o := newOptions([]Option{WithMaxHandshakes(1), WithHandshakeQueue(1, QueueReject)})
+		o.acquireHandshake(context.Background())
+
+		ctx, cancel := context.WithCancel(context.Background())
+		result := queued(t, &o, ctx)
+		cancel()
+		expect(t, result, rejectQueueTimeout)
+
+		if n := o.handshakeQueue.Len(); n != 0 {
+			t.Errorf("Expected an empty queue, got %d waiting", n)
+		}
+	})
+}
+
+func TestHTTPProxy_HandshakeQueueReject(t *testing.T) {
+	h := newTestHTTPProxy(WithMaxHandshakes(1), WithHandshakeQueue(1, QueueReject))
+
+	// Fill the only slot and the only queue place
+	h.acquireHandshake(context.Background())
+	go h.acquireHandshake(context.Background())
+	deadline := time.Now().Add(time.Second)
+	for h.handshakeQueue.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	resp := roundTrip(t, h, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", resp.StatusCode)
+	}
+}
@@ -2,148 +2,326 @@ package proxy
 
 import (
 	"bufio"
+	"context"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/seakee/dudu-proxy/internal/metrics"
 	"github.com/seakee/dudu-proxy/internal/middleware"
 	"github.com/seakee/dudu-proxy/pkg/logger"
 )
 
+// errResponseTooLarge is returned when a response exceeds the configured maximum size
+var errResponseTooLarge = errors.New("response exceeds the maximum size")
+
 // HTTPProxy represents an HTTP proxy server
 type HTTPProxy struct {
-	port           int
-	network        string // 网络类型: "tcp", "tcp4", "tcp6"
-	auth           *middleware.AuthMiddleware
-	rateLimit      *middleware.RateLimitMiddleware
-	ipBan          *middleware.IPBanMiddleware
-	circuitBreaker *middleware.CircuitBreakerMiddleware
+	port int
+	options
+	pauseSwitch
+
+	ctx    context.Context // Canceled by Stop to abort in-flight connections
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	listeners []net.Listener
 }
 
 // NewHTTPProxy creates a new HTTP proxy
-func NewHTTPProxy(
-	port int,
-	network string,
-	auth *middleware.AuthMiddleware,
-	rateLimit *middleware.RateLimitMiddleware,
-	ipBan *middleware.IPBanMiddleware,
-	circuitBreaker *middleware.CircuitBreakerMiddleware,
-) *HTTPProxy {
+func NewHTTPProxy(port int, opts ...Option) *HTTPProxy {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &HTTPProxy{
-		port:           port,
-		network:        network,
-		auth:           auth,
-		rateLimit:      rateLimit,
-		ipBan:          ipBan,
-		circuitBreaker: circuitBreaker,
+		port:    port,
+		options: newOptions(opts),
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 }
 
-// Start starts the HTTP proxy server
+// Start starts the HTTP proxy server on every listen address
 func (h *HTTPProxy) Start() error {
-	listener, err := net.Listen(h.network, fmt.Sprintf(":%d", h.port))
+	listeners, err := h.openListeners(h.port)
 	if err != nil {
 		return fmt.Errorf("failed to start HTTP proxy: %w", err)
 	}
 
-	logger.Info("HTTP proxy server started", "port", h.port, "network", h.network)
+	h.mu.Lock()
+	h.listeners = listeners
+	h.mu.Unlock()
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			logger.Error("Failed to accept connection", "error", err)
-			continue
-		}
-
-		go h.handleConnection(conn)
+	for _, listener := range listeners {
+		logger.Info("HTTP proxy server started", "address", listener.Addr().String(), "network", h.network, "tls", h.tlsConfig != nil, "inherited", len(h.inherited) > 0)
 	}
+
+	return serveAll(listeners, "http", h.handleConnection)
+}
+
+// Stop closes every listener, which makes Start return, and aborts in-flight connections
+func (h *HTTPProxy) Stop() error {
+	h.cancel()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return closeListeners(h.listeners)
+}
+
+// Addrs returns the addresses the proxy is listening on
+func (h *HTTPProxy) Addrs() []net.Addr {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return listenerAddrs(h.listeners)
 }
 
 // handleConnection handles a single client connection
 func (h *HTTPProxy) handleConnection(clientConn net.Conn) {
 	defer clientConn.Close()
 
+	timer := newSetupTimer("http")
+	ctx, cancel := h.connContext(withSetupTimer(h.ctx, timer))
+	defer cancel()
+
 	clientIP := middleware.GetClientIP(clientConn)
+	remotePort := h.loggedClientPort(clientConn)
+	connID := nextConnID()
 
-	// Check circuit breaker
-	if h.circuitBreaker.IsOpen() {
-		logger.Warn("Request rejected: circuit breaker is open",
-			"client_ip", clientIP,
-			"circuit_state", h.circuitBreaker.GetState().String())
-		h.sendError(clientConn, http.StatusServiceUnavailable, "Service temporarily unavailable")
+	h.debugLog(clientIP)("Connection accepted", withClientPort([]interface{}{
+		"protocol", "http",
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"local_addr", clientConn.LocalAddr().String(),
+	}, remotePort)...)
+
+	if h.Paused() {
+		h.logRejection("http", connID, clientIP, remotePort, rejectPaused)
 		return
 	}
 
-	// Check IP ban
-	if h.ipBan.IsBlocked(clientIP) {
-		logger.Warn("Request rejected: IP is banned", "client_ip", clientIP)
-		h.sendError(clientConn, http.StatusForbidden, "Access denied")
+	// Check circuit breaker, IP ban and rate limit
+	if reason := h.admit(clientIP); reason != rejectNone {
+		h.logRejection("http", connID, clientIP, remotePort, reason)
+		switch reason {
+		case rejectCircuitBreaker:
+			h.sendError(clientConn, connID, http.StatusServiceUnavailable, "Service temporarily unavailable")
+		case rejectIPBan:
+			h.sendError(clientConn, connID, http.StatusForbidden, "Access denied")
+		default:
+			h.sendError(clientConn, connID, http.StatusTooManyRequests, "Too many requests")
+		}
+		h.resetOnReject(clientConn, reason)
 		return
 	}
 
-	// Check rate limit
-	if !h.rateLimit.Allow(clientIP) {
-		logger.Warn("Request rejected: rate limit exceeded", "client_ip", clientIP)
-		h.sendError(clientConn, http.StatusTooManyRequests, "Too many requests")
+	// Bound connections still in the request phase
+	release, reason := h.acquireHandshake(ctx)
+	if reason != rejectNone {
+		h.logRejection("http", connID, clientIP, remotePort, reason)
+		if reason == rejectQueueFull && h.handshakeQueue.policy == QueueReject {
+			h.sendError(clientConn, connID, http.StatusServiceUnavailable, "Service temporarily unavailable")
+		}
 		return
 	}
+	defer release()
 
-	// Read the request
-	reader := bufio.NewReader(clientConn)
-	req, err := http.ReadRequest(reader)
-	if err != nil {
-		logger.Error("Failed to read request", "client_ip", clientIP, "error", err)
+	if err := h.tlsHandshake(ctx, clientConn, "http", connID, clientIP); err != nil {
 		return
 	}
 
-	// Handle authentication
-	if h.auth.IsEnabled() {
-		username, password, ok := h.parseProxyAuth(req)
-		if !ok || !h.auth.Authenticate(username, password) {
-			logger.Warn("Authentication failed",
+	reader := bufio.NewReader(clientConn)
+	var req *http.Request
+	username := h.anonymousUser
+	var expires time.Time
+
+	// A client answering a 407 may retry on the same connection, up to the
+	// configured number of attempts
+	for attempt := 1; ; attempt++ {
+		var ok bool
+		req, ok = h.readRequest(clientConn, reader, clientIP)
+		if !ok {
+			return
+		}
+		if attempt == 1 {
+			timer.mark(phaseHandshake)
+		}
+
+		// A forward proxy expects CONNECT or absolute-form ("GET http://host/path").
+		// Origin-form requests are aimed at the proxy itself unless running transparently.
+		if !isProxyRequest(req) && !h.transparent {
+			h.debugLog(clientIP)("Non-proxy request received",
 				"client_ip", clientIP,
-				"username", username)
+				"method", req.Method,
+				"path", req.URL.Path)
+			h.sendResponse(clientConn, response{status: h.landingStatus, body: h.landingBody})
+			return
+		}
 
+		if !h.auth.IsEnabled() {
+			break
+		}
+
+		username, expires, ok = h.authenticate(req)
+		if ok {
+			break
+		}
+
+		logger.Warn("Authentication failed",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"username", username,
+			"attempt", attempt)
+
+		h.ipBan.RecordAuthFailure(clientIP)
+		h.circuitBreaker.RecordAuthFailure(clientIP)
+		h.publishAuthFailure("http", connID, clientIP, username)
+		// A request without credentials is the normal start of the challenge
+		if req.Header.Get("Proxy-Authorization") != "" {
+			h.authDelay.Fail(ctx, clientIP)
+		}
+
+		// Retrying needs the rejected request fully read, so only one without a
+		// body allows it, and an IP banned by the failures gets no more tries
+		retry := attempt < h.maxAuthAttempts && req.Body == http.NoBody && !req.Close && !h.ipBan.IsBlocked(clientIP)
+		if attempt >= h.maxAuthAttempts && h.maxAuthAttempts > 1 {
+			logger.WarnSampled("Authentication attempts exhausted on connection",
+				"conn_id", connID,
+				"client_ip", clientIP,
+				"attempts", attempt)
+			metrics.Default.Counter("dudu_auth_attempts_exhausted_total", "Connections closed for exceeding the authentication attempts allowed per connection",
+				"protocol", "http").Inc()
+		}
+		h.sendProxyAuthRequired(clientConn, connID, !retry)
+		if !retry {
+			return
+		}
+	}
+
+	if h.auth.IsEnabled() {
+		if !h.certUsernameMatches(clientConn, "http", connID, clientIP, username) {
 			h.ipBan.RecordAuthFailure(clientIP)
-			h.circuitBreaker.RecordAuthFailure()
-			h.sendProxyAuthRequired(clientConn)
+			h.circuitBreaker.RecordAuthFailure(clientIP)
+			h.publishAuthFailure("http", connID, clientIP, username)
+			h.sendError(clientConn, connID, http.StatusForbidden, "Access denied")
 			return
 		}
 
-		logger.Debug("Authentication successful",
+		h.debugLog(clientIP)("Authentication successful",
+			"conn_id", connID,
 			"client_ip", clientIP,
 			"username", username)
 
 		h.ipBan.RecordAuthSuccess(clientIP)
 		h.circuitBreaker.RecordAuthSuccess()
+		h.authDelay.Succeed(clientIP)
+		timer.mark(phaseAuth)
+
+		// The connection lives no longer than the token it authenticated with
+		if !expires.IsZero() {
+			var cancelExpiry context.CancelFunc
+			ctx, cancelExpiry = context.WithDeadlineCause(ctx, expires, errCredentialExpired)
+			defer cancelExpiry()
+		}
+	}
+
+	// The request phase is complete
+	release()
+
+	releaseUser, ok := h.acquireUserConn("http", connID, clientIP, username)
+	if !ok {
+		h.sendError(clientConn, connID, http.StatusTooManyRequests, "Too many connections for user")
+		return
+	}
+	defer releaseUser()
+
+	releaseAnonymous, ok := h.acquireAnonymous("http", connID, clientIP)
+	if !ok {
+		h.sendError(clientConn, connID, http.StatusTooManyRequests, "Too many anonymous connections")
+		return
 	}
+	defer releaseAnonymous()
 
 	// Handle CONNECT method (for HTTPS)
 	if req.Method == http.MethodConnect {
-		h.handleConnect(clientConn, req, clientIP)
+		h.handleConnect(ctx, clientConn, reader, req, connID, clientIP, username)
 	} else {
 		// Handle regular HTTP request
-		h.handleHTTP(clientConn, req, clientIP)
+		h.handleHTTP(ctx, clientConn, req, connID, clientIP, username)
+	}
+}
+
+// readRequest reads the next request from reader, bounding the read with the
+// auth timeout when auth is enabled since credentials arrive with it. It
+// reports false when no request could be read.
+func (h *HTTPProxy) readRequest(clientConn net.Conn, reader *bufio.Reader, clientIP string) (*http.Request, bool) {
+	authDeadline := h.auth.IsEnabled() && h.authTimeout > 0
+	if authDeadline {
+		clientConn.SetReadDeadline(time.Now().Add(h.authTimeout))
+	}
+
+	req, err := http.ReadRequest(reader)
+	if authDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+		logger.Warn("Authentication timed out", "client_ip", clientIP)
+		h.ipBan.RecordAuthFailure(clientIP)
+		return nil, false
+	}
+	if err != nil {
+		logger.Error("Failed to read request", "client_ip", clientIP, "error", err)
+		return nil, false
+	}
+	if authDeadline {
+		clientConn.SetReadDeadline(time.Time{})
 	}
+	return req, true
 }
 
-// handleConnect handles HTTPS CONNECT requests
-func (h *HTTPProxy) handleConnect(clientConn net.Conn, req *http.Request, clientIP string) {
+// handleConnect handles HTTPS CONNECT requests. reader is the one the request
+// was read through, which may hold data the client sent right after it.
+func (h *HTTPProxy) handleConnect(ctx context.Context, clientConn net.Conn, reader *bufio.Reader, req *http.Request, connID uint64, clientIP, username string) {
+	if h.scanDetect.RecordTarget(clientIP, req.Host) {
+		logger.WarnSampled("Request rejected: scanning detected", "client_ip", clientIP, "target", req.Host)
+		h.sendError(clientConn, connID, http.StatusForbidden, "Access denied")
+		return
+	}
+
+	category := h.targetCategory.Categorize(req.Host)
+
+	releaseTarget, ok := h.acquireTargetConn("http", connID, clientIP, req.Host)
+	if !ok {
+		h.sendError(clientConn, connID, http.StatusServiceUnavailable, "Too many connections to target")
+		return
+	}
+	defer releaseTarget()
+
 	// Connect to the target server
-	targetConn, err := net.DialTimeout(h.network, req.Host, 10*time.Second)
+	targetConn, err := h.connectTarget(ctx, req.Host, clientConn)
+	if errors.Is(err, errTargetForbidden) {
+		logDialFailure("http", connID, clientIP, req.Host, category, err)
+		h.sendError(clientConn, connID, http.StatusForbidden, "Access denied")
+		return
+	}
 	if err != nil {
-		logger.Error("Failed to connect to target",
-			"client_ip", clientIP,
-			"target", req.Host,
-			"error", err)
-		h.sendError(clientConn, http.StatusBadGateway, "Failed to connect to target")
+		logDialFailure("http", connID, clientIP, req.Host, category, err)
+		h.sendResponse(clientConn, h.connectFailure)
 		return
 	}
 	defer targetConn.Close()
+	timer := setupTimerFrom(ctx)
+	timer.mark(phaseDial)
+
+	ctx, tracked, done := h.track(ctx, targetConn, connID, "http", clientIP, username, req.Host)
+	defer done()
 
 	// Send 200 Connection Established
 	_, err = clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
@@ -151,81 +329,275 @@ func (h *HTTPProxy) handleConnect(clientConn net.Conn, req *http.Request, client
 		logger.Error("Failed to send response", "client_ip", clientIP, "error", err)
 		return
 	}
+	timer.mark(phaseEstablished)
+	timer.log(h.debugLog(clientIP), connID, clientIP)
 
-	logger.Info("HTTPS tunnel established",
+	logger.Info("HTTPS tunnel established", withCategory([]interface{}{
 		"client_ip", clientIP,
-		"target", req.Host)
+		"username", username,
+		"target", req.Host,
+		"resolved", resolvedAddr(targetConn),
+	}, category)...)
+	countCategory("http", category)
+
+	// Clients that start TLS without waiting for the 200 have their first bytes
+	// in reader; only wrap then, so plain connections keep io.Copy's fast paths
+	client := clientConn
+	if reader.Buffered() > 0 {
+		client = &bufferedConn{Conn: clientConn, reader: reader}
+	}
+
+	var reason closeReason
+	if h.interceptor != nil && h.interceptor.intercepts(req.Host) {
+		reason = h.intercept(ctx, client, &streamConn{Conn: targetConn, stream: tracked}, req.Host, connID, clientIP)
+	} else {
+		// Bidirectional copy
+		reason = transfer(ctx, h.withClientTimeouts(client), tracked)
+	}
+	logTunnelClose("http", connID, clientIP, h.loggedClientPort(clientConn), req.Host, category, reason, done())
+}
 
-	// Bidirectional copy
-	h.transfer(clientConn, targetConn)
+// bufferedConn reads a client connection through the reader its request was
+// read with, so bytes buffered past the request are not lost
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads buffered bytes first, then from the connection
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
 }
 
 // handleHTTP handles regular HTTP requests
-func (h *HTTPProxy) handleHTTP(clientConn net.Conn, req *http.Request, clientIP string) {
+func (h *HTTPProxy) handleHTTP(ctx context.Context, clientConn io.ReadWriteCloser, req *http.Request, connID uint64, clientIP, username string) {
+	if h.connectOnly {
+		logger.WarnSampled("Request rejected: plain HTTP not allowed",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"method", req.Method,
+			"host", req.Host)
+		h.sendError(clientConn, connID, http.StatusForbidden, "Plain HTTP is not allowed through this proxy, use CONNECT")
+		return
+	}
+
 	// Remove proxy-specific headers
 	req.Header.Del("Proxy-Authorization")
 	req.Header.Del("Proxy-Connection")
 
-	// Determine target address
-	// For HTTP requests, req.Host might not include port, we need to add default port 80
-	targetAddr := req.Host
-	if !strings.Contains(targetAddr, ":") {
-		targetAddr = net.JoinHostPort(targetAddr, "80")
+	targetAddr, err := httpTarget(req)
+	if err != nil {
+		logger.WarnSampled("Request rejected: invalid target host",
+			"client_ip", clientIP,
+			"host", req.Host,
+			"error", err)
+		h.sendError(clientConn, connID, http.StatusBadRequest, "Bad Request: "+err.Error())
+		return
 	}
 
+	if h.scanDetect.RecordTarget(clientIP, targetAddr) {
+		logger.WarnSampled("Request rejected: scanning detected", "client_ip", clientIP, "target", targetAddr)
+		h.sendError(clientConn, connID, http.StatusForbidden, "Access denied")
+		return
+	}
+
+	category := h.targetCategory.Categorize(targetAddr)
+
+	releaseTarget, ok := h.acquireTargetConn("http", connID, clientIP, targetAddr)
+	if !ok {
+		h.sendError(clientConn, connID, http.StatusServiceUnavailable, "Too many connections to target")
+		return
+	}
+	defer releaseTarget()
+
 	// Connect to the target server
-	targetConn, err := net.DialTimeout(h.network, targetAddr, 10*time.Second)
+	targetConn, err := h.connectTarget(ctx, targetAddr, clientConn)
+	if errors.Is(err, errTargetForbidden) {
+		logDialFailure("http", connID, clientIP, targetAddr, category, err)
+		h.sendError(clientConn, connID, http.StatusForbidden, "Access denied")
+		return
+	}
 	if err != nil {
-		logger.Error("Failed to connect to target",
-			"client_ip", clientIP,
-			"target", targetAddr,
-			"error", err)
-		h.sendError(clientConn, http.StatusBadGateway, "Failed to connect to target")
+		logDialFailure("http", connID, clientIP, targetAddr, category, err)
+		h.sendError(clientConn, connID, http.StatusBadGateway, "Failed to connect to target")
 		return
 	}
 	defer targetConn.Close()
+	timer := setupTimerFrom(ctx)
+	timer.mark(phaseDial)
+
+	resolved := resolvedAddr(targetConn)
+	ctx, tracked, done := h.track(ctx, targetConn, connID, "http", clientIP, username, targetAddr)
+	defer done()
+
+	// Abort the exchange when the connection's context ends
+	stop := context.AfterFunc(ctx, func() { targetConn.Close() })
+	defer stop()
 
 	// Write the request to the target
-	if err := req.Write(targetConn); err != nil {
+	if err := req.Write(tracked); err != nil {
 		logger.Error("Failed to send request to target",
 			"client_ip", clientIP,
 			"target", targetAddr,
 			"error", err)
 		return
 	}
+	timer.mark(phaseEstablished)
+	timer.log(h.debugLog(clientIP), connID, clientIP)
 
-	logger.Info("HTTP request proxied",
+	loggedURL := req.URL.String()
+	if !h.logFullURL {
+		h.debugLog(clientIP)("HTTP request URL", "client_ip", clientIP, "url", loggedURL)
+		loggedURL = originURL(req)
+	}
+	logger.Info("HTTP request proxied", withCategory(withClientPort([]interface{}{
 		"client_ip", clientIP,
+		"username", username,
 		"method", req.Method,
-		"url", req.URL.String())
+		"url", loggedURL,
+		"resolved", resolved,
+	}, h.loggedClientPort(clientConn)), category)...)
+	countCategory("http", category)
+
+	var response io.Reader = tracked
+	if h.maxResponseBytes > 0 {
+		response = &cappedReader{r: tracked, remaining: h.maxResponseBytes}
+	}
+
+	// Compress the response for the client when enabled
+	if h.compress {
+		err = relayCompressed(clientConn, response, req)
+	} else {
+		_, err = io.Copy(clientConn, response)
+	}
 
-	// Copy response back to client
-	_, err = io.Copy(clientConn, targetConn)
-	if err != nil && err != io.EOF {
-		logger.Debug("Error copying response",
+	switch {
+	case errors.Is(err, errResponseTooLarge):
+		logger.Warn("HTTP response truncated",
+			"client_ip", clientIP,
+			"target", targetAddr,
+			"max_response_bytes", h.maxResponseBytes)
+	case err != nil && err != io.EOF:
+		h.debugLog(clientIP)("Error relaying response",
 			"client_ip", clientIP,
 			"error", err)
 	}
 }
 
-// transfer bidirectionally copies data between two connections
-func (h *HTTPProxy) transfer(conn1, conn2 net.Conn) {
-	done := make(chan struct{}, 2)
+// cappedReader reads at most remaining bytes from r, then fails with
+// errResponseTooLarge if r has more to give
+type cappedReader struct {
+	r         io.Reader
+	remaining int64
+}
 
-	go func() {
-		io.Copy(conn1, conn2)
-		done <- struct{}{}
-	}()
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.remaining <= 0 {
+		// Only an exhausted source may end exactly at the limit
+		var probe [1]byte
+		if n, err := c.r.Read(probe[:]); n == 0 && err != nil {
+			return 0, err
+		}
+		return 0, errResponseTooLarge
+	}
 
-	go func() {
-		io.Copy(conn2, conn1)
-		done <- struct{}{}
-	}()
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	return n, err
+}
+
+// isProxyRequest reports whether req is a CONNECT or an absolute-form request
+func isProxyRequest(req *http.Request) bool {
+	return req.Method == http.MethodConnect || req.URL.IsAbs()
+}
 
-	<-done
+// originURL returns the scheme and host of req's target, without the path
+// and query string
+func originURL(req *http.Request) string {
+	scheme, host := req.URL.Scheme, req.URL.Host
+	if scheme == "" {
+		scheme = "http"
+	}
+	if host == "" {
+		host = req.Host
+	}
+	return scheme + "://" + host
 }
 
-// parseProxyAuth parses the Proxy-Authorization header
+// httpTarget returns the host:port a plain HTTP request is forwarded to, taken
+// from the absolute-form URL or else the Host header, with port 80 when none
+// is given. Empty or malformed hosts are rejected before anything is dialed.
+func httpTarget(req *http.Request) (string, error) {
+	hostport := req.URL.Host
+	if hostport == "" {
+		hostport = req.Host
+	}
+	if hostport == "" {
+		return "", fmt.Errorf("missing target host")
+	}
+
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		// No port: strip the brackets of an IPv6 literal and use the default
+		host, port = hostport, "80"
+		if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+			host = host[1 : len(host)-1]
+		}
+	}
+
+	if !validTargetHost(host) {
+		return "", fmt.Errorf("invalid target host %q", hostport)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return "", fmt.Errorf("invalid target port in %q", hostport)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// validTargetHost reports whether host is an IP literal or a hostname made of
+// letters, digits, hyphens, underscores and dots
+func validTargetHost(host string) bool {
+	if host == "" {
+		return false
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return true
+	}
+	for _, c := range host {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// authenticate checks the Proxy-Authorization header, choosing Bearer or Basic
+// by its scheme, and returns the authenticated username
+func (h *HTTPProxy) authenticate(req *http.Request) (username string, expires time.Time, ok bool) {
+	if token, isBearer := h.parseBearerToken(req); isBearer {
+		return h.auth.AuthenticateTokenUntil(token)
+	}
+
+	username, password, ok := h.parseProxyAuth(req)
+	if !ok {
+		return username, time.Time{}, false
+	}
+
+	return username, time.Time{}, h.auth.Authenticate(username, password)
+}
+
+// parseBearerToken returns the token from a Bearer Proxy-Authorization header
+func (h *HTTPProxy) parseBearerToken(req *http.Request) (token string, ok bool) {
+	return strings.CutPrefix(req.Header.Get("Proxy-Authorization"), "Bearer ")
+}
+
+// parseProxyAuth parses a Basic Proxy-Authorization header
 func (h *HTTPProxy) parseProxyAuth(req *http.Request) (username, password string, ok bool) {
 	auth := req.Header.Get("Proxy-Authorization")
 	if auth == "" {
@@ -250,22 +622,95 @@ func (h *HTTPProxy) parseProxyAuth(req *http.Request) (username, password string
 	return credentials[0], credentials[1], true
 }
 
-// sendProxyAuthRequired sends a 407 Proxy Authentication Required response
-func (h *HTTPProxy) sendProxyAuthRequired(conn net.Conn) {
-	response := "HTTP/1.1 407 Proxy Authentication Required\r\n" +
-		"Proxy-Authenticate: Basic realm=\"DuDu Proxy\"\r\n" +
-		"Content-Length: 0\r\n" +
-		"\r\n"
-	conn.Write([]byte(response))
+// realmEscaper escapes a realm for use in a quoted-string
+var realmEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// sendProxyAuthRequired sends a 407 Proxy Authentication Required response,
+// announcing that the connection closes unless the client may retry on it
+func (h *HTTPProxy) sendProxyAuthRequired(conn io.Writer, connID uint64, closing bool) {
+	resp := h.errorResponse(connID, http.StatusProxyAuthRequired, "Proxy authentication required")
+	resp.headers = maps.Clone(resp.headers)
+	if resp.headers == nil {
+		resp.headers = make(map[string]string)
+	}
+	resp.headers["Proxy-Authenticate"] = "Basic realm=\"" + realmEscaper.Replace(h.realm) + "\""
+	resp.keepAlive = !closing
+	h.sendResponse(conn, resp)
+}
+
+// response is a complete response the proxy generates itself
+type response struct {
+	status    int
+	body      string
+	headers   map[string]string // Extra headers; a Content-Type replaces text/plain
+	keepAlive bool              // The connection stays open after it, otherwise it announces Connection: close
+}
+
+// sendError sends an error response, as JSON when JSON errors are enabled.
+// connID identifies the connection in JSON bodies and in the logs.
+func (h *HTTPProxy) sendError(conn io.Writer, connID uint64, statusCode int, message string) {
+	h.sendResponse(conn, h.errorResponse(connID, statusCode, message))
+}
+
+// errorResponse returns an error response with message as its body, as JSON
+// when JSON errors are enabled
+func (h *HTTPProxy) errorResponse(connID uint64, statusCode int, message string) response {
+	if h.jsonErrors {
+		return h.jsonError(connID, statusCode, message)
+	}
+	return response{status: statusCode, body: message}
+}
+
+// jsonError returns an error response with a body of the form
+// {"error":{"code":403,"message":"Access denied","request_id":42}}
+func (h *HTTPProxy) jsonError(connID uint64, statusCode int, message string) response {
+	type errorBody struct {
+		Code      int    `json:"code"`
+		Message   string `json:"message"`
+		RequestID uint64 `json:"request_id"`
+	}
+	body, _ := json.Marshal(map[string]errorBody{
+		"error": {Code: statusCode, Message: message, RequestID: connID},
+	})
+	return response{
+		status:  statusCode,
+		body:    string(body),
+		headers: map[string]string{"Content-Type": "application/json"},
+	}
+}
+
+// sendResponse sends resp with its body framed by Content-Length, so clients
+// can parse it whatever its status. Every generated response goes through it,
+// so the framing headers are set last and cannot be overridden.
+func (h *HTTPProxy) sendResponse(conn io.Writer, resp response) {
+	header := http.Header{
+		"Content-Type": {"text/plain"},
+		"Date":         {time.Now().UTC().Format(http.TimeFormat)},
+	}
+	for name, value := range resp.headers {
+		header.Set(name, value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(resp.body)))
+	if resp.keepAlive {
+		header.Del("Connection")
+	} else {
+		header.Set("Connection", "close")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "HTTP/1.1 %d %s\r\n", resp.status, http.StatusText(resp.status))
+	b.WriteString(h.serverHeaderLine())
+	header.Write(&b)
+	b.WriteString("\r\n")
+	b.WriteString(resp.body)
+	conn.Write([]byte(b.String()))
 }
 
-// sendError sends an error response
-func (h *HTTPProxy) sendError(conn net.Conn, statusCode int, message string) {
-	response := fmt.Sprintf("HTTP/1.1 %d %s\r\n"+
-		"Content-Type: text/plain\r\n"+
-		"Content-Length: %d\r\n"+
-		"\r\n"+
-		"%s",
-		statusCode, http.StatusText(statusCode), len(message), message)
-	conn.Write([]byte(response))
+// serverHeaderLine returns the Server header line for generated responses, or
+// nothing when none is configured
+func (h *HTTPProxy) serverHeaderLine() string {
+	if h.serverHeader == "" {
+		return ""
+	}
+	return "Server: " + h.serverHeader + "\r\n"
 }
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// httpDialer connects to targets through an upstream HTTP proxy using CONNECT
+type httpDialer struct {
+	address  string // Upstream host:port
+	username string // Empty sends no Proxy-Authorization header
+	password string
+	forward  Dialer // Connects to the upstream itself
+}
+
+// DialContext opens a tunnel to address through the upstream. The CONNECT
+// exchange is bounded by ctx; the returned connection carries no deadline.
+func (d *httpDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to HTTP upstream %s: %w", d.address, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock the exchange when ctx is canceled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	tunnel, err := httpConnect(conn, address, d.username, d.password)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("HTTP upstream %s: %w", d.address, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return tunnel, nil
+}
+
+// httpConnect sends a CONNECT for target over conn, with Basic credentials when
+// username is set, and returns the tunneled stream once the upstream accepts it
+func httpConnect(conn net.Conn, target, username, password string) (net.Conn, error) {
+	if _, _, err := net.SplitHostPort(target); err != nil || strings.ContainsAny(target, " \r\n") {
+		return nil, fmt.Errorf("invalid target: %s", target)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n", target, target)
+	if username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		fmt.Fprintf(&b, "Proxy-Authorization: Basic %s\r\n", credentials)
+	}
+	b.WriteString("\r\n")
+	if _, err := conn.Write([]byte(b.String())); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusProxyAuthRequired {
+		return nil, errors.New("authentication rejected")
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s failed with status %s", target, resp.Status)
+	}
+
+	// Keep tunnel bytes that arrived along with the response
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func TestHTTPProxy_ChainedUpstream(t *testing.T) {
+	cert := newTestCert(t, "proxy.test")
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	trusted := x509.NewCertPool()
+	trusted.AddCert(leaf)
+
+	serverTLS, err := NewTLSConfig([]tls.Certificate{cert})
+	if err != nil {
+		t.Fatalf("Failed to build TLS config: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		upstream  func(dialer Dialer, opts ...Option) prober
+		option    func(address string, tlsConfig *tls.Config) Option
+		serveTLS  bool
+		clientTLS *tls.Config
+		wantOK    bool
+	}{
+		{
+			name:     "http",
+			upstream: func(d Dialer, opts ...Option) prober { return NewHTTPProxy(0, append(opts, WithDialer(d))...) },
+			option: func(address string, tlsConfig *tls.Config) Option {
+				return WithHTTPUpstream(address, "bridge", "secret", tlsConfig)
+			},
+			wantOK: true,
+		},
+		{
+			name:     "http with wrong credentials",
+			upstream: func(d Dialer, opts ...Option) prober { return NewHTTPProxy(0, append(opts, WithDialer(d))...) },
+			option: func(address string, tlsConfig *tls.Config) Option {
+				return WithHTTPUpstream(address, "bridge", "wrong", tlsConfig)
+			},
+			wantOK: false,
+		},
+		{
+			name:     "https",
+			upstream: func(d Dialer, opts ...Option) prober { return NewHTTPProxy(0, append(opts, WithDialer(d))...) },
+			option: func(address string, tlsConfig *tls.Config) Option {
+				return WithHTTPUpstream(address, "bridge", "secret", tlsConfig)
+			},
+			serveTLS:  true,
+			clientTLS: &tls.Config{RootCAs: trusted, ServerName: "proxy.test"},
+			wantOK:    true,
+		},
+		{
+			name:     "socks5+tls",
+			upstream: func(d Dialer, opts ...Option) prober { return NewSOCKS5Proxy(0, append(opts, WithDialer(d))...) },
+			option: func(address string, tlsConfig *tls.Config) Option {
+				return WithSOCKS5Upstream(address, "bridge", "secret", tlsConfig)
+			},
+			serveTLS:  true,
+			clientTLS: &tls.Config{RootCAs: trusted, ServerName: "proxy.test"},
+			wantOK:    true,
+		},
+		{
+			name:     "untrusted upstream certificate",
+			upstream: func(d Dialer, opts ...Option) prober { return NewSOCKS5Proxy(0, append(opts, WithDialer(d))...) },
+			option: func(address string, tlsConfig *tls.Config) Option {
+				return WithSOCKS5Upstream(address, "bridge", "secret", tlsConfig)
+			},
+			serveTLS:  true,
+			clientTLS: &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "proxy.test"},
+			wantOK:    false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &echoDialer{}
+			opts := []Option{
+				WithListenAddresses([]string{"127.0.0.1:0"}),
+				WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"bridge": "secret"})),
+			}
+			if tt.serveTLS {
+				opts = append(opts, WithTLS(serverTLS))
+			}
+			upstream := tt.upstream(dialer, opts...)
+			startProxy(t, upstream)
+
+			h := NewHTTPProxy(0,
+				WithDialTimeout(time.Second),
+				tt.option(upstream.Addrs()[0].String(), tt.clientTLS),
+			)
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go h.handleConnection(server)
+
+			go io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+
+			br := bufio.NewReader(client)
+			resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if (resp.StatusCode == http.StatusOK) != tt.wantOK {
+				t.Fatalf("Unexpected status %d", resp.StatusCode)
+			}
+			if !tt.wantOK {
+				return
+			}
+
+			go io.WriteString(client, "ping")
+			echo := make([]byte, 4)
+			if _, err := io.ReadFull(br, echo); err != nil || string(echo) != "ping" {
+				t.Fatalf("Expected echoed data, got %q (%v)", echo, err)
+			}
+
+			if len(dialer.targets) != 1 || dialer.targets[0] != "example.com:443" {
+				t.Errorf("Expected the upstream to dial example.com:443, got %v", dialer.targets)
+			}
+		})
+	}
+}
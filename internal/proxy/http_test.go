@@ -0,0 +1,979 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/events"
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+// newTestHTTPProxy creates an HTTP proxy with every middleware disabled
+func newTestHTTPProxy(opts ...Option) *HTTPProxy {
+	return NewHTTPProxy(0, opts...)
+}
+
+// roundTrip sends a raw request through handleConnection and parses the response
+func roundTrip(t *testing.T, h *HTTPProxy, raw string) *http.Response {
+	t.Helper()
+
+	client, server := net.Pipe()
+	go h.handleConnection(server)
+	t.Cleanup(func() { client.Close() })
+
+	go io.WriteString(client, raw)
+
+	resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp
+}
+
+func TestHTTPProxy_LandingResponse(t *testing.T) {
+	h := newTestHTTPProxy(WithLandingResponse(http.StatusTeapot, "not a web server"))
+
+	resp := roundTrip(t, h, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTeapot {
+		t.Errorf("Expected status %d, got %d", http.StatusTeapot, resp.StatusCode)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "not a web server" {
+		t.Errorf("Unexpected body: %q", body)
+	}
+}
+
+func TestHTTPProxy_IdentifyingHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantAuth   string
+		wantServer string
+	}{
+		{"defaults", nil, `Basic realm="DuDu Proxy"`, ""},
+		{"custom", []Option{WithRealm(`corp "edge"`), WithServerHeader("nginx")}, `Basic realm="corp \"edge\""`, "nginx"},
+		{"suppressed realm", []Option{WithRealm("")}, `Basic realm=""`, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{
+				WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+			}, tt.opts...)
+			h := newTestHTTPProxy(opts...)
+
+			resp := roundTrip(t, h, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusProxyAuthRequired {
+				t.Fatalf("Expected status 407, got %d", resp.StatusCode)
+			}
+			if got := resp.Header.Get("Proxy-Authenticate"); got != tt.wantAuth {
+				t.Errorf("Proxy-Authenticate = %q, want %q", got, tt.wantAuth)
+			}
+			if got := resp.Header.Get("Server"); got != tt.wantServer {
+				t.Errorf("Server = %q, want %q", got, tt.wantServer)
+			}
+		})
+	}
+}
+
+// eventRecorder collects published events
+type eventRecorder struct {
+	events []events.Event
+}
+
+// Publish records e
+func (r *eventRecorder) Publish(e events.Event) {
+	r.events = append(r.events, e)
+}
+
+func TestHTTPProxy_AuthFailurePublished(t *testing.T) {
+	recorder := &eventRecorder{}
+	h := newTestHTTPProxy(WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})))
+	h.events = []events.Publisher{recorder}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("user1:wrong"))
+	resp := roundTrip(t, h, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic "+credentials+"\r\n\r\n")
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("Expected status 407, got %d", resp.StatusCode)
+	}
+	if len(recorder.events) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(recorder.events))
+	}
+	if got := recorder.events[0]; got.Type != events.TypeAuthFailure || got.Protocol != "http" || got.Username != "user1" {
+		t.Errorf("event = %+v, want an http auth failure for user1", got)
+	}
+}
+
+// eventChan passes published events to a test
+type eventChan chan events.Event
+
+// Publish sends e on the channel
+func (c eventChan) Publish(e events.Event) {
+	c <- e
+}
+
+func TestHTTPProxy_EventsShareConnID(t *testing.T) {
+	published := make(eventChan, 4)
+	h := newTestHTTPProxy(
+		WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+		WithDialer(&echoDialer{}),
+		WithMaxAuthAttempts(2),
+	)
+	h.events = []events.Publisher{published}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go h.handleConnection(server)
+	client.SetDeadline(time.Now().Add(time.Second))
+
+	// A failed attempt, then a tunnel on the same connection
+	reader := bufio.NewReader(client)
+	for _, password := range []string{"wrong", "pass1"} {
+		credentials := base64.StdEncoding.EncodeToString([]byte("user1:" + password))
+		go io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic "+credentials+"\r\n\r\n")
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response: %v", err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	var got []events.Event
+	for len(got) < 2 {
+		select {
+		case e := <-published:
+			got = append(got, e)
+		case <-time.After(time.Second):
+			t.Fatalf("Expected an auth failure and an open event, got %+v", got)
+		}
+	}
+	if got[0].Type != events.TypeAuthFailure || got[1].Type != events.TypeOpen {
+		t.Fatalf("Expected an auth failure then an open event, got %s and %s", got[0].Type, got[1].Type)
+	}
+	if got[0].ConnID == 0 || got[0].ConnID != got[1].ConnID {
+		t.Errorf("Expected both events to carry the same conn_id, got %d and %d", got[0].ConnID, got[1].ConnID)
+	}
+	if got[0].ID != 0 || got[1].ID == 0 {
+		t.Errorf("Expected only the registered connection to have an id, got %d and %d", got[0].ID, got[1].ID)
+	}
+}
+
+func TestHTTPProxy_AuthAttemptsPerConn(t *testing.T) {
+	wrong := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic " +
+		base64.StdEncoding.EncodeToString([]byte("user1:wrong")) + "\r\n\r\n"
+	right := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\nProxy-Authorization: Basic " +
+		base64.StdEncoding.EncodeToString([]byte("user1:pass1")) + "\r\n\r\n"
+	challenge := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"
+
+	tests := []struct {
+		name        string
+		maxAttempts int
+		banAfter    int
+		requests    []string
+		want        []int // Status of each response, the last closing the connection unless 200
+	}{
+		{"attempts exhausted", 3, 10, []string{wrong, wrong, wrong}, []int{407, 407, 407}},
+		{"single attempt", 1, 10, []string{wrong}, []int{407}},
+		{"banned before the limit", 3, 2, []string{wrong, wrong}, []int{407, 407}},
+		{"challenge then success", 3, 10, []string{challenge, right}, []int{407, 200}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bans := manager.NewIPBanManager(tt.banAfter, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+			defer bans.Stop()
+
+			h := newTestHTTPProxy(
+				WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+				WithIPBan(middleware.NewIPBanMiddleware(true, bans)),
+				WithDialer(&echoDialer{}),
+				WithMaxAuthAttempts(tt.maxAttempts),
+			)
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go h.handleConnection(server)
+			client.SetDeadline(time.Now().Add(time.Second))
+
+			// Each request goes out on the same connection once the previous one is answered
+			reader := bufio.NewReader(client)
+			for i, status := range tt.want {
+				go io.WriteString(client, tt.requests[i])
+				resp, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					t.Fatalf("Request %d: failed to read response: %v", i+1, err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+
+				if resp.StatusCode != status {
+					t.Fatalf("Request %d: expected status %d, got %d", i+1, status, resp.StatusCode)
+				}
+				last := i == len(tt.want)-1
+				if closing := resp.Close; status == http.StatusProxyAuthRequired && closing != last {
+					t.Errorf("Request %d: Connection: close = %v, want %v", i+1, closing, last)
+				}
+			}
+
+			if tt.want[len(tt.want)-1] == http.StatusProxyAuthRequired {
+				if _, err := reader.ReadByte(); err != io.EOF {
+					t.Errorf("Expected the connection to be closed, got %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_ConnectPipelinedData(t *testing.T) {
+	h := newTestHTTPProxy(WithDialer(&echoDialer{}))
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go h.handleConnection(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// The payload follows the request in the same write, before the 200 is read
+	go io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\nclient hello")
+
+	reader := bufio.NewReader(client)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	// The echo target returns the early payload once it reaches it
+	echoed := make([]byte, len("client hello"))
+	if _, err := io.ReadFull(reader, echoed); err != nil {
+		t.Fatalf("Early payload was not forwarded: %v", err)
+	}
+	if string(echoed) != "client hello" {
+		t.Errorf("Expected %q echoed, got %q", "client hello", echoed)
+	}
+}
+
+func TestHTTPProxy_ConnectFailureResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantStatus int
+		wantBody   string
+		wantType   string
+		wantRetry  string
+	}{
+		{"default", nil, http.StatusBadGateway, "Failed to connect to target", "text/plain", ""},
+		{
+			"captive portal friendly",
+			[]Option{WithConnectFailureResponse(http.StatusOK, "<h1>Target unreachable</h1>",
+				map[string]string{"content-type": "text/html", "Retry-After": "30"})},
+			http.StatusOK, "<h1>Target unreachable</h1>", "text/html", "30",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHTTPProxy(tt.opts...)
+
+			// Nothing listens on port 1, so the dial fails
+			resp := roundTrip(t, h, "CONNECT 127.0.0.1:1 HTTP/1.1\r\nHost: 127.0.0.1:1\r\n\r\n")
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil || string(body) != tt.wantBody {
+				t.Errorf("Unexpected body %q (%v)", body, err)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantType)
+			}
+			if got := resp.Header.Get("Retry-After"); got != tt.wantRetry {
+				t.Errorf("Retry-After = %q, want %q", got, tt.wantRetry)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_RequestForms(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream:"+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	tests := []struct {
+		name        string
+		transparent bool
+		raw         string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name:       "absolute-form is proxied",
+			raw:        "GET http://" + host + "/hello HTTP/1.1\r\nHost: " + host + "\r\n\r\n",
+			wantStatus: http.StatusOK,
+			wantBody:   "upstream:/hello",
+		},
+		{
+			name:       "origin-form is rejected",
+			raw:        "GET /hello HTTP/1.1\r\nHost: " + host + "\r\n\r\n",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "Bad Request: this is a proxy",
+		},
+		{
+			name:        "origin-form is forwarded in transparent mode",
+			transparent: true,
+			raw:         "GET /hello HTTP/1.1\r\nHost: " + host + "\r\n\r\n",
+			wantStatus:  http.StatusOK,
+			wantBody:    "upstream:/hello",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHTTPProxy(WithTransparent(tt.transparent))
+
+			resp := roundTrip(t, h, tt.raw)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_RequestBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// Report how the body was framed on the way to the target
+		w.Header().Set("X-Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		w.Header().Set("X-Transfer-Encoding", strings.Join(r.TransferEncoding, ","))
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	head := "POST http://" + host + "/echo HTTP/1.1\r\nHost: " + host + "\r\n"
+
+	// The request reader buffers the start of the body along with the headers
+	small := "name=dudu"
+	large := strings.Repeat("0123456789abcdef", 8192)
+	huge := strings.Repeat("0123456789abcdef", 1<<16)
+
+	tests := []struct {
+		name         string
+		raw          string
+		want         string
+		wantLength   string
+		wantEncoding string
+	}{
+		{"buffered with headers", head + "Content-Length: 9\r\n\r\n" + small, small, "9", ""},
+		{"larger than buffer", head + "Content-Length: " + strconv.Itoa(len(large)) + "\r\n\r\n" + large, large, strconv.Itoa(len(large)), ""},
+		{"1 MiB", head + "Content-Length: " + strconv.Itoa(len(huge)) + "\r\n\r\n" + huge, huge, strconv.Itoa(len(huge)), ""},
+		{"chunked", head + "Transfer-Encoding: chunked\r\n\r\n4\r\nname\r\n5\r\n=dudu\r\n0\r\n\r\n", small, "-1", "chunked"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := roundTrip(t, newTestHTTPProxy(), tt.raw)
+			defer resp.Body.Close()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			if string(body) != tt.want {
+				t.Errorf("Expected %d body bytes echoed, got %d", len(tt.want), len(body))
+			}
+			if got := resp.Header.Get("X-Content-Length"); got != tt.wantLength {
+				t.Errorf("Expected upstream Content-Length %s, got %s", tt.wantLength, got)
+			}
+			if got := resp.Header.Get("X-Transfer-Encoding"); got != tt.wantEncoding {
+				t.Errorf("Expected upstream Transfer-Encoding %q, got %q", tt.wantEncoding, got)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_MaxResponseBytes(t *testing.T) {
+	body := strings.Repeat("x", 4096)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+	raw := "GET http://" + host + "/ HTTP/1.1\r\nHost: " + host + "\r\n\r\n"
+
+	tests := []struct {
+		name     string
+		max      int64
+		compress bool
+		wantFull bool
+	}{
+		{"unlimited", 0, false, true},
+		{"within limit", 1 << 20, false, true},
+		{"truncated", 1024, false, false},
+		{"truncated with compression", 1024, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHTTPProxy(WithMaxResponseBytes(tt.max), WithCompression(tt.compress))
+
+			resp := roundTrip(t, h, raw)
+			defer resp.Body.Close()
+
+			got, err := io.ReadAll(resp.Body)
+			if full := err == nil && string(got) == body; full != tt.wantFull {
+				t.Errorf("Expected full body %v, got %d bytes (%v)", tt.wantFull, len(got), err)
+			}
+		})
+	}
+}
+
+func TestCappedReader(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		max     int64
+		wantErr error
+	}{
+		{"under limit", "abc", 4, nil},
+		{"exactly at limit", "abcd", 4, nil},
+		{"over limit", "abcde", 4, errResponseTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(&cappedReader{r: strings.NewReader(tt.input), remaining: tt.max})
+			if err != tt.wantErr {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if want := tt.input[:min(len(tt.input), int(tt.max))]; string(got) != want {
+				t.Errorf("Expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_Compression(t *testing.T) {
+	const text = "hello hello hello hello hello hello"
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/text":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+		case "/gzipped":
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Content-Encoding", "gzip")
+		}
+		io.WriteString(w, text)
+	}))
+	defer upstream.Close()
+
+	host := strings.TrimPrefix(upstream.URL, "http://")
+
+	tests := []struct {
+		name           string
+		path           string
+		acceptEncoding string
+		wantGzip       bool
+	}{
+		{name: "text is compressed", path: "/text", acceptEncoding: "gzip, deflate", wantGzip: true},
+		{name: "client without gzip", path: "/text", acceptEncoding: "", wantGzip: false},
+		{name: "client refuses gzip", path: "/text", acceptEncoding: "gzip;q=0", wantGzip: false},
+		{name: "binary content", path: "/image", acceptEncoding: "gzip", wantGzip: false},
+		{name: "already compressed", path: "/gzipped", acceptEncoding: "gzip", wantGzip: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHTTPProxy(WithCompression(true))
+
+			raw := "GET http://" + host + tt.path + " HTTP/1.1\r\nHost: " + host + "\r\n"
+			if tt.acceptEncoding != "" {
+				raw += "Accept-Encoding: " + tt.acceptEncoding + "\r\n"
+			}
+			resp := roundTrip(t, h, raw+"\r\n")
+			defer resp.Body.Close()
+
+			// Only the proxy adds Vary, so it marks responses the proxy compressed
+			gzipped := resp.Header.Get("Vary") == "Accept-Encoding"
+			if gzipped != tt.wantGzip {
+				t.Fatalf("Expected compressed=%v, got headers %v", tt.wantGzip, resp.Header)
+			}
+
+			body := resp.Body.(io.Reader)
+			if tt.wantGzip {
+				gz, err := gzip.NewReader(resp.Body)
+				if err != nil {
+					t.Fatalf("Failed to open gzip body: %v", err)
+				}
+				body = gz
+			}
+			data, _ := io.ReadAll(body)
+			if string(data) != text {
+				t.Errorf("Expected body %q, got %q", text, data)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_Authentication(t *testing.T) {
+	auth := middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"},
+		middleware.WithTokens(map[string]string{"tok-alpha": "api"}))
+	h := newTestHTTPProxy(WithAuth(auth))
+
+	basic := "Basic " + base64.StdEncoding.EncodeToString([]byte("user1:pass1"))
+
+	tests := []struct {
+		name   string
+		header string
+		want   int
+	}{
+		{"no credentials", "", http.StatusProxyAuthRequired},
+		{"basic", basic, http.StatusBadGateway},
+		{"wrong basic password", "Basic " + base64.StdEncoding.EncodeToString([]byte("user1:nope")), http.StatusProxyAuthRequired},
+		{"bearer", "Bearer tok-alpha", http.StatusBadGateway},
+		{"wrong bearer token", "Bearer tok-beta", http.StatusProxyAuthRequired},
+		{"password as bearer token", "Bearer pass1", http.StatusProxyAuthRequired},
+		{"unknown scheme", "Digest tok-alpha", http.StatusProxyAuthRequired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "GET http://127.0.0.1:1/ HTTP/1.1\r\nHost: 127.0.0.1:1\r\n"
+			if tt.header != "" {
+				raw += "Proxy-Authorization: " + tt.header + "\r\n"
+			}
+
+			resp := roundTrip(t, h, raw+"\r\n")
+			defer resp.Body.Close()
+
+			// Authenticated requests reach the dial, which fails against the closed port
+			if resp.StatusCode != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestSharedAuthBackend(t *testing.T) {
+	// One backend injected into both proxies, as the server does when both listeners require auth
+	auth := middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1", "user2": "pass2"})
+	h := newTestHTTPProxy(WithAuth(auth))
+	s := NewSOCKS5Proxy(0, WithAuth(auth))
+
+	// authenticateBoth returns the identity each protocol assigns to the credentials
+	authenticateBoth := func(username, password string) (httpUser string, httpOK bool, socksUser string, socksOK bool) {
+		req := httptest.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+		httpUser, _, httpOK = h.authenticate(req)
+
+		conn := newScriptConn(authMethod(username, password))
+		socksUser, err := s.authenticatePassword(context.Background(), conn, 1, "192.0.2.1")
+		return httpUser, httpOK, socksUser, err == nil
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantOK   bool
+	}{
+		{"first user", "user1", "pass1", true},
+		{"second user", "user2", "pass2", true},
+		{"wrong password", "user1", "pass2", false},
+		{"unknown user", "user3", "pass1", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			httpUser, httpOK, socksUser, socksOK := authenticateBoth(tt.username, tt.password)
+			if httpOK != tt.wantOK || socksOK != tt.wantOK {
+				t.Fatalf("Expected both protocols to return %v, got HTTP %v and SOCKS5 %v", tt.wantOK, httpOK, socksOK)
+			}
+			if tt.wantOK && (httpUser != tt.username || socksUser != tt.username) {
+				t.Errorf("Expected identity %q, got HTTP %q and SOCKS5 %q", tt.username, httpUser, socksUser)
+			}
+		})
+	}
+
+	// Changes to the backend reach both protocols at once
+	auth.RemoveUser("user1")
+	if _, httpOK, _, socksOK := authenticateBoth("user1", "pass1"); httpOK || socksOK {
+		t.Errorf("Expected a revoked user to be rejected by both protocols, got HTTP %v and SOCKS5 %v", httpOK, socksOK)
+	}
+}
+
+func TestHTTPTarget(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{name: "absolute form", url: "http://example.com/a", want: "example.com:80"},
+		{name: "absolute form with port", url: "http://example.com:8080/a", want: "example.com:8080"},
+		{name: "origin form", url: "/a", host: "example.com", want: "example.com:80"},
+		{name: "IPv4", url: "/a", host: "192.0.2.1:81", want: "192.0.2.1:81"},
+		{name: "IPv6 without port", url: "/a", host: "[2001:db8::1]", want: "[2001:db8::1]:80"},
+		{name: "IPv6 with port", url: "http://[2001:db8::1]:8080/", want: "[2001:db8::1]:8080"},
+		{name: "missing host", url: "/a", wantErr: true},
+		{name: "empty hostname", url: "/a", host: ":8080", wantErr: true},
+		{name: "space in host", url: "/a", host: "exa mple.com", wantErr: true},
+		{name: "userinfo in host", url: "/a", host: "user@example.com", wantErr: true},
+		{name: "port out of range", url: "/a", host: "example.com:65536", wantErr: true},
+		{name: "non-numeric port", url: "/a", host: "example.com:http", wantErr: true},
+		{name: "unclosed bracket", url: "/a", host: "[2001:db8::1", wantErr: true},
+		{name: "several colons", url: "/a", host: "example.com:80:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Host = tt.host
+			if req.URL.Host != "" {
+				req.Host = req.URL.Host
+			}
+
+			got, err := httpTarget(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("httpTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("httpTarget() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_InvalidHost(t *testing.T) {
+	h := newTestHTTPProxy(WithTransparent(true))
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{"missing Host header", "GET /path HTTP/1.1\r\n\r\n"},
+		{"empty absolute-form host", "GET http://:8080/path HTTP/1.1\r\nHost: :8080\r\n\r\n"},
+		{"malformed Host header", "GET /path HTTP/1.1\r\nHost: bad!host\r\n\r\n"},
+		{"invalid port", "GET /path HTTP/1.1\r\nHost: example.com:0\r\n\r\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := roundTrip(t, h, tt.raw)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, resp.StatusCode)
+			}
+		})
+	}
+}
+
+func TestOriginURL(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"absolute form", "GET http://example.com/path?token=secret HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.com"},
+		{"absolute form with port", "GET https://example.com:8443/a HTTP/1.1\r\nHost: example.com:8443\r\n\r\n", "https://example.com:8443"},
+		{"origin form", "GET /path?token=secret HTTP/1.1\r\nHost: example.com\r\n\r\n", "http://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(tt.raw)))
+			if err != nil {
+				t.Fatalf("Failed to parse request: %v", err)
+			}
+			if got := originURL(req); got != tt.want {
+				t.Errorf("originURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_Pause(t *testing.T) {
+	// Echo server standing in for the target
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+
+	h := newTestHTTPProxy()
+
+	// Open a tunnel before pausing
+	tunnel, server := net.Pipe()
+	defer tunnel.Close()
+	go h.handleConnection(server)
+	go io.WriteString(tunnel, "CONNECT "+target.Addr().String()+" HTTP/1.1\r\nHost: "+target.Addr().String()+"\r\n\r\n")
+	reader := bufio.NewReader(tunnel)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("Failed to open tunnel: %v", err)
+	}
+
+	h.Pause()
+
+	rejected, server := net.Pipe()
+	defer rejected.Close()
+	go h.handleConnection(server)
+	if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Expected a new connection to be closed while paused, got %v", err)
+	}
+
+	// The established tunnel still carries data
+	go io.WriteString(tunnel, "ping")
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != "ping" {
+		t.Errorf("Expected the tunnel to echo ping while paused, got %q (%v)", buf, err)
+	}
+
+	h.Resume()
+
+	resp = roundTrip(t, h, "GET / HTTP/1.1\r\nHost: proxy.local\r\n\r\n")
+	resp.Body.Close()
+}
+
+func TestHTTPProxy_MaxConnsPerUser(t *testing.T) {
+	h := newTestHTTPProxy(
+		WithDialer(&echoDialer{}),
+		WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+		WithMaxConnsPerUser(manager.NewConnLimiter(1)),
+	)
+	connect := "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n" +
+		"Proxy-Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("user1:pass1")) + "\r\n\r\n"
+
+	// The first tunnel stays open for the rest of the test
+	first := roundTrip(t, h, connect)
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the first tunnel to be established, got %d", first.StatusCode)
+	}
+
+	second := roundTrip(t, h, connect)
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, second.StatusCode)
+	}
+}
+
+func TestHTTPProxy_MaxConnsPerTarget(t *testing.T) {
+	h := newTestHTTPProxy(
+		WithDialer(&echoDialer{}),
+		WithMaxConnsPerTarget(manager.NewConnLimiter(1)),
+	)
+
+	// The first tunnel stays open for the rest of the test
+	first := roundTrip(t, h, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	if first.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the first tunnel to be established, got %d", first.StatusCode)
+	}
+
+	// The cap is per host, whatever the port or case
+	second := roundTrip(t, h, "CONNECT EXAMPLE.com:8443 HTTP/1.1\r\nHost: EXAMPLE.com:8443\r\n\r\n")
+	defer second.Body.Close()
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status %d, got %d", http.StatusServiceUnavailable, second.StatusCode)
+	}
+
+	other := roundTrip(t, h, "CONNECT example.org:443 HTTP/1.1\r\nHost: example.org:443\r\n\r\n")
+	if other.StatusCode != http.StatusOK {
+		t.Errorf("Expected other hosts to have their own slots, got %d", other.StatusCode)
+	}
+}
+
+func TestHTTPProxy_SendResponseFraming(t *testing.T) {
+	h := newTestHTTPProxy()
+
+	tests := []struct {
+		name      string
+		send      func(w io.Writer)
+		wantBody  string
+		wantClose bool
+	}{
+		{
+			name:      "error",
+			send:      func(w io.Writer) { h.sendError(w, 1, http.StatusForbidden, "Access denied") },
+			wantBody:  "Access denied",
+			wantClose: true,
+		},
+		{
+			name:      "auth required",
+			send:      func(w io.Writer) { h.sendProxyAuthRequired(w, 1, true) },
+			wantBody:  "Proxy authentication required",
+			wantClose: true,
+		},
+		{
+			name:     "auth required with retry",
+			send:     func(w io.Writer) { h.sendProxyAuthRequired(w, 1, false) },
+			wantBody: "Proxy authentication required",
+		},
+		{
+			name: "framing headers cannot be overridden",
+			send: func(w io.Writer) {
+				h.sendResponse(w, response{status: http.StatusBadGateway, body: "upstream down",
+					headers: map[string]string{"Content-Length": "0", "Connection": "keep-alive"}})
+			},
+			wantBody:  "upstream down",
+			wantClose: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.send(&buf)
+			// A second response right behind shows the first was framed exactly
+			h.sendResponse(&buf, response{status: http.StatusTeapot})
+
+			reader := bufio.NewReader(&buf)
+			resp, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			if string(body) != tt.wantBody {
+				t.Errorf("Body = %q, want %q", body, tt.wantBody)
+			}
+			if resp.Close != tt.wantClose {
+				t.Errorf("Connection: close = %v, want %v", resp.Close, tt.wantClose)
+			}
+			if _, err := http.ParseTime(resp.Header.Get("Date")); err != nil {
+				t.Errorf("Expected a valid Date header, got %q", resp.Header.Get("Date"))
+			}
+			if resp.Header.Get("Content-Type") == "" {
+				t.Error("Expected a Content-Type header")
+			}
+
+			if next, err := http.ReadResponse(reader, nil); err != nil || next.StatusCode != http.StatusTeapot {
+				t.Errorf("Expected the next response to follow the body, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_JSONErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		json       bool
+		raw        string
+		wantStatus int
+		wantType   string
+		wantMsg    string
+	}{
+		{
+			"auth required", true,
+			"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+			http.StatusProxyAuthRequired, "application/json", "Proxy authentication required",
+		},
+		{
+			// Nothing listens on port 1, so the dial fails
+			"bad gateway", true,
+			"GET http://127.0.0.1:1/ HTTP/1.1\r\nHost: 127.0.0.1:1\r\nProxy-Authorization: Basic " +
+				base64.StdEncoding.EncodeToString([]byte("user1:pass1")) + "\r\n\r\n",
+			http.StatusBadGateway, "application/json", "Failed to connect to target",
+		},
+		{
+			"plain text by default", false,
+			"CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n",
+			http.StatusProxyAuthRequired, "text/plain", "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHTTPProxy(
+				WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+				WithJSONErrors(tt.json),
+			)
+
+			resp := roundTrip(t, h, tt.raw)
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d", tt.wantStatus, resp.StatusCode)
+			}
+			if got := resp.Header.Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Expected Content-Type %q, got %q", tt.wantType, got)
+			}
+			if !tt.json {
+				return
+			}
+
+			var body struct {
+				Error struct {
+					Code      int    `json:"code"`
+					Message   string `json:"message"`
+					RequestID uint64 `json:"request_id"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatalf("Invalid JSON body: %v", err)
+			}
+			if body.Error.Code != tt.wantStatus || body.Error.Message != tt.wantMsg || body.Error.RequestID == 0 {
+				t.Errorf("Unexpected error body: %+v", body.Error)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_ConnectOnly(t *testing.T) {
+	h := newTestHTTPProxy(WithConnectOnly(true), WithDialer(&echoDialer{}))
+
+	resp := roundTrip(t, h, "GET http://example.com/ HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected plain HTTP to get status %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	tunnel := roundTrip(t, h, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+	if tunnel.StatusCode != http.StatusOK {
+		t.Errorf("Expected CONNECT to be allowed, got status %d", tunnel.StatusCode)
+	}
+}
@@ -0,0 +1,388 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// defaultInterceptCertCache is how many issued certificates are kept when no
+// cache size is set
+const defaultInterceptCertCache = 1000
+
+// interceptCertLifetime is how long issued certificates are valid. Cached ones
+// are reissued once less than interceptCertRenewal remains.
+const (
+	interceptCertLifetime = 7 * 24 * time.Hour
+	interceptCertRenewal  = 24 * time.Hour
+)
+
+// RequestFilter inspects requests decrypted by HTTPS interception
+type RequestFilter interface {
+	// Allow reports whether req may be forwarded to its target; blocked
+	// requests are answered with a 403. req.URL.Host is the tunnel's CONNECT
+	// target, which req.Host has been checked to name. It may modify req,
+	// such as its headers, before it is sent.
+	Allow(req *http.Request) bool
+}
+
+// Interceptor terminates TLS inside HTTP CONNECT tunnels with certificates it
+// issues from a local CA, so requests can be filtered in plaintext before
+// being sent on to their target over a new TLS connection
+type Interceptor struct {
+	ca      *x509.Certificate
+	caKey   crypto.Signer
+	leafKey *ecdsa.PrivateKey // Shared by every issued certificate
+	filter  RequestFilter     // Nil forwards every request
+	bypass  []string          // Host patterns tunneled without interception, lower case
+	roots   *x509.CertPool    // Verifies targets, nil uses the system roots
+
+	mu       sync.Mutex
+	certs    map[string]*tls.Certificate // Host -> issued certificate
+	maxCerts int
+}
+
+// NewInterceptor creates an interceptor issuing certificates from ca, a CA
+// certificate with its private key. Requests are passed to filter when it is
+// not nil. Hosts matching bypassHosts ("*.example.com" for subdomains) are
+// tunneled untouched, and at most cacheSize issued certificates are kept.
+func NewInterceptor(ca tls.Certificate, filter RequestFilter, bypassHosts []string, cacheSize int) (*Interceptor, error) {
+	if len(ca.Certificate) == 0 {
+		return nil, errors.New("no CA certificate")
+	}
+	leaf := ca.Leaf
+	if leaf == nil {
+		var err error
+		if leaf, err = x509.ParseCertificate(ca.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("failed to parse CA certificate: %w", err)
+		}
+	}
+	if !leaf.IsCA || (leaf.KeyUsage != 0 && leaf.KeyUsage&x509.KeyUsageCertSign == 0) {
+		return nil, errors.New("certificate is not allowed to sign certificates (not a CA)")
+	}
+	caKey, ok := ca.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported CA private key")
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate certificate key: %w", err)
+	}
+
+	if cacheSize <= 0 {
+		cacheSize = defaultInterceptCertCache
+	}
+	bypass := make([]string, len(bypassHosts))
+	for i, host := range bypassHosts {
+		bypass[i] = strings.ToLower(host)
+	}
+
+	return &Interceptor{
+		ca:       leaf,
+		caKey:    caKey,
+		leafKey:  leafKey,
+		filter:   filter,
+		bypass:   bypass,
+		certs:    make(map[string]*tls.Certificate),
+		maxCerts: cacheSize,
+	}, nil
+}
+
+// intercepts reports whether tunnels to target, a host:port, are intercepted
+func (i *Interceptor) intercepts(target string) bool {
+	return !middleware.MatchHost(i.bypass, hostnameOf(target))
+}
+
+// hostnameOf returns the host of a host or host:port in lower case, without
+// IPv6 brackets or a trailing dot
+func hostnameOf(hostport string) string {
+	host, _, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = strings.Trim(hostport, "[]")
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// certificate returns a certificate for host, issuing one when none is cached
+// or the cached one is about to expire
+func (i *Interceptor) certificate(host string) (*tls.Certificate, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	// Certificates already lasting as long as the CA can't be renewed further
+	cached, ok := i.certs[host]
+	if ok && (time.Until(cached.Leaf.NotAfter) > interceptCertRenewal || !cached.Leaf.NotAfter.Before(i.ca.NotAfter)) {
+		return cached, nil
+	}
+
+	cert, err := i.issue(host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make room by dropping an arbitrary certificate; it is reissued on demand
+	if !ok && len(i.certs) >= i.maxCerts {
+		for name := range i.certs {
+			delete(i.certs, name)
+			break
+		}
+	}
+	i.certs[host] = cert
+
+	return cert, nil
+}
+
+// issue signs a new server certificate for host, a DNS name or an IP address
+func (i *Interceptor) issue(host string) (*tls.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour), // Tolerate clients whose clocks are slightly behind
+		NotAfter:     now.Add(interceptCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if template.NotAfter.After(i.ca.NotAfter) {
+		template.NotAfter = i.ca.NotAfter
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, i.ca, &i.leafKey.PublicKey, i.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate for %s: %w", host, err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Certificate{
+		Certificate: [][]byte{der, i.ca.Raw},
+		PrivateKey:  i.leafKey,
+		Leaf:        leaf,
+	}, nil
+}
+
+// URLBlockFilter blocks intercepted requests whose "host/path" starts with
+// one of its prefixes, such as "example.com/ads/"
+type URLBlockFilter struct {
+	prefixes []string // Lower case
+}
+
+// NewURLBlockFilter creates a filter blocking requests matching prefixes
+func NewURLBlockFilter(prefixes []string) *URLBlockFilter {
+	f := &URLBlockFilter{}
+	for _, prefix := range prefixes {
+		f.prefixes = append(f.prefixes, strings.ToLower(prefix))
+	}
+	return f
+}
+
+// Allow implements RequestFilter
+func (f *URLBlockFilter) Allow(req *http.Request) bool {
+	host, _, err := net.SplitHostPort(req.URL.Host)
+	if err != nil {
+		host = req.URL.Host
+	}
+	url := strings.ToLower(host) + req.URL.Path
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(url, prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// streamConn presents a target stream, such as a tracked connection, as the
+// net.Conn crypto/tls needs; addresses and deadlines are conn's
+type streamConn struct {
+	net.Conn
+	stream io.ReadWriteCloser
+}
+
+func (c *streamConn) Read(p []byte) (int, error)  { return c.stream.Read(p) }
+func (c *streamConn) Write(p []byte) (int, error) { return c.stream.Write(p) }
+func (c *streamConn) Close() error                { return c.stream.Close() }
+
+// intercept relays a CONNECT tunnel's HTTPS requests in plaintext. It
+// terminates the client's TLS with a certificate issued for the CONNECT
+// target, opens TLS to the target itself, verifying its certificate, and
+// passes each request through the interceptor's filter. A server name or
+// Host header naming another host is answered with a 421, so the filter
+// always sees the host actually connected to. Only HTTP/1.1 is offered;
+// upgraded connections such as WebSockets are relayed as they are. It
+// returns why the tunnel ended.
+func (h *HTTPProxy) intercept(ctx context.Context, client, target net.Conn, host string, connID uint64, clientIP string) closeReason {
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		target.Close()
+	})
+	defer stop()
+
+	hostname := hostnameOf(host)
+
+	clientTLS := tls.Server(client, &tls.Config{
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return h.interceptor.certificate(hostname)
+		},
+		NextProtos: []string{"http/1.1"},
+	})
+	if err := clientTLS.HandshakeContext(ctx); err != nil {
+		// Usually a client that doesn't trust the interception CA
+		logger.WarnSampled("TLS interception handshake with client failed",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"target", host,
+			"error", err)
+		return interceptCloseReason(ctx, err, closeClientClosed)
+	}
+
+	if serverName := clientTLS.ConnectionState().ServerName; serverName != "" && hostnameOf(serverName) != hostname {
+		logger.WarnSampled("Intercepted tunnel's server name does not match its target",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"target", host,
+			"server_name", serverName)
+		countIntercepted("misdirected")
+		h.sendResponse(clientTLS, h.errorResponse(connID, http.StatusMisdirectedRequest, "Misdirected request"))
+		return closeError
+	}
+
+	targetTLS := tls.Client(target, &tls.Config{
+		ServerName: hostname,
+		RootCAs:    h.interceptor.roots,
+		NextProtos: []string{"http/1.1"},
+	})
+	if err := targetTLS.HandshakeContext(ctx); err != nil {
+		logger.Warn("TLS interception handshake with target failed",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"target", host,
+			"error", err)
+		h.sendError(clientTLS, connID, http.StatusBadGateway, "Failed to connect to target")
+		return closeError
+	}
+
+	clientReader := bufio.NewReader(clientTLS)
+	targetReader := bufio.NewReader(targetTLS)
+	for {
+		req, err := http.ReadRequest(clientReader)
+		if err != nil {
+			return interceptCloseReason(ctx, err, closeClientClosed)
+		}
+		if req.Host == "" {
+			req.Host = host
+		}
+		if hostnameOf(req.Host) != hostname {
+			logger.WarnSampled("Intercepted request's host does not match its tunnel",
+				"conn_id", connID,
+				"client_ip", clientIP,
+				"target", host,
+				"host", req.Host)
+			countIntercepted("misdirected")
+
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+			resp := h.errorResponse(connID, http.StatusMisdirectedRequest, "Misdirected request")
+			resp.keepAlive = true
+			h.sendResponse(clientTLS, resp)
+			continue
+		}
+		req.URL.Scheme, req.URL.Host = "https", host
+
+		if h.interceptor.filter != nil && !h.interceptor.filter.Allow(req) {
+			logger.Info("Intercepted request blocked",
+				"conn_id", connID,
+				"client_ip", clientIP,
+				"method", req.Method,
+				"url", req.URL.Host+req.URL.Path)
+			countIntercepted("blocked")
+
+			// Skip the body so the next request on the connection can be read
+			io.Copy(io.Discard, req.Body)
+			req.Body.Close()
+			resp := h.errorResponse(connID, http.StatusForbidden, "Access denied")
+			resp.keepAlive = true
+			h.sendResponse(clientTLS, resp)
+			continue
+		}
+		h.debugLog(clientIP)("Intercepted request forwarded",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"method", req.Method,
+			"url", req.URL.String())
+		countIntercepted("forwarded")
+
+		if err := req.Write(targetTLS); err != nil {
+			return interceptCloseReason(ctx, err, closeUpstreamClosed)
+		}
+		resp, err := http.ReadResponse(targetReader, req)
+		if err != nil {
+			return interceptCloseReason(ctx, err, closeUpstreamClosed)
+		}
+		err = resp.Write(clientTLS)
+		resp.Body.Close()
+		if err != nil {
+			return interceptCloseReason(ctx, err, closeClientClosed)
+		}
+
+		switch {
+		case resp.StatusCode == http.StatusSwitchingProtocols:
+			return transfer(ctx, &bufferedConn{Conn: clientTLS, reader: clientReader},
+				&bufferedConn{Conn: targetTLS, reader: targetReader})
+		case resp.Close:
+			return closeUpstreamClosed
+		case req.Close:
+			return closeClientClosed
+		}
+	}
+}
+
+// interceptCloseReason returns why an intercepted tunnel ended with err: eof
+// when that side finished cleanly, otherwise an error unless ctx ended it
+func interceptCloseReason(ctx context.Context, err error, eof closeReason) closeReason {
+	switch {
+	case ctx.Err() != nil:
+		return ctxCloseReason(ctx)
+	case errors.Is(err, io.EOF):
+		return eof
+	default:
+		return closeError
+	}
+}
+
+// countIntercepted counts an intercepted request by what happened to it
+func countIntercepted(action string) {
+	metrics.Default.Counter("dudu_intercepted_requests_total", "Requests decrypted by HTTPS interception, by whether they were forwarded, blocked or misdirected",
+		"action", action).Inc()
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+)
+
+// newTestCA returns a self-signed certificate, a CA unless leaf is set
+func newTestCA(t *testing.T, leaf bool) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "DuDu Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  !leaf,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestNewInterceptor_RequiresCA(t *testing.T) {
+	if _, err := NewInterceptor(newTestCA(t, false), nil, nil, 0); err != nil {
+		t.Errorf("Expected a CA to be accepted, got %v", err)
+	}
+	if _, err := NewInterceptor(newTestCA(t, true), nil, nil, 0); err == nil {
+		t.Error("Expected a certificate that isn't a CA to be rejected")
+	}
+}
+
+func TestInterceptor_Intercepts(t *testing.T) {
+	i, err := NewInterceptor(newTestCA(t, false), nil, []string{"Bank.example", "*.pinned.example"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target string
+		want   bool
+	}{
+		{"example.com:443", true},
+		{"bank.example:443", false},
+		{"BANK.example.:443", false},
+		{"app.pinned.example:443", false},
+		{"pinned.example:443", true},
+	}
+
+	for _, tt := range tests {
+		if got := i.intercepts(tt.target); got != tt.want {
+			t.Errorf("intercepts(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestInterceptor_CertificateCache(t *testing.T) {
+	ca := newTestCA(t, false)
+	i, err := NewInterceptor(ca, nil, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := i.certificate("example.com")
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	if again, _ := i.certificate("example.com"); again != first {
+		t.Error("Expected the cached certificate to be reused")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(i.ca)
+	if _, err := first.Leaf.Verify(x509.VerifyOptions{DNSName: "example.com", Roots: roots}); err != nil {
+		t.Errorf("Issued certificate does not verify: %v", err)
+	}
+
+	// A second host evicts the first from the one-entry cache
+	ipCert, err := i.certificate("192.0.2.1")
+	if err != nil {
+		t.Fatalf("Failed to issue certificate: %v", err)
+	}
+	if len(ipCert.Leaf.IPAddresses) != 1 || len(ipCert.Leaf.DNSNames) != 0 {
+		t.Errorf("Expected an IP address SAN, got %v and %v", ipCert.Leaf.IPAddresses, ipCert.Leaf.DNSNames)
+	}
+	if len(i.certs) != 1 {
+		t.Errorf("Expected 1 cached certificate, got %d", len(i.certs))
+	}
+	if again, _ := i.certificate("example.com"); again == first {
+		t.Error("Expected an evicted certificate to be reissued")
+	}
+}
+
+func TestURLBlockFilter(t *testing.T) {
+	f := NewURLBlockFilter([]string{"example.com/ads/", "Tracker.example"})
+
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com/index.html", true},
+		{"https://example.com/ads/banner.png", false},
+		{"https://example.com:8443/ads/banner.png", false},
+		{"https://tracker.example/pixel", false},
+		{"https://other.example/ads/", true},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		if got := f.Allow(req); got != tt.want {
+			t.Errorf("Allow(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestHTTPProxy_Intercept(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "upstream "+r.URL.Path)
+	}))
+	defer upstream.Close()
+
+	ca := newTestCA(t, false)
+	interceptor, err := NewInterceptor(ca, NewURLBlockFilter([]string{"example.com/blocked/"}), nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interceptor.roots = x509.NewCertPool()
+	interceptor.roots.AddCert(upstream.Certificate())
+
+	// Tunnels to example.com reach the test server, whose certificate names it
+	h := newTestHTTPProxy(WithInterception(interceptor),
+		WithHostOverrides(manager.NewHostOverrides(map[string]string{"example.com": "127.0.0.1"})))
+	_, port, _ := net.SplitHostPort(upstream.Listener.Addr().String())
+	target := net.JoinHostPort("example.com", port)
+
+	// The client only trusts the interception CA, so the handshake fails
+	// unless the proxy presents a certificate it issued for the target
+	roots := x509.NewCertPool()
+	roots.AddCert(interceptor.ca)
+
+	// tunnel opens an intercepted tunnel to target, sending serverName
+	tunnel := func(serverName string) (*tls.Conn, *bufio.Reader) {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+		go h.handleConnection(server)
+		client.SetDeadline(time.Now().Add(5 * time.Second))
+
+		go io.WriteString(client, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(client), nil)
+		if err != nil || resp.StatusCode != http.StatusOK {
+			t.Fatalf("Failed to establish tunnel: %v", err)
+		}
+
+		conn := tls.Client(client, &tls.Config{ServerName: serverName, RootCAs: roots, InsecureSkipVerify: serverName != "example.com"})
+		return conn, bufio.NewReader(conn)
+	}
+
+	// get sends a request for path with host as its Host header
+	get := func(conn *tls.Conn, reader *bufio.Reader, host, path string) (int, string) {
+		if _, err := io.WriteString(conn, "GET "+path+" HTTP/1.1\r\nHost: "+host+"\r\n\r\n"); err != nil {
+			t.Fatalf("Failed to send request: %v", err)
+		}
+		resp, err := http.ReadResponse(reader, nil)
+		if err != nil {
+			t.Fatalf("Failed to read response for %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	conn, reader := tunnel("example.com")
+	tests := []struct {
+		host       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"example.com", "/allowed", http.StatusOK, "upstream /allowed"},
+		{"example.com", "/blocked/page", http.StatusForbidden, "Access denied"},
+		{"allowed.example", "/blocked/page", http.StatusMisdirectedRequest, "Misdirected request"}, // Host can't dodge the filter
+		{"Example.COM.:" + port, "/after", http.StatusOK, "upstream /after"},                       // Refused requests leave the connection usable
+	}
+
+	for _, tt := range tests {
+		status, body := get(conn, reader, tt.host, tt.path)
+		if status != tt.wantStatus {
+			t.Errorf("%s%s: expected status %d, got %d", tt.host, tt.path, tt.wantStatus, status)
+		}
+		if body != tt.wantBody {
+			t.Errorf("%s%s: expected body %q, got %q", tt.host, tt.path, tt.wantBody, body)
+		}
+	}
+
+	// A server name other than the target is refused right after the handshake
+	_, reader = tunnel("allowed.example")
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMisdirectedRequest {
+		t.Errorf("Expected a mismatched server name to get %d, got %d", http.StatusMisdirectedRequest, resp.StatusCode)
+	}
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+const (
+	// Backoff bounds for retryable accept errors (e.g. too many open files)
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = 1 * time.Second
+)
+
+// pauseSwitch lets a proxy turn away new connections, closing them as soon as
+// they are accepted, while established tunnels carry on
+type pauseSwitch struct {
+	paused atomic.Bool
+}
+
+// Pause makes the proxy close new connections until Resume is called
+func (p *pauseSwitch) Pause() {
+	p.paused.Store(true)
+}
+
+// Resume makes the proxy serve new connections again
+func (p *pauseSwitch) Resume() {
+	p.paused.Store(false)
+}
+
+// Paused reports whether new connections are being turned away
+func (p *pauseSwitch) Paused() bool {
+	return p.paused.Load()
+}
+
+// acceptLoop accepts connections and hands them to handle until the listener is closed.
+// Retryable errors are retried with a capped exponential backoff so a listener in a bad
+// state doesn't spin the CPU; a closed listener returns nil.
+func acceptLoop(listener net.Listener, protocol string, handle func(net.Conn)) error {
+	var delay time.Duration
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			if retryableAcceptError(err) {
+				if delay == 0 {
+					delay = minAcceptDelay
+				} else {
+					delay *= 2
+				}
+				if delay > maxAcceptDelay {
+					delay = maxAcceptDelay
+				}
+
+				logger.Error("Failed to accept connection",
+					"protocol", protocol,
+					"error", err,
+					"retry_in", delay.String())
+				time.Sleep(delay)
+				continue
+			}
+
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		delay = 0
+		go handle(conn)
+	}
+}
+
+// retryableAcceptError reports whether an accept error is worth retrying: the
+// process or system running out of descriptors or buffers, a connection
+// aborted before it was accepted, or a timeout
+func retryableAcceptError(err error) bool {
+	if errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE) ||
+		errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM) ||
+		errors.Is(err, syscall.ECONNABORTED) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// listenAddresses returns the configured listen addresses, or port on all interfaces
+func (o *options) listenAddresses(port int) []string {
+	if len(o.listenAddrs) > 0 {
+		return o.listenAddrs
+	}
+	return []string{fmt.Sprintf(":%d", port)}
+}
+
+// openListeners returns the inherited listeners when there are any, wrapped in
+// TLS when configured, and otherwise listens on the configured addresses
+func (o *options) openListeners(port int) ([]net.Listener, error) {
+	if len(o.inherited) == 0 {
+		return o.listen(o.listenAddresses(port))
+	}
+
+	listeners := make([]net.Listener, 0, len(o.inherited))
+	for _, listener := range o.inherited {
+		if o.tlsConfig != nil {
+			listener = tls.NewListener(listener, o.tlsConfig)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// listen opens a listener on every address, wrapping each in TLS when configured.
+// If any address fails, the listeners already opened are closed.
+func (o *options) listen(addrs []string) ([]net.Listener, error) {
+	var lc net.ListenConfig
+	if o.reusePort {
+		if reusePortSupported {
+			lc.Control = reusePortControl
+		} else {
+			logger.Warn("SO_REUSEPORT is not supported on this platform, listening without it")
+		}
+	}
+
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		listener, err := lc.Listen(context.Background(), o.network, addr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+
+		if o.tlsConfig != nil {
+			listener = tls.NewListener(listener, o.tlsConfig)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// serveAll runs an accept loop per listener and returns once all of them have
+// stopped, with the first error encountered
+func serveAll(listeners []net.Listener, protocol string, handle func(net.Conn)) error {
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			if err := acceptLoop(listener, protocol, handle); err != nil {
+				once.Do(func() { firstErr = err })
+			}
+		}(listener)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// closeListeners closes every listener and returns the errors joined
+func closeListeners(listeners []net.Listener) error {
+	var errs []error
+	for _, listener := range listeners {
+		if err := listener.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// listenerAddrs returns the address of every listener
+func listenerAddrs(listeners []net.Listener) []net.Addr {
+	addrs := make([]net.Addr, 0, len(listeners))
+	for _, listener := range listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
@@ -0,0 +1,174 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAcceptLoop_ReturnsOnClose(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	handled := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- acceptLoop(listener, "test", func(conn net.Conn) {
+			conn.Close()
+			handled <- struct{}{}
+		})
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Close()
+
+	select {
+	case <-handled:
+	case <-time.After(time.Second):
+		t.Fatal("Connection was not handled")
+	}
+
+	listener.Close()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil error after close, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("acceptLoop did not return after listener was closed")
+	}
+}
+
+func TestRetryableAcceptError(t *testing.T) {
+	acceptErr := func(err error) error {
+		return &net.OpError{Op: "accept", Net: "tcp", Err: os.NewSyscallError("accept4", err)}
+	}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"too many open files", acceptErr(syscall.EMFILE), true},
+		{"file table full", acceptErr(syscall.ENFILE), true},
+		{"connection aborted", acceptErr(syscall.ECONNABORTED), true},
+		{"timeout", &net.OpError{Op: "accept", Net: "tcp", Err: os.ErrDeadlineExceeded}, true},
+		{"invalid argument", acceptErr(syscall.EINVAL), false},
+		{"other error", errors.New("listener broken"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryableAcceptError(tt.err); got != tt.want {
+				t.Errorf("retryableAcceptError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTTPProxy_MultipleListenAddresses(t *testing.T) {
+	h := NewHTTPProxy(0, WithListenAddresses([]string{"127.0.0.1:0", "127.0.0.1:0"}))
+
+	done := make(chan error, 1)
+	go func() { done <- h.Start() }()
+
+	// Wait for both listeners to be up
+	deadline := time.Now().Add(time.Second)
+	for len(h.Addrs()) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("Listeners did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, addr := range h.Addrs() {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatalf("Failed to dial %s: %v", addr, err)
+		}
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: proxy\r\n\r\n"))
+		reply := make([]byte, 12)
+		if _, err := io.ReadFull(conn, reply); err != nil || string(reply) != "HTTP/1.1 400" {
+			t.Errorf("Expected the landing response from %s, got %q (%v)", addr, reply, err)
+		}
+		conn.Close()
+	}
+
+	if err := h.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil after Stop, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return after Stop closed every listener")
+	}
+}
+
+func TestListen_ClosesOpenedOnFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer taken.Close()
+
+	// Reserve a free port for the first address
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	free := probe.Addr().String()
+	probe.Close()
+
+	o := newOptions(nil)
+	if _, err := o.listen([]string{free, taken.Addr().String()}); err == nil {
+		t.Fatal("Expected an error when an address is in use")
+	}
+
+	// The listener opened on the free address must have been released
+	again, err := net.Listen("tcp", free)
+	if err != nil {
+		t.Fatalf("Expected %s to be released: %v", free, err)
+	}
+	again.Close()
+}
+
+func TestListen_ReusePort(t *testing.T) {
+	if !reusePortSupported {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+
+	o := newOptions([]Option{WithReusePort(true)})
+	first, err := o.listen([]string{"127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer closeListeners(first)
+
+	// A second instance can bind the same address during a handoff
+	second, err := o.listen([]string{first[0].Addr().String()})
+	if err != nil {
+		t.Fatalf("Expected a second listener on %s: %v", first[0].Addr(), err)
+	}
+	closeListeners(second)
+
+	// Without the option the address stays exclusive
+	exclusive := newOptions(nil)
+	if l, err := exclusive.listen([]string{first[0].Addr().String()}); err == nil {
+		closeListeners(l)
+		t.Error("Expected a listener without SO_REUSEPORT to fail")
+	}
+}
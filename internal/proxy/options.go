@@ -0,0 +1,650 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/events"
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Option configures an HTTP or SOCKS5 proxy
+type Option func(*options)
+
+// options holds the settings shared by both proxies. Protocol-specific
+// settings are ignored by the proxy that doesn't use them.
+type options struct {
+	network           string         // 网络类型: "tcp", "tcp4", "tcp6"
+	listenAddrs       []string       // Listen on these address:port endpoints instead of the port on all interfaces
+	reusePort         bool           // Set SO_REUSEPORT so another process can share the listen addresses
+	inherited         []net.Listener // Serve these instead of binding, e.g. from socket activation
+	proxyProtocol     int            // PROXY protocol version written to targets, 0 disables
+	dialTimeout       time.Duration
+	connTimeout       time.Duration // Max lifetime of a client connection, zero means unlimited
+	dialer            Dialer
+	handshakeTimeout  time.Duration     // Deadline for reading the SOCKS5 greeting
+	readTimeout       time.Duration     // Longest a tunnel's client may send nothing, zero means unlimited
+	writeTimeout      time.Duration     // Longest a write to a tunnel's client may block, zero means unlimited
+	authTimeout       time.Duration     // Deadline for receiving credentials when auth is enabled
+	tlsConfig         *tls.Config       // Serve TLS on the listener when set
+	logTLSHandshakes  bool              // Log negotiated TLS parameters at info instead of debug
+	debugIPs          *manager.DebugIPs // Clients whose connections log debug messages whatever the level, nil for none
+	logClientPort     bool              // Add the client's source port to connection logs as client_port
+	handshakes        chan struct{}     // Semaphore for in-progress handshakes, nil means unlimited
+	handshakeQueue    *handshakeQueue   // Connections waiting for a handshake slot, nil rejects them at once
+	rejectWithRST     bool              // Reset connections rejected for bans, rate limits or the circuit breaker instead of closing them gracefully
+	auth              *middleware.AuthMiddleware
+	rateLimit         *middleware.RateLimitMiddleware
+	ipBan             *middleware.IPBanMiddleware
+	authDelay         *middleware.AuthDelayMiddleware
+	circuitBreaker    *middleware.CircuitBreakerMiddleware
+	scanDetect        *middleware.ScanDetectMiddleware
+	targetBreaker     *middleware.TargetBreakerMiddleware
+	ssrfGuard         *middleware.SSRFGuardMiddleware
+	targetCategory    *middleware.TargetCategoryMiddleware
+	anonymousUser     string // Username logged for connections without authentication
+	conns             *manager.ConnRegistry
+	targetStats       *manager.TargetStats      // Counts connections and bytes per target host, nil disables
+	events            []events.Publisher        // Receive connection open, close and auth failure events
+	userLog           *events.UserLog           // Records each closed connection in its user's file, nil disables
+	ipBandwidth       *manager.BandwidthLimiter // Byte-rate limit shared by a client IP's connections, nil disables
+	userBandwidth     *manager.BandwidthLimiter // Byte-rate limit shared by a user's connections, nil disables
+	userConns         *manager.ConnLimiter      // Caps each authenticated user's simultaneous connections, nil disables
+	targetConns       *manager.ConnLimiter      // Caps the simultaneous connections to each target host, nil disables
+	anonymousLimits   *AnonymousLimits          // Stricter limits for connections without authentication, nil disables
+	certUsernameMatch bool                      // Require usernames on TLS connections to equal the client certificate's common name
+	hostOverrides     *manager.HostOverrides    // Addresses dialed instead of resolving matching hosts, nil disables
+
+	// HTTP proxy only
+	landingStatus    int          // Status returned to non-proxy requests
+	landingBody      string       // Body returned to non-proxy requests
+	connectFailure   response     // Response to a CONNECT whose target cannot be reached
+	transparent      bool         // Forward origin-form requests using the Host header
+	connectOnly      bool         // Refuse plain HTTP requests, allowing only CONNECT tunnels
+	compress         bool         // Gzip compressible responses for clients that accept it
+	logFullURL       bool         // Log paths and query strings at info level instead of only at debug
+	maxResponseBytes int64        // Close the connection once a response exceeds this, zero means unlimited
+	realm            string       // Realm advertised in 407 responses
+	maxAuthAttempts  int          // 407s answered on one connection before it is closed, at most 1 closes after the first
+	serverHeader     string       // Server header on responses the proxy generates, empty omits it
+	interceptor      *Interceptor // Decrypts CONNECT tunnels to filter their requests, nil disables
+	jsonErrors       bool         // Send generated errors as JSON bodies instead of plain text
+
+	// SOCKS5 proxy only
+	resolveExtension   bool // Answer Tor RESOLVE/RESOLVE_PTR commands
+	preserveSourcePort bool // Dial targets from the client's source port when it is free
+	zeroReplyAddress   bool // Answer CONNECT with 0.0.0.0:0 instead of the bound address
+	policyDenyReply    byte // Reply code for targets the SSRF guard rejects
+}
+
+// newOptions returns the defaults with every middleware disabled, then applies opts
+func newOptions(opts []Option) options {
+	o := options{
+		network:          "tcp",
+		dialTimeout:      10 * time.Second,
+		dialer:           &directDialer{},
+		handshakeTimeout: 10 * time.Second,
+		authTimeout:      10 * time.Second,
+		auth:             middleware.NewAuthMiddleware(false, nil),
+		rateLimit:        middleware.NewRateLimitMiddleware(false, 0, 0),
+		ipBan:            middleware.NewIPBanMiddleware(false, nil),
+		authDelay:        middleware.NewAuthDelayMiddleware(false, nil),
+		circuitBreaker:   middleware.NewCircuitBreakerMiddleware(false, nil),
+		scanDetect:       middleware.NewScanDetectMiddleware(false, nil, nil, false),
+		targetBreaker:    middleware.NewTargetBreakerMiddleware(false, nil),
+		ssrfGuard:        middleware.NewSSRFGuardMiddleware(false, nil),
+		targetCategory:   middleware.NewTargetCategoryMiddleware(false, nil, ""),
+		anonymousUser:    "anonymous",
+		conns:            manager.NewConnRegistry(),
+		landingStatus:    http.StatusBadRequest,
+		landingBody:      "Bad Request: this is a proxy",
+		connectFailure:   response{status: http.StatusBadGateway, body: "Failed to connect to target"},
+		realm:            "DuDu Proxy",
+		policyDenyReply:  repConnectionNotAllowed,
+	}
+
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// WithAnonymousUser sets the username logged for connections when authentication is disabled
+func WithAnonymousUser(label string) Option {
+	return func(o *options) {
+		o.anonymousUser = label
+	}
+}
+
+// connContext derives the context for a client connection from parent,
+// bounded by the connection timeout when one is set
+func (o *options) connContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if o.connTimeout > 0 {
+		return context.WithTimeout(parent, o.connTimeout)
+	}
+	return context.WithCancel(parent)
+}
+
+// debugLog returns the function debug messages about clientIP's connection
+// are logged with: logger.ForceDebug for debug IPs, logger.Debug otherwise
+func (o *options) debugLog(clientIP string) func(msg string, keysAndValues ...interface{}) {
+	if o.debugIPs != nil && o.debugIPs.Contains(clientIP) {
+		return logger.ForceDebug
+	}
+	return logger.Debug
+}
+
+// WithDebugIPs logs debug messages about connections from the clients in
+// debugIPs whatever the configured log level
+func WithDebugIPs(debugIPs *manager.DebugIPs) Option {
+	return func(o *options) {
+		o.debugIPs = debugIPs
+	}
+}
+
+// WithClientPortLogging controls whether connection logs include the client's
+// source port as client_port, for correlating with firewall or NAT logs.
+// Middleware keeps tracking clients by IP only.
+func WithClientPortLogging(enabled bool) Option {
+	return func(o *options) {
+		o.logClientPort = enabled
+	}
+}
+
+// loggedClientPort returns the source port of conn to log as client_port, or
+// 0 when client ports are not logged
+func (o *options) loggedClientPort(conn io.ReadWriteCloser) int {
+	if !o.logClientPort {
+		return 0
+	}
+	return clientPort(conn)
+}
+
+// withClientPort appends the client_port field to fields unless port is 0
+func withClientPort(fields []interface{}, port int) []interface{} {
+	if port == 0 {
+		return fields
+	}
+	return append(fields, "client_port", port)
+}
+
+// WithNetwork sets the network used for listening and dialing ("tcp", "tcp4" or "tcp6")
+func WithNetwork(network string) Option {
+	return func(o *options) {
+		o.network = network
+	}
+}
+
+// WithListenAddresses makes the proxy listen on every address:port endpoint,
+// sharing the same handlers and middleware, instead of its port on all interfaces
+func WithListenAddresses(addrs []string) Option {
+	return func(o *options) {
+		o.listenAddrs = addrs
+	}
+}
+
+// WithListeners makes the proxy serve already-open listeners, such as sockets
+// inherited through systemd socket activation, instead of binding its own.
+// An empty slice keeps normal binding.
+func WithListeners(listeners []net.Listener) Option {
+	return func(o *options) {
+		o.inherited = listeners
+	}
+}
+
+// WithProxyProtocol writes a PROXY protocol header of version 1 or 2 as the
+// first bytes on every target connection, so the target sees the client's
+// address instead of the proxy's. Zero disables it.
+func WithProxyProtocol(version int) Option {
+	return func(o *options) {
+		o.proxyProtocol = version
+	}
+}
+
+// WithDialTimeout sets the timeout for connecting to targets
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.dialTimeout = timeout
+	}
+}
+
+// WithConnectionTimeout limits how long a client connection, including its
+// tunnel, may stay open. Zero means unlimited.
+func WithConnectionTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.connTimeout = timeout
+	}
+}
+
+// WithTransferTimeouts bounds a tunnel's client connection once the tunnel
+// is open: it closes when the client sends nothing for the read timeout or a
+// write to it blocks for the write timeout. Zero leaves either unbounded.
+func WithTransferTimeouts(read, write time.Duration) Option {
+	return func(o *options) {
+		o.readTimeout = read
+		o.writeTimeout = write
+	}
+}
+
+// WithReusePort sets SO_REUSEPORT on the listeners so a new process can bind
+// the same addresses before this one exits. Ignored with a warning on
+// platforms without SO_REUSEPORT.
+func WithReusePort(enabled bool) Option {
+	return func(o *options) {
+		o.reusePort = enabled
+	}
+}
+
+// WithDialer sets the dialer used to connect to targets
+func WithDialer(dialer Dialer) Option {
+	return func(o *options) {
+		o.dialer = dialer
+	}
+}
+
+// WithSourceIPs binds connections to targets to the given local addresses,
+// rotating through them per connection. Empty keeps the system's choice.
+func WithSourceIPs(ips []net.IP) Option {
+	return func(o *options) {
+		if len(ips) > 0 {
+			o.dialer = &sourceIPDialer{sources: ips}
+		}
+	}
+}
+
+// WithHandshakeTimeout sets how long a SOCKS5 client has to send its version
+// and authentication methods before the connection is closed
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.handshakeTimeout = timeout
+	}
+}
+
+// WithAuthTimeout sets how long a client has to send complete credentials
+// when authentication is enabled: the SOCKS5 username/password exchange, or
+// the HTTP request carrying Proxy-Authorization. Clients that stall are
+// disconnected and counted as failed authentications. Zero means unlimited.
+func WithAuthTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.authTimeout = timeout
+	}
+}
+
+// WithTLS makes the proxy listener terminate TLS using tlsConfig
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.tlsConfig = tlsConfig
+	}
+}
+
+// WithTLSHandshakeLogging logs the negotiated version, cipher suite and server
+// name of every TLS handshake at info level instead of debug
+func WithTLSHandshakeLogging(enabled bool) Option {
+	return func(o *options) {
+		o.logTLSHandshakes = enabled
+	}
+}
+
+// WithMaxHandshakes bounds how many connections may be in the handshake/request
+// phase at once. Connections over the limit are closed immediately. Zero means unlimited.
+func WithMaxHandshakes(max int) Option {
+	return func(o *options) {
+		if max > 0 {
+			o.handshakes = make(chan struct{}, max)
+		} else {
+			o.handshakes = nil
+		}
+	}
+}
+
+// WithHandshakeQueue lets up to size connections wait for a handshake slot
+// when WithMaxHandshakes' limit is reached, instead of closing them at once.
+// policy (QueueDropNewest, QueueDropOldest or QueueReject) decides which
+// connection is turned away when the queue is full. Zero size disables it.
+func WithHandshakeQueue(size int, policy string) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.handshakeQueue = newHandshakeQueue(size, policy)
+		} else {
+			o.handshakeQueue = nil
+		}
+	}
+}
+
+// WithRejectWithRST closes connections rejected for an IP ban, a rate limit or
+// an open circuit breaker with a TCP RST instead of a FIN, so the proxy keeps
+// no TIME_WAIT state for clients it is turning away. A response already
+// written may be discarded before it reaches the client.
+func WithRejectWithRST(enabled bool) Option {
+	return func(o *options) {
+		o.rejectWithRST = enabled
+	}
+}
+
+// WithAuth sets the authentication middleware
+func WithAuth(auth *middleware.AuthMiddleware) Option {
+	return func(o *options) {
+		o.auth = auth
+	}
+}
+
+// WithRateLimit sets the rate limit middleware
+func WithRateLimit(rateLimit *middleware.RateLimitMiddleware) Option {
+	return func(o *options) {
+		o.rateLimit = rateLimit
+	}
+}
+
+// WithIPBan sets the IP ban middleware
+func WithIPBan(ipBan *middleware.IPBanMiddleware) Option {
+	return func(o *options) {
+		o.ipBan = ipBan
+	}
+}
+
+// WithAuthDelay sets the middleware that holds back failed authentication responses
+func WithAuthDelay(authDelay *middleware.AuthDelayMiddleware) Option {
+	return func(o *options) {
+		o.authDelay = authDelay
+	}
+}
+
+// WithCircuitBreaker sets the circuit breaker middleware
+func WithCircuitBreaker(circuitBreaker *middleware.CircuitBreakerMiddleware) Option {
+	return func(o *options) {
+		o.circuitBreaker = circuitBreaker
+	}
+}
+
+// WithScanDetect sets the scan detection middleware
+func WithScanDetect(scanDetect *middleware.ScanDetectMiddleware) Option {
+	return func(o *options) {
+		o.scanDetect = scanDetect
+	}
+}
+
+// WithTargetBreaker sets the per-target circuit breaker middleware used when dialing
+func WithTargetBreaker(targetBreaker *middleware.TargetBreakerMiddleware) Option {
+	return func(o *options) {
+		o.targetBreaker = targetBreaker
+	}
+}
+
+// WithConnRegistry sets the registry established connections are tracked in
+func WithConnRegistry(conns *manager.ConnRegistry) Option {
+	return func(o *options) {
+		o.conns = conns
+	}
+}
+
+// WithTargetStats counts established connections and their bytes per target host
+func WithTargetStats(stats *manager.TargetStats) Option {
+	return func(o *options) {
+		o.targetStats = stats
+	}
+}
+
+// WithBandwidthLimits paces each client's traffic through byte-rate limits
+// shared by all connections from the same IP and, when authentication is
+// enabled, of the same user. Either limiter may be nil.
+func WithBandwidthLimits(perIP, perUser *manager.BandwidthLimiter) Option {
+	return func(o *options) {
+		o.ipBandwidth = perIP
+		o.userBandwidth = perUser
+	}
+}
+
+// WithCertUsernameMatch requires clients on TLS connections that present a
+// verified client certificate to authenticate as the certificate's common name
+func WithCertUsernameMatch(enabled bool) Option {
+	return func(o *options) {
+		o.certUsernameMatch = enabled
+	}
+}
+
+// WithMaxConnsPerTarget caps the simultaneous connections to each target host
+// with limiter, keyed by host without the port. Share one limiter between the
+// proxies so the cap spans both listeners.
+func WithMaxConnsPerTarget(limiter *manager.ConnLimiter) Option {
+	return func(o *options) {
+		o.targetConns = limiter
+	}
+}
+
+// WithMaxConnsPerUser caps the simultaneous connections of each authenticated
+// user, whatever IPs they come from; nil disables the cap
+func WithMaxConnsPerUser(limiter *manager.ConnLimiter) Option {
+	return func(o *options) {
+		o.userConns = limiter
+	}
+}
+
+// WithHostOverrides dials the pinned address of targets whose host matches
+// one of overrides' rules instead of resolving it
+func WithHostOverrides(overrides *manager.HostOverrides) Option {
+	return func(o *options) {
+		o.hostOverrides = overrides
+	}
+}
+
+// WithEventStream publishes an event when each connection opens and closes
+// and when a client fails to authenticate
+func WithEventStream(stream *events.Stream) Option {
+	return func(o *options) {
+		if stream != nil {
+			o.events = append(o.events, stream)
+		}
+	}
+}
+
+// WithEventQueue publishes the same events as WithEventStream to a message queue
+func WithEventQueue(queue *events.NATS) Option {
+	return func(o *options) {
+		if queue != nil {
+			o.events = append(o.events, queue)
+		}
+	}
+}
+
+// WithUserLog records each connection in its user's log file when it closes
+func WithUserLog(log *events.UserLog) Option {
+	return func(o *options) {
+		o.userLog = log
+	}
+}
+
+// WithSSRFGuard sets the guard that keeps targets from resolving to internal addresses
+func WithSSRFGuard(ssrfGuard *middleware.SSRFGuardMiddleware) Option {
+	return func(o *options) {
+		o.ssrfGuard = ssrfGuard
+	}
+}
+
+// WithTargetCategories sets the rules that tag each connection's logs and
+// metrics with a category derived from its target
+func WithTargetCategories(targetCategory *middleware.TargetCategoryMiddleware) Option {
+	return func(o *options) {
+		o.targetCategory = targetCategory
+	}
+}
+
+// WithLandingResponse sets the response returned to requests that are not proxy
+// requests, e.g. a browser or scanner sending "GET /" directly to the proxy port (HTTP only)
+func WithLandingResponse(status int, body string) Option {
+	return func(o *options) {
+		o.landingStatus = status
+		o.landingBody = body
+	}
+}
+
+// WithConnectFailureResponse sets the response sent when a CONNECT target
+// cannot be reached, for clients that mishandle the default 502. headers are
+// added to the response, and a Content-Type among them replaces text/plain (HTTP only).
+func WithConnectFailureResponse(status int, body string, headers map[string]string) Option {
+	return func(o *options) {
+		o.connectFailure = response{status: status, body: body, headers: headers}
+	}
+}
+
+// WithTransparent controls whether origin-form requests ("GET /path" with a Host header)
+// are forwarded to their Host. When disabled they receive the landing response (HTTP only).
+func WithTransparent(transparent bool) Option {
+	return func(o *options) {
+		o.transparent = transparent
+	}
+}
+
+// WithConnectOnly refuses to proxy plain HTTP requests with a 403, so only
+// CONNECT tunnels, usually carrying TLS, are allowed (HTTP only)
+func WithConnectOnly(enabled bool) Option {
+	return func(o *options) {
+		o.connectOnly = enabled
+	}
+}
+
+// WithCompression controls whether compressible upstream responses are gzip-compressed
+// for clients whose Accept-Encoding allows it (HTTP only, plain HTTP requests)
+func WithCompression(compress bool) Option {
+	return func(o *options) {
+		o.compress = compress
+	}
+}
+
+// WithFullURLLogging controls whether proxied request URLs are logged in full
+// at info level. Otherwise info logs only the scheme and host and the full URL
+// is logged at debug, keeping tokens in query strings out of access logs (HTTP only).
+func WithFullURLLogging(enabled bool) Option {
+	return func(o *options) {
+		o.logFullURL = enabled
+	}
+}
+
+// WithMaxResponseBytes caps the bytes, headers included, relayed from the
+// target for a plain HTTP request. Larger responses are cut off and the
+// connection closed. Zero means unlimited (HTTP only, CONNECT tunnels are not capped).
+func WithMaxResponseBytes(n int64) Option {
+	return func(o *options) {
+		o.maxResponseBytes = n
+	}
+}
+
+// WithRealm sets the realm advertised in 407 responses. An empty realm is
+// sent as realm="" so clients still prompt for credentials.
+func WithRealm(realm string) Option {
+	return func(o *options) {
+		o.realm = realm
+	}
+}
+
+// WithMaxAuthAttempts lets a client retry authentication on the same
+// connection after a 407, until it has failed n times
+func WithMaxAuthAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAuthAttempts = n
+	}
+}
+
+// WithServerHeader adds a Server header with value to the responses the HTTP
+// proxy generates itself, such as errors and 407s. Empty omits the header.
+func WithServerHeader(value string) Option {
+	return func(o *options) {
+		o.serverHeader = value
+	}
+}
+
+// WithJSONErrors sends the errors the proxy generates, such as 403s and 407s,
+// as JSON bodies carrying the connection ID for programmatic clients (HTTP only)
+func WithJSONErrors(enabled bool) Option {
+	return func(o *options) {
+		o.jsonErrors = enabled
+	}
+}
+
+// WithInterception decrypts HTTPS inside CONNECT tunnels with i so their
+// requests can be filtered. HTTP proxy only; nil disables it.
+func WithInterception(i *Interceptor) Option {
+	return func(o *options) {
+		o.interceptor = i
+	}
+}
+
+// WithSOCKS5Upstream sends every connection to a target through the SOCKS5
+// proxy at address, authenticating with username and password when username is
+// set. The upstream is reached with the dialer configured before this option,
+// over TLS when tlsConfig is set.
+func WithSOCKS5Upstream(address, username, password string, tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.dialer = &socks5Dialer{
+			address:  address,
+			username: username,
+			password: password,
+			forward:  upstreamForward(o.dialer, tlsConfig),
+		}
+	}
+}
+
+// WithHTTPUpstream sends every connection to a target through the HTTP proxy
+// at address using CONNECT, with Basic credentials when username is set. The
+// upstream is reached with the dialer configured before this option, over TLS
+// when tlsConfig is set.
+func WithHTTPUpstream(address, username, password string, tlsConfig *tls.Config) Option {
+	return func(o *options) {
+		o.dialer = &httpDialer{
+			address:  address,
+			username: username,
+			password: password,
+			forward:  upstreamForward(o.dialer, tlsConfig),
+		}
+	}
+}
+
+// upstreamForward returns the dialer connecting to an upstream proxy, wrapping
+// forward in TLS when tlsConfig is set
+func upstreamForward(forward Dialer, tlsConfig *tls.Config) Dialer {
+	if tlsConfig == nil {
+		return forward
+	}
+	return &tlsDialer{config: tlsConfig, forward: forward}
+}
+
+// WithResolveExtension enables Tor's nonstandard RESOLVE (0xF0) and RESOLVE_PTR (0xF1)
+// commands, which ask the proxy to perform DNS lookups (SOCKS5 only)
+func WithResolveExtension(enabled bool) Option {
+	return func(o *options) {
+		o.resolveExtension = enabled
+	}
+}
+
+// WithSourcePortPreservation dials each target from the same local port the
+// client connected from, falling back to an ephemeral port when it is taken.
+// Dialers set with WithDialer decide for themselves (SOCKS5 only).
+func WithSourcePortPreservation(enabled bool) Option {
+	return func(o *options) {
+		o.preserveSourcePort = enabled
+	}
+}
+
+// WithPolicyDenyReplyCode sets the reply code sent when the SSRF guard
+// rejects a target, such as 0x04 (host unreachable) to make denied targets
+// indistinguishable from unreachable ones (SOCKS5 only)
+func WithPolicyDenyReplyCode(code byte) Option {
+	return func(o *options) {
+		o.policyDenyReply = code
+	}
+}
+
+// WithZeroReplyAddress answers successful CONNECT requests with the IPv4
+// address 0.0.0.0:0 instead of the bound address, for clients that can't
+// parse IPv6 or non-zero bound addresses (SOCKS5 only)
+func WithZeroReplyAddress(enabled bool) Option {
+	return func(o *options) {
+		o.zeroReplyAddress = enabled
+	}
+}
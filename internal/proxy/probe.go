@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Probe opens a CONNECT tunnel to target through the proxy's own listener, as
+// a client would, to check that requests are actually served end to end.
+// Empty credentials skip authentication.
+func (h *HTTPProxy) Probe(ctx context.Context, target, username, password string) error {
+	conn, err := h.dialSelf(ctx, h.Addrs())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: target},
+		Host:   target,
+		Header: make(http.Header),
+	}
+	if username != "" {
+		req.Header.Set("Proxy-Authorization", "Basic "+
+			base64.StdEncoding.EncodeToString([]byte(username+":"+password)))
+	}
+
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("failed to read CONNECT response: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("CONNECT %s returned %s", target, resp.Status)
+	}
+
+	return nil
+}
+
+// Probe opens a SOCKS5 tunnel to target through the proxy's own listener, as
+// a client would, to check that requests are actually served end to end.
+// Empty credentials offer only the no-authentication method.
+func (s *SOCKS5Proxy) Probe(ctx context.Context, target, username, password string) error {
+	conn, err := s.dialSelf(ctx, s.Addrs())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return socks5Connect(conn, target, username, password)
+}
+
+// dialSelf connects to the first listen address, using loopback for a
+// wildcard address, and bounds the connection by ctx
+func (o *options) dialSelf(ctx context.Context, addrs []net.Addr) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("proxy is not listening")
+	}
+
+	tcpAddr, ok := addrs[0].(*net.TCPAddr)
+	if !ok {
+		return nil, fmt.Errorf("unexpected listen address %s", addrs[0])
+	}
+	ip := tcpAddr.IP
+	if ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+		if tcpAddr.IP.To4() == nil && o.network == "tcp6" {
+			ip = net.IPv6loopback
+		}
+	}
+	address := net.JoinHostPort(ip.String(), strconv.Itoa(tcpAddr.Port))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Now().Add(o.dialTimeout))
+	}
+
+	if o.tlsConfig != nil {
+		// The certificate is ours and its names needn't cover the loopback address
+		conn = tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+// prober is implemented by both proxies
+type prober interface {
+	Start() error
+	Stop() error
+	Addrs() []net.Addr
+	Probe(ctx context.Context, target, username, password string) error
+}
+
+// startProxy starts p on a loopback port and stops it when the test ends
+func startProxy(t *testing.T, p prober) {
+	t.Helper()
+
+	go p.Start()
+	t.Cleanup(func() { p.Stop() })
+
+	deadline := time.Now().Add(time.Second)
+	for len(p.Addrs()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Proxy did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	newAuth := func() Option {
+		return WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"probe": "secret"}))
+	}
+	listen := WithListenAddresses([]string{"0.0.0.0:0"})
+
+	tests := []struct {
+		name     string
+		proxy    prober
+		username string
+		password string
+		wantErr  bool
+	}{
+		{"http", NewHTTPProxy(0, listen, WithDialer(&echoDialer{})), "", "", false},
+		{"http with auth", NewHTTPProxy(0, listen, WithDialer(&echoDialer{}), newAuth()), "probe", "secret", false},
+		{"http wrong password", NewHTTPProxy(0, listen, WithDialer(&echoDialer{}), newAuth()), "probe", "wrong", true},
+		{"http dial failure", NewHTTPProxy(0, listen, WithDialer(blockingDialer{}), WithDialTimeout(10*time.Millisecond)), "", "", true},
+		{"socks5", NewSOCKS5Proxy(0, listen, WithDialer(&echoDialer{})), "", "", false},
+		{"socks5 with auth", NewSOCKS5Proxy(0, listen, WithDialer(&echoDialer{}), newAuth()), "probe", "secret", false},
+		{"socks5 wrong password", NewSOCKS5Proxy(0, listen, WithDialer(&echoDialer{}), newAuth()), "probe", "wrong", true},
+		{"socks5 missing credentials", NewSOCKS5Proxy(0, listen, WithDialer(&echoDialer{}), newAuth()), "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			startProxy(t, tt.proxy)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer cancel()
+
+			err := tt.proxy.Probe(ctx, "example.com:443", tt.username, tt.password)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProbe_NotListening(t *testing.T) {
+	h := NewHTTPProxy(0)
+	if err := h.Probe(context.Background(), "example.com:443", "", ""); err == nil {
+		t.Error("Expected an error when the proxy is not listening")
+	}
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+)
+
+// proxyV2Signature starts every PROXY protocol version 2 header
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// PROXY protocol version 2 command and address family bytes
+const (
+	proxyV2Proxy  = 0x21 // Version 2, PROXY command
+	proxyV2TCP4   = 0x11 // TCP over IPv4
+	proxyV2TCP6   = 0x21 // TCP over IPv6
+	proxyV2Unspec = 0x00 // Unknown family, the receiver uses the real endpoints
+)
+
+// connectTarget dials target and, when PROXY protocol emission is enabled,
+// writes the header describing clientConn as the first bytes on the connection
+func (o *options) connectTarget(ctx context.Context, target string, clientConn io.ReadWriteCloser) (net.Conn, error) {
+	targetConn, err := o.dial(ctx, target)
+	if err != nil || o.proxyProtocol == 0 {
+		return targetConn, err
+	}
+
+	var src, dst net.Addr
+	if addrs, ok := clientConn.(interface {
+		RemoteAddr() net.Addr
+		LocalAddr() net.Addr
+	}); ok {
+		src, dst = addrs.RemoteAddr(), addrs.LocalAddr()
+	}
+
+	if _, err := targetConn.Write(appendProxyHeader(nil, o.proxyProtocol, src, dst)); err != nil {
+		targetConn.Close()
+		return nil, fmt.Errorf("failed to send PROXY protocol header: %w", err)
+	}
+	return targetConn, nil
+}
+
+// appendProxyHeader appends a PROXY protocol header of version 1 or 2 for a
+// connection from src to dst. Endpoints that aren't both TCP are sent as
+// unknown; an IPv4 endpoint paired with an IPv6 one is sent IPv4-mapped.
+func appendProxyHeader(b []byte, version int, src, dst net.Addr) []byte {
+	srcTCP, srcOK := src.(*net.TCPAddr)
+	dstTCP, dstOK := dst.(*net.TCPAddr)
+	known := srcOK && dstOK
+	ipv4 := known && srcTCP.IP.To4() != nil && dstTCP.IP.To4() != nil
+
+	if version == 1 {
+		switch {
+		case !known:
+			return append(b, "PROXY UNKNOWN\r\n"...)
+		case ipv4:
+			b = append(b, "PROXY TCP4 "...)
+			b = append(b, srcTCP.IP.To4().String()+" "+dstTCP.IP.To4().String()...)
+		default:
+			b = append(b, "PROXY TCP6 "...)
+			// netip keeps an IPv4-mapped address in IPv6 form, which TCP6 requires
+			src, dst := netip.AddrFrom16([16]byte(srcTCP.IP.To16())), netip.AddrFrom16([16]byte(dstTCP.IP.To16()))
+			b = append(b, src.String()+" "+dst.String()...)
+		}
+		return append(b, " "+strconv.Itoa(srcTCP.Port)+" "+strconv.Itoa(dstTCP.Port)+"\r\n"...)
+	}
+
+	b = append(b, proxyV2Signature...)
+	b = append(b, proxyV2Proxy)
+	switch {
+	case !known:
+		return append(b, proxyV2Unspec, 0, 0)
+	case ipv4:
+		b = append(b, proxyV2TCP4, 0, 12)
+		b = append(b, srcTCP.IP.To4()...)
+		b = append(b, dstTCP.IP.To4()...)
+	default:
+		b = append(b, proxyV2TCP6, 0, 36)
+		b = append(b, srcTCP.IP.To16()...)
+		b = append(b, dstTCP.IP.To16()...)
+	}
+	b = binary.BigEndian.AppendUint16(b, uint16(srcTCP.Port))
+	return binary.BigEndian.AppendUint16(b, uint16(dstTCP.Port))
+}
@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestAppendProxyHeader(t *testing.T) {
+	v4src := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}
+	v4dst := &net.TCPAddr{IP: net.ParseIP("198.51.100.2"), Port: 8080}
+	v6src := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}
+	v6dst := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 8080, Zone: "eth0"}
+
+	v2 := func(rest ...byte) []byte {
+		return append(append([]byte("\r\n\r\n\x00\r\nQUIT\n"), 0x21), rest...)
+	}
+
+	tests := []struct {
+		name     string
+		version  int
+		src, dst net.Addr
+		want     []byte
+	}{
+		{"v1 ipv4", 1, v4src, v4dst, []byte("PROXY TCP4 192.0.2.1 198.51.100.2 56324 8080\r\n")},
+		{"v1 ipv6", 1, v6src, v6dst, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 8080\r\n")},
+		{"v1 mixed families", 1, v4src, v6dst, []byte("PROXY TCP6 ::ffff:192.0.2.1 2001:db8::2 56324 8080\r\n")},
+		{"v1 unknown", 1, nil, v4dst, []byte("PROXY UNKNOWN\r\n")},
+		{"v2 ipv4", 2, v4src, v4dst, v2(0x11, 0, 12,
+			192, 0, 2, 1, 198, 51, 100, 2, 0xdc, 0x04, 0x1f, 0x90)},
+		{"v2 unknown", 2, &net.UnixAddr{Name: "/tmp/sock"}, v4dst, v2(0x00, 0, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := appendProxyHeader(nil, tt.version, tt.src, tt.dst); !bytes.Equal(got, tt.want) {
+				t.Errorf("appendProxyHeader() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("v2 ipv6 length", func(t *testing.T) {
+		got := appendProxyHeader(nil, 2, v6src, v6dst)
+		if len(got) != 16+36 || got[13] != 0x21 || got[15] != 36 {
+			t.Errorf("Unexpected IPv6 header %x", got)
+		}
+	})
+}
+
+func TestConnectTarget_ProxyProtocol(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf, _ := io.ReadAll(conn)
+		received <- string(buf)
+	}()
+
+	client := &stubAddrConn{
+		remote: &net.TCPAddr{IP: net.ParseIP("203.0.113.7"), Port: 40000},
+		local:  &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 8080},
+	}
+	o := newOptions([]Option{WithNetwork("tcp4"), WithProxyProtocol(1)})
+	targetConn, err := o.connectTarget(context.Background(), ln.Addr().String(), client)
+	if err != nil {
+		t.Fatalf("connectTarget() error = %v", err)
+	}
+	targetConn.Write([]byte("hello"))
+	targetConn.Close()
+
+	want := "PROXY TCP4 203.0.113.7 127.0.0.1 40000 8080\r\nhello"
+	if got := <-received; got != want {
+		t.Errorf("Target received %q, want %q", got, want)
+	}
+}
+
+// stubAddrConn is a client connection that only reports its addresses
+type stubAddrConn struct {
+	io.ReadWriteCloser
+	remote, local net.Addr
+}
+
+func (c *stubAddrConn) RemoteAddr() net.Addr { return c.remote }
+func (c *stubAddrConn) LocalAddr() net.Addr  { return c.local }
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// rejectReason names the connection-level check that turned a client away
+type rejectReason string
+
+// Reasons reported by admit and in dudu_rejections_total
+const (
+	rejectNone            rejectReason = ""
+	rejectCircuitBreaker  rejectReason = "circuit_breaker"
+	rejectIPBan           rejectReason = "ip_ban"
+	rejectRateLimitGlobal rejectReason = "rate_limit_global"
+	rejectRateLimitPerIP  rejectReason = "rate_limit_per_ip"
+	rejectBudget          rejectReason = "budget_exhausted"
+	rejectRateLimitDown   rejectReason = "rate_limit_unavailable" // The shared rate limit backend failed while set to fail closed
+	rejectMaxHandshakes   rejectReason = "max_handshakes"
+	rejectPaused          rejectReason = "paused"
+	rejectQueueFull       rejectReason = "queue_full"    // The handshake queue was full
+	rejectQueueEvicted    rejectReason = "queue_evicted" // Displaced from the handshake queue by a newer connection
+	rejectQueueTimeout    rejectReason = "queue_timeout" // The connection ended while waiting in the handshake queue
+)
+
+// connIDs numbers accepted connections so a rejection can be correlated
+var connIDs atomic.Uint64
+
+// nextConnID returns the ID of a newly accepted connection
+func nextConnID() uint64 {
+	return connIDs.Add(1)
+}
+
+// admit runs the checks made before reading anything from a client, in
+// order, and returns the reason of the first one that rejects it
+func (o *options) admit(clientIP string) rejectReason {
+	if o.circuitBreaker.IsOpen() {
+		return rejectCircuitBreaker
+	}
+
+	if o.ipBan.IsBlocked(clientIP) {
+		return rejectIPBan
+	}
+
+	if allowed, reason := o.rateLimit.AllowWithReason(clientIP); !allowed {
+		switch reason {
+		case middleware.LimitGlobalExceeded:
+			return rejectRateLimitGlobal
+		case middleware.LimitBudgetExhausted:
+			return rejectBudget
+		case middleware.LimitBackendUnavailable:
+			return rejectRateLimitDown
+		default:
+			return rejectRateLimitPerIP
+		}
+	}
+
+	return rejectNone
+}
+
+// resetOnReject sets conn to send a TCP RST when it is closed if the proxy is
+// configured to reset connections rejected for reason. Rejections over
+// handshake slots, the queue or a pause are always closed gracefully, since
+// they say nothing about the client. Connections that are not TCP underneath,
+// such as pipes in tests, are left alone.
+func (o *options) resetOnReject(conn net.Conn, reason rejectReason) {
+	if !o.rejectWithRST {
+		return
+	}
+
+	switch reason {
+	case rejectCircuitBreaker, rejectIPBan, rejectRateLimitGlobal, rejectRateLimitPerIP, rejectBudget, rejectRateLimitDown:
+	default:
+		return
+	}
+
+	// Look through wrappers such as TLS for the TCP connection
+	for {
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			break
+		}
+		conn = wrapper.NetConn()
+	}
+
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+}
+
+// acquireUserConn takes one of username's connection slots, logging and
+// counting the rejection when the user already has the maximum open. The cap
+// only applies with authentication, since without it every client shares one
+// username. Call release once the connection ends.
+func (o *options) acquireUserConn(protocol string, connID uint64, clientIP, username string) (release func(), ok bool) {
+	if o.userConns == nil || !o.auth.IsEnabled() {
+		return func() {}, true
+	}
+
+	release, ok = o.userConns.Acquire(username)
+	if !ok {
+		logger.WarnSampled("Connection rejected: too many connections for user",
+			"protocol", protocol,
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"username", username,
+			"max_conns_per_user", o.userConns.Max())
+		metrics.Default.Counter("dudu_user_conn_limit_rejections_total", "Connections rejected because their user had the maximum open",
+			"protocol", protocol).Inc()
+	}
+	return release, ok
+}
+
+// acquireTargetConn takes a connection slot for target's host, logging and
+// counting the request when the host already has the maximum open
+func (o *options) acquireTargetConn(protocol string, connID uint64, clientIP, target string) (release func(), ok bool) {
+	if o.targetConns == nil {
+		return func() {}, true
+	}
+
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	release, ok = o.targetConns.Acquire(host)
+	if !ok {
+		logger.WarnSampled("Request rejected: too many connections to target",
+			"protocol", protocol,
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"target", target,
+			"max_conns_per_target", o.targetConns.Max())
+		metrics.Default.Counter("dudu_target_conn_limit_rejections_total", "Requests rejected because their target host had the maximum connections open",
+			"protocol", protocol).Inc()
+	}
+	return release, ok
+}
+
+// logRejection records a rejected connection as one structured event and
+// counts it by protocol and reason
+func (o *options) logRejection(protocol string, connID uint64, clientIP string, clientPort int, reason rejectReason) {
+	fields := withClientPort([]interface{}{
+		"reason", string(reason),
+		"protocol", protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+	}, clientPort)
+	if reason == rejectCircuitBreaker {
+		fields = append(fields, "circuit_state", o.circuitBreaker.GetState().String())
+	}
+	logger.WarnSampled("Connection rejected", fields...)
+
+	metrics.Default.Counter("dudu_rejections_total", "Connections rejected before their request was read",
+		"protocol", protocol, "reason", string(reason)).Inc()
+}
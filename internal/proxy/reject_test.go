@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func TestAdmit(t *testing.T) {
+	bans := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+	defer bans.Stop()
+	bans.BanIP("10.0.0.66")
+
+	openBreaker := manager.NewCircuitBreaker(manager.WithMinRequests(1), manager.WithBreakDuration(time.Minute))
+	openBreaker.RecordFailure()
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		clientIP string
+		requests int // Admitted before the last one is checked
+		want     rejectReason
+	}{
+		{"all disabled", nil, "10.0.0.1", 0, rejectNone},
+		{"circuit breaker open", []Option{WithCircuitBreaker(middleware.NewCircuitBreakerMiddleware(true, openBreaker))}, "10.0.0.1", 0, rejectCircuitBreaker},
+		{"banned IP", []Option{WithIPBan(middleware.NewIPBanMiddleware(true, bans))}, "10.0.0.66", 0, rejectIPBan},
+		{"global rate limit", []Option{WithRateLimit(middleware.NewRateLimitMiddleware(true, 1, 0))}, "10.0.0.1", 2, rejectRateLimitGlobal},
+		{"per-IP rate limit", []Option{WithRateLimit(middleware.NewRateLimitMiddleware(true, 0, 1))}, "10.0.0.1", 2, rejectRateLimitPerIP},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions(tt.opts)
+			for i := 0; i < tt.requests; i++ {
+				if reason := o.admit(tt.clientIP); reason != rejectNone {
+					t.Fatalf("Request %d: unexpected rejection %q", i+1, reason)
+				}
+			}
+			if got := o.admit(tt.clientIP); got != tt.want {
+				t.Errorf("admit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogRejection_CountsByReason(t *testing.T) {
+	counter := metrics.Default.Counter("dudu_rejections_total", "", "protocol", "socks5", "reason", string(rejectIPBan))
+	before := counter.Value()
+
+	o := newOptions(nil)
+	o.logRejection("socks5", nextConnID(), "10.0.0.1", 0, rejectIPBan)
+
+	if got := counter.Value() - before; got != 1 {
+		t.Errorf("Expected the ip_ban counter to grow by 1, got %d", got)
+	}
+}
+
+func TestLoggedClientPort(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %v", err)
+	}
+	defer server.Close()
+
+	want := client.LocalAddr().(*net.TCPAddr).Port
+	tests := []struct {
+		name       string
+		opts       []Option
+		wantPort   int
+		wantFields int
+	}{
+		{"disabled", nil, 0, 2},
+		{"enabled", []Option{WithClientPortLogging(true)}, want, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions(tt.opts)
+			port := o.loggedClientPort(server)
+			if port != tt.wantPort {
+				t.Errorf("loggedClientPort() = %d, want %d", port, tt.wantPort)
+			}
+			if fields := withClientPort([]interface{}{"client_ip", "127.0.0.1"}, port); len(fields) != tt.wantFields {
+				t.Errorf("Expected %d log fields, got %v", tt.wantFields, fields)
+			}
+		})
+	}
+}
+
+func TestAcquireUserConn(t *testing.T) {
+	counter := metrics.Default.Counter("dudu_user_conn_limit_rejections_total", "", "protocol", "http")
+	before := counter.Value()
+
+	// Without authentication every client shares one username, so no cap applies
+	o := newOptions([]Option{WithMaxConnsPerUser(manager.NewConnLimiter(1))})
+	for i := 0; i < 2; i++ {
+		if _, ok := o.acquireUserConn("http", nextConnID(), "10.0.0.1", "anonymous"); !ok {
+			t.Fatal("Expected no cap without authentication")
+		}
+	}
+
+	o = newOptions([]Option{
+		WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+		WithMaxConnsPerUser(manager.NewConnLimiter(1)),
+	})
+	release, ok := o.acquireUserConn("http", nextConnID(), "10.0.0.1", "user1")
+	if !ok {
+		t.Fatal("Expected the first connection to be allowed")
+	}
+	// The cap follows the user across IPs
+	if _, ok := o.acquireUserConn("http", nextConnID(), "10.0.0.2", "user1"); ok {
+		t.Error("Expected a second connection for the user to be rejected")
+	}
+	if got := counter.Value() - before; got != 1 {
+		t.Errorf("Expected the rejection counter to grow by 1, got %d", got)
+	}
+
+	release()
+	if _, ok := o.acquireUserConn("http", nextConnID(), "10.0.0.2", "user1"); !ok {
+		t.Error("Expected a connection to be allowed once the first closed")
+	}
+}
+
+func TestAcquireTargetConn(t *testing.T) {
+	counter := metrics.Default.Counter("dudu_target_conn_limit_rejections_total", "", "protocol", "socks5")
+	before := counter.Value()
+
+	o := newOptions([]Option{WithMaxConnsPerTarget(manager.NewConnLimiter(1))})
+	release, ok := o.acquireTargetConn("socks5", nextConnID(), "10.0.0.1", "example.com:443")
+	if !ok {
+		t.Fatal("Expected the first connection to be allowed")
+	}
+	// The cap follows the host across clients and ports
+	if _, ok := o.acquireTargetConn("socks5", nextConnID(), "10.0.0.2", "example.com.:80"); ok {
+		t.Error("Expected a second connection to the host to be rejected")
+	}
+	if got := counter.Value() - before; got != 1 {
+		t.Errorf("Expected the rejection counter to grow by 1, got %d", got)
+	}
+
+	release()
+	if _, ok := o.acquireTargetConn("socks5", nextConnID(), "10.0.0.2", "example.com:80"); !ok {
+		t.Error("Expected a connection to be allowed once the first closed")
+	}
+}
+
+func TestResetOnReject(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		reason    rejectReason
+		wantReset bool
+	}{
+		{"disabled", false, rejectIPBan, false},
+		{"ip ban", true, rejectIPBan, true},
+		{"rate limit", true, rejectRateLimitPerIP, true},
+		{"circuit breaker", true, rejectCircuitBreaker, true},
+		{"max handshakes", true, rejectMaxHandshakes, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			defer listener.Close()
+
+			client, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatalf("Failed to dial: %v", err)
+			}
+			defer client.Close()
+
+			server, err := listener.Accept()
+			if err != nil {
+				t.Fatalf("Failed to accept: %v", err)
+			}
+
+			o := newOptions([]Option{WithRejectWithRST(tt.enabled)})
+			o.resetOnReject(server, tt.reason)
+			server.Close()
+
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			_, err = client.Read(make([]byte, 1))
+			if reset := errors.Is(err, syscall.ECONNRESET); reset != tt.wantReset {
+				t.Errorf("Read error = %v, want reset %v", err, tt.wantReset)
+			}
+		})
+	}
+}
+
+func TestResetOnReject_NonTCP(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+
+	// Connections that aren't TCP underneath are left to close normally
+	o := newOptions([]Option{WithRejectWithRST(true)})
+	o.resetOnReject(server, rejectIPBan)
+	server.Close()
+
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("Read error = %v, want EOF", err)
+	}
+}
@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// handleResolve answers a Tor RESOLVE or RESOLVE_PTR command. RESOLVE replies
+// with an address for the domain, RESOLVE_PTR with the hostname for the address.
+func (s *SOCKS5Proxy) handleResolve(ctx context.Context, conn io.Writer, connID uint64, clientIP, username string, cmd byte, host string) error {
+	ctx, cancel := context.WithTimeout(ctx, s.dialTimeout)
+	defer cancel()
+
+	if cmd == cmdResolvePTR {
+		ip := net.ParseIP(manager.StripZone(host))
+		if ip == nil {
+			s.sendReply(conn, repAddressNotSupported, atypIPv4)
+			return fmt.Errorf("RESOLVE_PTR requires an IP address, got %q", host)
+		}
+
+		names, err := net.DefaultResolver.LookupAddr(ctx, ip.String())
+		if err != nil || len(names) == 0 {
+			s.sendReply(conn, repHostUnreachable, atypIPv4)
+			return fmt.Errorf("failed to reverse resolve %s: %w", host, err)
+		}
+
+		name := strings.TrimSuffix(names[0], ".")
+		if len(name) > 255 {
+			s.sendReply(conn, repServerFailure, atypIPv4)
+			return fmt.Errorf("resolved name too long: %d bytes", len(name))
+		}
+
+		s.sendAddrReply(conn, repSuccess, atypDomain, []byte(name), 0)
+		logger.Info("SOCKS5 reverse resolve", "conn_id", connID, "client_ip", clientIP, "username", username, "command", commandName(cmd), "ip", host, "name", name)
+		return nil
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		ips, err := net.DefaultResolver.LookupIP(ctx, resolveNetwork(s.network), host)
+		if err != nil || len(ips) == 0 {
+			s.sendReply(conn, repHostUnreachable, atypIPv4)
+			return fmt.Errorf("failed to resolve %s: %w", host, err)
+		}
+		ip = ips[0]
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		s.sendAddrReply(conn, repSuccess, atypIPv4, ip4, 0)
+	} else {
+		s.sendAddrReply(conn, repSuccess, atypIPv6, ip.To16(), 0)
+	}
+
+	logger.Info("SOCKS5 resolve", "conn_id", connID, "client_ip", clientIP, "username", username, "command", commandName(cmd), "host", host, "ip", ip.String())
+	return nil
+}
+
+// resolveNetwork maps a dial network to the matching lookup network
+func resolveNetwork(network string) string {
+	switch network {
+	case "tcp4":
+		return "ip4"
+	case "tcp6":
+		return "ip6"
+	default:
+		return "ip"
+	}
+}
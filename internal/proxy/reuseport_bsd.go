@@ -0,0 +1,8 @@
+//go:build darwin || freebsd || netbsd || openbsd || (linux && (mips || mipsle || mips64 || mips64le))
+
+package proxy
+
+import "syscall"
+
+// soReusePort is SO_REUSEPORT
+const soReusePort = syscall.SO_REUSEPORT
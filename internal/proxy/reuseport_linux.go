@@ -0,0 +1,7 @@
+//go:build linux && !(mips || mipsle || mips64 || mips64le)
+
+package proxy
+
+// soReusePort is SO_REUSEPORT, which the syscall package doesn't define on
+// most Linux architectures
+const soReusePort = 0xf
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package proxy
+
+import "syscall"
+
+// reusePortSupported reports whether listeners can set SO_REUSEPORT
+const reusePortSupported = false
+
+// reusePortControl is unused where SO_REUSEPORT is unavailable
+var reusePortControl func(network, address string, c syscall.RawConn) error
@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package proxy
+
+import "syscall"
+
+// reusePortSupported reports whether listeners can set SO_REUSEPORT
+const reusePortSupported = true
+
+// reusePortControl sets SO_REUSEPORT on a socket before it is bound, so
+// another process can listen on the same address at the same time
+func reusePortControl(network, address string, c syscall.RawConn) error {
+	var sockErr error
+	if err := c.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -1,12 +1,20 @@
 package proxy
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/seakee/dudu-proxy/internal/metrics"
 	"github.com/seakee/dudu-proxy/internal/middleware"
 	"github.com/seakee/dudu-proxy/pkg/logger"
 )
@@ -16,11 +24,18 @@ const (
 
 	// Authentication methods
 	authNone     = 0x00
+	authGSSAPI   = 0x01
 	authPassword = 0x02
 	authNoAccept = 0xFF
 
 	// Commands
-	cmdConnect = 0x01
+	cmdConnect      = 0x01
+	cmdBind         = 0x02 // Not supported
+	cmdUDPAssociate = 0x03 // Not supported
+
+	// Tor extension commands
+	cmdResolve    = 0xF0
+	cmdResolvePTR = 0xF1
 
 	// Address types
 	atypIPv4   = 0x01
@@ -41,111 +56,159 @@ const (
 
 // SOCKS5Proxy represents a SOCKS5 proxy server
 type SOCKS5Proxy struct {
-	port           int
-	network        string // 网络类型: "tcp", "tcp4", "tcp6"
-	auth           *middleware.AuthMiddleware
-	rateLimit      *middleware.RateLimitMiddleware
-	ipBan          *middleware.IPBanMiddleware
-	circuitBreaker *middleware.CircuitBreakerMiddleware
+	port int
+	options
+	pauseSwitch
+
+	ctx    context.Context // Canceled by Stop to abort in-flight connections
+	cancel context.CancelFunc
+
+	mu        sync.Mutex
+	listeners []net.Listener
 }
 
 // NewSOCKS5Proxy creates a new SOCKS5 proxy
-func NewSOCKS5Proxy(
-	port int,
-	network string,
-	auth *middleware.AuthMiddleware,
-	rateLimit *middleware.RateLimitMiddleware,
-	ipBan *middleware.IPBanMiddleware,
-	circuitBreaker *middleware.CircuitBreakerMiddleware,
-) *SOCKS5Proxy {
+func NewSOCKS5Proxy(port int, opts ...Option) *SOCKS5Proxy {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SOCKS5Proxy{
-		port:           port,
-		network:        network,
-		auth:           auth,
-		rateLimit:      rateLimit,
-		ipBan:          ipBan,
-		circuitBreaker: circuitBreaker,
+		port:    port,
+		options: newOptions(opts),
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 }
 
-// Start starts the SOCKS5 proxy server
+// Start starts the SOCKS5 proxy server on every listen address
 func (s *SOCKS5Proxy) Start() error {
-	listener, err := net.Listen(s.network, fmt.Sprintf(":%d", s.port))
+	listeners, err := s.openListeners(s.port)
 	if err != nil {
 		return fmt.Errorf("failed to start SOCKS5 proxy: %w", err)
 	}
 
-	logger.Info("SOCKS5 proxy server started", "port", s.port, "network", s.network)
-
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			logger.Error("Failed to accept connection", "error", err)
-			continue
-		}
+	s.mu.Lock()
+	s.listeners = listeners
+	s.mu.Unlock()
 
-		go s.handleConnection(conn)
+	for _, listener := range listeners {
+		logger.Info("SOCKS5 proxy server started", "address", listener.Addr().String(), "network", s.network, "tls", s.tlsConfig != nil, "inherited", len(s.inherited) > 0)
 	}
+
+	return serveAll(listeners, "socks5", s.handleConnection)
+}
+
+// Stop closes every listener, which makes Start return, and aborts in-flight connections
+func (s *SOCKS5Proxy) Stop() error {
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return closeListeners(s.listeners)
+}
+
+// Addrs returns the addresses the proxy is listening on
+func (s *SOCKS5Proxy) Addrs() []net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return listenerAddrs(s.listeners)
 }
 
 // handleConnection handles a single SOCKS5 connection
 func (s *SOCKS5Proxy) handleConnection(clientConn net.Conn) {
 	defer clientConn.Close()
 
+	timer := newSetupTimer("socks5")
+	ctx, cancel := s.connContext(withSetupTimer(s.ctx, timer))
+	defer cancel()
+
 	clientIP := middleware.GetClientIP(clientConn)
+	remotePort := s.loggedClientPort(clientConn)
+	connID := nextConnID()
 
-	// Check circuit breaker
-	if s.circuitBreaker.IsOpen() {
-		logger.Warn("SOCKS5 request rejected: circuit breaker is open",
-			"client_ip", clientIP,
-			"circuit_state", s.circuitBreaker.GetState().String())
+	s.debugLog(clientIP)("Connection accepted", withClientPort([]interface{}{
+		"protocol", "socks5",
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"local_addr", clientConn.LocalAddr().String(),
+	}, remotePort)...)
+
+	if s.Paused() {
+		s.logRejection("socks5", connID, clientIP, remotePort, rejectPaused)
 		return
 	}
 
-	// Check IP ban
-	if s.ipBan.IsBlocked(clientIP) {
-		logger.Warn("SOCKS5 request rejected: IP is banned", "client_ip", clientIP)
+	// Check circuit breaker, IP ban and rate limit
+	if reason := s.admit(clientIP); reason != rejectNone {
+		s.logRejection("socks5", connID, clientIP, remotePort, reason)
+		s.resetOnReject(clientConn, reason)
 		return
 	}
 
-	// Check rate limit
-	if !s.rateLimit.Allow(clientIP) {
-		logger.Warn("SOCKS5 request rejected: rate limit exceeded", "client_ip", clientIP)
+	// Bound connections still in the handshake/request phase
+	release, reason := s.acquireHandshake(ctx)
+	if reason != rejectNone {
+		s.logRejection("socks5", connID, clientIP, remotePort, reason)
+		return
+	}
+	defer release()
+
+	if err := s.tlsHandshake(ctx, clientConn, "socks5", connID, clientIP); err != nil {
 		return
 	}
 
 	// SOCKS5 handshake
-	if err := s.handshake(clientConn, clientIP); err != nil {
-		logger.Error("SOCKS5 handshake failed", "client_ip", clientIP, "error", err)
+	username, err := s.handshake(ctx, clientConn, connID, clientIP)
+	if err != nil {
+		logger.Error("SOCKS5 handshake failed", "conn_id", connID, "client_ip", clientIP, "error", err)
 		return
 	}
 
 	// Handle the request
-	if err := s.handleRequest(clientConn, clientIP); err != nil {
-		logger.Error("Failed to handle SOCKS5 request", "client_ip", clientIP, "error", err)
+	if err := s.handleRequest(ctx, clientConn, connID, clientIP, username, release); err != nil {
+		logger.Error("Failed to handle SOCKS5 request", "conn_id", connID, "client_ip", clientIP, "error", err)
 		return
 	}
 }
 
-// handshake performs the SOCKS5 handshake
-func (s *SOCKS5Proxy) handshake(conn net.Conn, clientIP string) error {
+// readDeadliner is implemented by connections that support read deadlines
+type readDeadliner interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// handshake performs the SOCKS5 handshake and returns the authenticated
+// username, or the anonymous label when authentication is disabled
+func (s *SOCKS5Proxy) handshake(ctx context.Context, conn io.ReadWriter, connID uint64, clientIP string) (string, error) {
+	// Bound the greeting so clients that stall before sending their methods are dropped
+	deadliner, hasDeadline := conn.(readDeadliner)
+	if hasDeadline && s.handshakeTimeout > 0 {
+		deadliner.SetReadDeadline(time.Now().Add(s.handshakeTimeout))
+	}
+
 	// Read version and methods
 	buf := make([]byte, 2)
 	if _, err := io.ReadFull(conn, buf); err != nil {
-		return fmt.Errorf("failed to read version: %w", err)
+		return "", fmt.Errorf("failed to read version: %w", err)
 	}
 
 	version := buf[0]
 	nMethods := buf[1]
 
 	if version != socks5Version {
-		return fmt.Errorf("unsupported SOCKS version: %d", version)
+		return "", fmt.Errorf("unsupported SOCKS version: %d", version)
+	}
+
+	if nMethods == 0 {
+		return "", fmt.Errorf("no authentication methods offered")
 	}
 
 	// Read methods
 	methods := make([]byte, nMethods)
 	if _, err := io.ReadFull(conn, methods); err != nil {
-		return fmt.Errorf("failed to read methods: %w", err)
+		return "", fmt.Errorf("failed to read methods: %w", err)
+	}
+	if hasDeadline {
+		deadliner.SetReadDeadline(time.Time{})
 	}
 
 	// Determine authentication method
@@ -168,60 +231,172 @@ func (s *SOCKS5Proxy) handshake(conn net.Conn, clientIP string) error {
 		}
 	}
 
+	s.recordAuthNegotiation(connID, clientIP, methods, byte(selectedMethod))
+
 	// Send selected method
 	if _, err := conn.Write([]byte{socks5Version, byte(selectedMethod)}); err != nil {
-		return fmt.Errorf("failed to send method selection: %w", err)
+		return "", fmt.Errorf("failed to send method selection: %w", err)
 	}
 
 	if selectedMethod == authNoAccept {
-		return fmt.Errorf("no acceptable authentication method")
+		s.logNoAcceptableMethod(connID, clientIP, methods)
+		return "", fmt.Errorf("no acceptable authentication method")
 	}
+	timer := setupTimerFrom(ctx)
+	timer.mark(phaseHandshake)
 
 	// Perform authentication if required
 	if selectedMethod == authPassword {
-		if err := s.authenticatePassword(conn, clientIP); err != nil {
-			return err
+		username, err := s.authenticatePassword(ctx, conn, connID, clientIP)
+		if err == nil {
+			timer.mark(phaseAuth)
 		}
+		return username, err
 	}
 
-	return nil
+	return s.anonymousUser, nil
 }
 
-// authenticatePassword performs username/password authentication
-func (s *SOCKS5Proxy) authenticatePassword(conn net.Conn, clientIP string) error {
-	// Read authentication request
-	buf := make([]byte, 2)
-	if _, err := io.ReadFull(conn, buf); err != nil {
-		return fmt.Errorf("failed to read auth version: %w", err)
+// logNoAcceptableMethod records a greeting that offered none of the methods
+// the listener accepts, which usually means the client is misconfigured, e.g.
+// it has no credentials set while authentication is required
+func (s *SOCKS5Proxy) logNoAcceptableMethod(connID uint64, clientIP string, offered []byte) {
+	required := authMethodName(authNone)
+	if s.auth.IsEnabled() {
+		required = authMethodName(authPassword)
 	}
 
-	authVersion := buf[0]
-	if authVersion != 0x01 {
-		return fmt.Errorf("unsupported auth version: %d", authVersion)
+	logger.WarnSampled("SOCKS5 no acceptable authentication method",
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"offered_methods", authMethodNames(offered),
+		"required_method", required)
+
+	metrics.Default.Counter("dudu_socks5_no_acceptable_method_total", "SOCKS5 greetings offering none of the accepted authentication methods",
+		"required", required).Inc()
+}
+
+// recordAuthNegotiation counts a greeting by whether it offered password
+// authentication and the method selected, "rejected" when none was acceptable
+func (s *SOCKS5Proxy) recordAuthNegotiation(connID uint64, clientIP string, offered []byte, selected byte) {
+	offersPassword := slices.Contains(offered, authPassword)
+	selectedName := authMethodName(selected)
+	if selected == authNoAccept {
+		selectedName = "rejected"
 	}
 
-	// Read username
-	usernameLen := int(buf[1])
-	username := make([]byte, usernameLen)
-	if _, err := io.ReadFull(conn, username); err != nil {
-		return fmt.Errorf("failed to read username: %w", err)
+	s.debugLog(clientIP)("SOCKS5 authentication method negotiated",
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"offered_methods", authMethodNames(offered),
+		"selected_method", selectedName)
+
+	metrics.Default.Counter("dudu_socks5_auth_negotiations_total",
+		"SOCKS5 greetings, by whether they offered password authentication and the method selected",
+		"offers_password", strconv.FormatBool(offersPassword), "selected", selectedName).Inc()
+}
+
+// recordRequest logs a request's command and address type at debug level,
+// or as a warning when err shows it was refused, and counts it in
+// dudu_socks5_requests_total
+func (s *SOCKS5Proxy) recordRequest(connID uint64, clientIP string, req *socks5Request, err error) {
+	command, atyp := commandName(req.cmd), atypName(req.atyp)
+
+	if err != nil {
+		logger.WarnSampled("SOCKS5 request refused",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"command", command,
+			"atyp", atyp,
+			"error", err)
+	} else {
+		s.debugLog(clientIP)("SOCKS5 request",
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"command", command,
+			"atyp", atyp)
 	}
 
-	// Read password length
-	passwordLenBuf := make([]byte, 1)
-	if _, err := io.ReadFull(conn, passwordLenBuf); err != nil {
-		return fmt.Errorf("failed to read password length: %w", err)
+	metrics.Default.Counter("dudu_socks5_requests_total", "SOCKS5 requests read, by command and address type",
+		"command", command, "atyp", atyp).Inc()
+}
+
+// commandName returns a readable name for a SOCKS5 command
+func commandName(cmd byte) string {
+	switch cmd {
+	case cmdConnect:
+		return "connect"
+	case cmdBind:
+		return "bind"
+	case cmdUDPAssociate:
+		return "udp_associate"
+	case cmdResolve:
+		return "resolve"
+	case cmdResolvePTR:
+		return "resolve_ptr"
+	default:
+		return fmt.Sprintf("0x%02x", cmd)
 	}
+}
 
-	// Read password
-	passwordLen := int(passwordLenBuf[0])
-	password := make([]byte, passwordLen)
-	if _, err := io.ReadFull(conn, password); err != nil {
-		return fmt.Errorf("failed to read password: %w", err)
+// atypName returns a readable name for a SOCKS5 address type
+func atypName(atyp byte) string {
+	switch atyp {
+	case atypIPv4:
+		return "ipv4"
+	case atypDomain:
+		return "domain"
+	case atypIPv6:
+		return "ipv6"
+	default:
+		return fmt.Sprintf("0x%02x", atyp)
 	}
+}
 
-	// Authenticate
-	authSuccess := s.auth.Authenticate(string(username), string(password))
+// authMethodNames returns the readable names of methods, comma separated
+func authMethodNames(methods []byte) string {
+	names := make([]string, len(methods))
+	for i, method := range methods {
+		names[i] = authMethodName(method)
+	}
+	return strings.Join(names, ",")
+}
+
+// authMethodName returns a readable name for a SOCKS5 authentication method
+func authMethodName(method byte) string {
+	switch method {
+	case authNone:
+		return "none"
+	case authGSSAPI:
+		return "gssapi"
+	case authPassword:
+		return "password"
+	default:
+		return fmt.Sprintf("0x%02x", method)
+	}
+}
+
+// authenticatePassword performs username/password authentication and returns the
+// username. Failures are answered after the client IP's auth delay.
+func (s *SOCKS5Proxy) authenticatePassword(ctx context.Context, conn io.ReadWriter, connID uint64, clientIP string) (string, error) {
+	// Bound the credential exchange so clients that stall mid-authentication are dropped
+	if deadliner, ok := conn.(readDeadliner); ok && s.authTimeout > 0 {
+		deadliner.SetReadDeadline(time.Now().Add(s.authTimeout))
+		defer deadliner.SetReadDeadline(time.Time{})
+	}
+
+	username, password, err := readPasswordAuth(conn)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		logger.Warn("SOCKS5 authentication timed out", "conn_id", connID, "client_ip", clientIP)
+		s.ipBan.RecordAuthFailure(clientIP)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// Authenticate, binding the username to the client certificate when required
+	authSuccess := s.auth.Authenticate(username, password) &&
+		s.certUsernameMatches(conn, "socks5", connID, clientIP, username)
 
 	// Send authentication response
 	var status byte
@@ -229,37 +404,86 @@ func (s *SOCKS5Proxy) authenticatePassword(conn net.Conn, clientIP string) error
 		status = 0x00
 		s.ipBan.RecordAuthSuccess(clientIP)
 		s.circuitBreaker.RecordAuthSuccess()
+		s.authDelay.Succeed(clientIP)
 
-		logger.Debug("SOCKS5 authentication successful",
+		s.debugLog(clientIP)("SOCKS5 authentication successful",
+			"conn_id", connID,
 			"client_ip", clientIP,
-			"username", string(username))
+			"username", username)
 	} else {
 		status = 0x01
 		s.ipBan.RecordAuthFailure(clientIP)
-		s.circuitBreaker.RecordAuthFailure()
+		s.circuitBreaker.RecordAuthFailure(clientIP)
+		s.publishAuthFailure("socks5", connID, clientIP, username)
 
 		logger.Warn("SOCKS5 authentication failed",
+			"conn_id", connID,
 			"client_ip", clientIP,
-			"username", string(username))
+			"username", username)
+
+		s.authDelay.Fail(ctx, clientIP)
 	}
 
 	if _, err := conn.Write([]byte{0x01, status}); err != nil {
-		return fmt.Errorf("failed to send auth response: %w", err)
+		return "", fmt.Errorf("failed to send auth response: %w", err)
 	}
 
 	if !authSuccess {
-		return fmt.Errorf("authentication failed")
+		return "", fmt.Errorf("authentication failed")
 	}
 
-	return nil
+	return username, nil
 }
 
-// handleRequest handles the SOCKS5 request
-func (s *SOCKS5Proxy) handleRequest(clientConn net.Conn, clientIP string) error {
+// readPasswordAuth reads a username/password authentication request (RFC 1929)
+func readPasswordAuth(conn io.Reader) (username, password string, err error) {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return "", "", fmt.Errorf("failed to read auth version: %w", err)
+	}
+
+	authVersion := buf[0]
+	if authVersion != 0x01 {
+		return "", "", fmt.Errorf("unsupported auth version: %d", authVersion)
+	}
+
+	// Read username
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return "", "", fmt.Errorf("failed to read username: %w", err)
+	}
+
+	// Read password length
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return "", "", fmt.Errorf("failed to read password length: %w", err)
+	}
+
+	// Read password
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return "", "", fmt.Errorf("failed to read password: %w", err)
+	}
+
+	return string(user), string(pass), nil
+}
+
+// socks5Request is a parsed SOCKS5 request
+type socks5Request struct {
+	cmd  byte
+	atyp byte
+	host string // IP address or domain name
+	port uint16
+}
+
+// readRequest reads a SOCKS5 request from conn. Malformed or unsupported
+// requests are answered with the matching failure reply before returning an
+// error, along with the request's command and address type once its header
+// has been read.
+func (s *SOCKS5Proxy) readRequest(conn io.ReadWriter) (*socks5Request, error) {
 	// Read request header
 	buf := make([]byte, 4)
-	if _, err := io.ReadFull(clientConn, buf); err != nil {
-		return fmt.Errorf("failed to read request: %w", err)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return nil, fmt.Errorf("failed to read request: %w", err)
 	}
 
 	version := buf[0]
@@ -268,89 +492,180 @@ func (s *SOCKS5Proxy) handleRequest(clientConn net.Conn, clientIP string) error
 	atyp := buf[3]
 
 	if version != socks5Version {
-		s.sendReply(clientConn, repServerFailure, atyp)
-		return fmt.Errorf("invalid version: %d", version)
+		s.sendReply(conn, repServerFailure, atyp)
+		return nil, fmt.Errorf("invalid version: %d", version)
 	}
 
-	if cmd != cmdConnect {
-		s.sendReply(clientConn, repCommandNotSupported, atyp)
-		return fmt.Errorf("unsupported command: %d", cmd)
+	// Failures past the header return the request's command and address type
+	req := &socks5Request{cmd: cmd, atyp: atyp}
+
+	isResolve := s.resolveExtension && (cmd == cmdResolve || cmd == cmdResolvePTR)
+	if cmd != cmdConnect && !isResolve {
+		s.sendReply(conn, repCommandNotSupported, atyp)
+		return req, fmt.Errorf("unsupported command: %d", cmd)
 	}
 
 	// Read target address
-	var targetAddr string
+	var host string
 	switch atyp {
 	case atypIPv4:
 		addr := make([]byte, 4)
-		if _, err := io.ReadFull(clientConn, addr); err != nil {
-			s.sendReply(clientConn, repServerFailure, atyp)
-			return fmt.Errorf("failed to read IPv4 address: %w", err)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			s.sendReply(conn, repServerFailure, atyp)
+			return req, fmt.Errorf("failed to read IPv4 address: %w", err)
 		}
-		targetAddr = net.IPv4(addr[0], addr[1], addr[2], addr[3]).String()
+		host = net.IPv4(addr[0], addr[1], addr[2], addr[3]).String()
 
 	case atypDomain:
 		lenBuf := make([]byte, 1)
-		if _, err := io.ReadFull(clientConn, lenBuf); err != nil {
-			s.sendReply(clientConn, repServerFailure, atyp)
-			return fmt.Errorf("failed to read domain length: %w", err)
+		if _, err := io.ReadFull(conn, lenBuf); err != nil {
+			s.sendReply(conn, repServerFailure, atyp)
+			return req, fmt.Errorf("failed to read domain length: %w", err)
 		}
 		domain := make([]byte, lenBuf[0])
-		if _, err := io.ReadFull(clientConn, domain); err != nil {
-			s.sendReply(clientConn, repServerFailure, atyp)
-			return fmt.Errorf("failed to read domain: %w", err)
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			s.sendReply(conn, repServerFailure, atyp)
+			return req, fmt.Errorf("failed to read domain: %w", err)
 		}
-		targetAddr = string(domain)
+		host = string(domain)
 
 	case atypIPv6:
 		addr := make([]byte, 16)
-		if _, err := io.ReadFull(clientConn, addr); err != nil {
-			s.sendReply(clientConn, repServerFailure, atyp)
-			return fmt.Errorf("failed to read IPv6 address: %w", err)
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			s.sendReply(conn, repServerFailure, atyp)
+			return req, fmt.Errorf("failed to read IPv6 address: %w", err)
 		}
-		targetAddr = net.IP(addr).String()
+		host = net.IP(addr).String()
 
 	default:
-		s.sendReply(clientConn, repAddressNotSupported, atyp)
-		return fmt.Errorf("unsupported address type: %d", atyp)
+		s.sendReply(conn, repAddressNotSupported, atyp)
+		return req, fmt.Errorf("unsupported address type: %d", atyp)
 	}
 
 	// Read port
 	portBuf := make([]byte, 2)
-	if _, err := io.ReadFull(clientConn, portBuf); err != nil {
-		s.sendReply(clientConn, repServerFailure, atyp)
-		return fmt.Errorf("failed to read port: %w", err)
+	if _, err := io.ReadFull(conn, portBuf); err != nil {
+		s.sendReply(conn, repServerFailure, atyp)
+		return req, fmt.Errorf("failed to read port: %w", err)
 	}
-	targetPort := binary.BigEndian.Uint16(portBuf)
 
-	target := net.JoinHostPort(targetAddr, fmt.Sprintf("%d", targetPort))
+	req.host = host
+	req.port = binary.BigEndian.Uint16(portBuf)
+	return req, nil
+}
+
+// handleRequest handles the SOCKS5 request. release is called once the request
+// has been read, ending the handshake phase.
+func (s *SOCKS5Proxy) handleRequest(ctx context.Context, clientConn io.ReadWriteCloser, connID uint64, clientIP, username string, release func()) error {
+	req, err := s.readRequest(clientConn)
+	if req != nil {
+		s.recordRequest(connID, clientIP, req, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The request phase is complete
+	release()
+
+	releaseUser, ok := s.acquireUserConn("socks5", connID, clientIP, username)
+	if !ok {
+		s.sendReply(clientConn, repConnectionNotAllowed, req.atyp)
+		return fmt.Errorf("too many connections for user %s", username)
+	}
+	defer releaseUser()
+
+	releaseAnonymous, ok := s.acquireAnonymous("socks5", connID, clientIP)
+	if !ok {
+		s.sendReply(clientConn, repConnectionNotAllowed, req.atyp)
+		return fmt.Errorf("anonymous limit reached")
+	}
+	defer releaseAnonymous()
+
+	// Resolve commands answer in the reply without opening a tunnel
+	if req.cmd != cmdConnect {
+		return s.handleResolve(ctx, clientConn, connID, clientIP, username, req.cmd, req.host)
+	}
+
+	target := net.JoinHostPort(req.host, strconv.Itoa(int(req.port)))
+
+	if s.scanDetect.RecordTarget(clientIP, target) {
+		logger.WarnSampled("SOCKS5 request rejected: scanning detected", "conn_id", connID, "client_ip", clientIP, "target", target)
+		s.sendReply(clientConn, repConnectionNotAllowed, req.atyp)
+		return fmt.Errorf("scanning detected")
+	}
+
+	category := s.targetCategory.Categorize(target)
+
+	releaseTarget, ok := s.acquireTargetConn("socks5", connID, clientIP, target)
+	if !ok {
+		s.sendReply(clientConn, repConnectionRefused, req.atyp)
+		return fmt.Errorf("too many connections to target %s", target)
+	}
+	defer releaseTarget()
+
+	if s.preserveSourcePort {
+		ctx = withSourcePort(ctx, clientPort(clientConn))
+	}
 
 	// Connect to target
-	targetConn, err := net.DialTimeout(s.network, target, 10*time.Second)
+	targetConn, err := s.connectTarget(ctx, target, clientConn)
+	if errors.Is(err, errTargetForbidden) {
+		logDialFailure("socks5", connID, clientIP, target, category, err)
+		s.sendReply(clientConn, s.policyDenyReply, req.atyp)
+		return err
+	}
 	if err != nil {
-		logger.Error("Failed to connect to target",
-			"client_ip", clientIP,
-			"target", target,
-			"error", err)
-		s.sendReply(clientConn, repHostUnreachable, atyp)
+		logDialFailure("socks5", connID, clientIP, target, category, err)
+		s.sendReply(clientConn, repHostUnreachable, req.atyp)
 		return fmt.Errorf("failed to connect to target: %w", err)
 	}
 	defer targetConn.Close()
+	timer := setupTimerFrom(ctx)
+	timer.mark(phaseDial)
+
+	ctx, tracked, done := s.track(ctx, targetConn, connID, "socks5", clientIP, username, target)
+	defer done()
 
-	// Send success reply
-	s.sendReply(clientConn, repSuccess, atyp)
+	// Send success reply with the address family of the bound local address
+	if s.zeroReplyAddress {
+		s.sendReply(clientConn, repSuccess, req.atyp)
+	} else {
+		s.sendBoundReply(clientConn, repSuccess, targetConn.LocalAddr())
+	}
+	timer.mark(phaseEstablished)
+	timer.log(s.debugLog(clientIP), connID, clientIP)
 
-	logger.Info("SOCKS5 connection established",
+	logger.Info("SOCKS5 connection established", withCategory([]interface{}{
+		"conn_id", connID,
 		"client_ip", clientIP,
-		"target", target)
+		"username", username,
+		"command", commandName(req.cmd),
+		"atyp", atypName(req.atyp),
+		"target", target,
+		"resolved", resolvedAddr(targetConn),
+	}, category)...)
+	countCategory("socks5", category)
 
 	// Bidirectional copy
-	s.transfer(clientConn, targetConn)
+	reason := transfer(ctx, s.withClientTimeouts(clientConn), tracked)
+	logTunnelClose("socks5", connID, clientIP, s.loggedClientPort(clientConn), target, category, reason, done())
 
 	return nil
 }
 
+// clientPort returns the port conn's client connected from, zero if unknown
+func clientPort(conn io.ReadWriteCloser) int {
+	if remote, ok := conn.(interface{ RemoteAddr() net.Addr }); ok {
+		if addr, ok := remote.RemoteAddr().(*net.TCPAddr); ok {
+			return addr.Port
+		}
+	}
+	return 0
+}
+
 // sendReply sends a SOCKS5 reply
-func (s *SOCKS5Proxy) sendReply(conn net.Conn, rep byte, atyp byte) {
+func (s *SOCKS5Proxy) sendReply(conn io.Writer, rep byte, atyp byte) {
 	reply := []byte{
 		socks5Version,
 		rep,
@@ -362,19 +677,30 @@ func (s *SOCKS5Proxy) sendReply(conn net.Conn, rep byte, atyp byte) {
 	conn.Write(reply)
 }
 
-// transfer bidirectionally copies data between two connections
-func (s *SOCKS5Proxy) transfer(conn1, conn2 net.Conn) {
-	done := make(chan struct{}, 2)
-
-	go func() {
-		io.Copy(conn1, conn2)
-		done <- struct{}{}
-	}()
+// sendAddrReply sends a SOCKS5 reply carrying addr and port as the bound address.
+// addr is an IPv4/IPv6 address or, for atypDomain, the domain name.
+func (s *SOCKS5Proxy) sendAddrReply(conn io.Writer, rep byte, atyp byte, addr []byte, port uint16) {
+	reply := []byte{socks5Version, rep, 0x00, atyp}
+	if atyp == atypDomain {
+		reply = append(reply, byte(len(addr)))
+	}
+	reply = append(reply, addr...)
+	reply = binary.BigEndian.AppendUint16(reply, port)
+	conn.Write(reply)
+}
 
-	go func() {
-		io.Copy(conn2, conn1)
-		done <- struct{}{}
-	}()
+// sendBoundReply sends a SOCKS5 reply carrying the bound address, choosing the
+// address type from its family so IPv6 connections are answered with ATYP 0x04
+func (s *SOCKS5Proxy) sendBoundReply(conn io.Writer, rep byte, bound net.Addr) {
+	tcpAddr, ok := bound.(*net.TCPAddr)
+	if !ok {
+		s.sendReply(conn, rep, atypIPv4)
+		return
+	}
 
-	<-done
+	if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+		s.sendAddrReply(conn, rep, atypIPv4, ip4, uint16(tcpAddr.Port))
+	} else {
+		s.sendAddrReply(conn, rep, atypIPv6, tcpAddr.IP.To16(), uint16(tcpAddr.Port))
+	}
 }
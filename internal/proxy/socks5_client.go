@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// socks5Dialer connects to targets through an upstream SOCKS5 proxy, letting
+// clients that only speak HTTP CONNECT reach a SOCKS5-only upstream
+type socks5Dialer struct {
+	address  string // Upstream host:port
+	username string // Empty offers only the no-authentication method
+	password string
+	forward  Dialer // Connects to the upstream itself
+}
+
+// DialContext opens a tunnel to address through the upstream. The handshake is
+// bounded by ctx; the returned connection carries no deadline.
+func (d *socks5Dialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.forward.DialContext(ctx, network, d.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SOCKS5 upstream %s: %w", d.address, err)
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	// Unblock the handshake when ctx is canceled
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Unix(1, 0)) })
+
+	err = socks5Connect(conn, address, d.username, d.password)
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5 upstream %s: %w", d.address, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5Connect performs the client side of a SOCKS5 CONNECT to target over
+// conn, authenticating with username and password (RFC 1929) when username is
+// set. On success conn is positioned at the start of the tunneled stream.
+func socks5Connect(conn io.ReadWriter, target, username, password string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return fmt.Errorf("invalid target: %w", err)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil || len(host) > 255 {
+		return fmt.Errorf("invalid target: %s", target)
+	}
+
+	// Greeting
+	method := byte(authNone)
+	if username != "" {
+		method = authPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return fmt.Errorf("failed to send greeting: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("failed to read greeting reply: %w", err)
+	}
+	if reply[1] != method {
+		return fmt.Errorf("proxy selected authentication method %#x, want %#x", reply[1], method)
+	}
+
+	// Username/password sub-negotiation (RFC 1929)
+	if method == authPassword {
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("username and password must be at most 255 bytes")
+		}
+		msg := []byte{0x01, byte(len(username))}
+		msg = append(msg, username...)
+		msg = append(msg, byte(len(password)))
+		msg = append(msg, password...)
+		if _, err := conn.Write(msg); err != nil {
+			return fmt.Errorf("failed to send credentials: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("failed to read authentication reply: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return errors.New("authentication rejected")
+		}
+	}
+
+	// CONNECT request, with the target's IP when it is one
+	msg := []byte{socks5Version, cmdConnect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		msg = append(msg, atypDomain, byte(len(host)))
+		msg = append(msg, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		msg = append(msg, atypIPv4)
+		msg = append(msg, ip4...)
+	} else {
+		msg = append(msg, atypIPv6)
+		msg = append(msg, ip.To16()...)
+	}
+	msg = append(msg, byte(port>>8), byte(port))
+	if _, err := conn.Write(msg); err != nil {
+		return fmt.Errorf("failed to send CONNECT: %w", err)
+	}
+
+	// VER, REP, RSV, ATYP
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read CONNECT reply: %w", err)
+	}
+	if header[1] != repSuccess {
+		return fmt.Errorf("CONNECT %s failed with reply %#x", target, header[1])
+	}
+
+	// Discard the bound address and port so the tunnel starts clean
+	var addrLen int
+	switch header[3] {
+	case atypIPv4:
+		addrLen = net.IPv4len
+	case atypIPv6:
+		addrLen = net.IPv6len
+	case atypDomain:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return fmt.Errorf("failed to read CONNECT reply: %w", err)
+		}
+		addrLen = int(reply[0])
+	default:
+		return fmt.Errorf("unsupported bound address type %#x", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return fmt.Errorf("failed to read CONNECT reply: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func TestHTTPProxy_SOCKS5Upstream(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		wantOK   bool
+	}{
+		{"bridged", "secret", true},
+		{"upstream rejects credentials", "wrong", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &echoDialer{}
+			upstream := NewSOCKS5Proxy(0,
+				WithListenAddresses([]string{"127.0.0.1:0"}),
+				WithDialer(dialer),
+				WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"bridge": "secret"})),
+			)
+			startProxy(t, upstream)
+
+			h := NewHTTPProxy(0,
+				WithDialTimeout(time.Second),
+				WithSOCKS5Upstream(upstream.Addrs()[0].String(), "bridge", tt.password, nil),
+			)
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go h.handleConnection(server)
+
+			go io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n")
+
+			br := bufio.NewReader(client)
+			resp, err := http.ReadResponse(br, &http.Request{Method: http.MethodConnect})
+			if err != nil {
+				t.Fatalf("Failed to read response: %v", err)
+			}
+			if (resp.StatusCode == http.StatusOK) != tt.wantOK {
+				t.Fatalf("Unexpected status %d", resp.StatusCode)
+			}
+			if !tt.wantOK {
+				return
+			}
+
+			go io.WriteString(client, "ping")
+			echo := make([]byte, 4)
+			if _, err := io.ReadFull(br, echo); err != nil || string(echo) != "ping" {
+				t.Fatalf("Expected echoed data, got %q (%v)", echo, err)
+			}
+
+			if len(dialer.targets) != 1 || dialer.targets[0] != "example.com:443" {
+				t.Errorf("Expected the upstream to dial example.com:443, got %v", dialer.targets)
+			}
+		})
+	}
+}
@@ -0,0 +1,774 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+// scriptConn feeds a fixed byte sequence to the proxy and records what it writes back
+type scriptConn struct {
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+// newScriptConn returns a scriptConn that reads the concatenated input
+func newScriptConn(input ...[]byte) *scriptConn {
+	return &scriptConn{in: bytes.NewReader(bytes.Join(input, nil))}
+}
+
+func (c *scriptConn) Read(p []byte) (int, error)  { return c.in.Read(p) }
+func (c *scriptConn) Write(p []byte) (int, error) { return c.out.Write(p) }
+
+// authMethod returns a username/password sub-negotiation request
+func authMethod(username, password string) []byte {
+	b := append([]byte{0x01, byte(len(username))}, username...)
+	b = append(b, byte(len(password)))
+	return append(b, password...)
+}
+
+// socks5Exchange performs a no-auth handshake, sends request and returns the reply
+func socks5Exchange(t *testing.T, s *SOCKS5Proxy, request []byte) []byte {
+	t.Helper()
+
+	client, server := net.Pipe()
+	go s.handleConnection(server)
+	t.Cleanup(func() { client.Close() })
+
+	go func() {
+		client.Write([]byte{socks5Version, 1, authNone})
+		client.Write(request)
+	}()
+
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(client, method); err != nil {
+		t.Fatalf("Failed to read method selection: %v", err)
+	}
+	if method[1] != authNone {
+		t.Fatalf("Expected no-auth method, got %#x", method[1])
+	}
+
+	reply, _ := io.ReadAll(client)
+	return reply
+}
+
+func TestSOCKS5Proxy_Resolve(t *testing.T) {
+	localhost := append([]byte{socks5Version, cmdResolve, 0x00, atypDomain, 9}, "localhost"...)
+	localhost = append(localhost, 0, 0)
+	loopbackPTR := []byte{socks5Version, cmdResolvePTR, 0x00, atypIPv4, 127, 0, 0, 1, 0, 0}
+
+	tests := []struct {
+		name    string
+		enabled bool
+		request []byte
+		want    []byte
+	}{
+		{
+			name:    "disabled",
+			request: localhost,
+			want:    []byte{socks5Version, repCommandNotSupported, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0},
+		},
+		{
+			name:    "resolve",
+			enabled: true,
+			request: localhost,
+			want:    []byte{socks5Version, repSuccess, 0x00, atypIPv4, 127, 0, 0, 1, 0, 0},
+		},
+		{
+			name:    "resolve ptr",
+			enabled: true,
+			request: loopbackPTR,
+			want:    append(append([]byte{socks5Version, repSuccess, 0x00, atypDomain, 9}, "localhost"...), 0, 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSOCKS5Proxy(0, WithNetwork("tcp4"), WithResolveExtension(tt.enabled))
+
+			reply := socks5Exchange(t, s, tt.request)
+			if !bytes.Equal(reply, tt.want) {
+				t.Errorf("Expected reply %v, got %v", tt.want, reply)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_ZeroReplyAddress(t *testing.T) {
+	upstream, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("tcp6 not available: %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		if conn, err := upstream.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	target := upstream.Addr().(*net.TCPAddr)
+	request := append([]byte{socks5Version, cmdConnect, 0x00, atypIPv6}, target.IP.To16()...)
+	request = append(request, byte(target.Port>>8), byte(target.Port))
+
+	reply := socks5Exchange(t, NewSOCKS5Proxy(0, WithZeroReplyAddress(true)), request)
+
+	want := []byte{socks5Version, repSuccess, 0x00, atypIPv4, 0, 0, 0, 0, 0, 0}
+	if !bytes.Equal(reply, want) {
+		t.Errorf("Expected reply %v, got %v", want, reply)
+	}
+}
+
+func TestSOCKS5Proxy_PolicyDenyReplyCode(t *testing.T) {
+	request := []byte{socks5Version, cmdConnect, 0x00, atypIPv4, 127, 0, 0, 1, 0, 80}
+
+	tests := []struct {
+		name      string
+		opts      []Option
+		wantReply byte
+	}{
+		{"default", nil, repConnectionNotAllowed},
+		{"host unreachable", []Option{WithPolicyDenyReplyCode(repHostUnreachable)}, repHostUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithSSRFGuard(middleware.NewSSRFGuardMiddleware(true, nil))}, tt.opts...)
+			reply := socks5Exchange(t, NewSOCKS5Proxy(0, opts...), request)
+
+			if len(reply) < 2 || reply[1] != tt.wantReply {
+				t.Errorf("Expected reply code %#x, got %v", tt.wantReply, reply)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_BoundAddressFamily(t *testing.T) {
+	tests := []struct {
+		name     string
+		network  string
+		address  string
+		wantAtyp byte
+		wantLen  int
+	}{
+		{name: "ipv4 target", network: "tcp4", address: "127.0.0.1:0", wantAtyp: atypIPv4, wantLen: net.IPv4len},
+		{name: "ipv6 target", network: "tcp6", address: "[::1]:0", wantAtyp: atypIPv6, wantLen: net.IPv6len},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream, err := net.Listen(tt.network, tt.address)
+			if err != nil {
+				t.Skipf("%s not available: %v", tt.network, err)
+			}
+			defer upstream.Close()
+
+			// Accept and close so the tunnel ends after the reply
+			go func() {
+				if conn, err := upstream.Accept(); err == nil {
+					conn.Close()
+				}
+			}()
+
+			target := upstream.Addr().(*net.TCPAddr)
+			request := []byte{socks5Version, cmdConnect, 0x00, atypIPv4}
+			if ip4 := target.IP.To4(); ip4 != nil {
+				request = append(request, ip4...)
+			} else {
+				request[3] = atypIPv6
+				request = append(request, target.IP.To16()...)
+			}
+			request = append(request, byte(target.Port>>8), byte(target.Port))
+
+			reply := socks5Exchange(t, NewSOCKS5Proxy(0), request)
+
+			if len(reply) != 4+tt.wantLen+2 {
+				t.Fatalf("Unexpected reply length %d: %v", len(reply), reply)
+			}
+			if reply[1] != repSuccess || reply[3] != tt.wantAtyp {
+				t.Errorf("Expected success with ATYP %#x, got rep %#x ATYP %#x", tt.wantAtyp, reply[1], reply[3])
+			}
+			if bound := net.IP(reply[4 : 4+tt.wantLen]); !bound.IsLoopback() {
+				t.Errorf("Expected loopback bound address, got %s", bound)
+			}
+			if port := int(reply[len(reply)-2])<<8 | int(reply[len(reply)-1]); port == 0 {
+				t.Error("Expected a non-zero bound port")
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_DialString(t *testing.T) {
+	tests := []struct {
+		name    string
+		address []byte // ATYP and address
+		want    string
+	}{
+		{"IPv4", append([]byte{atypIPv4}, 192, 0, 2, 1), "192.0.2.1:443"},
+		{"IPv6", append([]byte{atypIPv6}, net.ParseIP("2001:db8::1")...), "[2001:db8::1]:443"},
+		{"domain", append([]byte{atypDomain, 11}, "example.com"...), "example.com:443"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &echoDialer{}
+			s := NewSOCKS5Proxy(0, WithDialer(dialer))
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go s.handleConnection(server)
+
+			go func() {
+				client.Write([]byte{socks5Version, 1, authNone})
+				client.Write(append(append([]byte{socks5Version, cmdConnect, 0}, tt.address...), 0x01, 0xBB))
+			}()
+
+			// Method selection plus a reply for the non-TCP bound address
+			reply := make([]byte, 12)
+			if _, err := io.ReadFull(client, reply); err != nil || reply[3] != repSuccess {
+				t.Fatalf("Failed to establish tunnel: %#v (%v)", reply, err)
+			}
+			if len(dialer.targets) != 1 || dialer.targets[0] != tt.want {
+				t.Errorf("Expected dial to %q, got %v", tt.want, dialer.targets)
+			}
+		})
+	}
+}
+
+// recordingDialer dials for real and records the network and address of each dial
+type recordingDialer struct {
+	mu    sync.Mutex
+	dials []string
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials = append(d.dials, network+" "+address)
+	d.mu.Unlock()
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, address)
+}
+
+func TestSOCKS5Proxy_IPv6Target(t *testing.T) {
+	upstream, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		for {
+			conn, err := upstream.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	port := upstream.Addr().(*net.TCPAddr).Port
+	target := net.JoinHostPort("::1", strconv.Itoa(port))
+	request := append(append([]byte{socks5Version, cmdConnect, 0x00, atypIPv6}, net.IPv6loopback...), byte(port>>8), byte(port))
+
+	tests := []struct {
+		name    string
+		network string
+		wantRep byte
+	}{
+		{"any family", "tcp", repSuccess},
+		{"IPv6 only", "tcp6", repSuccess},
+		{"IPv4 only", "tcp4", repHostUnreachable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dialer := &recordingDialer{}
+			s := NewSOCKS5Proxy(0, WithNetwork(tt.network), WithDialer(dialer))
+
+			client, server := net.Pipe()
+			defer client.Close()
+			go s.handleConnection(server)
+
+			go func() {
+				client.Write([]byte{socks5Version, 1, authNone})
+				client.Write(request)
+			}()
+
+			method := make([]byte, 2)
+			if _, err := io.ReadFull(client, method); err != nil {
+				t.Fatalf("Failed to read method selection: %v", err)
+			}
+			header := make([]byte, 4)
+			if _, err := io.ReadFull(client, header); err != nil {
+				t.Fatalf("Failed to read reply: %v", err)
+			}
+			if header[1] != tt.wantRep {
+				t.Fatalf("Expected reply %#x, got %#x", tt.wantRep, header[1])
+			}
+
+			// The dial string brackets the literal and uses the configured network
+			dialer.mu.Lock()
+			dials := dialer.dials
+			dialer.mu.Unlock()
+			if want := tt.network + " " + target; len(dials) != 1 || dials[0] != want {
+				t.Errorf("Expected dial %q, got %v", want, dials)
+			}
+			if tt.wantRep != repSuccess {
+				return
+			}
+
+			if header[3] != atypIPv6 {
+				t.Fatalf("Expected bound address ATYP %#x, got %#x", atypIPv6, header[3])
+			}
+			bound := make([]byte, net.IPv6len+2)
+			if _, err := io.ReadFull(client, bound); err != nil {
+				t.Fatalf("Failed to read bound address: %v", err)
+			}
+			if ip := net.IP(bound[:net.IPv6len]); !ip.Equal(net.IPv6loopback) {
+				t.Errorf("Expected bound address ::1, got %s", ip)
+			}
+
+			// Data flows through the tunnel to the IPv6 target and back
+			go client.Write([]byte("ping"))
+			echo := make([]byte, 4)
+			if _, err := io.ReadFull(client, echo); err != nil || string(echo) != "ping" {
+				t.Errorf("Expected the target to echo %q, got %q (%v)", "ping", echo, err)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_HandshakeTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		greeting []byte
+	}{
+		{"stalled methods", []byte{socks5Version, 3, authNone}},
+		{"no methods", []byte{socks5Version, 0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSOCKS5Proxy(0, WithHandshakeTimeout(50*time.Millisecond))
+
+			client, server := net.Pipe()
+			defer client.Close()
+
+			done := make(chan struct{})
+			go func() {
+				s.handleConnection(server)
+				close(done)
+			}()
+
+			if _, err := client.Write(tt.greeting); err != nil {
+				t.Fatalf("Failed to write greeting: %v", err)
+			}
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Expected the connection to be closed")
+			}
+
+			if _, err := client.Read(make([]byte, 1)); err == nil {
+				t.Error("Expected no method selection to be sent")
+			}
+		})
+	}
+}
+
+func TestAuthTimeout(t *testing.T) {
+	tests := []struct {
+		name  string
+		serve func(opts ...Option) func(net.Conn)
+		stall func(client net.Conn)
+	}{
+		{
+			name:  "socks5 stalled credentials",
+			serve: func(opts ...Option) func(net.Conn) { return NewSOCKS5Proxy(0, opts...).handleConnection },
+			stall: func(client net.Conn) {
+				client.Write([]byte{socks5Version, 1, authPassword})
+				io.ReadFull(client, make([]byte, 2))
+				client.Write([]byte{0x01, 5, 'u'})
+			},
+		},
+		{
+			name:  "http stalled request",
+			serve: func(opts ...Option) func(net.Conn) { return NewHTTPProxy(0, opts...).handleConnection },
+			stall: func(client net.Conn) {
+				io.WriteString(client, "CONNECT example.com:443 HTTP/1.1\r\n")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bans := manager.NewIPBanManager(3, time.Minute, manager.WithoutPersistence(), manager.WithoutCleanup())
+			defer bans.Stop()
+
+			handle := tt.serve(
+				WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+				WithIPBan(middleware.NewIPBanMiddleware(true, bans)),
+				WithAuthTimeout(50*time.Millisecond),
+			)
+
+			client, server := net.Pipe()
+			defer client.Close()
+			clientIP := middleware.GetClientIP(server)
+
+			done := make(chan struct{})
+			go func() {
+				handle(server)
+				close(done)
+			}()
+			tt.stall(client)
+
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("Expected the connection to be closed")
+			}
+
+			if n := bans.GetFailureCount(clientIP); n != 1 {
+				t.Errorf("Expected the timeout to count as 1 auth failure, got %d", n)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_HandshakeBytes(t *testing.T) {
+	tests := []struct {
+		name     string
+		auth     bool
+		input    [][]byte
+		want     []byte
+		wantUser string
+		wantErr  bool
+	}{
+		{
+			name:     "no auth",
+			input:    [][]byte{{socks5Version, 1, authNone}},
+			want:     []byte{socks5Version, authNone},
+			wantUser: "anonymous",
+		},
+		{
+			name:     "no auth among several methods",
+			input:    [][]byte{{socks5Version, 2, authPassword, authNone}},
+			want:     []byte{socks5Version, authNone},
+			wantUser: "anonymous",
+		},
+		{
+			name:    "bad version",
+			input:   [][]byte{{0x04, 1, authNone}},
+			wantErr: true,
+		},
+		{
+			name:    "no acceptable method",
+			input:   [][]byte{{socks5Version, 1, authPassword}},
+			want:    []byte{socks5Version, authNoAccept},
+			wantErr: true,
+		},
+		{
+			name:    "truncated greeting",
+			input:   [][]byte{{socks5Version}},
+			wantErr: true,
+		},
+		{
+			name:    "truncated methods",
+			input:   [][]byte{{socks5Version, 3, authNone}},
+			wantErr: true,
+		},
+		{
+			name:     "password accepted",
+			auth:     true,
+			input:    [][]byte{{socks5Version, 1, authPassword}, authMethod("user", "pass")},
+			want:     []byte{socks5Version, authPassword, 0x01, 0x00},
+			wantUser: "user",
+		},
+		{
+			name:    "password rejected",
+			auth:    true,
+			input:   [][]byte{{socks5Version, 1, authPassword}, authMethod("user", "wrong")},
+			want:    []byte{socks5Version, authPassword, 0x01, 0x01},
+			wantErr: true,
+		},
+		{
+			name:    "password required",
+			auth:    true,
+			input:   [][]byte{{socks5Version, 1, authNone}},
+			want:    []byte{socks5Version, authNoAccept},
+			wantErr: true,
+		},
+		{
+			name:    "truncated password",
+			auth:    true,
+			input:   [][]byte{{socks5Version, 1, authPassword}, authMethod("user", "pass")[:7]},
+			want:    []byte{socks5Version, authPassword},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSOCKS5Proxy(0, WithAuth(middleware.NewAuthMiddleware(tt.auth, map[string]string{"user": "pass"})))
+			conn := newScriptConn(tt.input...)
+
+			username, err := s.handshake(context.Background(), conn, 1, "10.0.0.1")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handshake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && username != tt.wantUser {
+				t.Errorf("Username = %q, want %q", username, tt.wantUser)
+			}
+			if got := conn.out.Bytes(); !bytes.Equal(got, tt.want) {
+				t.Errorf("Reply = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_NoAcceptableMethodCounted(t *testing.T) {
+	counter := metrics.Default.Counter("dudu_socks5_no_acceptable_method_total", "", "required", "password")
+	before := counter.Value()
+
+	s := NewSOCKS5Proxy(0, WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user": "pass"})))
+	conn := newScriptConn([]byte{socks5Version, 2, authNone, authGSSAPI})
+	if _, err := s.handshake(context.Background(), conn, 1, "10.0.0.1"); err == nil {
+		t.Fatal("Expected the handshake to fail")
+	}
+
+	if got := counter.Value() - before; got != 1 {
+		t.Errorf("Expected the counter to grow by 1, got %d", got)
+	}
+}
+
+func TestSOCKS5Proxy_AuthNegotiationCounted(t *testing.T) {
+	tests := []struct {
+		name           string
+		auth           bool
+		methods        []byte
+		offersPassword string
+		selected       string
+	}{
+		{"no auth", false, []byte{authNone}, "false", "none"},
+		{"no auth offered password too", false, []byte{authNone, authPassword}, "true", "none"},
+		{"password", true, []byte{authNone, authPassword}, "true", "password"},
+		{"rejected", true, []byte{authNone}, "false", "rejected"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := metrics.Default.Counter("dudu_socks5_auth_negotiations_total", "",
+				"offers_password", tt.offersPassword, "selected", tt.selected)
+			before := counter.Value()
+
+			s := NewSOCKS5Proxy(0, WithAuth(middleware.NewAuthMiddleware(tt.auth, map[string]string{"user": "pass"})))
+			greeting := append([]byte{socks5Version, byte(len(tt.methods))}, tt.methods...)
+			s.handshake(context.Background(), newScriptConn(greeting), 1, "10.0.0.1")
+
+			if got := counter.Value() - before; got != 1 {
+				t.Errorf("Expected the counter to grow by 1, got %d", got)
+			}
+		})
+	}
+}
+
+func TestAuthMethodName(t *testing.T) {
+	tests := []struct {
+		method byte
+		want   string
+	}{
+		{authNone, "none"},
+		{authGSSAPI, "gssapi"},
+		{authPassword, "password"},
+		{0x80, "0x80"},
+	}
+
+	for _, tt := range tests {
+		if got := authMethodName(tt.method); got != tt.want {
+			t.Errorf("authMethodName(%#x) = %q, want %q", tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestSOCKS5Proxy_ReadRequestBytes(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     []byte
+		wantReq   *socks5Request
+		wantReply byte // Expected reply code when the request is rejected
+	}{
+		{
+			name:    "IPv4",
+			input:   []byte{socks5Version, cmdConnect, 0, atypIPv4, 10, 0, 0, 1, 0x01, 0xBB},
+			wantReq: &socks5Request{cmd: cmdConnect, atyp: atypIPv4, host: "10.0.0.1", port: 443},
+		},
+		{
+			name:    "domain",
+			input:   append(append([]byte{socks5Version, cmdConnect, 0, atypDomain, 11}, "example.com"...), 0, 80),
+			wantReq: &socks5Request{cmd: cmdConnect, atyp: atypDomain, host: "example.com", port: 80},
+		},
+		{
+			name:    "IPv6",
+			input:   append(append([]byte{socks5Version, cmdConnect, 0, atypIPv6}, net.ParseIP("2001:db8::1")...), 0x1F, 0x90),
+			wantReq: &socks5Request{cmd: cmdConnect, atyp: atypIPv6, host: "2001:db8::1", port: 8080},
+		},
+		{
+			name:      "bad version",
+			input:     []byte{0x04, cmdConnect, 0, atypIPv4, 10, 0, 0, 1, 0, 80},
+			wantReply: repServerFailure,
+		},
+		{
+			name:      "unsupported command",
+			input:     []byte{socks5Version, 0x02, 0, atypIPv4, 10, 0, 0, 1, 0, 80},
+			wantReply: repCommandNotSupported,
+		},
+		{
+			name:      "resolve without extension",
+			input:     []byte{socks5Version, cmdResolve, 0, atypDomain, 1, 'a', 0, 0},
+			wantReply: repCommandNotSupported,
+		},
+		{
+			name:      "unsupported address type",
+			input:     []byte{socks5Version, cmdConnect, 0, 0x05, 10, 0, 0, 1, 0, 80},
+			wantReply: repAddressNotSupported,
+		},
+		{
+			name:      "truncated IPv4",
+			input:     []byte{socks5Version, cmdConnect, 0, atypIPv4, 10, 0},
+			wantReply: repServerFailure,
+		},
+		{
+			name:      "truncated domain",
+			input:     []byte{socks5Version, cmdConnect, 0, atypDomain, 11, 'e', 'x'},
+			wantReply: repServerFailure,
+		},
+		{
+			name:      "truncated IPv6",
+			input:     []byte{socks5Version, cmdConnect, 0, atypIPv6, 0x20, 0x01},
+			wantReply: repServerFailure,
+		},
+		{
+			name:      "truncated port",
+			input:     []byte{socks5Version, cmdConnect, 0, atypIPv4, 10, 0, 0, 1, 0},
+			wantReply: repServerFailure,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSOCKS5Proxy(0)
+			conn := newScriptConn(tt.input)
+
+			req, err := s.readRequest(conn)
+			if tt.wantReq != nil {
+				if err != nil {
+					t.Fatalf("readRequest() error = %v", err)
+				}
+				if *req != *tt.wantReq {
+					t.Errorf("Request = %+v, want %+v", *req, *tt.wantReq)
+				}
+				if conn.out.Len() != 0 {
+					t.Errorf("Expected no reply for a valid request, got %#v", conn.out.Bytes())
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			reply := conn.out.Bytes()
+			if len(reply) < 2 || reply[0] != socks5Version || reply[1] != tt.wantReply {
+				t.Errorf("Reply = %#v, want reply code %#x", reply, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_RequestMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		command string
+		atyp    string
+	}{
+		{"bind", []byte{socks5Version, cmdBind, 0, atypIPv4, 10, 0, 0, 1, 0, 80}, "bind", "ipv4"},
+		{"udp associate", []byte{socks5Version, cmdUDPAssociate, 0, atypIPv6}, "udp_associate", "ipv6"},
+		{"unknown command", []byte{socks5Version, 0x09, 0, 0x07}, "0x09", "0x07"},
+		{"unsupported address type", []byte{socks5Version, cmdConnect, 0, 0x07}, "connect", "0x07"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := metrics.Default.Counter("dudu_socks5_requests_total", "", "command", tt.command, "atyp", tt.atyp)
+			before := counter.Value()
+
+			s := NewSOCKS5Proxy(0)
+			req, err := s.readRequest(newScriptConn(tt.input))
+			if err == nil || req == nil {
+				t.Fatalf("Expected the request to be refused with its header, got %+v, %v", req, err)
+			}
+			s.recordRequest(nextConnID(), "10.0.0.1", req, err)
+			if got := counter.Value() - before; got != 1 {
+				t.Errorf("Expected the request counter to grow by 1, got %d", got)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Proxy_ReadRequestEmpty(t *testing.T) {
+	conn := newScriptConn()
+	if _, err := NewSOCKS5Proxy(0).readRequest(conn); err == nil {
+		t.Error("Expected an error for an empty request")
+	}
+	if conn.out.Len() != 0 {
+		t.Errorf("Expected no reply before the header is read, got %#v", conn.out.Bytes())
+	}
+}
+
+func TestAuthDelay(t *testing.T) {
+	s := NewSOCKS5Proxy(0,
+		WithAuth(middleware.NewAuthMiddleware(true, map[string]string{"user1": "pass1"})),
+		WithAuthDelay(middleware.NewAuthDelayMiddleware(true,
+			manager.NewAuthDelay(40*time.Millisecond, 80*time.Millisecond, time.Minute))),
+	)
+
+	attempt := func(password string) time.Duration {
+		conn := newScriptConn([]byte{socks5Version, 1, authPassword}, authMethod("user1", password))
+		start := time.Now()
+		s.handshake(context.Background(), conn, 1, "10.0.0.1")
+		return time.Since(start)
+	}
+
+	if elapsed := attempt("pass1"); elapsed >= 40*time.Millisecond {
+		t.Errorf("Expected successful auth to be answered at once, took %v", elapsed)
+	}
+	if elapsed := attempt("wrong"); elapsed < 40*time.Millisecond {
+		t.Errorf("Expected the first failure to wait one step, took %v", elapsed)
+	}
+	if elapsed := attempt("wrong"); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected the second failure to wait two steps, took %v", elapsed)
+	}
+	if elapsed := attempt("wrong"); elapsed >= 160*time.Millisecond {
+		t.Errorf("Expected the delay to be capped, took %v", elapsed)
+	}
+
+	// Success resets the delay
+	attempt("pass1")
+	if elapsed := attempt("wrong"); elapsed >= 80*time.Millisecond {
+		t.Errorf("Expected the delay to restart after success, took %v", elapsed)
+	}
+}
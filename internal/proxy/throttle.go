@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"context"
+	"io"
+
+	"golang.org/x/time/rate"
+)
+
+// throttledConn paces the bytes exchanged with a target in both directions
+// through byte-rate limiters, waiting for every limiter before each chunk
+type throttledConn struct {
+	io.ReadWriteCloser
+	ctx      context.Context // Aborts waits when the connection ends
+	limiters []*rate.Limiter
+	chunk    int // Largest read or write, the smallest limiter burst
+}
+
+// newThrottledConn wraps conn so its traffic draws from limiters
+func newThrottledConn(ctx context.Context, conn io.ReadWriteCloser, limiters []*rate.Limiter) *throttledConn {
+	chunk := limiters[0].Burst()
+	for _, limiter := range limiters[1:] {
+		chunk = min(chunk, limiter.Burst())
+	}
+	return &throttledConn{ReadWriteCloser: conn, ctx: ctx, limiters: limiters, chunk: max(chunk, 1)}
+}
+
+// Read reads at most one chunk from the target, then waits until the bytes
+// read fit within every limiter
+func (c *throttledConn) Read(p []byte) (int, error) {
+	if len(p) > c.chunk {
+		p = p[:c.chunk]
+	}
+	n, err := c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		if waitErr := c.wait(n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Write writes p to the target one chunk at a time, waiting before each
+func (c *throttledConn) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), c.chunk)
+		if err := c.wait(n); err != nil {
+			return written, err
+		}
+		n, err := c.ReadWriteCloser.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// wait blocks until n bytes fit within every limiter or the connection ends
+func (c *throttledConn) wait(n int) error {
+	for _, limiter := range c.limiters {
+		if err := limiter.WaitN(c.ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
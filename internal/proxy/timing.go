@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+)
+
+// Connection setup phases, each ending at the milestone it is named after
+const (
+	phaseHandshake   = "handshake"   // From accept until the client's greeting or request is read
+	phaseAuth        = "auth"        // Checking the client's credentials, when auth is enabled
+	phaseDial        = "dial"        // From authentication until the target is connected
+	phaseEstablished = "established" // From the target connecting until the client is told
+)
+
+// setupTimer measures how long a connection spends in each setup phase. A nil
+// timer ignores every call, so code reached without one needs no checks.
+type setupTimer struct {
+	protocol string
+	last     time.Time
+	fields   []interface{} // Alternating "<phase>_ms" names and durations, for the log
+}
+
+// setupTimerKey carries a connection's setupTimer in its context
+type setupTimerKey struct{}
+
+// newSetupTimer starts timing a connection's setup from now
+func newSetupTimer(protocol string) *setupTimer {
+	return &setupTimer{protocol: protocol, last: time.Now()}
+}
+
+// withSetupTimer returns a context carrying t
+func withSetupTimer(ctx context.Context, t *setupTimer) context.Context {
+	return context.WithValue(ctx, setupTimerKey{}, t)
+}
+
+// setupTimerFrom returns the connection's timer, or nil when it has none
+func setupTimerFrom(ctx context.Context) *setupTimer {
+	t, _ := ctx.Value(setupTimerKey{}).(*setupTimer)
+	return t
+}
+
+// mark ends phase now, counting its duration in the setup metrics
+func (t *setupTimer) mark(phase string) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(t.last)
+	t.last = now
+	t.fields = append(t.fields, phase+"_ms", float64(elapsed.Microseconds())/1000)
+
+	metrics.Default.Counter("dudu_connection_setup_microseconds_total", "Time connections spent in each setup phase",
+		"protocol", t.protocol, "phase", phase).Add(elapsed.Microseconds())
+	metrics.Default.Counter("dudu_connection_setup_phases_total", "Connections that completed each setup phase",
+		"protocol", t.protocol, "phase", phase).Inc()
+}
+
+// log writes the phase durations recorded so far with log, usually a debug logger
+func (t *setupTimer) log(log func(msg string, kv ...interface{}), connID uint64, clientIP string) {
+	if t == nil {
+		return
+	}
+
+	log("Connection setup timing", append([]interface{}{
+		"protocol", t.protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+	}, t.fields...)...)
+}
@@ -0,0 +1,57 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+)
+
+func TestSetupTimer(t *testing.T) {
+	timer := newSetupTimer("test")
+	timer.mark(phaseHandshake)
+	timer.mark(phaseDial)
+
+	if len(timer.fields) != 4 || timer.fields[0] != "handshake_ms" || timer.fields[2] != "dial_ms" {
+		t.Fatalf("Unexpected fields %v", timer.fields)
+	}
+	if got := setupTimerFrom(withSetupTimer(context.Background(), timer)); got != timer {
+		t.Error("Expected the timer carried by the context")
+	}
+
+	// Connections handled without a timer, e.g. in tests, aren't timed
+	var none *setupTimer
+	none.mark(phaseAuth)
+	none.log(func(string, ...interface{}) { t.Error("A nil timer should not log") }, 1, "10.0.0.1")
+	if setupTimerFrom(context.Background()) != nil {
+		t.Error("Expected no timer in a bare context")
+	}
+}
+
+func TestSOCKS5Proxy_SetupPhasesCounted(t *testing.T) {
+	phases := []string{phaseHandshake, phaseDial, phaseEstablished}
+	before := make([]int64, len(phases))
+	for i, phase := range phases {
+		before[i] = metrics.Default.Counter("dudu_connection_setup_phases_total", "", "protocol", "socks5", "phase", phase).Value()
+	}
+	auth := metrics.Default.Counter("dudu_connection_setup_phases_total", "", "protocol", "socks5", "phase", phaseAuth)
+	authBefore := auth.Value()
+
+	establishTunnel(t, NewSOCKS5Proxy(0, WithDialer(&echoDialer{})))
+
+	for i, phase := range phases {
+		counter := metrics.Default.Counter("dudu_connection_setup_phases_total", "", "protocol", "socks5", "phase", phase)
+		// The established phase ends just after the reply the client has already read
+		deadline := time.Now().Add(time.Second)
+		for counter.Value() == before[i] && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		if got := counter.Value() - before[i]; got != 1 {
+			t.Errorf("Expected phase %s to be counted once, got %d", phase, got)
+		}
+	}
+	if got := auth.Value() - authBefore; got != 0 {
+		t.Errorf("Expected no auth phase without authentication, got %d", got)
+	}
+}
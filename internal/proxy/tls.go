@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// certSelector picks a certificate by SNI, falling back to the first certificate
+type certSelector struct {
+	byName      map[string]*tls.Certificate // Exact names, lower case
+	wildcards   map[string]*tls.Certificate // "*.example.com" keyed by "example.com"
+	defaultCert *tls.Certificate
+}
+
+// NewTLSConfig builds a server TLS config serving certs. The certificate is chosen
+// by the client's SNI using each certificate's DNS names (wildcards included); when
+// SNI is missing or matches nothing the first certificate is used.
+func NewTLSConfig(certs []tls.Certificate) (*tls.Config, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("at least one certificate is required")
+	}
+
+	selector := &certSelector{
+		byName:      make(map[string]*tls.Certificate),
+		wildcards:   make(map[string]*tls.Certificate),
+		defaultCert: &certs[0],
+	}
+
+	for i := range certs {
+		cert := &certs[i]
+		leaf := cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				return nil, fmt.Errorf("failed to parse certificate %d: %w", i, err)
+			}
+			cert.Leaf = leaf
+		}
+
+		names := leaf.DNSNames
+		if len(names) == 0 && leaf.Subject.CommonName != "" {
+			names = []string{leaf.Subject.CommonName}
+		}
+		for _, name := range names {
+			selector.add(strings.ToLower(name), cert)
+		}
+	}
+
+	return &tls.Config{
+		GetCertificate: selector.getCertificate,
+	}, nil
+}
+
+// add registers cert for name. The first certificate registered for a name wins.
+func (c *certSelector) add(name string, cert *tls.Certificate) {
+	target, key := c.byName, name
+	if strings.HasPrefix(name, "*.") {
+		target, key = c.wildcards, name[2:]
+	}
+
+	if _, exists := target[key]; !exists {
+		target[key] = cert
+	}
+}
+
+// getCertificate implements tls.Config.GetCertificate
+func (c *certSelector) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name == "" {
+		return c.defaultCert, nil
+	}
+
+	if cert, ok := c.byName[name]; ok {
+		return cert, nil
+	}
+
+	// A wildcard covers exactly one label
+	if _, parent, ok := strings.Cut(name, "."); ok {
+		if cert, ok := c.wildcards[parent]; ok {
+			return cert, nil
+		}
+	}
+
+	return c.defaultCert, nil
+}
+
+// tlsHandshake completes the handshake of a connection accepted on a TLS
+// listener, bounded by the handshake timeout, and logs what was negotiated.
+// Plain connections are left alone.
+func (o *options) tlsHandshake(ctx context.Context, conn net.Conn, protocol string, connID uint64, clientIP string) error {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+
+	if o.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.handshakeTimeout)
+		defer cancel()
+	}
+
+	log := o.debugLog(clientIP)
+	if o.logTLSHandshakes {
+		log = logger.Info
+	}
+
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		log("TLS handshake failed",
+			"protocol", protocol,
+			"conn_id", connID,
+			"client_ip", clientIP,
+			"error", err)
+		return err
+	}
+
+	state := tlsConn.ConnectionState()
+	fields := []interface{}{
+		"protocol", protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"tls_version", tls.VersionName(state.Version),
+		"cipher_suite", tls.CipherSuiteName(state.CipherSuite),
+		"server_name", state.ServerName,
+	}
+	if len(state.PeerCertificates) > 0 {
+		fields = append(fields, "client_cert_subject", state.PeerCertificates[0].Subject.String())
+	}
+	log("TLS handshake completed", fields...)
+	return nil
+}
+
+// clientCertName returns the common name of the verified client certificate
+// on conn, reporting false for plain connections and ones without a certificate
+func clientCertName(conn any) (string, bool) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return "", false
+	}
+	chains := tlsConn.ConnectionState().VerifiedChains
+	if len(chains) == 0 || len(chains[0]) == 0 {
+		return "", false
+	}
+	return chains[0][0].Subject.CommonName, true
+}
+
+// certUsernameMatches reports whether username may be used on conn. With
+// WithCertUsernameMatch, a TLS connection presenting a verified client
+// certificate must have authenticated as the certificate's common name;
+// mismatches are logged as a security event and counted.
+func (o *options) certUsernameMatches(conn any, protocol string, connID uint64, clientIP, username string) bool {
+	if !o.certUsernameMatch {
+		return true
+	}
+	certName, ok := clientCertName(conn)
+	if !ok || certName == username {
+		return true
+	}
+
+	logger.Warn("Security: username does not match client certificate",
+		"protocol", protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"username", username,
+		"cert_common_name", certName)
+	metrics.Default.Counter("dudu_cert_username_mismatches_total", "Authenticated usernames rejected for not matching the client certificate",
+		"protocol", protocol).Inc()
+	return false
+}
+
+// tlsDialer wraps the connections forward makes in TLS, for upstream proxies
+// that are reached over TLS
+type tlsDialer struct {
+	config  *tls.Config
+	forward Dialer
+}
+
+// DialContext connects to address and completes the TLS handshake within ctx.
+// The certificate is verified for address's host unless config names a server.
+func (d *tlsDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	config := d.config
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	conn, err := d.forward.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+	}
+	return tlsConn, nil
+}
@@ -0,0 +1,200 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// newTestCert creates a self-signed certificate for the given DNS names
+func newTestCert(t *testing.T, names ...string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: names[0]},
+		DNSNames:     names,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestNewTLSConfig_SNI(t *testing.T) {
+	tlsConfig, err := NewTLSConfig([]tls.Certificate{
+		newTestCert(t, "default.example.com"),
+		newTestCert(t, "a.example.com"),
+		newTestCert(t, "*.b.example.com"),
+	})
+	if err != nil {
+		t.Fatalf("NewTLSConfig failed: %v", err)
+	}
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{"a.example.com", "a.example.com"},
+		{"A.Example.COM", "a.example.com"},
+		{"www.b.example.com", "*.b.example.com"},
+		{"deep.www.b.example.com", "default.example.com"},
+		{"unknown.example.org", "default.example.com"},
+		{"", "default.example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+
+			go func() {
+				defer server.Close()
+				tls.Server(server, tlsConfig).Handshake()
+			}()
+
+			conn := tls.Client(client, &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true})
+			if err := conn.Handshake(); err != nil {
+				t.Fatalf("Handshake failed: %v", err)
+			}
+
+			got := conn.ConnectionState().PeerCertificates[0].DNSNames[0]
+			if got != tt.want {
+				t.Errorf("Expected certificate for %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfig_NoCertificates(t *testing.T) {
+	if _, err := NewTLSConfig(nil); err == nil {
+		t.Error("Expected error without certificates")
+	}
+}
+
+func TestTLSHandshake(t *testing.T) {
+	tlsConfig, err := NewTLSConfig([]tls.Certificate{newTestCert(t, "proxy.example.com")})
+	if err != nil {
+		t.Fatalf("NewTLSConfig() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		client  func(net.Conn)
+		wantErr bool
+	}{
+		{"completes", func(conn net.Conn) {
+			tls.Client(conn, &tls.Config{ServerName: "proxy.example.com", InsecureSkipVerify: true}).Handshake()
+		}, false},
+		{"plaintext client", func(conn net.Conn) {
+			conn.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+		}, true},
+		{"stalled client", func(conn net.Conn) {}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+			go tt.client(client)
+
+			o := newOptions([]Option{WithTLS(tlsConfig), WithHandshakeTimeout(100 * time.Millisecond)})
+			err := o.tlsHandshake(context.Background(), tls.Server(server, tlsConfig), "http", 1, "127.0.0.1")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("tlsHandshake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("plain connection", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+
+		o := newOptions(nil)
+		if err := o.tlsHandshake(context.Background(), server, "http", 1, "127.0.0.1"); err != nil {
+			t.Errorf("tlsHandshake() on a plain connection error = %v", err)
+		}
+	})
+}
+
+func TestCertUsernameMatches(t *testing.T) {
+	serverCert := newTestCert(t, "proxy.example.com")
+	clientCert := newTestCert(t, "alice")
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Failed to parse client certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+
+	// handshake returns the server side of a mutual TLS connection
+	handshake := func(t *testing.T) *tls.Conn {
+		client, server := net.Pipe()
+		t.Cleanup(func() { client.Close(); server.Close() })
+		go tls.Client(client, &tls.Config{
+			ServerName:         "proxy.example.com",
+			InsecureSkipVerify: true,
+			Certificates:       []tls.Certificate{clientCert},
+		}).Handshake()
+
+		conn := tls.Server(server, &tls.Config{
+			Certificates: []tls.Certificate{serverCert},
+			ClientCAs:    pool,
+			ClientAuth:   tls.RequireAndVerifyClientCert,
+		})
+		if err := conn.Handshake(); err != nil {
+			t.Fatalf("Handshake() error = %v", err)
+		}
+		return conn
+	}
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		username string
+		want     bool
+	}{
+		{"matching username", true, "alice", true},
+		{"mismatched username", true, "bob", false},
+		{"disabled", false, "bob", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions([]Option{WithCertUsernameMatch(tt.enabled)})
+			if got := o.certUsernameMatches(handshake(t), "http", 1, "127.0.0.1", tt.username); got != tt.want {
+				t.Errorf("certUsernameMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("plain connection", func(t *testing.T) {
+		client, server := net.Pipe()
+		defer client.Close()
+		defer server.Close()
+
+		o := newOptions([]Option{WithCertUsernameMatch(true)})
+		if !o.certUsernameMatches(server, "socks5", 1, "127.0.0.1", "bob") {
+			t.Error("certUsernameMatches() on a plain connection = false, want true")
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/events"
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"golang.org/x/time/rate"
+)
+
+// countingConn counts the bytes exchanged with a target: writes go up from
+// the client, reads come down to it
+type countingConn struct {
+	io.ReadWriteCloser
+	tracked *manager.TrackedConn
+}
+
+// Read reads from the target and counts the bytes as download
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.tracked.AddDown(int64(n))
+	return n, err
+}
+
+// Write writes to the target and counts the bytes as upload
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.tracked.AddUp(int64(n))
+	return n, err
+}
+
+// track registers an established connection and returns targetConn wrapped to
+// count its traffic and pace it through the client's bandwidth limits, along
+// with a context derived from ctx that is canceled when the registry aborts
+// the connection, with errClosedByAdmin as its cause.
+// done removes it from the registry and returns its final byte counts; calls
+// after the first return the same counts.
+func (o *options) track(ctx context.Context, targetConn io.ReadWriteCloser, connID uint64, protocol, clientIP, username, target string) (context.Context, io.ReadWriteCloser, func() manager.ConnInfo) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	tracked := o.conns.Register(connID, protocol, clientIP, username, target, func() { cancel(errClosedByAdmin) })
+
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	if o.targetStats != nil {
+		o.targetStats.RecordConnection(host)
+	}
+	if len(o.events) > 0 {
+		info := tracked.Info()
+		o.publish(events.Event{Type: events.TypeOpen, Time: info.StartedAt, ConnInfo: info})
+	}
+
+	var conn io.ReadWriteCloser = &countingConn{ReadWriteCloser: targetConn, tracked: tracked}
+	limiters, releaseBandwidth := o.acquireBandwidth(clientIP, username)
+	if len(limiters) > 0 {
+		conn = newThrottledConn(ctx, conn, limiters)
+	}
+
+	var once sync.Once
+	var final manager.ConnInfo
+	return ctx, conn, func() manager.ConnInfo {
+		once.Do(func() {
+			tracked.Close()
+			cancel(nil)
+			releaseBandwidth()
+			final = tracked.Info()
+			if o.targetStats != nil {
+				o.targetStats.RecordBytes(host, final.BytesUp+final.BytesDown)
+			}
+			if len(o.events) > 0 || o.userLog != nil {
+				now := time.Now()
+				event := events.Event{
+					Type:       events.TypeClose,
+					Time:       now,
+					ConnInfo:   final,
+					DurationMs: now.Sub(final.StartedAt).Milliseconds(),
+				}
+				o.publish(event)
+				if o.userLog != nil {
+					o.userLog.Publish(event)
+				}
+			}
+		})
+		return final
+	}
+}
+
+// publish sends e to every event publisher
+func (o *options) publish(e events.Event) {
+	for _, p := range o.events {
+		p.Publish(e)
+	}
+}
+
+// publishAuthFailure publishes an event for a client that failed to authenticate
+func (o *options) publishAuthFailure(protocol string, connID uint64, clientIP, username string) {
+	if len(o.events) == 0 {
+		return
+	}
+	o.publish(events.Event{
+		Type: events.TypeAuthFailure,
+		Time: time.Now(),
+		ConnInfo: manager.ConnInfo{
+			ConnID:   connID,
+			Protocol: protocol,
+			ClientIP: clientIP,
+			Username: username,
+		},
+	})
+}
+
+// acquireBandwidth returns the byte-rate limiters that apply to a client and
+// a function releasing them. Per-user limits only apply to authenticated
+// users, since without authentication every client shares one username.
+func (o *options) acquireBandwidth(clientIP, username string) ([]*rate.Limiter, func()) {
+	var limiters []*rate.Limiter
+	var releases []func()
+	if o.ipBandwidth != nil {
+		limiter, release := o.ipBandwidth.Acquire(clientIP)
+		limiters, releases = append(limiters, limiter), append(releases, release)
+	}
+	if o.userBandwidth != nil && o.auth.IsEnabled() {
+		limiter, release := o.userBandwidth.Acquire(username)
+		limiters, releases = append(limiters, limiter), append(releases, release)
+	}
+
+	return limiters, func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// closeReason names why a tunnel ended
+type closeReason string
+
+// Reasons reported as close_reason and in dudu_tunnel_closes_total
+const (
+	closeClientClosed   closeReason = "client_closed"      // The client finished sending
+	closeUpstreamClosed closeReason = "upstream_closed"    // The target finished sending
+	closeLifetime       closeReason = "lifetime_exceeded"  // The connection timeout passed
+	closeAdmin          closeReason = "admin_closed"       // Closed through the admin API, e.g. a user drain
+	closeShutdown       closeReason = "shutdown"           // The proxy is stopping
+	closeTimeout        closeReason = "timeout"            // The client stayed silent or stopped reading past its read or write timeout
+	closeError          closeReason = "error"              // Reading or writing either side failed
+	closeExpired        closeReason = "credential_expired" // The token the client authenticated with expired
+)
+
+var (
+	// errClosedByAdmin is the cause of a tracked connection's context when
+	// the registry aborts it
+	errClosedByAdmin = errors.New("connection closed by admin")
+	// errCredentialExpired is the cause of a connection's context when the
+	// token it authenticated with expires
+	errCredentialExpired = errors.New("credential expired")
+)
+
+// transfer bidirectionally copies data between a client and its target until
+// either side finishes or ctx is done, in which case both connections are
+// closed. It returns why the tunnel ended.
+func transfer(ctx context.Context, client, target io.ReadWriteCloser) closeReason {
+	stop := context.AfterFunc(ctx, func() {
+		client.Close()
+		target.Close()
+	})
+	defer stop()
+
+	done := make(chan closeReason, 2)
+
+	go func() {
+		_, err := io.Copy(client, target)
+		done <- copyCloseReason(err, closeUpstreamClosed)
+	}()
+
+	go func() {
+		_, err := io.Copy(target, client)
+		done <- copyCloseReason(err, closeClientClosed)
+	}()
+
+	reason := <-done
+	// Copies cut short by ctx fail with errors about closed connections
+	if ctx.Err() != nil {
+		return ctxCloseReason(ctx)
+	}
+	return reason
+}
+
+// bytesPerSecond returns the average rate of n bytes moved over d, 0 when d is
+// not positive
+func bytesPerSecond(n int64, d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(float64(n) / d.Seconds())
+}
+
+// copyCloseReason returns eof when a copy ended because its source finished
+func copyCloseReason(err error, eof closeReason) closeReason {
+	switch {
+	case err == nil:
+		return eof
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return closeTimeout
+	default:
+		return closeError
+	}
+}
+
+// deadliner is a connection whose reads and writes can be given deadlines
+type deadliner interface {
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// timeoutConn gives each read and write on a client connection a fresh
+// deadline, so the tunnel fails once the client sends nothing for the read
+// timeout or a write to it blocks for the write timeout
+type timeoutConn struct {
+	io.ReadWriteCloser
+	conn         deadliner
+	readTimeout  time.Duration // Zero leaves reads unbounded
+	writeTimeout time.Duration // Zero leaves writes unbounded
+}
+
+// Read reads from the client within the read timeout
+func (c *timeoutConn) Read(p []byte) (int, error) {
+	if c.readTimeout > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+	return c.ReadWriteCloser.Read(p)
+}
+
+// Write writes to the client within the write timeout
+func (c *timeoutConn) Write(p []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	return c.ReadWriteCloser.Write(p)
+}
+
+// withClientTimeouts wraps a tunnel's client connection to apply the read and
+// write timeouts. It is returned as is when neither is set, keeping io.Copy's
+// fast paths, or when it doesn't support deadlines.
+func (o *options) withClientTimeouts(client io.ReadWriteCloser) io.ReadWriteCloser {
+	conn, ok := client.(deadliner)
+	if !ok || (o.readTimeout <= 0 && o.writeTimeout <= 0) {
+		return client
+	}
+	return &timeoutConn{ReadWriteCloser: client, conn: conn, readTimeout: o.readTimeout, writeTimeout: o.writeTimeout}
+}
+
+// ctxCloseReason returns why ctx, a tracked connection's context, is done
+func ctxCloseReason(ctx context.Context) closeReason {
+	switch {
+	case errors.Is(context.Cause(ctx), errClosedByAdmin):
+		return closeAdmin
+	case errors.Is(context.Cause(ctx), errCredentialExpired):
+		return closeExpired
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return closeLifetime
+	default:
+		return closeShutdown
+	}
+}
+
+// logTunnelClose logs why a tunnel ended, with the bytes info records it
+// exchanged and their average rate, and counts it by protocol and reason
+func logTunnelClose(protocol string, connID uint64, clientIP string, clientPort int, target, category string, reason closeReason, info manager.ConnInfo) {
+	duration := time.Since(info.StartedAt)
+	logger.Info("Tunnel closed", withCategory(withClientPort([]interface{}{
+		"close_reason", string(reason),
+		"protocol", protocol,
+		"conn_id", connID,
+		"client_ip", clientIP,
+		"target", target,
+		"bytes_up", info.BytesUp,
+		"bytes_down", info.BytesDown,
+		"duration_ms", duration.Milliseconds(),
+		"avg_bps_up", bytesPerSecond(info.BytesUp, duration),
+		"avg_bps_down", bytesPerSecond(info.BytesDown, duration),
+	}, clientPort), category)...)
+
+	metrics.Default.Counter("dudu_tunnel_closes_total", "Tunnels closed, by why they ended",
+		"protocol", protocol, "reason", string(reason)).Inc()
+}
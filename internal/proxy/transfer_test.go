@@ -0,0 +1,376 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"golang.org/x/time/rate"
+)
+
+// establishTunnel opens a SOCKS5 tunnel through s to an echo target and returns the client end
+func establishTunnel(t *testing.T, s *SOCKS5Proxy) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+	go s.handleConnection(server)
+
+	go func() {
+		client.Write([]byte{socks5Version, 1, authNone})
+		client.Write(append([]byte{socks5Version, cmdConnect, 0, atypDomain, 11}, "example.com\x00\x50"...))
+	}()
+
+	reply := make([]byte, 12)
+	if _, err := io.ReadFull(client, reply); err != nil || reply[3] != repSuccess {
+		t.Fatalf("Failed to establish tunnel: %#v (%v)", reply, err)
+	}
+	return client
+}
+
+// expectClosed fails unless conn is closed by the proxy within a second
+func expectClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, conn)
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the tunnel to be closed")
+	}
+}
+
+func TestTransfer_StopAbortsTunnels(t *testing.T) {
+	s := NewSOCKS5Proxy(0, WithDialer(&echoDialer{}))
+	client := establishTunnel(t, s)
+
+	s.Stop()
+	expectClosed(t, client)
+}
+
+func TestTransfer_ConnectionTimeout(t *testing.T) {
+	s := NewSOCKS5Proxy(0, WithDialer(&echoDialer{}), WithConnectionTimeout(100*time.Millisecond))
+	client := establishTunnel(t, s)
+
+	// The tunnel works until the deadline
+	go client.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(client, echo); err != nil {
+		t.Fatalf("Expected echoed data before the deadline: %v", err)
+	}
+
+	expectClosed(t, client)
+}
+
+func TestTransfer_CloseReason(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(client, target net.Conn, cancel context.CancelCauseFunc)
+		ctx  func() (context.Context, context.CancelCauseFunc)
+		want closeReason
+	}{
+		{
+			name: "client closed",
+			end:  func(client, target net.Conn, cancel context.CancelCauseFunc) { client.Close() },
+			want: closeClientClosed,
+		},
+		{
+			name: "upstream closed",
+			end:  func(client, target net.Conn, cancel context.CancelCauseFunc) { target.Close() },
+			want: closeUpstreamClosed,
+		},
+		{
+			name: "admin closed",
+			end:  func(client, target net.Conn, cancel context.CancelCauseFunc) { cancel(errClosedByAdmin) },
+			want: closeAdmin,
+		},
+		{
+			name: "shutdown",
+			end:  func(client, target net.Conn, cancel context.CancelCauseFunc) { cancel(nil) },
+			want: closeShutdown,
+		},
+		{
+			name: "lifetime exceeded",
+			end:  func(client, target net.Conn, cancel context.CancelCauseFunc) {},
+			ctx: func() (context.Context, context.CancelCauseFunc) {
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				return ctx, func(error) { cancel() }
+			},
+			want: closeLifetime,
+		},
+		{
+			name: "credential expired",
+			end:  func(client, target net.Conn, cancel context.CancelCauseFunc) {},
+			ctx: func() (context.Context, context.CancelCauseFunc) {
+				ctx, cancel := context.WithDeadlineCause(context.Background(), time.Now().Add(20*time.Millisecond), errCredentialExpired)
+				return ctx, func(error) { cancel() }
+			},
+			want: closeExpired,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancelCause(context.Background())
+			if tt.ctx != nil {
+				ctx, cancel = tt.ctx()
+			}
+			defer cancel(nil)
+
+			client, clientEnd := net.Pipe()
+			target, targetEnd := net.Pipe()
+			defer clientEnd.Close()
+			defer targetEnd.Close()
+
+			go tt.end(client, target, cancel)
+			if got := transfer(ctx, clientEnd, targetEnd); got != tt.want {
+				t.Errorf("transfer() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransfer_ClientTimeouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		read  time.Duration
+		write time.Duration
+		// target acts on the target's end of the tunnel
+		target func(target net.Conn)
+	}{
+		{"silent client", 20 * time.Millisecond, 0, func(target net.Conn) {}},
+		{"client not reading", 0, 20 * time.Millisecond, func(target net.Conn) { target.Write([]byte("response")) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, clientEnd := net.Pipe()
+			target, targetEnd := net.Pipe()
+			defer client.Close()
+			defer target.Close()
+
+			go tt.target(target)
+			o := newOptions([]Option{WithTransferTimeouts(tt.read, tt.write)})
+			if got := transfer(context.Background(), o.withClientTimeouts(clientEnd), targetEnd); got != closeTimeout {
+				t.Errorf("transfer() = %q, want %q", got, closeTimeout)
+			}
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		client, _ := net.Pipe()
+		defer client.Close()
+
+		o := newOptions(nil)
+		if got := o.withClientTimeouts(client); got != client {
+			t.Error("Expected the connection unwrapped without timeouts")
+		}
+	})
+}
+
+// blockingDialer blocks every dial until its context is done
+type blockingDialer struct{}
+
+func (blockingDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestDial_Canceled(t *testing.T) {
+	o := newOptions([]Option{WithDialer(blockingDialer{}), WithDialTimeout(time.Minute)})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+
+	start := time.Now()
+	if _, err := o.dial(ctx, "example.com:80"); err == nil {
+		t.Fatal("Expected the dial to fail once canceled")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Dial took %v, expected it to stop on cancel", elapsed)
+	}
+}
+
+func TestTransfer_TracksConnection(t *testing.T) {
+	conns := manager.NewConnRegistry()
+	s := NewSOCKS5Proxy(0, WithDialer(&echoDialer{}), WithConnRegistry(conns))
+	client := establishTunnel(t, s)
+
+	go client.Write([]byte("ping"))
+	echo := make([]byte, 4)
+	if _, err := io.ReadFull(client, echo); err != nil {
+		t.Fatalf("Expected echoed data: %v", err)
+	}
+
+	list := conns.List()
+	if len(list) != 1 || list[0].Protocol != "socks5" || list[0].Target != "example.com:80" {
+		t.Fatalf("Unexpected tracked connections: %+v", list)
+	}
+	if up, down := conns.TotalBytes(); up != 4 || down != 4 {
+		t.Errorf("Expected 4 bytes each way, got up=%d down=%d", up, down)
+	}
+
+	client.Close()
+	deadline := time.Now().Add(time.Second)
+	for conns.Count() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := conns.Count(); n != 0 {
+		t.Errorf("Expected the connection to be unregistered, %d remain", n)
+	}
+}
+
+func TestTransfer_CloseUser(t *testing.T) {
+	conns := manager.NewConnRegistry()
+	s := NewSOCKS5Proxy(0, WithDialer(&echoDialer{}), WithConnRegistry(conns), WithAnonymousUser("guest"))
+	client := establishTunnel(t, s)
+
+	if n := conns.CloseUser("guest"); n != 1 {
+		t.Fatalf("Expected 1 connection closed, got %d", n)
+	}
+	expectClosed(t, client)
+}
+
+func TestBytesPerSecond(t *testing.T) {
+	tests := []struct {
+		n    int64
+		d    time.Duration
+		want int64
+	}{
+		{1000, time.Second, 1000},
+		{1000, 500 * time.Millisecond, 2000},
+		{3000, 2 * time.Second, 1500},
+		{1000, 0, 0},
+		{0, time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		if got := bytesPerSecond(tt.n, tt.d); got != tt.want {
+			t.Errorf("bytesPerSecond(%d, %s) = %d, want %d", tt.n, tt.d, got, tt.want)
+		}
+	}
+}
+
+// recordingConn records the size of every write and reads from an endless source
+type recordingConn struct {
+	writes []int
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) { return len(p), nil }
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.writes = append(c.writes, len(p))
+	return len(p), nil
+}
+func (c *recordingConn) Close() error { return nil }
+
+func TestThrottledConn(t *testing.T) {
+	perIP := rate.NewLimiter(100_000, 10_000)
+	perUser := rate.NewLimiter(1_000_000, 50_000)
+	target := &recordingConn{}
+	conn := newThrottledConn(context.Background(), target, []*rate.Limiter{perIP, perUser})
+
+	start := time.Now()
+	if n, err := conn.Write(make([]byte, 25_000)); n != 25_000 || err != nil {
+		t.Fatalf("Write() = %d, %v", n, err)
+	}
+	// Reads draw from the same limiters as writes
+	if n, err := conn.Read(make([]byte, 32*1024)); n != 10_000 || err != nil {
+		t.Fatalf("Read() = %d, %v, want one chunk of the smallest burst", n, err)
+	}
+	elapsed := time.Since(start)
+
+	for _, n := range target.writes {
+		if n > 10_000 {
+			t.Errorf("Expected writes of at most the smallest burst, got %d", n)
+		}
+	}
+	// 35,000 bytes at 100,000/s with 10,000 up front take about 250ms
+	if elapsed < 200*time.Millisecond {
+		t.Errorf("Expected the per-IP limit to pace traffic, took %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	conn = newThrottledConn(ctx, target, []*rate.Limiter{rate.NewLimiter(1, 1)})
+	if _, err := conn.Write([]byte{0}); err == nil {
+		t.Error("Expected waiting to fail once the connection's context is done")
+	}
+}
+
+// sourceConn is a connection whose reads return n zero bytes, then EOF when
+// eof is set or block until closed otherwise. Writes are discarded.
+type sourceConn struct {
+	n      int64
+	eof    bool
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newSourceConn(n int64, eof bool) *sourceConn {
+	return &sourceConn{n: n, eof: eof, closed: make(chan struct{})}
+}
+
+func (c *sourceConn) Read(p []byte) (int, error) {
+	if c.n == 0 {
+		if !c.eof {
+			<-c.closed
+		}
+		return 0, io.EOF
+	}
+	n := int64(len(p))
+	if n > c.n {
+		n = c.n
+	}
+	c.n -= n
+	return int(n), nil
+}
+
+func (c *sourceConn) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *sourceConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+// BenchmarkTransfer moves 1 MiB per tunnel over many tunnels at once, with
+// and without the byte counting every tracked connection does
+func BenchmarkTransfer(b *testing.B) {
+	const size = 1 << 20
+
+	for _, counted := range []bool{false, true} {
+		name := "plain"
+		if counted {
+			name = "counted"
+		}
+		b.Run(name, func(b *testing.B) {
+			conns := manager.NewConnRegistry()
+			b.SetBytes(size)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					client := newSourceConn(size, true)
+					target := newSourceConn(0, false)
+					var conn io.ReadWriteCloser = target
+					var tracked *manager.TrackedConn
+					if counted {
+						tracked = conns.Register(6, "socks5", "10.0.0.1", "anonymous", "example.com:443", nil)
+						conn = &countingConn{ReadWriteCloser: target, tracked: tracked}
+					}
+					transfer(context.Background(), client, conn)
+					target.Close()
+					if tracked != nil {
+						tracked.Close()
+					}
+				}
+			})
+		})
+	}
+}
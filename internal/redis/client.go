@@ -0,0 +1,390 @@
+// Package redis implements the small subset of the Redis protocol (RESP2)
+// needed to share state between proxy instances, without an external client
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+// Error is an error reply sent by the server, such as a script error
+type Error string
+
+// Error returns the server's message
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+var (
+	// ErrClosed is returned by Do after Close
+	ErrClosed = errors.New("redis: client closed")
+	// ErrUnavailable is returned by Do while the server is unreachable and
+	// the health check has not yet found it back
+	ErrUnavailable = errors.New("redis: server unavailable")
+)
+
+// Client sends commands to a single Redis server over a small pool of
+// connections. It is safe for concurrent use. A command failing on its
+// connection marks the server disconnected; the next command that succeeds,
+// or with a health check the next successful PING, marks it connected again.
+type Client struct {
+	address     string
+	password    string
+	db          int
+	timeout     time.Duration // Bounds dialing and each command
+	maxIdle     int
+	healthCheck time.Duration // Interval between PINGs, zero disables
+
+	disconnected atomic.Bool
+	stop         chan struct{}
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+// conn is one connection with its buffered reader
+type conn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+// Option configures optional Client behavior
+type Option func(*Client)
+
+// WithPassword authenticates new connections with AUTH
+func WithPassword(password string) Option {
+	return func(c *Client) {
+		c.password = password
+	}
+}
+
+// WithDB selects the logical database on new connections
+func WithDB(db int) Option {
+	return func(c *Client) {
+		c.db = db
+	}
+}
+
+// WithTimeout bounds dialing and each command when the context has no
+// earlier deadline
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithMaxIdle sets how many connections are kept open between commands
+func WithMaxIdle(n int) Option {
+	return func(c *Client) {
+		c.maxIdle = n
+	}
+}
+
+// WithHealthCheck sends a PING every interval. While the server is
+// disconnected, commands fail at once with ErrUnavailable instead of each
+// waiting on a dial, until a PING succeeds.
+func WithHealthCheck(interval time.Duration) Option {
+	return func(c *Client) {
+		c.healthCheck = interval
+	}
+}
+
+// NewClient creates a client for the server at address (host:port).
+// Connections are opened lazily by the first command.
+func NewClient(address string, opts ...Option) *Client {
+	c := &Client{
+		address: address,
+		timeout: time.Second,
+		maxIdle: 8,
+		stop:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.healthCheck > 0 {
+		go c.healthLoop()
+	}
+
+	return c
+}
+
+// Connected reports whether the last exchange with the server succeeded
+func (c *Client) Connected() bool {
+	return !c.disconnected.Load()
+}
+
+// Do sends one command and returns its reply: string for simple strings,
+// int64 for integers, []byte for bulk strings (nil when null) and
+// []interface{} for arrays. Error replies are returned as Error.
+func (c *Client) Do(ctx context.Context, args ...string) (interface{}, error) {
+	if c.healthCheck > 0 && c.disconnected.Load() {
+		return nil, ErrUnavailable
+	}
+	return c.do(ctx, args)
+}
+
+// do sends one command, tracking whether the server is reachable
+func (c *Client) do(ctx context.Context, args []string) (interface{}, error) {
+	reply, err := c.exchange(ctx, args)
+	var replyErr Error
+	switch {
+	case err == nil, errors.As(err, &replyErr):
+		c.markConnected()
+	case !errors.Is(err, ErrClosed):
+		c.markDisconnected(err)
+	}
+	return reply, err
+}
+
+// exchange sends one command on a pooled connection and reads its reply
+func (c *Client) exchange(ctx context.Context, args []string) (interface{}, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.roundTrip(ctx, args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection may hold a partial reply
+		cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes idle connections and fails later commands. Commands in
+// flight finish and close their connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.closed {
+		close(c.stop)
+	}
+	c.closed = true
+	c.closeIdle()
+
+	return nil
+}
+
+// markDisconnected records that an exchange failed, logging the transition
+// and dropping idle connections, which likely broke with the one that failed
+func (c *Client) markDisconnected(err error) {
+	if !c.disconnected.CompareAndSwap(false, true) {
+		return
+	}
+	logger.Warn("Redis connection lost", "address", c.address, "error", err)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closeIdle()
+}
+
+// markConnected records that an exchange succeeded, logging the transition
+func (c *Client) markConnected() {
+	if c.disconnected.CompareAndSwap(true, false) {
+		logger.Info("Redis connection restored", "address", c.address)
+	}
+}
+
+// closeIdle closes the pooled connections. The caller must hold mu.
+func (c *Client) closeIdle() {
+	for _, cn := range c.idle {
+		cn.Close()
+	}
+	c.idle = nil
+}
+
+// healthLoop pings the server every health check interval until Close
+func (c *Client) healthLoop() {
+	ticker := time.NewTicker(c.healthCheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.do(context.Background(), []string{"PING"})
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// get returns an idle connection or dials a new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", c.address)
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to connect to %s: %w", c.address, err)
+	}
+	cn := &conn{Conn: nc, br: bufio.NewReader(nc)}
+
+	if c.password != "" {
+		if _, err := cn.roundTrip(ctx, []string{"AUTH", c.password}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+	if c.db != 0 {
+		if _, err := cn.roundTrip(ctx, []string{"SELECT", strconv.Itoa(c.db)}); err != nil {
+			cn.Close()
+			return nil, err
+		}
+	}
+
+	return cn, nil
+}
+
+// put returns a healthy connection to the pool, closing it when the pool is full
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed || len(c.idle) >= c.maxIdle {
+		cn.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// roundTrip writes one command and reads its reply within ctx's deadline
+func (cn *conn) roundTrip(ctx context.Context, args []string) (interface{}, error) {
+	deadline, _ := ctx.Deadline()
+	cn.SetDeadline(deadline)
+
+	// Unblock the exchange when ctx is canceled
+	stop := context.AfterFunc(ctx, func() { cn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+
+	if _, err := cn.Write(appendCommand(nil, args)); err != nil {
+		return nil, fmt.Errorf("redis: failed to send command: %w", err)
+	}
+
+	reply, err := readReply(cn.br)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return reply, err
+}
+
+// appendCommand encodes args as a RESP array of bulk strings
+func appendCommand(buf []byte, args []string) []byte {
+	buf = append(buf, '*')
+	buf = strconv.AppendInt(buf, int64(len(args)), 10)
+	buf = append(buf, '\r', '\n')
+	for _, arg := range args {
+		buf = append(buf, '$')
+		buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+		buf = append(buf, '\r', '\n')
+		buf = append(buf, arg...)
+		buf = append(buf, '\r', '\n')
+	}
+	return buf
+}
+
+// readReply decodes one RESP2 reply
+func readReply(br *bufio.Reader) (interface{}, error) {
+	line, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return string(line[1:]), nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: invalid integer reply %q", line)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid bulk length %q", line)
+		}
+		if n == -1 {
+			return []byte(nil), nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, fmt.Errorf("redis: failed to read reply: %w", err)
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: invalid array length %q", line)
+		}
+		if n == -1 {
+			return []interface{}(nil), nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			// Errors nested in arrays are values, not failures of the command
+			item, err := readReply(br)
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", line[0])
+	}
+}
+
+// readLine reads one CRLF-terminated line without the terminator
+func readLine(br *bufio.Reader) ([]byte, error) {
+	line, err := br.ReadSlice('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: failed to read reply: %w", err)
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply line %q", line)
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,222 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers each command with the reply mapped to its name and
+// records the commands it received
+type fakeServer struct {
+	ln      net.Listener
+	replies map[string]string
+	mu      sync.Mutex
+	cmds    [][]string
+	conns   int
+}
+
+func newFakeServer(t *testing.T, replies map[string]string) *fakeServer {
+	t.Helper()
+	return newFakeServerAt(t, "127.0.0.1:0", replies)
+}
+
+// newFakeServerAt is newFakeServer listening on address
+func newFakeServerAt(t *testing.T, address string, replies map[string]string) *fakeServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", address)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeServer{ln: ln, replies: replies}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns++
+		s.mu.Unlock()
+
+		go func() {
+			defer conn.Close()
+			br := bufio.NewReader(conn)
+			for {
+				reply, err := readReply(br)
+				if err != nil {
+					return
+				}
+				var args []string
+				for _, arg := range reply.([]interface{}) {
+					args = append(args, string(arg.([]byte)))
+				}
+				s.mu.Lock()
+				s.cmds = append(s.cmds, args)
+				s.mu.Unlock()
+				conn.Write([]byte(s.replies[args[0]]))
+			}
+		}()
+	}
+}
+
+func (s *fakeServer) commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmds
+}
+
+func TestClient_Do(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		want    interface{}
+		wantErr error
+	}{
+		{"simple string", "+OK\r\n", "OK", nil},
+		{"integer", ":42\r\n", int64(42), nil},
+		{"bulk string", "$5\r\nhello\r\n", []byte("hello"), nil},
+		{"null bulk string", "$-1\r\n", []byte(nil), nil},
+		{"array", "*2\r\n:1\r\n$1\r\na\r\n", []interface{}{int64(1), []byte("a")}, nil},
+		{"error", "-ERR unknown command\r\n", nil, Error("ERR unknown command")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, map[string]string{"CMD": tt.reply})
+			c := NewClient(s.ln.Addr().String())
+			defer c.Close()
+
+			got, err := c.Do(context.Background(), "CMD", "arg")
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Do() error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Do() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_AuthAndSelect(t *testing.T) {
+	s := newFakeServer(t, map[string]string{"AUTH": "+OK\r\n", "SELECT": "+OK\r\n", "PING": "+PONG\r\n"})
+	c := NewClient(s.ln.Addr().String(), WithPassword("secret"), WithDB(2))
+	defer c.Close()
+
+	for range 3 {
+		if _, err := c.Do(context.Background(), "PING"); err != nil {
+			t.Fatalf("Do() error = %v", err)
+		}
+	}
+
+	want := [][]string{{"AUTH", "secret"}, {"SELECT", "2"}, {"PING"}, {"PING"}, {"PING"}}
+	if got := s.commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected one connection setup followed by the commands, got %v", got)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conns != 1 {
+		t.Errorf("Expected the connection to be reused, got %d connections", s.conns)
+	}
+}
+
+func TestClient_AuthRejected(t *testing.T) {
+	s := newFakeServer(t, map[string]string{"AUTH": "-WRONGPASS invalid password\r\n"})
+	c := NewClient(s.ln.Addr().String(), WithPassword("wrong"))
+	defer c.Close()
+
+	_, err := c.Do(context.Background(), "PING")
+	if err == nil || !strings.Contains(err.Error(), "WRONGPASS") {
+		t.Errorf("Expected the AUTH error, got %v", err)
+	}
+}
+
+func TestClient_Timeout(t *testing.T) {
+	// Replies to nothing, so every command times out
+	s := newFakeServer(t, nil)
+	c := NewClient(s.ln.Addr().String(), WithTimeout(50*time.Millisecond))
+	defer c.Close()
+
+	start := time.Now()
+	if _, err := c.Do(context.Background(), "PING"); err == nil {
+		t.Fatal("Expected a timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Timeout took %v", elapsed)
+	}
+}
+
+func TestClient_Closed(t *testing.T) {
+	s := newFakeServer(t, map[string]string{"PING": "+PONG\r\n"})
+	c := NewClient(s.ln.Addr().String())
+	c.Close()
+
+	if _, err := c.Do(context.Background(), "PING"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Expected ErrClosed, got %v", err)
+	}
+}
+
+func TestClient_HealthCheck(t *testing.T) {
+	// Reserve an address, then leave it unserved so the server starts out down
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	address := ln.Addr().String()
+	ln.Close()
+
+	c := NewClient(address, WithHealthCheck(20*time.Millisecond))
+	defer c.Close()
+
+	if !c.Connected() {
+		t.Fatal("Expected a new client to report connected")
+	}
+	if _, err := c.Do(context.Background(), "PING"); err == nil {
+		t.Fatal("Expected the first command to fail")
+	}
+	if c.Connected() {
+		t.Fatal("Expected the client to report disconnected after a failed command")
+	}
+	if _, err := c.Do(context.Background(), "PING"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable while disconnected, got %v", err)
+	}
+
+	// The health check finds the server once it is back
+	newFakeServerAt(t, address, map[string]string{"PING": "+PONG\r\n"})
+	deadline := time.Now().Add(2 * time.Second)
+	for !c.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the health check to reconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := c.Do(context.Background(), "PING"); err != nil {
+		t.Errorf("Expected commands to resume, got %v", err)
+	}
+}
+
+func TestClient_ErrorReplyKeepsConnected(t *testing.T) {
+	s := newFakeServer(t, map[string]string{"CMD": "-ERR unknown command\r\n"})
+	c := NewClient(s.ln.Addr().String(), WithHealthCheck(time.Hour))
+	defer c.Close()
+
+	if _, err := c.Do(context.Background(), "CMD"); err == nil {
+		t.Fatal("Expected the error reply")
+	}
+	if !c.Connected() {
+		t.Error("Expected an error reply not to mark the server disconnected")
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/seakee/dudu-proxy/pkg/logger"
+)
+
+const (
+	readyPollInterval  = 50 * time.Millisecond // How often the listeners are checked for being bound
+	readyProbeInterval = 5 * time.Second       // Wait between startup probes that failed
+)
+
+// readyNotice is the JSON written to the ready file and sent to the webhook
+type readyNotice struct {
+	PID     int       `json:"pid"`
+	ReadyAt time.Time `json:"ready_at"`
+	HTTP    []string  `json:"http"`   // Bound HTTP proxy addresses
+	SOCKS5  []string  `json:"socks5"` // Bound SOCKS5 proxy addresses
+}
+
+// notifyReady waits for both listeners to be bound and, when configured, for
+// the deep check to pass, then signals readiness through each configured
+// mechanism. Failures to notify are logged and otherwise ignored.
+func (s *Server) notifyReady() {
+	cfg := s.config.Startup.ReadyNotify
+
+	for len(s.httpProxy.Addrs()) == 0 || len(s.socks5Proxy.Addrs()) == 0 {
+		select {
+		case <-s.done:
+			return
+		case <-time.After(readyPollInterval):
+		}
+	}
+
+	if cfg.Probe {
+		for !s.probePasses() {
+			select {
+			case <-s.done:
+				return
+			case <-time.After(readyProbeInterval):
+			}
+		}
+	}
+
+	notice := readyNotice{
+		PID:     os.Getpid(),
+		ReadyAt: time.Now(),
+		HTTP:    addrStrings(s.httpProxy.Addrs()),
+		SOCKS5:  addrStrings(s.socks5Proxy.Addrs()),
+	}
+
+	if cfg.Systemd {
+		if sent, err := sdNotify("READY=1"); err != nil {
+			logger.Warn("Failed to notify systemd of readiness", "error", err)
+		} else if !sent {
+			logger.Warn("Systemd readiness notification enabled but NOTIFY_SOCKET is not set")
+		}
+	}
+	if cfg.File != "" {
+		if err := writeReadyFile(cfg.File, notice); err != nil {
+			logger.Warn("Failed to write ready file", "file", cfg.File, "error", err)
+		}
+	}
+	if cfg.WebhookURL != "" {
+		if err := postReadyWebhook(cfg.WebhookURL, time.Duration(cfg.WebhookTimeoutSeconds)*time.Second, notice); err != nil {
+			logger.Warn("Failed to send ready webhook", "url", cfg.WebhookURL, "error", err)
+		}
+	}
+
+	logger.Info("Readiness signaled",
+		"systemd", cfg.Systemd,
+		"file", cfg.File,
+		"webhook", cfg.WebhookURL != "")
+}
+
+// probePasses runs the deep check once and reports whether every proxy passed
+func (s *Server) probePasses() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.Admin.ProbeTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	for _, result := range s.deepCheck(ctx) {
+		if !result.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// notifyStopping tells systemd the proxy is shutting down and removes the
+// ready file, so neither reports a stopped proxy as ready
+func (s *Server) notifyStopping() {
+	cfg := s.config.Startup.ReadyNotify
+
+	if cfg.Systemd {
+		if _, err := sdNotify("STOPPING=1"); err != nil {
+			logger.Warn("Failed to notify systemd of shutdown", "error", err)
+		}
+	}
+	if cfg.File != "" {
+		if err := os.Remove(cfg.File); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Failed to remove ready file", "file", cfg.File, "error", err)
+		}
+	}
+}
+
+// sdNotify sends state to the socket systemd names in NOTIFY_SOCKET, and
+// reports false without error when the variable is unset
+func sdNotify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	// A leading @ names a socket in the abstract namespace
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// writeReadyFile writes notice to path, through a temporary file so watchers
+// never see it partly written
+func writeReadyFile(path string, notice readyNotice) error {
+	data, err := json.MarshalIndent(notice, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// postReadyWebhook POSTs notice as JSON to url, failing on a non-2xx status
+func postReadyWebhook(url string, timeout time.Duration, notice readyNotice) error {
+	body, err := json.Marshal(notice)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// addrStrings returns the string form of addrs
+func addrStrings(addrs []net.Addr) []string {
+	strs := make([]string, len(addrs))
+	for i, addr := range addrs {
+		strs[i] = addr.String()
+	}
+	return strs
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSDNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := sdNotify("READY=1"); sent || err != nil {
+		t.Errorf("Expected nothing sent without NOTIFY_SOCKET, got %v, %v", sent, err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	if sent, err := sdNotify("READY=1"); !sent || err != nil {
+		t.Fatalf("sdNotify() = %v, %v", sent, err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read notification: %v", err)
+	}
+	if got := string(buf[:n]); got != "READY=1" {
+		t.Errorf("Expected READY=1, got %q", got)
+	}
+}
+
+func TestWriteReadyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ready.json")
+	notice := readyNotice{PID: 42, HTTP: []string{"127.0.0.1:8080"}, SOCKS5: []string{"127.0.0.1:1080"}}
+
+	if err := writeReadyFile(path, notice); err != nil {
+		t.Fatalf("writeReadyFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read ready file: %v", err)
+	}
+	var got readyNotice
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Ready file is not JSON: %v", err)
+	}
+	if !reflect.DeepEqual(got, notice) {
+		t.Errorf("Expected %+v, got %+v", notice, got)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Expected the temporary file to be renamed away")
+	}
+}
+
+func TestPostReadyWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusNoContent, false},
+		{"server error", http.StatusInternalServerError, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got readyNotice
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("Unexpected request %s with Content-Type %q", r.Method, r.Header.Get("Content-Type"))
+				}
+				json.NewDecoder(r.Body).Decode(&got)
+				w.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			err := postReadyWebhook(srv.URL, time.Second, readyNotice{PID: 42})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postReadyWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got.PID != 42 {
+				t.Errorf("Expected the notice to be posted, got %+v", got)
+			}
+		})
+	}
+}
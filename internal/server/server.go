@@ -2,90 +2,641 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/seakee/dudu-proxy/internal/admin"
 	"github.com/seakee/dudu-proxy/internal/config"
+	"github.com/seakee/dudu-proxy/internal/events"
 	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/metrics"
 	"github.com/seakee/dudu-proxy/internal/middleware"
 	"github.com/seakee/dudu-proxy/internal/proxy"
+	"github.com/seakee/dudu-proxy/internal/redis"
 	"github.com/seakee/dudu-proxy/pkg/logger"
 )
 
 // Server represents the proxy server
 type Server struct {
 	config      *config.Config
+	configFile  string // Re-read on SIGHUP, empty disables reloading
 	httpProxy   *proxy.HTTPProxy
 	socks5Proxy *proxy.SOCKS5Proxy
+	adminServer *admin.Server
 	ipBanMgr    *manager.IPBanManager
+	banMgrs     []*manager.IPBanManager      // ipBanMgr and those of listeners with their own ip_ban
+	authMWs     []*middleware.AuthMiddleware // One per listener, updated on reload
+	redisLimits []*manager.RedisLimiter      // Rate limits shared through Redis, one per rate_limit section using it
+
+	// Read by Stats
+	conns          *manager.ConnRegistry
+	circuitBreaker *manager.CircuitBreaker
+	targetBreakers *manager.TargetBreakers
+	rateLimitMWs   []*middleware.RateLimitMiddleware // The shared one first, then per-listener overrides
+	targetStats    *manager.TargetStats              // Nil when disabled
+	eventStream    *events.Stream                    // Nil when disabled
+	eventQueue     *events.NATS                      // Nil when disabled
+	userLog        *events.UserLog                   // Nil when disabled
+	hostOverrides  *manager.HostOverrides            // Updated on reload
+	probeCreds     atomic.Pointer[probeCredentials]  // Used by deepCheck, updated on reload
+
+	runtimeMonitor *metrics.RuntimeMonitor
+
+	done chan struct{} // Closed when shutdown begins
+}
+
+// probeCredentials are the username and password deepCheck authenticates with
+type probeCredentials struct {
+	username string
+	password string
 }
 
-// NewServer creates a new server instance
-func NewServer(cfg *config.Config) *Server {
+// Option configures optional Server behavior
+type Option func(*Server)
+
+// WithConfigFile sets the configuration file re-read on SIGHUP to reload credentials
+func WithConfigFile(path string) Option {
+	return func(s *Server) {
+		s.configFile = path
+	}
+}
+
+// NewServer creates a new server instance. version is reported by the admin /info endpoint.
+func NewServer(cfg *config.Config, version string, opts ...Option) (*Server, error) {
 	// Create managers
-	ipBanMgr := manager.NewIPBanManager(
-		cfg.IPBan.MaxFailures,
-		time.Duration(cfg.IPBan.BanDurationSeconds)*time.Second,
-		cfg.IPBan.Whitelist,
-	)
+	ipBanMgr, ipBanMW := newIPBan(cfg.IPBan, "")
 
-	circuitBreaker := manager.NewCircuitBreaker(
-		cfg.CircuitBreaker.FailureThresholdPercent,
-		time.Duration(cfg.CircuitBreaker.WindowSizeSeconds)*time.Second,
-		cfg.CircuitBreaker.MinRequests,
-		time.Duration(cfg.CircuitBreaker.BreakDurationSeconds)*time.Second,
-	)
+	circuitBreaker := manager.NewCircuitBreaker(breakerOptions(cfg.CircuitBreaker)...)
+
+	circuitBreaker.OnStateChange(func(from, to manager.CircuitBreakerState) {
+		total, failures, failureRate := circuitBreaker.GetStats()
+		logger.Warn("Circuit breaker state changed",
+			"from", from.String(),
+			"to", to.String(),
+			"shadow", circuitBreaker.IsShadow(),
+			"requests", total,
+			"failures", failures,
+			"failure_rate", failureRate)
+
+		if to == manager.StateOpen && cfg.IPBan.Enabled && cfg.CircuitBreaker.OffenderPercent > 0 {
+			attributeBreakerTrip(circuitBreaker, ipBanMgr, cfg.CircuitBreaker.OffenderPercent)
+		}
+	})
 
-	// Create middlewares
-	authMW := middleware.NewAuthMiddleware(
-		cfg.Auth.Enabled,
+	targetBreakers := manager.NewTargetBreakers(breakerOptions(cfg.TargetBreaker)...)
+
+	targetBreakers.OnStateChange(func(target string, from, to manager.CircuitBreakerState) {
+		total, failures, failureRate := targetBreakers.Get(target).GetStats()
+		logger.Warn("Target circuit breaker state changed",
+			"target", target,
+			"from", from.String(),
+			"to", to.String(),
+			"shadow", cfg.TargetBreaker.ShadowMode,
+			"requests", total,
+			"failures", failures,
+			"failure_rate", failureRate)
+	})
+
+	// Create middlewares, each listener gets its own authentication toggle
+	httpAuthMW := middleware.NewAuthMiddleware(
+		cfg.Auth.EnabledFor("http"),
 		cfg.GetUserCredentials(),
+		middleware.WithTokens(cfg.GetTokenUsers()),
+		middleware.WithTokenExpiry(cfg.GetTokenExpiry()),
+		middleware.WithRotationGrace(time.Duration(cfg.Auth.RotationGraceSeconds)*time.Second),
 	)
-
-	rateLimitMW := middleware.NewRateLimitMiddleware(
-		cfg.RateLimit.Enabled,
-		cfg.RateLimit.GlobalRequestsPerSecond,
-		cfg.RateLimit.PerIPRequestsPerSecond,
+	socks5AuthMW := middleware.NewAuthMiddleware(
+		cfg.Auth.EnabledFor("socks5"),
+		cfg.GetUserCredentials(),
+		middleware.WithTokens(cfg.GetTokenUsers()),
+		middleware.WithTokenExpiry(cfg.GetTokenExpiry()),
+		middleware.WithRotationGrace(time.Duration(cfg.Auth.RotationGraceSeconds)*time.Second),
 	)
+	warnOpenListeners(cfg)
 
-	ipBanMW := middleware.NewIPBanMiddleware(
-		cfg.IPBan.Enabled,
-		ipBanMgr,
+	authDelayMW := middleware.NewAuthDelayMiddleware(
+		cfg.Auth.FailureDelayMs > 0,
+		manager.NewAuthDelay(
+			time.Duration(cfg.Auth.FailureDelayMs)*time.Millisecond,
+			time.Duration(cfg.Auth.MaxFailureDelayMs)*time.Millisecond,
+			time.Duration(cfg.Auth.FailureDelayResetSeconds)*time.Second,
+		),
 	)
 
+	rateLimitMW, redisLimiter, budget := newRateLimit(cfg.RateLimit, ipBanMW)
+	ipBandwidth, userBandwidth := newBandwidthLimits(cfg.RateLimit)
+
+	// Listeners with their own rate_limit or ip_ban section get separate
+	// instances; the others share the top-level ones
+	banMgrs := []*manager.IPBanManager{ipBanMgr}
+	rateLimitMWs := []*middleware.RateLimitMiddleware{rateLimitMW}
+	var redisLimiters []*manager.RedisLimiter
+	var budgets []*manager.Budget
+	keep := func(limiter *manager.RedisLimiter, budget *manager.Budget) {
+		if limiter != nil {
+			redisLimiters = append(redisLimiters, limiter)
+		}
+		if budget != nil {
+			budgets = append(budgets, budget)
+		}
+	}
+	keep(redisLimiter, budget)
+	limitOpts := func(listener string, banCfg *config.IPBanConfig, limitCfg *config.RateLimitConfig) []proxy.Option {
+		banMW := ipBanMW
+		if banCfg != nil {
+			var banMgr *manager.IPBanManager
+			banMgr, banMW = newIPBan(*banCfg, listener)
+			banMgrs = append(banMgrs, banMgr)
+		}
+
+		limitMW, ipBW, userBW := rateLimitMW, ipBandwidth, userBandwidth
+		if limitCfg != nil {
+			var limiter *manager.RedisLimiter
+			var listenerBudget *manager.Budget
+			limitMW, limiter, listenerBudget = newRateLimit(*limitCfg, banMW)
+			ipBW, userBW = newBandwidthLimits(*limitCfg)
+			rateLimitMWs = append(rateLimitMWs, limitMW)
+			keep(limiter, listenerBudget)
+		}
+
+		return []proxy.Option{
+			proxy.WithIPBan(banMW),
+			proxy.WithRateLimit(limitMW),
+			proxy.WithBandwidthLimits(ipBW, userBW),
+		}
+	}
+	httpLimitOpts := limitOpts("http", cfg.HTTP.IPBan, cfg.HTTP.RateLimit)
+	socks5LimitOpts := limitOpts("socks5", cfg.SOCKS5.IPBan, cfg.SOCKS5.RateLimit)
+
+	if len(redisLimiters) > 0 {
+		metrics.Default.GaugeFunc("dudu_redis_connected", "1 when every Redis rate limit backend is reachable, 0 otherwise",
+			func() int64 {
+				for _, limiter := range redisLimiters {
+					if !limiter.Connected() {
+						return 0
+					}
+				}
+				return 1
+			})
+	}
+	if len(budgets) > 0 {
+		metrics.Default.GaugeFunc("dudu_budget_used_connections", "Connections admitted within the current budget window",
+			func() int64 {
+				var used int64
+				for _, budget := range budgets {
+					used += int64(budget.Used())
+				}
+				return used
+			})
+	}
+	metrics.Default.CounterFunc("dudu_ipban_save_failures_total", "Saves of the IP ban state that failed after every retry",
+		func() int64 {
+			var failures int64
+			for _, banMgr := range banMgrs {
+				failures += banMgr.SaveFailures()
+			}
+			return failures
+		})
+	metrics.Default.CounterFunc("dudu_circuit_breaker_shadow_rejections_total",
+		"Requests the circuit breaker would have rejected outside shadow mode",
+		circuitBreaker.ShadowRejections)
+	runtimeMonitor := metrics.NewRuntimeMonitor(metrics.Default, time.Duration(cfg.Log.RuntimeStatsIntervalSeconds)*time.Second)
+	metrics.Default.GaugeFunc("dudu_ratelimit_tracked_ips", "IPs with a per-IP rate limiter",
+		func() int64 {
+			var tracked int64
+			for _, mw := range rateLimitMWs {
+				tracked += int64(mw.TrackedIPs())
+			}
+			return tracked
+		})
+	metrics.Default.GaugeFunc("dudu_ratelimit_fair_queue_waiting", "Requests waiting for their turn at the global rate limit",
+		func() int64 {
+			var waiting int64
+			for _, mw := range rateLimitMWs {
+				waiting += int64(mw.FairQueueWaiting())
+			}
+			return waiting
+		})
+
 	circuitBreakerMW := middleware.NewCircuitBreakerMiddleware(
 		cfg.CircuitBreaker.Enabled,
 		circuitBreaker,
 	)
 
+	scanDetectMW := middleware.NewScanDetectMiddleware(
+		cfg.ScanDetection.Enabled,
+		manager.NewScanDetector(
+			cfg.ScanDetection.MaxDistinctTargets,
+			time.Duration(cfg.ScanDetection.WindowSeconds)*time.Second,
+		),
+		ipBanMW,
+		cfg.ScanDetection.BanImmediately,
+	)
+
+	targetBreakerMW := middleware.NewTargetBreakerMiddleware(
+		cfg.TargetBreaker.Enabled,
+		targetBreakers,
+	)
+
+	conns := manager.NewConnRegistry()
+
+	var targetStats *manager.TargetStats
+	if cfg.TargetStats.Enabled {
+		targetStats = manager.NewTargetStats(
+			cfg.TargetStats.Capacity,
+			manager.WithTargetDecay(time.Duration(cfg.TargetStats.DecayIntervalSeconds)*time.Second),
+			manager.WithTargetLogging(time.Duration(cfg.TargetStats.LogIntervalSeconds)*time.Second, cfg.TargetStats.LogTop),
+		)
+	}
+
+	var eventStream *events.Stream
+	if cfg.EventStream.Enabled {
+		eventStream = events.NewStream(cfg.EventStream.SocketPath, events.WithBufferSize(cfg.EventStream.BufferSize))
+	}
+
+	var eventQueue *events.NATS
+	if cfg.EventQueue.Enabled {
+		eventQueue = events.NewNATS(cfg.EventQueue.Address, cfg.EventQueue.Subject,
+			events.WithNATSFormat(cfg.EventQueue.Format),
+			events.WithNATSCredentials(cfg.EventQueue.Username, cfg.EventQueue.Password),
+			events.WithNATSBufferSize(cfg.EventQueue.BufferSize))
+	}
+
+	hostOverrides := manager.NewHostOverrides(cfg.HostOverrides)
+
+	var userConns *manager.ConnLimiter
+	if cfg.Auth.MaxConnsPerUser > 0 {
+		userConns = manager.NewConnLimiter(cfg.Auth.MaxConnsPerUser)
+	}
+
+	var anonymousLimits *proxy.AnonymousLimits
+	if anon := cfg.Auth.Anonymous; anon.PerIPRequestsPerSecond > 0 || anon.MaxConns > 0 || len(anon.AllowedPorts) > 0 {
+		anonymousLimits = proxy.NewAnonymousLimits(anon.PerIPRequestsPerSecond, anon.MaxConns, anon.AllowedPorts)
+		metrics.Default.GaugeFunc("dudu_anonymous_connections", "Anonymous connections open under the anonymous connection limit",
+			func() int64 { return int64(anonymousLimits.Active()) })
+	}
+
+	var targetConns *manager.ConnLimiter
+	if cfg.Upstream.MaxConnsPerTarget > 0 {
+		targetConns = manager.NewConnLimiter(cfg.Upstream.MaxConnsPerTarget)
+		metrics.Default.GaugeFunc("dudu_target_conn_limit_hosts", "Target hosts with connections open under the per-target limit",
+			func() int64 { return int64(targetConns.Len()) })
+	}
+
+	var userLog *events.UserLog
+	if cfg.UserLog.Enabled {
+		userLog = events.NewUserLog(cfg.UserLog.Directory, cfg.UserLog.FileName, cfg.UserLog.MaxOpenFiles)
+		metrics.Default.GaugeFunc("dudu_user_log_open_files", "Per-user log files currently open",
+			func() int64 { return int64(userLog.OpenFiles()) })
+	}
+
+	inherited, err := proxy.InheritedListeners()
+	if err != nil {
+		return nil, fmt.Errorf("socket activation: %w", err)
+	}
+	if inherited != nil {
+		logger.Info("Using sockets inherited from socket activation",
+			"http", len(inherited["http"]), "socks5", len(inherited["socks5"]))
+	}
+
+	debugIPs, err := manager.NewDebugIPs(cfg.Log.DebugIPs)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create proxies
+	proxyOpts := []proxy.Option{
+		proxy.WithNetwork(cfg.Server.Network),
+		proxy.WithReusePort(cfg.Server.ReusePort),
+		proxy.WithDialTimeout(time.Duration(cfg.Server.DialTimeoutSeconds) * time.Second),
+		proxy.WithSourceIPs(cfg.Server.SourceIPAddrs()),
+		proxy.WithConnectionTimeout(time.Duration(cfg.Server.ConnectionTimeoutSeconds) * time.Second),
+		proxy.WithHandshakeTimeout(time.Duration(cfg.Server.HandshakeTimeoutSeconds) * time.Second),
+		proxy.WithAuthTimeout(time.Duration(cfg.Server.AuthTimeoutSeconds) * time.Second),
+		proxy.WithMaxHandshakes(cfg.Server.MaxHandshakes),
+		proxy.WithHandshakeQueue(cfg.Server.HandshakeQueueSize, cfg.Server.HandshakeQueuePolicy),
+		proxy.WithRejectWithRST(cfg.Server.RejectWithRST),
+		proxy.WithAnonymousUser(cfg.Auth.AnonymousUser),
+		proxy.WithAuthDelay(authDelayMW),
+		proxy.WithCircuitBreaker(circuitBreakerMW),
+		proxy.WithScanDetect(scanDetectMW),
+		proxy.WithTargetBreaker(targetBreakerMW),
+		proxy.WithSSRFGuard(middleware.NewSSRFGuardMiddleware(cfg.SSRFGuard.Enabled, cfg.SSRFGuard.Allow)),
+		proxy.WithTargetCategories(newTargetCategories(cfg.TargetCategories)),
+		proxy.WithConnRegistry(conns),
+		proxy.WithTargetStats(targetStats),
+		proxy.WithEventStream(eventStream),
+		proxy.WithEventQueue(eventQueue),
+		proxy.WithUserLog(userLog),
+		proxy.WithProxyProtocol(cfg.Upstream.ProxyProtocolVersion()),
+		proxy.WithTLSHandshakeLogging(cfg.TLS.LogHandshakes),
+		proxy.WithDebugIPs(debugIPs),
+		proxy.WithMaxConnsPerUser(userConns),
+		proxy.WithMaxConnsPerTarget(targetConns),
+		proxy.WithAnonymousLimits(anonymousLimits),
+		proxy.WithCertUsernameMatch(cfg.Auth.RequireCertUsernameMatch),
+		proxy.WithHostOverrides(hostOverrides),
+		proxy.WithClientPortLogging(cfg.Log.IncludeClientPort),
+	}
+
+	httpOpts := append(append([]proxy.Option{proxy.WithAuth(httpAuthMW)}, httpLimitOpts...), proxyOpts...)
+	socks5Opts := append(append([]proxy.Option{proxy.WithAuth(socks5AuthMW)}, socks5LimitOpts...), proxyOpts...)
+	if cfg.TLS.Enabled {
+		tlsConfig, err := loadTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if cfg.TLS.ServesTLS("http") {
+			httpOpts = append(httpOpts, proxy.WithTLS(tlsConfig))
+		}
+		if cfg.TLS.ServesTLS("socks5") {
+			socks5Opts = append(socks5Opts, proxy.WithTLS(tlsConfig))
+		}
+	}
+
+	if cfg.HTTP.SOCKS5Upstream.Address != "" {
+		logger.Warn("http.socks5_upstream is deprecated, rename it to http.upstream")
+	}
+	if upstream := cfg.HTTP.Upstream; upstream.Address != "" {
+		opt, err := newUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		httpOpts = append(httpOpts, opt)
+	}
+
+	if cfg.HTTP.Intercept.Enabled {
+		interceptor, err := newInterceptor(cfg.HTTP.Intercept)
+		if err != nil {
+			return nil, err
+		}
+		httpOpts = append(httpOpts, proxy.WithInterception(interceptor))
+		logger.Warn("HTTPS interception enabled: CONNECT tunnels are decrypted, clients must trust the interception CA",
+			"ca_cert_file", cfg.HTTP.Intercept.CACertFile,
+			"bypass_hosts", cfg.HTTP.Intercept.BypassHosts)
+	}
+
 	httpProxy := proxy.NewHTTPProxy(
 		cfg.Server.HTTPPort,
-		cfg.Server.Network,
-		authMW,
-		rateLimitMW,
-		ipBanMW,
-		circuitBreakerMW,
+		append(httpOpts,
+			proxy.WithListenAddresses(cfg.Server.HTTPListen),
+			proxy.WithListeners(inherited["http"]),
+			proxy.WithLandingResponse(cfg.HTTP.LandingStatus, cfg.HTTP.LandingBody),
+			proxy.WithConnectFailureResponse(cfg.HTTP.ConnectFailure.Status, cfg.HTTP.ConnectFailure.Body, cfg.HTTP.ConnectFailure.Headers),
+			proxy.WithTransparent(cfg.HTTP.Transparent),
+			proxy.WithConnectOnly(cfg.HTTP.ConnectOnly),
+			proxy.WithCompression(cfg.HTTP.Compress),
+			proxy.WithFullURLLogging(cfg.Log.LogFullURL),
+			proxy.WithMaxResponseBytes(cfg.HTTP.MaxResponseBytes),
+			proxy.WithRealm(cfg.HTTP.GetRealm()),
+			proxy.WithMaxAuthAttempts(cfg.Auth.MaxAttemptsPerConn),
+			proxy.WithServerHeader(cfg.HTTP.ServerHeader),
+			proxy.WithJSONErrors(cfg.HTTP.ErrorFormat == "json"),
+			proxy.WithTransferTimeouts(time.Duration(cfg.HTTP.ReadTimeoutSeconds)*time.Second, time.Duration(cfg.HTTP.WriteTimeoutSeconds)*time.Second),
+		)...,
 	)
 
 	socks5Proxy := proxy.NewSOCKS5Proxy(
 		cfg.Server.SOCKS5Port,
-		cfg.Server.Network,
-		authMW,
-		rateLimitMW,
-		ipBanMW,
-		circuitBreakerMW,
+		append(socks5Opts,
+			proxy.WithListenAddresses(cfg.Server.SOCKS5Listen),
+			proxy.WithListeners(inherited["socks5"]),
+			proxy.WithResolveExtension(cfg.SOCKS5.EnableResolveExtension),
+			proxy.WithSourcePortPreservation(cfg.SOCKS5.PreserveSourcePort),
+			proxy.WithZeroReplyAddress(cfg.SOCKS5.ReplyAddressMode == "zeros"),
+			proxy.WithPolicyDenyReplyCode(byte(cfg.SOCKS5.PolicyDenyReplyCode)),
+			proxy.WithTransferTimeouts(time.Duration(cfg.SOCKS5.ReadTimeoutSeconds)*time.Second, time.Duration(cfg.SOCKS5.WriteTimeoutSeconds)*time.Second),
+		)...,
 	)
 
-	return &Server{
-		config:      cfg,
-		httpProxy:   httpProxy,
-		socks5Proxy: socks5Proxy,
-		ipBanMgr:    ipBanMgr,
+	s := &Server{
+		config:         cfg,
+		httpProxy:      httpProxy,
+		socks5Proxy:    socks5Proxy,
+		ipBanMgr:       ipBanMgr,
+		banMgrs:        banMgrs,
+		authMWs:        []*middleware.AuthMiddleware{httpAuthMW, socks5AuthMW},
+		redisLimits:    redisLimiters,
+		conns:          conns,
+		targetStats:    targetStats,
+		eventStream:    eventStream,
+		eventQueue:     eventQueue,
+		runtimeMonitor: runtimeMonitor,
+		userLog:        userLog,
+		hostOverrides:  hostOverrides,
+		circuitBreaker: circuitBreaker,
+		targetBreakers: targetBreakers,
+		rateLimitMWs:   rateLimitMWs,
+		done:           make(chan struct{}),
+	}
+	s.probeCreds.Store(&probeCredentials{cfg.Admin.ProbeUsername, cfg.Admin.ProbePassword})
+
+	if cfg.Admin.Enabled {
+		adminOpts := []admin.Option{
+			admin.WithListenerControl(map[string]admin.Pausable{"http": httpProxy, "socks5": socks5Proxy}),
+			admin.WithDebugIPs(debugIPs),
+		}
+		if cfg.IPBan.Enabled {
+			adminOpts = append(adminOpts, admin.WithBans(ipBanMgr))
+		}
+		if cfg.Auth.AnyEnabled() {
+			adminOpts = append(adminOpts, admin.WithUserDrain(conns, s.authMWs...))
+		}
+		if cfg.Admin.Dashboard {
+			adminOpts = append(adminOpts, admin.WithDashboard(s.Stats))
+		}
+		if targetStats != nil {
+			adminOpts = append(adminOpts, admin.WithTargetStats(targetStats))
+		}
+		if targetConns != nil {
+			adminOpts = append(adminOpts, admin.WithTargetConns(targetConns))
+		}
+		if cfg.Admin.ProbeTarget != "" {
+			adminOpts = append(adminOpts, admin.WithDeepCheck(s.deepCheck,
+				time.Duration(cfg.Admin.ProbeCacheSeconds)*time.Second,
+				time.Duration(cfg.Admin.ProbeTimeoutSeconds)*time.Second))
+		}
+		s.adminServer = admin.NewServer(cfg.Admin.Address, admin.NewInfo(cfg, version), adminOpts...)
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// warnOpenListeners logs every listen address reachable beyond loopback on a
+// listener that accepts clients without authentication
+func warnOpenListeners(cfg *config.Config) {
+	listeners := []struct {
+		name  string
+		addrs []string
+	}{
+		{"http", cfg.Server.HTTPListenAddresses()},
+		{"socks5", cfg.Server.SOCKS5ListenAddresses()},
+	}
+
+	for _, l := range listeners {
+		if cfg.Auth.EnabledFor(l.name) {
+			continue
+		}
+		for _, addr := range l.addrs {
+			if !isLoopbackAddress(addr) {
+				logger.Warn("Authentication is disabled on a non-loopback listener, anyone who can reach it can use the proxy",
+					"listener", l.name,
+					"address", addr)
+			}
+		}
+	}
+}
+
+// isLoopbackAddress reports whether a host:port listen address only accepts
+// local clients. An empty host binds every interface.
+func isLoopbackAddress(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// attributeBreakerTrip counts an IP ban failure against the client IP that
+// caused at least percent of the failures that opened the circuit, so
+// repeated trips caused by one client end with that client banned. In
+// shadow mode the offender is only logged.
+func attributeBreakerTrip(breaker *manager.CircuitBreaker, ipBan *manager.IPBanManager, percent int) {
+	ip, ipFailures, failures := breaker.TopFailureSource()
+	if ip == "" || ipFailures*100 < percent*failures {
+		return
+	}
+
+	logger.Warn("Circuit breaker trip attributed to client",
+		"client_ip", ip,
+		"client_failures", ipFailures,
+		"failures", failures,
+		"shadow", breaker.IsShadow())
+
+	if !breaker.IsShadow() {
+		ipBan.RecordFailure(ip)
+	}
+}
+
+// breakerOptions converts circuit breaker settings into manager options
+func breakerOptions(cfg config.CircuitBreakerConfig) []manager.CircuitBreakerOption {
+	return []manager.CircuitBreakerOption{
+		manager.WithFailureThreshold(cfg.FailureThresholdPercent),
+		manager.WithWindowSize(time.Duration(cfg.WindowSizeSeconds) * time.Second),
+		manager.WithMinRequests(cfg.MinRequests),
+		manager.WithBreakDuration(time.Duration(cfg.BreakDurationSeconds) * time.Second),
+		manager.WithHalfOpenMaxRequests(cfg.HalfOpenSuccesses),
+		manager.WithShadowMode(cfg.ShadowMode),
+		manager.WithSlowStart(
+			cfg.HalfOpenProbes,
+			time.Duration(cfg.HalfOpenProbeIntervalMs)*time.Millisecond,
+			time.Duration(cfg.HalfOpenSuccessSeconds)*time.Second,
+		),
+	}
+}
+
+// loadTLSConfig loads the configured certificates into an SNI-aware TLS config
+func loadTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	certs := make([]tls.Certificate, 0, len(cfg.Certificates))
+	for _, c := range cfg.Certificates {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate %s: %w", c.CertFile, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	tlsConfig, err := proxy.NewTLSConfig(certs)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.MinVersion = cfg.MinTLSVersion()
+	tlsConfig.CipherSuites = cfg.CipherSuiteIDs()
+
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls client_ca_file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in tls client_ca_file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
 	}
+
+	return tlsConfig, nil
+}
+
+// newInterceptor loads the interception CA and builds the configured filter
+func newInterceptor(cfg config.InterceptConfig) (*proxy.Interceptor, error) {
+	ca, err := tls.LoadX509KeyPair(cfg.CACertFile, cfg.CAKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load interception CA %s: %w", cfg.CACertFile, err)
+	}
+
+	var filter proxy.RequestFilter
+	if len(cfg.BlockURLPrefixes) > 0 {
+		filter = proxy.NewURLBlockFilter(cfg.BlockURLPrefixes)
+	}
+
+	interceptor, err := proxy.NewInterceptor(ca, filter, cfg.BypassHosts, cfg.CertCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interception CA %s: %w", cfg.CACertFile, err)
+	}
+	return interceptor, nil
+}
+
+// newUpstream returns the option bridging HTTP requests to the upstream proxy,
+// loading the CAs it is verified with when it is reached over TLS
+func newUpstream(cfg config.UpstreamProxyConfig) (proxy.Option, error) {
+	_, address := cfg.Endpoint()
+
+	var tlsConfig *tls.Config
+	if cfg.UsesTLS() {
+		tlsConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: cfg.InsecureSkipVerify,
+		}
+		if cfg.CAFile != "" {
+			data, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read http upstream ca_file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(data) {
+				return nil, fmt.Errorf("no certificates found in http upstream ca_file %s", cfg.CAFile)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		if cfg.InsecureSkipVerify {
+			logger.Warn("Upstream certificate verification disabled: the connection to the upstream proxy can be intercepted",
+				"upstream", address)
+		}
+	}
+
+	if cfg.IsHTTP() {
+		return proxy.WithHTTPUpstream(address, cfg.Username, cfg.Password, tlsConfig), nil
+	}
+	return proxy.WithSOCKS5Upstream(address, cfg.Username, cfg.Password, tlsConfig), nil
 }
 
 // Run starts the server
@@ -104,9 +655,39 @@ func (s *Server) Run() error {
 		}
 	}()
 
+	// Start admin server in a goroutine
+	if s.adminServer != nil {
+		go func() {
+			if err := s.adminServer.Start(); err != nil {
+				logger.Fatal("Admin server failed to start", "error", err)
+			}
+		}()
+	}
+
+	// Start event stream in a goroutine
+	if s.eventStream != nil {
+		go func() {
+			if err := s.eventStream.Start(); err != nil {
+				logger.Fatal("Event stream failed to start", "error", err)
+			}
+		}()
+	}
+
+	// Start event queue publisher in a goroutine
+	if s.eventQueue != nil {
+		go s.eventQueue.Start()
+	}
+
+	if notify := s.config.Startup.ReadyNotify; notify.Systemd || notify.File != "" || notify.WebhookURL != "" {
+		go s.notifyReady()
+	}
+
 	logger.Info("DuDu Proxy is running")
-	logger.Info(fmt.Sprintf("HTTP Proxy: localhost:%d", s.config.Server.HTTPPort))
-	logger.Info(fmt.Sprintf("SOCKS5 Proxy: localhost:%d", s.config.Server.SOCKS5Port))
+	logger.Info(fmt.Sprintf("HTTP Proxy: %s", strings.Join(s.config.Server.HTTPListenAddresses(), ", ")))
+	logger.Info(fmt.Sprintf("SOCKS5 Proxy: %s", strings.Join(s.config.Server.SOCKS5ListenAddresses(), ", ")))
+	if s.adminServer != nil {
+		logger.Info(fmt.Sprintf("Admin: http://%s", s.config.Admin.Address))
+	}
 
 	// Wait for interrupt signal
 	s.waitForShutdown()
@@ -117,9 +698,13 @@ func (s *Server) Run() error {
 // waitForShutdown waits for interrupt signal and performs graceful shutdown
 func (s *Server) waitForShutdown() {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 
 	sig := <-sigChan
+	for sig == syscall.SIGHUP {
+		s.reload()
+		sig = <-sigChan
+	}
 	logger.Info(fmt.Sprintf("Received signal: %v", sig))
 	logger.Info("Shutting down gracefully...")
 
@@ -129,21 +714,332 @@ func (s *Server) waitForShutdown() {
 	logger.Info("Server stopped")
 }
 
+// reload re-reads the configuration file and applies the user credentials,
+// the deep check's probe credentials and host overrides. Other settings
+// require a restart. An invalid file leaves everything unchanged.
+func (s *Server) reload() {
+	if s.configFile == "" {
+		logger.Warn("Received SIGHUP but no configuration file is set, ignoring")
+		return
+	}
+
+	cfg, err := config.Load(s.configFile)
+	if err != nil {
+		logger.Error("Failed to reload configuration, keeping current settings", "error", err)
+		return
+	}
+
+	for _, authMW := range s.authMWs {
+		authMW.Update(cfg.GetUserCredentials())
+		authMW.UpdateTokensWithExpiry(cfg.GetTokenUsers(), cfg.GetTokenExpiry())
+	}
+	s.hostOverrides.Update(cfg.HostOverrides)
+	s.probeCreds.Store(&probeCredentials{cfg.Admin.ProbeUsername, cfg.Admin.ProbePassword})
+
+	logger.Info("Configuration reloaded",
+		"config_file", s.configFile,
+		"auth_users", len(cfg.Auth.Users),
+		"auth_tokens", len(cfg.Auth.Tokens),
+		"host_overrides", s.hostOverrides.Len())
+}
+
 // shutdown performs cleanup operations
 func (s *Server) shutdown() {
-	// Stop IP ban manager cleanup routine
-	if s.ipBanMgr != nil {
-		s.ipBanMgr.Stop()
+	close(s.done)
+	s.notifyStopping()
+
+	// Stop accepting new connections
+	if err := s.httpProxy.Stop(); err != nil {
+		logger.Error("Failed to stop HTTP proxy", "error", err)
 	}
+	if err := s.socks5Proxy.Stop(); err != nil {
+		logger.Error("Failed to stop SOCKS5 proxy", "error", err)
+	}
+	if s.adminServer != nil {
+		if err := s.adminServer.Stop(); err != nil {
+			logger.Error("Failed to stop admin server", "error", err)
+		}
+	}
+
+	// Let connections finish while the event stream and user log can still record them
+	s.drainConnections()
 
-	// Add a small delay to allow ongoing connections to complete
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	for _, limiter := range s.redisLimits {
+		limiter.Close()
+	}
 
-	<-ctx.Done()
+	if s.eventStream != nil {
+		if err := s.eventStream.Stop(); err != nil {
+			logger.Error("Failed to stop event stream", "error", err)
+		}
+	}
+
+	if s.eventQueue != nil {
+		s.eventQueue.Stop()
+	}
+
+	if s.userLog != nil {
+		s.userLog.Close()
+	}
+
+	if s.targetStats != nil {
+		s.targetStats.Stop()
+	}
+
+	s.runtimeMonitor.Stop()
+
+	// Stop IP ban manager cleanup routines
+	for _, banMgr := range s.banMgrs {
+		banMgr.Stop()
+	}
+}
+
+// shutdownDrainTimeout is how long shutdown waits for connections to close
+// before closing the rest
+const shutdownDrainTimeout = 5 * time.Second
+
+// drainConnections waits for the active connections to close, logging how
+// many remain every second, and closes those still open after
+// shutdownDrainTimeout
+func (s *Server) drainConnections() {
+	start := time.Now()
+	active := s.conns.Count()
+	if active == 0 {
+		logger.Info("Connections drained", "duration_ms", time.Since(start).Milliseconds())
+		return
+	}
+	logger.Info("Draining connections", "active", active, "timeout_seconds", int(shutdownDrainTimeout.Seconds()))
+
+	poll := time.NewTicker(50 * time.Millisecond)
+	defer poll.Stop()
+	report := time.NewTicker(time.Second)
+	defer report.Stop()
+	timeout := time.NewTimer(shutdownDrainTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-poll.C:
+			if s.conns.Count() == 0 {
+				logger.Info("Connections drained", "duration_ms", time.Since(start).Milliseconds())
+				return
+			}
+		case <-report.C:
+			logger.Info("Draining connections",
+				"active", s.conns.Count(),
+				"remaining_seconds", int((shutdownDrainTimeout - time.Since(start)).Round(time.Second).Seconds()))
+		case <-timeout.C:
+			logger.Warn("Connection drain timed out, closing remaining connections",
+				"force_closed", s.conns.CloseAll())
+			return
+		}
+	}
+}
+
+// dashboardTopTargets is how many target hosts Stats reports
+const dashboardTopTargets = 10
+
+// Stats returns a snapshot of active connections, bans, breaker state, rate
+// limit rejections and the most popular targets
+func (s *Server) Stats() admin.Stats {
+	stats := admin.Stats{
+		Connections:    s.conns.List(),
+		TargetBreakers: s.targetBreakers.Len(),
+	}
+	stats.BytesUp, stats.BytesDown = s.conns.TotalBytes()
+	for _, mw := range s.rateLimitMWs {
+		global, perIP := mw.Rejections()
+		stats.RateLimit.GlobalRejections += global
+		stats.RateLimit.PerIPRejections += perIP
+	}
+
+	if s.config.IPBan.Enabled {
+		stats.Bans, _ = s.ipBanMgr.ListBans(0, 0)
+	}
+	if s.targetStats != nil {
+		stats.TopTargets = s.targetStats.Top(dashboardTopTargets)
+	}
+
+	stats.CircuitBreaker.Enabled = s.config.CircuitBreaker.Enabled
+	stats.CircuitBreaker.State = s.circuitBreaker.GetState().String()
+	stats.CircuitBreaker.Requests, stats.CircuitBreaker.Failures, stats.CircuitBreaker.FailureRate = s.circuitBreaker.GetStats()
+	stats.CircuitBreaker.Shadow = s.circuitBreaker.IsShadow()
+	stats.CircuitBreaker.ShadowRejections = s.circuitBreaker.ShadowRejections()
+
+	return stats
+}
+
+// deepCheck tunnels to the probe target through each proxy's own listener,
+// authenticating with the probe credentials where auth is enabled
+func (s *Server) deepCheck(ctx context.Context) []admin.CheckResult {
+	creds := s.probeCreds.Load()
+
+	probes := []struct {
+		name  string
+		probe func(ctx context.Context, target, username, password string) error
+	}{
+		{"http", s.httpProxy.Probe},
+		{"socks5", s.socks5Proxy.Probe},
+	}
+
+	results := make([]admin.CheckResult, len(probes))
+	var wg sync.WaitGroup
+	for i, p := range probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var username, password string
+			if s.config.Auth.EnabledFor(p.name) {
+				username, password = creds.username, creds.password
+			}
+
+			start := time.Now()
+			err := p.probe(ctx, s.config.Admin.ProbeTarget, username, password)
+			results[i] = admin.CheckResult{
+				Name:       p.name,
+				OK:         err == nil,
+				DurationMs: time.Since(start).Milliseconds(),
+			}
+			if err != nil {
+				results[i].Error = err.Error()
+				logger.Warn("Deep check failed", "proxy", p.name, "target", s.config.Admin.ProbeTarget, "error", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results
 }
 
 // GetConfig returns the server configuration
 func (s *Server) GetConfig() *config.Config {
 	return s.config
 }
+
+// newIPBan creates an IP ban manager and its middleware from an ip_ban section.
+// A listener's own section persists its bans apart from the top-level one's.
+func newIPBan(cfg config.IPBanConfig, listener string) (*manager.IPBanManager, *middleware.IPBanMiddleware) {
+	ipBanOpts := []manager.IPBanOption{
+		manager.WithWhitelist(cfg.Whitelist),
+		manager.WithMaxTrackedIPs(cfg.MaxTrackedIPs),
+		manager.WithSaveRetries(cfg.SaveRetries),
+	}
+	if listener != "" {
+		ipBanOpts = append(ipBanOpts, manager.WithPersistFile("data/ipban-"+listener+".json"))
+	}
+	if cfg.WindowMode {
+		ipBanOpts = append(ipBanOpts, manager.WithFailureWindow(time.Duration(cfg.FailureWindowSeconds)*time.Second))
+	} else if cfg.FailureDecaySeconds > 0 {
+		ipBanOpts = append(ipBanOpts, manager.WithFailureDecay(time.Duration(cfg.FailureDecaySeconds)*time.Second))
+	}
+	if rdns := cfg.ReverseDNS; rdns.Enabled {
+		ipBanOpts = append(ipBanOpts, manager.WithReverseDNSRules(manager.NewReverseDNSRules(
+			rdns.Allow, rdns.Block,
+			time.Duration(rdns.CacheSeconds)*time.Second,
+			time.Duration(rdns.TimeoutMs)*time.Millisecond,
+		)))
+	}
+
+	ipBanMgr := manager.NewIPBanManager(
+		cfg.MaxFailures,
+		time.Duration(cfg.BanDurationSeconds)*time.Second,
+		ipBanOpts...,
+	)
+	if cfg.Enabled {
+		for _, feed := range cfg.Feeds {
+			ipBanMgr.WatchFeed(feed.Name, feed.Kind, feed.Location, time.Duration(feed.RefreshSeconds)*time.Second)
+		}
+	}
+
+	return ipBanMgr, middleware.NewIPBanMiddleware(cfg.Enabled, ipBanMgr)
+}
+
+// newRateLimit creates a rate limit middleware from a rate_limit section,
+// banning repeat offenders through ipBanMW. It also returns the Redis limiter
+// and connection budget the middleware uses, nil when not configured.
+func newRateLimit(cfg config.RateLimitConfig, ipBanMW *middleware.IPBanMiddleware) (*middleware.RateLimitMiddleware, *manager.RedisLimiter, *manager.Budget) {
+	rateLimitOpts := []middleware.RateLimitOption{
+		middleware.WithIdleTimeout(time.Duration(cfg.IdleTimeoutSeconds) * time.Second),
+		middleware.WithTrackedIPsWarning(cfg.WarnTrackedIPs),
+	}
+	if cfg.BanThreshold > 0 {
+		rateLimitOpts = append(rateLimitOpts, middleware.WithBanOnViolations(
+			manager.NewViolationCounter(
+				cfg.BanThreshold,
+				time.Duration(cfg.BanWindowSeconds)*time.Second,
+			),
+			ipBanMW,
+		))
+	}
+
+	var budget *manager.Budget
+	if cfg.BudgetConnections > 0 {
+		budget = manager.NewBudget(
+			cfg.BudgetConnections,
+			time.Duration(cfg.BudgetWindowSeconds)*time.Second,
+		)
+		rateLimitOpts = append(rateLimitOpts, middleware.WithBudget(budget))
+	}
+	if cfg.FairQueuing {
+		rateLimitOpts = append(rateLimitOpts, middleware.WithFairQueuing(time.Duration(cfg.FairQueueMaxWaitMs)*time.Millisecond))
+	}
+	if cfg.Adaptive.Enabled {
+		rateLimitOpts = append(rateLimitOpts, middleware.WithAdaptiveLimits(middleware.NewAdaptiveLimits(
+			ipBanMW,
+			cfg.Adaptive.CleanMultiplier,
+			cfg.Adaptive.FailurePenalty,
+			cfg.Adaptive.BannedMultiplier,
+			time.Duration(cfg.Adaptive.BanMemorySeconds)*time.Second,
+			time.Duration(cfg.Adaptive.RecomputeSeconds)*time.Second,
+		)))
+	}
+
+	var redisLimiter *manager.RedisLimiter
+	if cfg.Enabled && cfg.Backend == "redis" {
+		redisLimiter = manager.NewRedisLimiter(
+			redis.NewClient(
+				cfg.Redis.Address,
+				redis.WithPassword(cfg.Redis.Password),
+				redis.WithDB(cfg.Redis.DB),
+				redis.WithTimeout(time.Duration(cfg.Redis.TimeoutMs)*time.Millisecond),
+				redis.WithHealthCheck(time.Duration(cfg.Redis.HealthCheckIntervalSeconds)*time.Second),
+			),
+			cfg.Redis.KeyPrefix,
+		)
+		rateLimitOpts = append(rateLimitOpts,
+			middleware.WithLimiter(redisLimiter),
+			middleware.WithFailClosed(cfg.Redis.FailMode == "closed"))
+		logger.Info("Rate limits are shared through Redis", "address", cfg.Redis.Address, "fail_mode", cfg.Redis.FailMode)
+	}
+
+	rateLimitMW := middleware.NewRateLimitMiddleware(
+		cfg.Enabled,
+		cfg.GlobalRequestsPerSecond,
+		cfg.PerIPRequestsPerSecond,
+		rateLimitOpts...,
+	)
+
+	return rateLimitMW, redisLimiter, budget
+}
+
+// newBandwidthLimits creates the per-IP and per-user throughput limiters of a
+// rate_limit section, nil for those it leaves unlimited
+func newBandwidthLimits(cfg config.RateLimitConfig) (ipBandwidth, userBandwidth *manager.BandwidthLimiter) {
+	if cfg.Enabled && cfg.PerIPBytesPerSecond > 0 {
+		ipBandwidth = manager.NewBandwidthLimiter(cfg.PerIPBytesPerSecond)
+	}
+	if cfg.Enabled && cfg.PerUserBytesPerSecond > 0 {
+		userBandwidth = manager.NewBandwidthLimiter(cfg.PerUserBytesPerSecond)
+	}
+	return ipBandwidth, userBandwidth
+}
+
+// newTargetCategories builds the target category middleware from its configuration
+func newTargetCategories(cfg config.TargetCategoriesConfig) *middleware.TargetCategoryMiddleware {
+	rules := make([]middleware.CategoryRule, len(cfg.Rules))
+	for i, rule := range cfg.Rules {
+		rules[i] = middleware.CategoryRule{Name: rule.Name, Ports: rule.Ports, Hosts: rule.Hosts}
+	}
+	return middleware.NewTargetCategoryMiddleware(cfg.Enabled, rules, cfg.Default)
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seakee/dudu-proxy/internal/config"
+	"github.com/seakee/dudu-proxy/internal/manager"
+	"github.com/seakee/dudu-proxy/internal/middleware"
+)
+
+func TestServer_Reload(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		wantOldUser  bool
+		wantNewUser  bool
+		wantOverride string
+		wantProbe    string
+	}{
+		{
+			name: "valid config is applied",
+			config: `{
+				"server": {"http_port": 8080, "socks5_port": 1080},
+				"auth": {"enabled": true, "users": [{"username": "bob", "password": "new-secret"}]},
+				"admin": {"probe_target": "example.com:443", "probe_username": "bob", "probe_password": "new-secret"},
+				"host_overrides": {"api.example.com": "10.0.0.9"}
+			}`,
+			wantOldUser:  false,
+			wantNewUser:  true,
+			wantOverride: "10.0.0.9:443",
+			wantProbe:    "bob",
+		},
+		{
+			name: "invalid config keeps the running settings",
+			config: `{
+				"server": {"http_port": 8080, "socks5_port": 1080},
+				"http": {"error_format": "xml"},
+				"auth": {"enabled": true, "users": [{"username": "bob", "password": "new-secret"}]},
+				"host_overrides": {"api.example.com": "10.0.0.9"}
+			}`,
+			wantOldUser:  true,
+			wantNewUser:  false,
+			wantOverride: "10.0.0.5:443",
+			wantProbe:    "alice",
+		},
+		{
+			name:         "unparsable config keeps the running settings",
+			config:       `{"server": {"http_port": 8080,`,
+			wantOldUser:  true,
+			wantNewUser:  false,
+			wantOverride: "10.0.0.5:443",
+			wantProbe:    "alice",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.json")
+			if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+
+			authMW := middleware.NewAuthMiddleware(true, map[string]string{"alice": "old-secret"})
+			s := &Server{
+				configFile:    path,
+				authMWs:       []*middleware.AuthMiddleware{authMW},
+				hostOverrides: manager.NewHostOverrides(map[string]string{"api.example.com": "10.0.0.5"}),
+			}
+			s.probeCreds.Store(&probeCredentials{"alice", "old-secret"})
+
+			s.reload()
+
+			if got := authMW.Authenticate("alice", "old-secret"); got != tt.wantOldUser {
+				t.Errorf("Expected the old user to authenticate: %v, got %v", tt.wantOldUser, got)
+			}
+			if got := authMW.Authenticate("bob", "new-secret"); got != tt.wantNewUser {
+				t.Errorf("Expected the new user to authenticate: %v, got %v", tt.wantNewUser, got)
+			}
+			if got, _ := s.hostOverrides.Apply("api.example.com:443"); got != tt.wantOverride {
+				t.Errorf("Expected host override %s, got %s", tt.wantOverride, got)
+			}
+			if got := s.probeCreds.Load().username; got != tt.wantProbe {
+				t.Errorf("Expected probe user %s, got %s", tt.wantProbe, got)
+			}
+		})
+	}
+}
+
+func TestNewIPBan_ListenerPersistFile(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg := config.IPBanConfig{Enabled: true, MaxFailures: 3, BanDurationSeconds: 60}
+	shared, _ := newIPBan(cfg, "")
+	override, _ := newIPBan(cfg, "http")
+	shared.BanIP("10.0.0.1")
+	override.BanIP("10.0.0.2")
+	shared.Stop()
+	override.Stop()
+
+	tests := []struct {
+		file    string
+		want    string
+		notWant string
+	}{
+		{"data/ipban.json", "10.0.0.1", "10.0.0.2"},
+		{"data/ipban-http.json", "10.0.0.2", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		data, err := os.ReadFile(tt.file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", tt.file, err)
+		}
+		if !strings.Contains(string(data), tt.want) {
+			t.Errorf("Expected %s to hold the ban of %s", tt.file, tt.want)
+		}
+		if strings.Contains(string(data), tt.notWant) {
+			t.Errorf("Expected %s not to hold the ban of %s", tt.file, tt.notWant)
+		}
+	}
+}
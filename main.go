@@ -11,15 +11,22 @@ import (
 )
 
 var (
-	configFile = flag.String("config", "configs/config.example.json", "Path to configuration file")
-	version    = "1.0.0"
+	configFile   = flag.String("config", "configs/config.example.json", "Path to configuration file")
+	validateOnly = flag.Bool("validate", false, "Validate the configuration file and exit")
+	jsonOutput   = flag.Bool("json", false, "With -validate, print the result as JSON")
+	version      = "1.0.0"
 )
 
 func main() {
 	flag.Parse()
 
-	// Print banner
-	printBanner()
+	// Dry run: exit non-zero when the configuration is invalid
+	if *validateOnly {
+		if !validateConfig(os.Stdout, *configFile, *jsonOutput) {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// Load configuration
 	cfg, err := config.Load(*configFile)
@@ -28,8 +35,14 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Print banner, unless stdout must carry nothing but logs
+	if !cfg.Log.NoBanner {
+		printBanner()
+	}
+
 	// Initialize logger
-	logger.Init(cfg.Log.Level, cfg.Log.Driver, cfg.Log.Path)
+	logger.Init(cfg.Log.Level, cfg.Log.Driver, cfg.Log.Path, cfg.Log.Format)
+	logger.EnableSampling(cfg.Log.SampleInitial, cfg.Log.SampleThereafter)
 
 	logger.Info("Starting DuDu Proxy",
 		"version", version,
@@ -39,7 +52,10 @@ func main() {
 	logConfigSummary(cfg)
 
 	// Create and run server
-	srv := server.NewServer(cfg)
+	srv, err := server.NewServer(cfg, version, server.WithConfigFile(*configFile))
+	if err != nil {
+		logger.Fatal("Failed to create server", "error", err)
+	}
 	if err := srv.Run(); err != nil {
 		logger.Fatal("Server failed", "error", err)
 	}
@@ -60,29 +76,192 @@ Version: %s
 	fmt.Println()
 }
 
-func logConfigSummary(cfg *config.Config) {
-	logger.Info("Server configuration",
-		"http_port", cfg.Server.HTTPPort,
-		"socks5_port", cfg.Server.SOCKS5Port,
-		"network", cfg.Server.Network,
-		"auth_enabled", cfg.Auth.Enabled,
-		"auth_users", len(cfg.Auth.Users))
-
-	logger.Info("IP ban configuration",
-		"ip_ban_enabled", cfg.IPBan.Enabled,
-		"max_failures", cfg.IPBan.MaxFailures,
-		"ban_duration_seconds", cfg.IPBan.BanDurationSeconds,
-		"whitelist_count", len(cfg.IPBan.Whitelist))
+// summarySection is one group of settings in the configuration summary
+type summarySection struct {
+	name    string        // Key in the -validate -json summary
+	message string        // Log message
+	fields  []interface{} // Key/value pairs
+}
 
-	logger.Info("Rate limit configuration",
-		"rate_limit_enabled", cfg.RateLimit.Enabled,
-		"global_rps", cfg.RateLimit.GlobalRequestsPerSecond,
-		"per_ip_rps", cfg.RateLimit.PerIPRequestsPerSecond)
+// configSummary groups the effective settings for logging and -validate output
+func configSummary(cfg *config.Config) []summarySection {
+	return []summarySection{
+		{"server", "Server configuration", []interface{}{
+			"http_port", cfg.Server.HTTPPort,
+			"socks5_port", cfg.Server.SOCKS5Port,
+			"http_listen", cfg.Server.HTTPListenAddresses(),
+			"socks5_listen", cfg.Server.SOCKS5ListenAddresses(),
+			"source_ips", cfg.Server.SourceIPs,
+			"reuse_port", cfg.Server.ReusePort,
+			"http_upstream", cfg.HTTP.Upstream.Address,
+			"socks5_preserve_source_port", cfg.SOCKS5.PreserveSourcePort,
+			"socks5_reply_address_mode", cfg.SOCKS5.ReplyAddressMode,
+			"socks5_policy_deny_reply_code", cfg.SOCKS5.PolicyDenyReplyCode,
+			"socks5_read_timeout_seconds", cfg.SOCKS5.ReadTimeoutSeconds,
+			"socks5_write_timeout_seconds", cfg.SOCKS5.WriteTimeoutSeconds,
+			"send_proxy_protocol", cfg.Upstream.SendProxyProtocol,
+			"max_conns_per_target", cfg.Upstream.MaxConnsPerTarget,
+			"http_max_response_bytes", cfg.HTTP.MaxResponseBytes,
+			"http_read_timeout_seconds", cfg.HTTP.ReadTimeoutSeconds,
+			"http_write_timeout_seconds", cfg.HTTP.WriteTimeoutSeconds,
+			"http_realm", cfg.HTTP.GetRealm(),
+			"http_server_header", cfg.HTTP.ServerHeader,
+			"http_error_format", cfg.HTTP.ErrorFormat,
+			"http_connect_only", cfg.HTTP.ConnectOnly,
+			"http_connect_failure_status", cfg.HTTP.ConnectFailure.Status,
+			"http_intercept", cfg.HTTP.Intercept.Enabled,
+			"network", cfg.Server.Network,
+			"max_handshakes", cfg.Server.MaxHandshakes,
+			"handshake_queue_size", cfg.Server.HandshakeQueueSize,
+			"handshake_queue_policy", cfg.Server.HandshakeQueuePolicy,
+			"reject_with_rst", cfg.Server.RejectWithRST,
+			"handshake_timeout_seconds", cfg.Server.HandshakeTimeoutSeconds,
+			"auth_timeout_seconds", cfg.Server.AuthTimeoutSeconds,
+			"connection_timeout_seconds", cfg.Server.ConnectionTimeoutSeconds,
+			"auth_http", cfg.Auth.EnabledFor("http"),
+			"auth_socks5", cfg.Auth.EnabledFor("socks5"),
+			"auth_users", len(cfg.Auth.Users),
+			"auth_tokens", len(cfg.Auth.Tokens),
+			"anonymous_user", cfg.Auth.AnonymousUser,
+			"min_password_length", cfg.Auth.MinPasswordLength,
+			"reject_common_passwords", cfg.Auth.RejectCommonPasswords,
+			"failure_delay_ms", cfg.Auth.FailureDelayMs,
+			"max_failure_delay_ms", cfg.Auth.MaxFailureDelayMs,
+			"rotation_grace_seconds", cfg.Auth.RotationGraceSeconds,
+			"max_conns_per_user", cfg.Auth.MaxConnsPerUser,
+			"max_attempts_per_conn", cfg.Auth.MaxAttemptsPerConn,
+			"require_cert_username_match", cfg.Auth.RequireCertUsernameMatch,
+			"anonymous_per_ip_requests_per_second", cfg.Auth.Anonymous.PerIPRequestsPerSecond,
+			"anonymous_max_conns", cfg.Auth.Anonymous.MaxConns,
+			"anonymous_allowed_ports", cfg.Auth.Anonymous.AllowedPorts,
+		}},
+		{"ip_ban", "IP ban configuration", []interface{}{
+			"ip_ban_enabled", cfg.IPBan.Enabled,
+			"max_failures", cfg.IPBan.MaxFailures,
+			"ban_duration_seconds", cfg.IPBan.BanDurationSeconds,
+			"whitelist_count", len(cfg.IPBan.Whitelist),
+			"window_mode", cfg.IPBan.WindowMode,
+			"failure_window_seconds", cfg.IPBan.FailureWindowSeconds,
+			"failure_decay_seconds", cfg.IPBan.FailureDecaySeconds,
+			"max_tracked_ips", cfg.IPBan.MaxTrackedIPs,
+			"feed_count", len(cfg.IPBan.Feeds),
+			"save_retries", cfg.IPBan.SaveRetries,
+			"reverse_dns", cfg.IPBan.ReverseDNS.Enabled,
+			"http_override", cfg.HTTP.IPBan != nil,
+			"socks5_override", cfg.SOCKS5.IPBan != nil,
+		}},
+		{"rate_limit", "Rate limit configuration", []interface{}{
+			"rate_limit_enabled", cfg.RateLimit.Enabled,
+			"global_rps", cfg.RateLimit.GlobalRequestsPerSecond,
+			"per_ip_rps", cfg.RateLimit.PerIPRequestsPerSecond,
+			"fair_queuing", cfg.RateLimit.FairQueuing,
+			"adaptive", cfg.RateLimit.Adaptive.Enabled,
+			"idle_timeout_seconds", cfg.RateLimit.IdleTimeoutSeconds,
+			"warn_tracked_ips", cfg.RateLimit.WarnTrackedIPs,
+			"ban_threshold", cfg.RateLimit.BanThreshold,
+			"ban_window_seconds", cfg.RateLimit.BanWindowSeconds,
+			"budget_connections", cfg.RateLimit.BudgetConnections,
+			"budget_window_seconds", cfg.RateLimit.BudgetWindowSeconds,
+			"per_ip_bytes_per_second", cfg.RateLimit.PerIPBytesPerSecond,
+			"per_user_bytes_per_second", cfg.RateLimit.PerUserBytesPerSecond,
+			"backend", cfg.RateLimit.Backend,
+			"redis_address", cfg.RateLimit.Redis.Address,
+			"redis_fail_mode", cfg.RateLimit.Redis.FailMode,
+			"http_override", cfg.HTTP.RateLimit != nil,
+			"socks5_override", cfg.SOCKS5.RateLimit != nil,
+		}},
+		{"circuit_breaker", "Circuit breaker configuration", []interface{}{
+			"circuit_breaker_enabled", cfg.CircuitBreaker.Enabled,
+			"failure_threshold_percent", cfg.CircuitBreaker.FailureThresholdPercent,
+			"window_size_seconds", cfg.CircuitBreaker.WindowSizeSeconds,
+			"min_requests", cfg.CircuitBreaker.MinRequests,
+			"break_duration_seconds", cfg.CircuitBreaker.BreakDurationSeconds,
+			"half_open_successes", cfg.CircuitBreaker.HalfOpenSuccesses,
+			"half_open_probes", cfg.CircuitBreaker.HalfOpenProbes,
+			"half_open_probe_interval_ms", cfg.CircuitBreaker.HalfOpenProbeIntervalMs,
+			"half_open_success_seconds", cfg.CircuitBreaker.HalfOpenSuccessSeconds,
+			"shadow_mode", cfg.CircuitBreaker.ShadowMode,
+			"offender_percent", cfg.CircuitBreaker.OffenderPercent,
+		}},
+		{"target_circuit_breaker", "Target circuit breaker configuration", []interface{}{
+			"target_circuit_breaker_enabled", cfg.TargetBreaker.Enabled,
+			"failure_threshold_percent", cfg.TargetBreaker.FailureThresholdPercent,
+			"window_size_seconds", cfg.TargetBreaker.WindowSizeSeconds,
+			"min_requests", cfg.TargetBreaker.MinRequests,
+			"break_duration_seconds", cfg.TargetBreaker.BreakDurationSeconds,
+			"half_open_probes", cfg.TargetBreaker.HalfOpenProbes,
+			"shadow_mode", cfg.TargetBreaker.ShadowMode,
+		}},
+		{"scan_detection", "Scan detection configuration", []interface{}{
+			"scan_detection_enabled", cfg.ScanDetection.Enabled,
+			"max_distinct_targets", cfg.ScanDetection.MaxDistinctTargets,
+			"window_seconds", cfg.ScanDetection.WindowSeconds,
+			"ban_immediately", cfg.ScanDetection.BanImmediately,
+		}},
+		{"ssrf_guard", "SSRF guard configuration", []interface{}{
+			"ssrf_guard_enabled", cfg.SSRFGuard.Enabled,
+			"allow", cfg.SSRFGuard.Allow,
+		}},
+		{"host_overrides", "Host overrides configuration", []interface{}{
+			"host_overrides", len(cfg.HostOverrides),
+		}},
+		{"target_categories", "Target categories configuration", []interface{}{
+			"target_categories_enabled", cfg.TargetCategories.Enabled,
+			"rules", len(cfg.TargetCategories.Rules),
+			"default", cfg.TargetCategories.Default,
+		}},
+		{"target_stats", "Target stats configuration", []interface{}{
+			"target_stats_enabled", cfg.TargetStats.Enabled,
+			"capacity", cfg.TargetStats.Capacity,
+			"decay_interval_seconds", cfg.TargetStats.DecayIntervalSeconds,
+			"log_interval_seconds", cfg.TargetStats.LogIntervalSeconds,
+			"log_top", cfg.TargetStats.LogTop,
+		}},
+		{"event_stream", "Event stream configuration", []interface{}{
+			"event_stream_enabled", cfg.EventStream.Enabled,
+			"socket_path", cfg.EventStream.SocketPath,
+			"buffer_size", cfg.EventStream.BufferSize,
+		}},
+		{"event_queue", "Event queue configuration", []interface{}{
+			"event_queue_enabled", cfg.EventQueue.Enabled,
+			"address", cfg.EventQueue.Address,
+			"subject", cfg.EventQueue.Subject,
+			"format", cfg.EventQueue.Format,
+			"buffer_size", cfg.EventQueue.BufferSize,
+		}},
+		{"user_log", "User log configuration", []interface{}{
+			"user_log_enabled", cfg.UserLog.Enabled,
+			"directory", cfg.UserLog.Directory,
+			"file_name", cfg.UserLog.FileName,
+			"max_open_files", cfg.UserLog.MaxOpenFiles,
+		}},
+		{"tls", "TLS configuration", []interface{}{
+			"tls_enabled", cfg.TLS.Enabled,
+			"listeners", cfg.TLS.Listeners,
+			"certificates", len(cfg.TLS.Certificates),
+			"min_version", cfg.TLS.MinVersion,
+			"cipher_suites", cfg.TLS.CipherSuites,
+			"log_handshakes", cfg.TLS.LogHandshakes,
+			"client_ca_file", cfg.TLS.ClientCAFile,
+		}},
+		{"admin", "Admin configuration", []interface{}{
+			"admin_enabled", cfg.Admin.Enabled,
+			"admin_address", cfg.Admin.Address,
+			"admin_dashboard", cfg.Admin.Dashboard,
+			"probe_target", cfg.Admin.ProbeTarget,
+			"probe_username", cfg.Admin.ProbeUsername,
+		}},
+		{"startup", "Startup configuration", []interface{}{
+			"ready_notify_systemd", cfg.Startup.ReadyNotify.Systemd,
+			"ready_notify_file", cfg.Startup.ReadyNotify.File,
+			"ready_notify_webhook", cfg.Startup.ReadyNotify.WebhookURL != "",
+			"ready_notify_probe", cfg.Startup.ReadyNotify.Probe,
+		}},
+	}
+}
 
-	logger.Info("Circuit breaker configuration",
-		"circuit_breaker_enabled", cfg.CircuitBreaker.Enabled,
-		"failure_threshold_percent", cfg.CircuitBreaker.FailureThresholdPercent,
-		"window_size_seconds", cfg.CircuitBreaker.WindowSizeSeconds,
-		"min_requests", cfg.CircuitBreaker.MinRequests,
-		"break_duration_seconds", cfg.CircuitBreaker.BreakDurationSeconds)
+func logConfigSummary(cfg *config.Config) {
+	for _, section := range configSummary(cfg) {
+		logger.Info(section.message, section.fields...)
+	}
 }
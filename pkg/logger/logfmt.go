@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var logfmtPool = buffer.NewPool()
+
+// logfmtEncoderConfig names the standard logfmt keys
+var logfmtEncoderConfig = zapcore.EncoderConfig{
+	TimeKey:        "time",
+	LevelKey:       "level",
+	NameKey:        "logger",
+	MessageKey:     "msg",
+	CallerKey:      "caller",
+	StacktraceKey:  "stacktrace",
+	LineEnding:     zapcore.DefaultLineEnding,
+	EncodeLevel:    zapcore.LowercaseLevelEncoder,
+	EncodeTime:     zapcore.ISO8601TimeEncoder,
+	EncodeDuration: zapcore.StringDurationEncoder,
+	EncodeCaller:   zapcore.ShortCallerEncoder,
+}
+
+// logfmtEncoder renders entries as key=value lines. Fields are encoded by the
+// embedded JSON encoder and then rewritten in order, so every zap field type is
+// supported; nested objects and arrays become quoted JSON values.
+type logfmtEncoder struct {
+	zapcore.Encoder
+}
+
+// newLogfmtEncoder creates a logfmt encoder
+func newLogfmtEncoder() zapcore.Encoder {
+	return logfmtEncoder{zapcore.NewJSONEncoder(logfmtEncoderConfig)}
+}
+
+// Clone implements zapcore.Encoder
+func (e logfmtEncoder) Clone() zapcore.Encoder {
+	return logfmtEncoder{e.Encoder.Clone()}
+}
+
+// EncodeEntry implements zapcore.Encoder
+func (e logfmtEncoder) EncodeEntry(entry zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	jsonBuf, err := e.Encoder.EncodeEntry(entry, fields)
+	if err != nil {
+		return nil, err
+	}
+	defer jsonBuf.Free()
+
+	out := logfmtPool.Get()
+	if err := jsonToLogfmt(out, jsonBuf.Bytes()); err != nil {
+		out.Free()
+		return nil, err
+	}
+	out.AppendString(zapcore.DefaultLineEnding)
+
+	return out, nil
+}
+
+// jsonToLogfmt appends the top-level members of a JSON object as key=value pairs
+func jsonToLogfmt(out *buffer.Buffer, data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("logfmt: expected JSON object: %v", err)
+	}
+
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := tok.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+
+		if out.Len() > 0 {
+			out.AppendByte(' ')
+		}
+		out.AppendString(logfmtKey(key))
+		out.AppendByte('=')
+
+		if len(raw) > 0 && raw[0] == '"' {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return err
+			}
+			out.AppendString(logfmtValue(s))
+		} else if len(raw) > 0 && (raw[0] == '{' || raw[0] == '[') {
+			out.AppendString(logfmtValue(string(raw)))
+		} else {
+			out.Write(raw) // Numbers, booleans and null
+		}
+	}
+
+	return nil
+}
+
+// logfmtKey replaces characters that aren't allowed in a logfmt key
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes s when it is empty or contains spaces, quotes, '=' or control characters
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+
+	if strings.IndexFunc(s, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == '\\' || unicode.IsControl(r)
+	}) == -1 {
+		return s
+	}
+
+	quoted, _ := json.Marshal(s)
+	return string(quoted)
+}
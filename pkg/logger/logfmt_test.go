@@ -0,0 +1,55 @@
+package logger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLogfmtEncoder(t *testing.T) {
+	enc := newLogfmtEncoder()
+	entry := zapcore.Entry{
+		Level:   zapcore.InfoLevel,
+		Time:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Message: "SOCKS5 connection established",
+	}
+
+	buf, err := enc.EncodeEntry(entry, convertToZapFields([]interface{}{
+		"client_ip", "192.168.1.100",
+		"target", "example.com:443",
+		"port", 1080,
+		"tls", true,
+		"error", errors.New(`dial "x" failed`),
+		"empty", "",
+		"users", []string{"a", "b"},
+	}))
+	if err != nil {
+		t.Fatalf("EncodeEntry failed: %v", err)
+	}
+	defer buf.Free()
+
+	want := `level=info time=2024-01-02T03:04:05.000Z msg="SOCKS5 connection established" ` +
+		`client_ip=192.168.1.100 target=example.com:443 port=1080 tls=true error="dial \"x\" failed" ` +
+		`empty="" users="[\"a\",\"b\"]"` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Unexpected logfmt line:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestLogfmtEncoder_With(t *testing.T) {
+	enc := newLogfmtEncoder()
+	convertToZapFields([]interface{}{"component", "http"})[0].AddTo(enc)
+
+	buf, err := enc.EncodeEntry(zapcore.Entry{Level: zapcore.WarnLevel, Message: "hi"}, nil)
+	if err != nil {
+		t.Fatalf("EncodeEntry failed: %v", err)
+	}
+	defer buf.Free()
+
+	if !strings.HasSuffix(buf.String(), "msg=hi component=http\n") {
+		t.Errorf("Context fields should follow the message, got %q", buf.String())
+	}
+}
@@ -2,15 +2,23 @@ package logger
 
 import (
 	"context"
+	"os"
+	"time"
 
+	rotatelogs "github.com/lestrrat-go/file-rotatelogs"
 	skLogger "github.com/sk-pkg/logger"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-var globalLogger *skLogger.Manager
+var (
+	globalLogger  *skLogger.Manager
+	sampledLogger *skLogger.Manager // Used by WarnSampled when sampling is enabled
+)
 
-// Init initializes the logger with the specified level and format
-func Init(level, driver, path string) {
+// Init initializes the logger with the specified level, driver, path and format.
+// format is "console" (default), "json" or "logfmt".
+func Init(level, driver, path, format string) {
 	// Create logger options
 	opts := []skLogger.Option{
 		skLogger.WithLevel(level),
@@ -23,8 +31,8 @@ func Init(level, driver, path string) {
 	// Set log path
 	opts = append(opts, skLogger.WithLogPath(path))
 
-	// Enable color for console format
-	opts = append(opts, skLogger.WithColor(true))
+	// Console encoder when color is enabled, JSON encoder otherwise
+	opts = append(opts, skLogger.WithColor(format == "" || format == "console"))
 
 	// Initialize logger
 	var err error
@@ -32,6 +40,52 @@ func Init(level, driver, path string) {
 	if err != nil {
 		panic("failed to initialize logger: " + err.Error())
 	}
+
+	// sk-pkg/logger only knows console and JSON, so logfmt swaps in its own core
+	// writing to the same destination and keeping the configured level
+	if format == "logfmt" {
+		ws, err := newWriteSyncer(driver, path)
+		if err != nil {
+			panic("failed to initialize logger: " + err.Error())
+		}
+		globalLogger.Zap = globalLogger.Zap.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+			return zapcore.NewCore(newLogfmtEncoder(), ws, core)
+		}))
+	}
+}
+
+// EnableSampling rate-limits messages logged with WarnSampled: within each
+// second the first `initial` entries with the same message are logged, then
+// only every `thereafter`-th one. Zero thereafter drops the rest of the second.
+// Must be called after Init.
+func EnableSampling(initial, thereafter int) {
+	if globalLogger == nil || initial <= 0 {
+		return
+	}
+
+	sampled := *globalLogger
+	sampled.Zap = globalLogger.Zap.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return zapcore.NewSamplerWithOptions(core, time.Second, initial, thereafter)
+	}))
+	sampledLogger = &sampled
+}
+
+// newWriteSyncer opens the log destination the same way sk-pkg/logger does
+func newWriteSyncer(driver, path string) (zapcore.WriteSyncer, error) {
+	if driver != "file" {
+		return zapcore.AddSync(os.Stdout), nil
+	}
+
+	hook, err := rotatelogs.New(
+		path+"%Y-%m-%d.log",
+		rotatelogs.WithMaxAge(7*24*time.Hour),
+		rotatelogs.WithRotationTime(24*time.Hour),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return zapcore.AddSync(hook), nil
 }
 
 // Debug logs a debug message with key-value pairs
@@ -43,6 +97,41 @@ func Debug(msg string, keysAndValues ...interface{}) {
 	globalLogger.Debug(context.Background(), msg, fields...)
 }
 
+// ForceDebug logs a debug message even when the configured level is higher,
+// for singling out one client's connections while debugging
+func ForceDebug(msg string, keysAndValues ...interface{}) {
+	if globalLogger == nil {
+		return
+	}
+	forced := *globalLogger
+	forced.Zap = globalLogger.Zap.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+		return allLevelsCore{core}
+	}))
+	fields := convertToZapFields(keysAndValues)
+	forced.Debug(context.Background(), msg, fields...)
+}
+
+// allLevelsCore writes entries of every level, whatever the wrapped core's
+// level is set to
+type allLevelsCore struct {
+	zapcore.Core
+}
+
+// Enabled implements zapcore.Core
+func (c allLevelsCore) Enabled(zapcore.Level) bool {
+	return true
+}
+
+// With implements zapcore.Core
+func (c allLevelsCore) With(fields []zapcore.Field) zapcore.Core {
+	return allLevelsCore{c.Core.With(fields)}
+}
+
+// Check implements zapcore.Core
+func (c allLevelsCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(ent, c)
+}
+
 // Info logs an info message with key-value pairs
 func Info(msg string, keysAndValues ...interface{}) {
 	if globalLogger == nil {
@@ -61,6 +150,20 @@ func Warn(msg string, keysAndValues ...interface{}) {
 	globalLogger.Warn(context.Background(), msg, fields...)
 }
 
+// WarnSampled logs a warning that may repeat at high rates, such as rejected
+// requests under attack. It is subject to sampling when enabled.
+func WarnSampled(msg string, keysAndValues ...interface{}) {
+	l := sampledLogger
+	if l == nil {
+		l = globalLogger
+	}
+	if l == nil {
+		return
+	}
+	fields := convertToZapFields(keysAndValues)
+	l.Warn(context.Background(), msg, fields...)
+}
+
 // Error logs an error message with key-value pairs
 func Error(msg string, keysAndValues ...interface{}) {
 	if globalLogger == nil {
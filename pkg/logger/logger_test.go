@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"testing"
+
+	skLogger "github.com/sk-pkg/logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWarnSampled(t *testing.T) {
+	tests := []struct {
+		name       string
+		initial    int
+		thereafter int
+		want       int
+	}{
+		{"sampling disabled", 0, 0, 10},
+		{"first only", 2, 0, 2},
+		{"every third after first", 1, 3, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zapcore.DebugLevel)
+			m, err := skLogger.New(skLogger.WithDriver("stdout"))
+			if err != nil {
+				t.Fatalf("Failed to create logger: %v", err)
+			}
+			m.Zap = zap.New(core)
+			globalLogger = m
+			sampledLogger = nil
+			defer func() { globalLogger, sampledLogger = nil, nil }()
+
+			EnableSampling(tt.initial, tt.thereafter)
+			for i := 0; i < 10; i++ {
+				WarnSampled("Request rejected", "client_ip", "10.0.0.1")
+			}
+			Warn("Unsampled", "n", 1)
+
+			if got := logs.FilterMessage("Request rejected").Len(); got != tt.want {
+				t.Errorf("Expected %d sampled entries, got %d", tt.want, got)
+			}
+			if logs.FilterMessage("Unsampled").Len() != 1 {
+				t.Error("Expected regular warnings to bypass sampling")
+			}
+		})
+	}
+}
+
+func TestForceDebug(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	m, err := skLogger.New(skLogger.WithDriver("stdout"))
+	if err != nil {
+		t.Fatalf("Failed to create logger: %v", err)
+	}
+	m.Zap = zap.New(core)
+	globalLogger = m
+	defer func() { globalLogger = nil }()
+
+	Debug("Dropped", "client_ip", "10.0.0.1")
+	ForceDebug("Forced", "client_ip", "10.0.0.2")
+
+	if logs.FilterMessage("Dropped").Len() != 0 {
+		t.Error("Expected regular debug messages to respect the info level")
+	}
+	forced := logs.FilterMessage("Forced").All()
+	if len(forced) != 1 || forced[0].Level != zapcore.DebugLevel {
+		t.Errorf("Expected one forced debug entry, got %+v", forced)
+	}
+}
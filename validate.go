@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/seakee/dudu-proxy/internal/config"
+)
+
+// validationResult is printed by -validate -json
+type validationResult struct {
+	Valid   bool                              `json:"valid"`
+	Errors  []string                          `json:"errors"`
+	Summary map[string]map[string]interface{} `json:"summary,omitempty"` // Only for a valid configuration
+}
+
+// validateConfig loads the configuration file and reports the result to w, as
+// JSON when asJSON is set. It returns whether the configuration is valid.
+func validateConfig(w io.Writer, path string, asJSON bool) bool {
+	cfg, err := config.Load(path)
+
+	if !asJSON {
+		if err != nil {
+			fmt.Fprintf(w, "Configuration %s is invalid: %v\n", path, err)
+			return false
+		}
+		fmt.Fprintf(w, "Configuration %s is valid\n", path)
+		return true
+	}
+
+	result := validationResult{Valid: err == nil, Errors: []string{}}
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+	} else {
+		result.Summary = make(map[string]map[string]interface{})
+		for _, section := range configSummary(cfg) {
+			fields := make(map[string]interface{}, len(section.fields)/2)
+			for i := 0; i+1 < len(section.fields); i += 2 {
+				fields[fmt.Sprint(section.fields[i])] = section.fields[i+1]
+			}
+			result.Summary[section.name] = fields
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(result)
+
+	return result.Valid
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateConfig(t *testing.T) {
+	dir := t.TempDir()
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := os.WriteFile(invalid, []byte(`{"server":{"http_port":0}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		wantValid  bool
+		wantErrors int
+	}{
+		{"example config", "configs/config.example.json", true, 0},
+		{"invalid port", invalid, false, 1},
+		{"missing file", filepath.Join(dir, "missing.json"), false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if valid := validateConfig(&out, tt.path, true); valid != tt.wantValid {
+				t.Fatalf("Expected valid=%v, got %v", tt.wantValid, valid)
+			}
+
+			var result validationResult
+			if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+				t.Fatalf("Output is not JSON: %v\n%s", err, out.String())
+			}
+			if result.Valid != tt.wantValid || len(result.Errors) != tt.wantErrors {
+				t.Errorf("Unexpected result: %+v", result)
+			}
+			if tt.wantValid && result.Summary["server"]["http_port"] != float64(8080) {
+				t.Errorf("Expected the summary to include the server section, got %v", result.Summary)
+			}
+		})
+	}
+
+	var out bytes.Buffer
+	if validateConfig(&out, invalid, false) || !strings.Contains(out.String(), "invalid HTTP port") {
+		t.Errorf("Unexpected text output: %q", out.String())
+	}
+}